@@ -89,3 +89,18 @@ export async function lookupBarcode(upc) {
   }
 }
 
+
+/**
+ * Search trainings and inventory on the server, best matches first
+ * @param {string} q - Search text
+ * @param {string} email - User searching
+ * @param {Object} options - type ('training' or 'inventory'), scope ('all') and limit
+ */
+export async function search(q, email, options = {}) {
+  try {
+    return await apiGet('/search', { q, email, ...options })
+  } catch (error) {
+    console.error('Search failed:', error)
+    return { ok: false, error: error.message }
+  }
+}
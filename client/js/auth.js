@@ -7,6 +7,10 @@ import { handleError } from './utils.js'
 
 const CURRENT_KEY = STORAGE_KEYS.CURRENT_USER
 
+// The version of each user's data as last loaded or saved. Saves send it back
+// so the server can refuse one made over changes this client hasn't seen.
+const versions = new Map()
+
 export function getCurrentUser() {
   try { return JSON.parse(localStorage.getItem(CURRENT_KEY)) } catch (e) { return null }
 }
@@ -50,6 +54,7 @@ export async function getUserData(email) {
   if (!key) return null
   try {
     const res = await apiGet('/user', { email: key })
+    if (res && res.ok) versions.set(key, res.user.version || 0)
     if (res && res.ok) return {
       name: res.user.name,
       email: res.user.email,
@@ -67,10 +72,11 @@ export async function saveUserData(user) {
   const key = (user && user.email) || ''
   if (!key) return { ok: false }
   try {
-    const payload = { email: key }
+    const payload = { email: key, version: versions.get(key.toLowerCase().trim()) || 0 }
     if (user.inventory !== undefined) payload.inventory = user.inventory || []
     if (user.deleted_inventory !== undefined) payload.deleted_inventory = user.deleted_inventory || []
     const res = await apiPost('/user', payload)
+    if (res && res.ok) versions.set(key.toLowerCase().trim(), res.version)
     return res
   } catch (e) {
     console.error('saveUserData error', e)
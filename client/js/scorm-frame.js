@@ -0,0 +1,118 @@
+// SCORM runtime bridge. The server adds this to every page of a SCORM
+// package; packages play in a sandboxed frame that can't reach the app, so
+// the runtime API lives in the package's own window, where content looks
+// for window.API (SCORM 1.2) or window.API_1484_11 (SCORM 2004). The app
+// hands the learner's values over in the frame's name, values are cached
+// here since the API is synchronous, and changes are posted back to the app
+// to be saved whenever the content commits or finishes.
+(function () {
+  let state
+  try {
+    state = JSON.parse(window.name)
+  } catch {
+    return
+  }
+  if (!state || !state.scormFrame) return
+  const app = new URL(document.currentScript.src).origin
+  const { version, values } = state
+
+  const errorStrings = {
+    '0': 'No error',
+    '101': 'General exception',
+    '132': 'Not initialized',
+    '301': 'Not initialized',
+    '351': 'Read only',
+    '403': 'Element is read only',
+    '404': 'Element is read only',
+  }
+
+  // Elements the LMS provides, which content may read but not write
+  const readOnly = [
+    'cmi.core.student_id', 'cmi.core.student_name', 'cmi.core.credit', 'cmi.core.entry',
+    'cmi.core.lesson_mode', 'cmi.core.total_time', 'cmi.launch_data',
+    'cmi.learner_id', 'cmi.learner_name', 'cmi.credit', 'cmi.entry', 'cmi.mode', 'cmi.total_time',
+  ]
+
+  const is2004 = version === '2004'
+  const data = { ...values }
+  let pending = {}
+  let lastError = '0'
+  let initialized = false
+
+  const fail = (code, result) => { lastError = code; return result }
+  const notInitialized = is2004 ? '132' : '301'
+
+  const getValue = (key) => {
+    if (!initialized) return fail(notInitialized, '')
+    lastError = '0'
+    // Collections such as cmi.interactions report how many entries they hold
+    const count = /^(.*)\._count$/.exec(key)
+    if (count) {
+      const indexes = new Set()
+      Object.keys(data).forEach(k => {
+        const m = k.startsWith(`${count[1]}.`) && /^(\d+)\./.exec(k.slice(count[1].length + 1))
+        if (m) indexes.add(m[1])
+      })
+      return String(indexes.size)
+    }
+    return data[key] ?? ''
+  }
+
+  const setValue = (key, value) => {
+    if (!initialized) return fail(notInitialized, 'false')
+    if (readOnly.includes(key)) return fail(is2004 ? '404' : '403', 'false')
+    data[key] = String(value)
+    pending[key] = String(value)
+    lastError = '0'
+    return 'true'
+  }
+
+  const flush = () => {
+    if (Object.keys(pending).length === 0) return
+    const changes = pending
+    pending = {}
+    // Later pages of the package start from the values so far
+    window.name = JSON.stringify({ ...state, values: data })
+    window.parent.postMessage({ type: 'scorm-commit', frame: state.scormFrame, values: changes }, app)
+  }
+
+  const start = () => { initialized = true; lastError = '0'; return 'true' }
+  const save = () => {
+    if (!initialized) return fail(notInitialized, 'false')
+    flush()
+    lastError = '0'
+    return 'true'
+  }
+  const finish = () => {
+    const result = save()
+    initialized = false
+    return result
+  }
+  const errorString = (code) => errorStrings[code] || ''
+
+  if (is2004) {
+    window.API_1484_11 = {
+      Initialize: start,
+      Terminate: finish,
+      GetValue: getValue,
+      SetValue: setValue,
+      Commit: save,
+      GetLastError: () => lastError,
+      GetErrorString: errorString,
+      GetDiagnostic: errorString,
+    }
+  } else {
+    window.API = {
+      LMSInitialize: start,
+      LMSFinish: finish,
+      LMSGetValue: getValue,
+      LMSSetValue: setValue,
+      LMSCommit: save,
+      LMSGetLastError: () => lastError,
+      LMSGetErrorString: errorString,
+      LMSGetDiagnostic: errorString,
+    }
+  }
+
+  window.addEventListener('pagehide', flush)
+})()
@@ -0,0 +1,24 @@
+// Plays SCORM packages. Package content is someone else's code, so it runs
+// in a sandboxed frame with the runtime API provided inside it by
+// scorm-frame.js; the learner's values go in through the frame's name and
+// changes come back as messages, to be handed to commit.
+
+/**
+ * Play a package in a frame
+ * @param {HTMLIFrameElement} frame
+ * @param {Object} options - { url, version: '1.2' | '2004', values, commit(values) }
+ */
+export function playScorm(frame, { url, version, values, commit }) {
+  const id = Math.random().toString(36).slice(2) + Date.now().toString(36)
+  const onMessage = (event) => {
+    const message = event.data
+    if (!message || message.type !== 'scorm-commit' || message.frame !== id) return
+    if (message.values && typeof message.values === 'object') commit(message.values)
+    // The last changes arrive as the package unloads
+    if (!frame.isConnected) window.removeEventListener('message', onMessage)
+  }
+  window.addEventListener('message', onMessage)
+  frame.setAttribute('sandbox', 'allow-scripts allow-forms allow-popups')
+  frame.name = JSON.stringify({ scormFrame: id, version, values })
+  frame.src = url
+}
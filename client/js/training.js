@@ -3,11 +3,11 @@
 import { apiGet, apiPost } from './api.js'
 
 /**
- * Get all trainings
+ * Get all trainings (published ones, plus the given author's drafts)
  */
-export async function getTrainings() {
+export async function getTrainings(email) {
   try {
-    const res = await apiGet('/trainings')
+    const res = await apiGet('/trainings', email ? { email } : {})
     if (res && res.ok) {
       return res.trainings || []
     }
@@ -19,11 +19,31 @@ export async function getTrainings() {
 }
 
 /**
- * Get a single training by ID
+ * Browse the training catalog a page at a time, with facet counts for the
+ * filters. tags must all match.
  */
-export async function getTraining(id) {
+export async function getCatalog(email, { category = '', tags = [], author = '', sort = '', page = 1, perPage = 0 } = {}) {
+  const params = new URLSearchParams({ page })
+  if (email) params.set('email', email)
+  if (category) params.set('category', category)
+  tags.forEach(tag => params.append('tag', tag))
+  if (author) params.set('author', author)
+  if (sort) params.set('sort', sort)
+  if (perPage) params.set('per_page', perPage)
   try {
-    const res = await apiGet('/training', { id })
+    return await apiGet(`/trainings/catalog?${params}`)
+  } catch (e) {
+    console.error('getCatalog error', e)
+    return { ok: false, error: e.message }
+  }
+}
+
+/**
+ * Get a single training by ID (authors get their working copy)
+ */
+export async function getTraining(id, email) {
+  try {
+    const res = await apiGet('/training', email ? { id, email } : { id })
     if (res && res.ok) {
       return res.training
     }
@@ -51,7 +71,7 @@ export async function createTraining(email, training) {
 }
 
 /**
- * Update a training
+ * Update a training; training.email must be its author's
  */
 export async function updateTraining(training) {
   try {
@@ -66,6 +86,48 @@ export async function updateTraining(training) {
   }
 }
 
+/**
+ * Publish the working copy of a training as a new revision
+ */
+export async function publishTraining(id, email, note = '') {
+  try {
+    const res = await apiPost('/training/publish', { id, email, note })
+    return res
+  } catch (e) {
+    console.error('publishTraining error', e)
+    return { ok: false, error: e.message || 'Failed to publish training' }
+  }
+}
+
+/**
+ * Get the published revisions of a training
+ */
+export async function getRevisions(id, email) {
+  try {
+    const res = await apiGet('/training/revisions', { id, email })
+    if (res && res.ok) {
+      return res.revisions || []
+    }
+    return []
+  } catch (e) {
+    console.error('getRevisions error', e)
+    return []
+  }
+}
+
+/**
+ * Roll a training back to a past revision
+ */
+export async function rollbackTraining(id, email, revision) {
+  try {
+    const res = await apiPost('/training/rollback', { id, email, revision })
+    return res
+  } catch (e) {
+    console.error('rollbackTraining error', e)
+    return { ok: false, error: e.message || 'Failed to roll back training' }
+  }
+}
+
 /**
  * Delete a training (moves to recycling bin)
  */
@@ -122,38 +184,205 @@ export async function permanentDeleteTraining(id, email) {
 }
 
 /**
- * Upload a video file
+ * List the media library: the user's own uploads, or everyone's with
+ * scope 'all'. kind is 'video' or 'image'.
  */
-export async function uploadVideo(file, email) {
+export async function getMedia(email, { kind = '', scope = '', unused = false } = {}) {
+  try {
+    const params = { email }
+    if (kind) params.kind = kind
+    if (scope) params.scope = scope
+    if (unused) params.unused = 'true'
+    const res = await apiGet('/media', params)
+    if (res && res.ok) {
+      return res.media || []
+    }
+    return []
+  } catch (e) {
+    console.error('getMedia error', e)
+    return []
+  }
+}
+
+/**
+ * Delete one of the user's uploads that no training uses
+ */
+export async function deleteMedia(id, email) {
+  try {
+    const res = await apiPost('/media/delete', { id, email })
+    return res
+  } catch (e) {
+    console.error('deleteMedia error', e)
+    return { ok: false, error: e.message || 'Failed to delete media' }
+  }
+}
+
+const UPLOAD_CHUNK_SIZE = 5 * 1024 * 1024
+const UPLOAD_RETRIES = 5
+const WHOLE_FILE_CHECKSUM_LIMIT = 200 * 1024 * 1024 // Hashing needs the file in memory
+const tusHeaders = { 'Tus-Resumable': '1.0.0' }
+
+const toBase64 = (buffer) => btoa(String.fromCharCode(...new Uint8Array(buffer)))
+const toHex = (buffer) => [...new Uint8Array(buffer)].map(b => b.toString(16).padStart(2, '0')).join('')
+const sleep = (ms) => new Promise(resolve => setTimeout(resolve, ms))
+
+async function uploadError(res, fallback) {
+  try {
+    const data = await res.json()
+    return data?.error || fallback
+  } catch (e) {
+    return fallback
+  }
+}
+
+/**
+ * Start a resumable upload on the server, or find the one this file was
+ * already being sent to, and return its URL and how much it has received
+ */
+async function openUpload(file, email, storageKey) {
+  const existing = localStorage.getItem(storageKey)
+  if (existing) {
+    const res = await fetch(existing, { method: 'HEAD', headers: tusHeaders })
+    if (res.ok) return { url: existing, offset: Number(res.headers.get('Upload-Offset')) }
+    localStorage.removeItem(storageKey)
+  }
+
+  const metadata = [
+    `filename ${btoa(unescape(encodeURIComponent(file.name)))}`,
+    `filetype ${btoa(file.type)}`,
+  ]
+  if (file.size <= WHOLE_FILE_CHECKSUM_LIMIT && crypto.subtle) {
+    const sum = toHex(await crypto.subtle.digest('SHA-256', await file.arrayBuffer()))
+    metadata.push(`checksum ${btoa(`sha256 ${sum}`)}`)
+  }
+  const res = await fetch(`/api/uploads/videos?email=${encodeURIComponent(email)}`, {
+    method: 'POST',
+    headers: {
+      ...tusHeaders,
+      'Upload-Length': String(file.size),
+      'Upload-Metadata': metadata.join(','),
+    },
+  })
+  if (res.status !== 201) throw new Error(await uploadError(res, 'Failed to start upload'))
+  const url = res.headers.get('Location')
+  localStorage.setItem(storageKey, url)
+  return { url, offset: 0 }
+}
+
+/**
+ * Upload a video file. It is sent in chunks that are each checked on
+ * arrival; if the connection drops the upload carries on from the last chunk
+ * received, including after the page is reloaded and the same file chosen.
+ * @param {File} file
+ * @param {string} email
+ * @param {Function} [onProgress] - called with the fraction uploaded
+ */
+export async function uploadVideo(file, email, onProgress) {
+  if (!file.type.startsWith('video/')) return { ok: false, error: 'file must be a video' }
+  const storageKey = `upload:${email}:${file.name}:${file.size}:${file.lastModified}`
+
+  for (let attempt = 0; ; attempt++) {
+    try {
+      let { url, offset } = await openUpload(file, email, storageKey)
+      while (offset < file.size) {
+        onProgress?.(offset / file.size)
+        const chunk = await file.slice(offset, offset + UPLOAD_CHUNK_SIZE).arrayBuffer()
+        const headers = {
+          ...tusHeaders,
+          'Content-Type': 'application/offset+octet-stream',
+          'Upload-Offset': String(offset),
+        }
+        if (crypto.subtle) {
+          headers['Upload-Checksum'] = `sha256 ${toBase64(await crypto.subtle.digest('SHA-256', chunk))}`
+        }
+        const res = await fetch(url, { method: 'PATCH', headers, body: chunk })
+        if (!res.ok) throw new Error(await uploadError(res, 'Failed to upload chunk'))
+        offset = Number(res.headers.get('Upload-Offset'))
+      }
+      onProgress?.(1)
+
+      const res = await fetch(url)
+      const data = await res.json()
+      localStorage.removeItem(storageKey)
+      if (data?.ok && data.upload?.url) return { ok: true, video_url: data.upload.url }
+      return { ok: false, error: data?.error || 'Failed to upload video' }
+    } catch (e) {
+      console.error('uploadVideo error', e)
+      if (attempt >= UPLOAD_RETRIES) return { ok: false, error: e.message || 'Failed to upload video' }
+      // A corrupted file is discarded by the server, so the retry starts over
+      await sleep(1000 * 2 ** attempt)
+    }
+  }
+}
+
+/**
+ * Create a draft training from a Markdown file
+ */
+export async function importMarkdown(file, email) {
   try {
     const formData = new FormData()
-    formData.append('video', file)
-    
-    const res = await fetch(`/api/upload-video?email=${encodeURIComponent(email)}`, {
+    formData.append('file', file)
+
+    const res = await fetch(`/api/training/import/markdown?email=${encodeURIComponent(email)}`, {
       method: 'POST',
       body: formData,
     })
-    
-    // Check if response is JSON
-    const contentType = res.headers.get('content-type')
-    if (!contentType || !contentType.includes('application/json')) {
-      const text = await res.text()
-      console.error('Non-JSON response from server:', text)
-      return { ok: false, error: 'Server returned an invalid response. Please try again.' }
-    }
-    
-    const data = await res.json()
-    if (data && data.ok) {
-      return { ok: true, video_url: data.video_url }
-    }
-    return { ok: false, error: data?.error || 'Failed to upload video' }
+    return await res.json()
   } catch (e) {
-    console.error('uploadVideo error', e)
-    // Handle JSON parse errors specifically
-    if (e.message && e.message.includes('JSON')) {
-      return { ok: false, error: 'Server returned an invalid response. Please try again.' }
-    }
-    return { ok: false, error: e.message || 'Failed to upload video' }
+    console.error('importMarkdown error', e)
+    return { ok: false, error: e.message || 'Failed to import training' }
+  }
+}
+
+/**
+ * URL that downloads a training as Markdown
+ */
+export function markdownExportUrl(id, email) {
+  return `/api/training/export/markdown?${new URLSearchParams({ id, email })}`
+}
+
+/**
+ * Create a draft training from a package exported by another instance
+ */
+export async function importPackage(file, email) {
+  try {
+    const formData = new FormData()
+    formData.append('package', file)
+
+    const res = await fetch(`/api/training/import/package?email=${encodeURIComponent(email)}`, {
+      method: 'POST',
+      body: formData,
+    })
+    return await res.json()
+  } catch (e) {
+    console.error('importPackage error', e)
+    return { ok: false, error: e.message || 'Failed to import package' }
+  }
+}
+
+/**
+ * URL that downloads a training and its media as a zip package
+ */
+export function packageExportUrl(id, email) {
+  return `/api/training/export/package?${new URLSearchParams({ id, email })}`
+}
+
+/**
+ * Upload a WebVTT or SRT caption file for a video block. The server returns
+ * it as WebVTT along with its transcript.
+ */
+export async function uploadCaptions(file, email) {
+  try {
+    const formData = new FormData()
+    formData.append('captions', file)
+    const res = await fetch(`/api/upload-captions?email=${encodeURIComponent(email)}`, {
+      method: 'POST',
+      body: formData,
+    })
+    return await res.json()
+  } catch (e) {
+    console.error('uploadCaptions error', e)
+    return { ok: false, error: e.message || 'Failed to upload captions' }
   }
 }
 
@@ -193,3 +422,234 @@ export async function uploadImage(file, email) {
   }
 }
 
+
+/**
+ * Report that a learner has reached a block (with the watch position for videos)
+ */
+export async function recordProgress(trainingId, email, blockId = '', position = 0, duration = 0) {
+  try {
+    const res = await apiPost('/progress', {
+      email,
+      training_id: trainingId,
+      block_id: blockId,
+      position,
+      duration,
+    })
+    return res
+  } catch (e) {
+    console.error('recordProgress error', e)
+    return { ok: false, error: e.message || 'Failed to record progress' }
+  }
+}
+
+/**
+ * Get a learner's progress on a training (or on all trainings if no ID is given)
+ */
+export async function getProgress(email, trainingId = '') {
+  try {
+    const res = await apiGet('/progress', trainingId ? { email, training_id: trainingId } : { email })
+    if (res && res.ok) {
+      return res.progress
+    }
+    return null
+  } catch (e) {
+    console.error('getProgress error', e)
+    return null
+  }
+}
+
+/**
+ * Get the completion report for a trainer's trainings
+ */
+export async function getProgressReport(email, { trainingId = '', user = '' } = {}) {
+  try {
+    const params = { email }
+    if (trainingId) params.training_id = trainingId
+    if (user) params.user = user
+    return await apiGet('/progress/report', params)
+  } catch (e) {
+    console.error('getProgressReport error', e)
+    return { ok: false, error: e.message || 'Failed to load report' }
+  }
+}
+
+/**
+ * Submit answers to a quiz block for grading
+ */
+export async function submitQuiz(trainingId, email, blockId, answers) {
+  try {
+    return await apiPost('/quiz/submit', {
+      email,
+      training_id: trainingId,
+      block_id: blockId,
+      answers,
+    })
+  } catch (e) {
+    console.error('submitQuiz error', e)
+    return { ok: false, error: e.message || 'Failed to submit quiz' }
+  }
+}
+
+/**
+ * Get quiz attempts on a training (authors can pass a learner's email as user)
+ */
+export async function getQuizAttempts(trainingId, email, { blockId = '', user = '' } = {}) {
+  try {
+    const params = { email, training_id: trainingId }
+    if (blockId) params.block_id = blockId
+    if (user) params.user = user
+    const res = await apiGet('/quiz/attempts', params)
+    if (res && res.ok) {
+      return res.attempts || []
+    }
+    return []
+  } catch (e) {
+    console.error('getQuizAttempts error', e)
+    return []
+  }
+}
+
+/**
+ * Get the certificates a learner has earned
+ */
+export async function getCertificates(email) {
+  try {
+    const res = await apiGet('/certificates', { email })
+    if (res && res.ok) {
+      return res.certificates || []
+    }
+    return []
+  } catch (e) {
+    console.error('getCertificates error', e)
+    return []
+  }
+}
+
+/**
+ * URL to download a certificate as a PDF
+ */
+export function certificateDownloadUrl(id, email) {
+  return `/api/certificates/download?${new URLSearchParams({ id, email })}`
+}
+
+/**
+ * Check whether a certificate ID is genuine
+ */
+export async function verifyCertificate(id) {
+  try {
+    return await apiGet('/certificates/verify', { id })
+  } catch (e) {
+    console.error('verifyCertificate error', e)
+    return { ok: false, error: e.message || 'Failed to verify certificate' }
+  }
+}
+
+/**
+ * Get learners whose certifications on the trainer's trainings expire within the next N days
+ */
+export async function getExpiringCertifications(email, days = 30) {
+  try {
+    const res = await apiGet('/certifications/expiring', { email, days })
+    if (res && res.ok) {
+      return res.expiring || []
+    }
+    return []
+  } catch (e) {
+    console.error('getExpiringCertifications error', e)
+    return []
+  }
+}
+
+/**
+ * Get learning paths, with the learner's progress through each if an email is given
+ */
+export async function getPaths(email) {
+  try {
+    const res = await apiGet('/paths', email ? { email } : {})
+    if (res && res.ok) {
+      return res.paths || []
+    }
+    return []
+  } catch (e) {
+    console.error('getPaths error', e)
+    return []
+  }
+}
+
+/**
+ * Create a learning path from an ordered list of training IDs
+ */
+export async function createPath(email, path) {
+  try {
+    return await apiPost('/paths', { ...path, email })
+  } catch (e) {
+    console.error('createPath error', e)
+    return { ok: false, error: e.message || 'Failed to create learning path' }
+  }
+}
+
+/**
+ * Get a learner's progress through a learning path
+ */
+export async function getPathProgress(id, email) {
+  try {
+    const res = await apiGet('/paths/progress', { id, email })
+    if (res && res.ok) {
+      return res.progress
+    }
+    return null
+  } catch (e) {
+    console.error('getPathProgress error', e)
+    return null
+  }
+}
+
+/**
+ * Create a draft training from a SCORM 1.2 or 2004 package
+ */
+export async function importScorm(file, email) {
+  try {
+    const formData = new FormData()
+    formData.append('package', file)
+
+    const res = await fetch(`/api/scorm/import?email=${encodeURIComponent(email)}`, {
+      method: 'POST',
+      body: formData,
+    })
+    return await res.json()
+  } catch (e) {
+    console.error('importScorm error', e)
+    return { ok: false, error: e.message || 'Failed to import SCORM package' }
+  }
+}
+
+/**
+ * Get the SCORM runtime data a package starts with
+ */
+export async function getScormRuntime(trainingId, email, blockId) {
+  try {
+    return await apiGet('/scorm/runtime', { training_id: trainingId, email, block_id: blockId })
+  } catch (e) {
+    console.error('getScormRuntime error', e)
+    return { ok: false, error: e.message || 'Failed to load SCORM data' }
+  }
+}
+
+/**
+ * Save SCORM runtime values set by a package. Uses keepalive so the last
+ * commit still arrives when the learner leaves the page.
+ */
+export async function commitScormRuntime(trainingId, email, blockId, values) {
+  try {
+    const res = await fetch('/api/scorm/runtime', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ email, training_id: trainingId, block_id: blockId, values }),
+      keepalive: true,
+    })
+    return await res.json()
+  } catch (e) {
+    console.error('commitScormRuntime error', e)
+    return { ok: false, error: e.message || 'Failed to save SCORM data' }
+  }
+}
@@ -99,6 +99,29 @@ export function debounce(func, wait) {
   }
 }
 
+const IMAGE_WIDTHS = { thumb: 320, small: 640, medium: 1280, large: 1920 }
+
+/**
+ * URL of an uploaded image resized for display; other URLs are returned as is
+ * @param {string} url - Image URL
+ * @param {string} size - thumb, small, medium or large
+ * @returns {string} URL asking the server for that size
+ */
+export function imageSize(url, size) {
+  if (!url || !url.startsWith('/uploads/images/')) return url
+  return `${url}?size=${size}`
+}
+
+/**
+ * srcset offering every size of an uploaded image, so the browser can pick
+ * @param {string} url - Image URL
+ * @returns {string|null} srcset value, or null for other images
+ */
+export function imageSrcset(url) {
+  if (!url || !url.startsWith('/uploads/images/')) return null
+  return Object.entries(IMAGE_WIDTHS).map(([size, width]) => `${imageSize(url, size)} ${width}w`).join(', ')
+}
+
 /**
  * Formats date for display
  * @param {Date|string} date - Date to format
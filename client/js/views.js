@@ -1,7 +1,9 @@
 import * as auth from './auth.js'
 import * as training from './training.js'
 import { navigate } from './router.js'
-import { lookupBarcode } from './api.js'
+import { lookupBarcode, search } from './api.js'
+import { playScorm } from './scorm.js'
+import { debounce, imageSize, imageSrcset } from './utils.js'
 
 function el(tag, attrs = {}, ...children) {
   const e = document.createElement(tag)
@@ -239,8 +241,8 @@ export async function renderInventory(appEl) {
       if (typeof item === 'object' && item !== null && 'description' in item) {
         return {
           ...item,
-          quantity: parseInt(item.quantity) || 0,
-          target_quantity: parseInt(item.target_quantity) || 0
+          quantity: parseFloat(item.quantity) || 0,
+          target_quantity: parseFloat(item.target_quantity) || 0
         }
       }
       // If it's a string (old format), convert it to new format
@@ -315,11 +317,11 @@ export async function renderInventory(appEl) {
       ),
       el('div', { class: 'form-row', style: 'flex:0.5; min-width:80px; margin-bottom:0;' },
         el('label', { for: 'item-quantity', class: 'form-label' }, 'Stock'),
-        el('input', { id: 'item-quantity', placeholder: '5', type: 'number', min: '0', required: true })
+        el('input', { id: 'item-quantity', placeholder: '5', type: 'number', min: '0', step: 'any', required: true })
       ),
       el('div', { class: 'form-row', style: 'flex:0.5; min-width:80px; margin-bottom:0;' },
         el('label', { for: 'item-target', class: 'form-label' }, 'Target'),
-        el('input', { id: 'item-target', placeholder: '10', type: 'number', min: '0', required: true })
+        el('input', { id: 'item-target', placeholder: '10', type: 'number', min: '0', step: 'any', required: true })
       ),
       el('button', { class: 'btn primary', style: 'height:45px; margin-bottom:2px; white-space:nowrap;', onClick: addItem }, '➕ Add Item')
     )
@@ -401,7 +403,7 @@ export async function renderInventory(appEl) {
     const need = Math.max(0, (item.target_quantity || 0) - (item.quantity || 0))
     const rowClass = need > 0 ? 'inventory-row restock-needed' : 'inventory-row'
 
-    return el('tr', { class: rowClass, 'data-index': idx, 'data-search': `${item.description} ${item.upc} ${item.number}`.toLowerCase() },
+    return el('tr', { class: rowClass, 'data-index': idx, 'data-id': item.id || null, 'data-search': `${item.description} ${item.upc} ${item.number}`.toLowerCase() },
       el('td', { class: 'item-number-col' }, `${idx + 1}`),
       el('td', { class: 'item-description' }, item.description || ''),
       el('td', { class: 'item-upc' }, item.upc || ''),
@@ -437,13 +439,14 @@ export async function renderInventory(appEl) {
     const input = el('input', {
       type: 'number',
       min: '0',
+      step: 'any',
       value: currentValue,
       class: 'quantity-input',
       style: 'width:60px;padding:0.25rem;'
     })
 
     const saveField = async () => {
-      const newValue = parseInt(input.value) || 0
+      const newValue = parseFloat(input.value) || 0
       if (newValue !== currentValue) {
         const item = data.inventory[idx]
 
@@ -461,7 +464,12 @@ export async function renderInventory(appEl) {
         item[field] = newValue
         item.updated_at = new Date().toISOString()
 
-        await auth.saveUserData({ name: user.name, email: user.email, inventory: data.inventory })
+        const res = await auth.saveUserData({ name: user.name, email: user.email, inventory: data.inventory })
+        if (!res.ok) {
+          showToast(res.error || 'Failed to save', 'error')
+          navigate('/inventory', true)
+          return
+        }
         showToast(`${field.replace('_', ' ')} updated`, 'success')
 
         // Manually update "Need" calculation and UI alerts
@@ -542,22 +550,37 @@ export async function renderInventory(appEl) {
     }
   }
 
-  function filterInventory(query) {
+  // Searches on the server, which matches word prefixes and typos, and falls
+  // back to matching the text in the page for rows not saved yet or if the
+  // search fails
+  let latestSearch = 0
+  const filterInventory = debounce(async (query) => {
     const q = (query || '').toLowerCase().trim()
+    const current = ++latestSearch
+    let matches = null
+    if (q) {
+      const res = await search(q, user.email, { type: 'inventory', limit: 100 })
+      if (current !== latestSearch) return // A newer search has taken over
+      if (res.ok && res.total <= res.results.length) {
+        matches = new Set(res.results.map(r => r.id))
+      }
+    }
     const rows = document.querySelectorAll('#inventory-tbody tr')
     rows.forEach(row => {
+      const id = row.getAttribute('data-id')
       const searchText = row.getAttribute('data-search') || ''
-      row.style.display = searchText.includes(q) ? '' : 'none'
+      const match = matches && id ? matches.has(id) : searchText.includes(q)
+      row.style.display = match ? '' : 'none'
     })
-  }
+  }, 200)
 
 
   async function addItem() {
     const description = document.getElementById('item-description').value.trim()
     const upc = document.getElementById('item-upc').value.trim()
     const number = document.getElementById('item-number').value.trim()
-    const quantity = parseInt(document.getElementById('item-quantity').value) || 0
-    const target = parseInt(document.getElementById('item-target').value) || 0
+    const quantity = parseFloat(document.getElementById('item-quantity').value) || 0
+    const target = parseFloat(document.getElementById('item-target').value) || 0
 
     if (!description) { showToast('Please enter item description', 'error'); return }
     if (!upc) { showToast('Please enter UPC', 'error'); return }
@@ -584,7 +607,12 @@ export async function renderInventory(appEl) {
       updated_at: now
     }
     data.inventory.push(newItem)
-    await auth.saveUserData({ name: user.name, email: user.email, inventory: data.inventory })
+    const res = await auth.saveUserData({ name: user.name, email: user.email, inventory: data.inventory })
+    if (!res.ok) {
+      showToast(res.error || 'Failed to add item', 'error')
+      navigate('/inventory', true)
+      return
+    }
 
     // Clear form
     document.getElementById('item-description').value = ''
@@ -616,12 +644,17 @@ export async function renderInventory(appEl) {
     data.deleted_inventory = data.deleted_inventory || []
     data.deleted_inventory.push(item)
     data.inventory.splice(idx, 1)
-    await auth.saveUserData({
+    const res = await auth.saveUserData({
       name: user.name,
       email: user.email,
       inventory: data.inventory,
       deleted_inventory: data.deleted_inventory
     })
+    if (!res.ok) {
+      showToast(res.error || 'Failed to remove item', 'error')
+      navigate('/inventory', true)
+      return
+    }
     showToast('Item moved to recycling bin', 'success')
     navigate('/inventory', true)
   }
@@ -644,12 +677,17 @@ export async function renderInventory(appEl) {
     data.inventory = data.inventory || []
     data.inventory.push(item)
     data.deleted_inventory.splice(idx, 1)
-    await auth.saveUserData({
+    const res = await auth.saveUserData({
       name: user.name,
       email: user.email,
       inventory: data.inventory,
       deleted_inventory: data.deleted_inventory
     })
+    if (!res.ok) {
+      showToast(res.error || 'Failed to restore', 'error')
+      navigate('/inventory', true)
+      return
+    }
     showToast('Item restored', 'success')
     navigate('/inventory', true)
   }
@@ -984,15 +1022,75 @@ export async function renderTraining(appEl) {
       ),
       el('div', { class: 'cta-buttons' },
         el('button', { class: 'btn btn-large primary', onClick: () => navigate('/training/create') }, '➕ Create Training'),
-        el('button', { class: 'btn btn-large', onClick: () => training.getTrainings().then(ts => { trainings = ts; navigate('/training') }) }, '🔄 Refresh')
+        el('button', { class: 'btn btn-large', onClick: () => document.getElementById('markdown-import').click() }, '📄 Import Markdown'),
+        el('button', { class: 'btn btn-large', onClick: () => document.getElementById('package-import').click() }, '📦 Import Package'),
+        el('button', { class: 'btn btn-large', onClick: () => document.getElementById('scorm-import').click() }, '🎓 Import SCORM'),
+        el('input', {
+          type: 'file',
+          id: 'scorm-import',
+          accept: '.zip,application/zip',
+          style: 'display:none;',
+          onChange: async (e) => {
+            const file = e.target.files[0]
+            if (!file) return
+            showToast('Importing SCORM package...', 'success')
+            const res = await training.importScorm(file, user.email)
+            if (res.ok) {
+              showToast('Course imported as a draft', 'success')
+              navigate(`/training/view?id=${res.training.id}`)
+            } else {
+              showToast(res.error || 'Failed to import SCORM package', 'error')
+            }
+            e.target.value = ''
+          }
+        }),
+        el('input', {
+          type: 'file',
+          id: 'package-import',
+          accept: '.zip,application/zip',
+          style: 'display:none;',
+          onChange: async (e) => {
+            const file = e.target.files[0]
+            if (!file) return
+            showToast('Importing package...', 'success')
+            const res = await training.importPackage(file, user.email)
+            if (res.ok) {
+              showToast('Training imported as a draft', 'success')
+              navigate(`/training/view?id=${res.training.id}`)
+            } else {
+              showToast(blockErrorMessage(res) || 'Failed to import package', 'error')
+            }
+            e.target.value = ''
+          }
+        }),
+        el('input', {
+          type: 'file',
+          id: 'markdown-import',
+          accept: '.md,.markdown,text/markdown',
+          style: 'display:none;',
+          onChange: async (e) => {
+            const file = e.target.files[0]
+            if (!file) return
+            const res = await training.importMarkdown(file, user.email)
+            if (res.ok) {
+              showToast('Training imported as a draft', 'success')
+              navigate(`/training/view?id=${res.training.id}`)
+            } else {
+              showToast(blockErrorMessage(res) || 'Failed to import training', 'error')
+            }
+            e.target.value = ''
+          }
+        }),
+        el('button', { class: 'btn btn-large', onClick: () => navigate('/training') }, '🔄 Refresh')
       )
     )
   )
 
-  // Load trainings
-  let trainings = await training.getTrainings()
+  // Browse the catalog; changing a filter reloads from the first page
+  const filters = { category: '', tags: [], author: '', sort: 'newest', page: 1 }
+  const catalog = await training.getCatalog(user.email, filters)
 
-  if (trainings.length === 0) {
+  if (!catalog.ok || catalog.total === 0) {
     const emptyState = el('div', { class: 'card', style: 'text-align:center;padding:3rem;' },
       el('div', { style: 'text-align:center; padding:2rem; background: var(--card-bg); border-radius:12px; border: 2px dashed var(--border);' },
         el('h3', { style: 'margin-bottom:1rem;' }, 'Ready to start?'),
@@ -1004,26 +1102,97 @@ export async function renderTraining(appEl) {
     return
   }
 
-  const trainingsGrid = el('div', { class: 'trainings-grid' },
-    ...trainings.map(t => el('div', { class: 'card training-item-card', onClick: () => navigate(`/training/view?id=${t.id}`), style: 'cursor:pointer;' },
-      t.thumbnail_url ? el('img', { src: t.thumbnail_url, style: 'width:100%;max-height:250px;object-fit:cover;border-radius:12px 12px 0 0;margin:-1rem -1rem 1rem -1rem;display:block;' }) : null,
+  const catalogEl = el('div', { id: 'training-catalog' })
+  appEl.appendChild(header)
+  appEl.appendChild(catalogEl)
+  renderCatalog(catalog)
+
+  async function loadCatalog(changes = {}) {
+    Object.assign(filters, { page: 1 }, changes)
+    const res = await training.getCatalog(user.email, filters)
+    if (res.ok) renderCatalog(res)
+    else showToast(res.error || 'Failed to load trainings', 'error')
+  }
+
+  function renderCatalog(res) {
+    const { categories, tags, authors } = res.facets
+    const filterBar = el('div', { class: 'catalog-filters', style: 'display:flex;gap:0.75rem;flex-wrap:wrap;align-items:center;margin-bottom:1rem;' },
+      el('select', { 'aria-label': 'Category', onChange: (e) => loadCatalog({ category: e.target.value }) },
+        el('option', { value: '' }, 'All categories'),
+        ...categories.map(f => el('option', { value: f.value, selected: f.value === filters.category ? 'selected' : null }, `${f.value} (${f.count})`))
+      ),
+      el('select', { 'aria-label': 'Author', onChange: (e) => loadCatalog({ author: e.target.value }) },
+        el('option', { value: '' }, 'All authors'),
+        ...authors.map(f => el('option', { value: f.value, selected: f.value === filters.author ? 'selected' : null }, `${f.value} (${f.count})`))
+      ),
+      el('select', { 'aria-label': 'Sort by', onChange: (e) => loadCatalog({ sort: e.target.value }) },
+        ...[['newest', 'Newest'], ['completed', 'Most completed'], ['title', 'Title']].map(([value, label]) =>
+          el('option', { value, selected: value === filters.sort ? 'selected' : null }, label))
+      ),
+      el('span', { class: 'muted small' }, `${res.total} training${res.total !== 1 ? 's' : ''}`)
+    )
+
+    // Selected tags can be removed; the others narrow the results further
+    const tagBar = tags.length > 0 || filters.tags.length > 0
+      ? el('div', { class: 'catalog-tags', style: 'display:flex;gap:0.5rem;flex-wrap:wrap;margin-bottom:1.5rem;' },
+        ...filters.tags.map(tag => el('button', {
+          class: 'btn btn-small primary',
+          onClick: () => loadCatalog({ tags: filters.tags.filter(t => t !== tag) })
+        }, `#${tag} ×`)),
+        ...tags.filter(f => !filters.tags.includes(f.value)).map(f => el('button', {
+          class: 'btn btn-small',
+          onClick: () => loadCatalog({ tags: [...filters.tags, f.value] })
+        }, `#${f.value} (${f.count})`))
+      )
+      : null
+
+    const trainingsGrid = el('div', { class: 'trainings-grid' },
+      ...res.trainings.map(t => renderTrainingCard(t, res.completions[t.id] || 0))
+    )
+
+    const pager = res.pages > 1
+      ? el('div', { class: 'catalog-pager', style: 'display:flex;gap:1rem;justify-content:center;align-items:center;margin-top:1.5rem;' },
+        el('button', { class: 'btn btn-small', disabled: res.page <= 1 ? 'disabled' : null, onClick: () => loadCatalog({ page: res.page - 1 }) }, '← Previous'),
+        el('span', { class: 'muted small' }, `Page ${res.page} of ${res.pages}`),
+        el('button', { class: 'btn btn-small', disabled: res.page >= res.pages ? 'disabled' : null, onClick: () => loadCatalog({ page: res.page + 1 }) }, 'Next →')
+      )
+      : null
+
+    catalogEl.innerHTML = ''
+    catalogEl.appendChild(filterBar)
+    if (tagBar) catalogEl.appendChild(tagBar)
+    catalogEl.appendChild(res.trainings.length > 0 ? trainingsGrid : el('p', { class: 'muted' }, 'No trainings match these filters.'))
+    if (pager) catalogEl.appendChild(pager)
+  }
+
+  function renderTrainingCard(t, completions) {
+    return el('div', {
+      class: 'card training-item-card',
+      onClick: () => t.locked
+        ? showToast('Complete the prerequisite trainings to unlock this one', 'error')
+        : navigate(`/training/view?id=${t.id}`),
+      style: `cursor:pointer;${t.locked ? 'opacity:0.6;' : ''}`
+    },
+      t.thumbnail_url ? el('img', { src: imageSize(t.thumbnail_url, 'small'), style: 'width:100%;max-height:250px;object-fit:cover;border-radius:12px 12px 0 0;margin:-1rem -1rem 1rem -1rem;display:block;' }) : null,
       el('div', { class: 'training-item-content', style: 'padding:0;' },
-        el('h3', { style: 'margin:0 0 0.5rem 0;' }, t.title),
+        t.category ? el('span', { class: 'badge', style: 'margin-bottom:0.5rem;display:inline-block;' }, t.category) : null,
+        el('h3', { style: 'margin:0 0 0.5rem 0;' }, t.locked ? `🔒 ${t.title}` : t.title),
         el('p', { class: 'muted', style: 'margin:0 0 1rem 0;' }, t.description || 'No description'),
+        t.tags && t.tags.length > 0
+          ? el('p', { class: 'muted small', style: 'margin:0;' }, t.tags.map(tag => `#${tag}`).join(' '))
+          : null,
         el('div', { class: 'training-item-footer', style: 'display:flex;justify-content:space-between;align-items:center;padding-top:1rem;border-top:1px solid var(--border-light);margin-top:1rem;' },
           el('div', { style: 'display:flex;align-items:center;gap:0.5rem;' },
             // Placeholder for future profile image
             // el('img',{src:t.creator_profile_image, class:'profile-image-small', style:'width:24px;height:24px;border-radius:50%;object-fit:cover;'}),
-            el('span', { class: 'muted small' }, `Created by ${t.created_by}`)
+            el('span', { class: 'muted small' }, `Created by ${t.created_by}`),
+            completions > 0 ? el('span', { class: 'muted small' }, `· ${completions} completed`) : null
           ),
           t.created_by === user.email ? el('button', { class: 'btn-remove', onClick: (e) => { e.stopPropagation(); deleteAndRefresh(t.id); }, style: 'margin:0;' }, '×') : null
         )
       )
-    ))
-  )
-
-  appEl.appendChild(header)
-  appEl.appendChild(trainingsGrid)
+    )
+  }
 
   async function deleteTrainingItem(id) {
     const res = await training.deleteTraining(id, user.email)
@@ -1044,7 +1213,7 @@ export async function renderTraining(appEl) {
 
   // New helper for URL based routing
   window.viewTraining = async (id) => {
-    const t = await training.getTraining(id)
+    const t = await training.getTraining(id, user.email)
     if (t) renderTrainingView(appEl, t)
     else showToast('Training not found', 'error')
   }
@@ -1055,7 +1224,8 @@ export async function renderTrainingViewByQuery(appEl) {
   const id = urlParams.get('id')
   if (!id) { navigate('/training'); return }
 
-  const t = await training.getTraining(id)
+  const user = auth.getCurrentUser()
+  const t = await training.getTraining(id, user?.email)
   if (t) renderTrainingView(appEl, t)
   else {
     showToast('Training not found', 'error')
@@ -1081,6 +1251,26 @@ export function renderCreateTraining(appEl) {
         el('label', { for: 'training-description', class: 'form-label' }, 'Description'),
         el('input', { id: 'training-description', placeholder: 'Brief description of the training', type: 'text' })
       ),
+      el('div', { class: 'form-row' },
+        el('label', { for: 'training-category', class: 'form-label' }, 'Category (Optional)'),
+        el('input', { id: 'training-category', placeholder: 'e.g. Safety', type: 'text', list: 'training-categories', maxlength: 50 }),
+        el('datalist', { id: 'training-categories' })
+      ),
+      el('div', { class: 'form-row' },
+        el('label', { for: 'training-tags', class: 'form-label' }, 'Tags (Optional)'),
+        el('input', { id: 'training-tags', placeholder: 'e.g. ppe, electrical, forklifts', type: 'text' }),
+        el('p', { class: 'form-hint muted small' }, 'Separate tags with commas. Learners can filter the catalog by them.')
+      ),
+      el('div', { class: 'form-row' },
+        el('label', { for: 'training-prerequisites', class: 'form-label' }, 'Prerequisites (Optional)'),
+        el('select', { id: 'training-prerequisites', multiple: true, style: 'width:100%;min-height:80px;' }),
+        el('p', { class: 'form-hint muted small' }, 'Learners must complete these trainings before this one unlocks. Hold Ctrl/Cmd to select several.')
+      ),
+      el('div', { class: 'form-row' },
+        el('label', { for: 'training-validity', class: 'form-label' }, 'Certification Valid For (days)'),
+        el('input', { id: 'training-validity', placeholder: 'e.g. 365 for yearly recertification', type: 'number', min: 0 }),
+        el('p', { class: 'form-hint muted small' }, 'Leave empty if completions never expire. Learners are re-assigned the training when their certification lapses.')
+      ),
       el('div', { class: 'form-row' },
         el('label', { for: 'training-thumbnail', class: 'form-label' }, 'Thumbnail Image (Optional)'),
         el('input', { id: 'training-thumbnail', type: 'file', accept: 'image/*', onChange: handleThumbnailSelect }),
@@ -1101,7 +1291,8 @@ export function renderCreateTraining(appEl) {
           el('button', { class: 'btn btn-small', onClick: () => addBlock('code') }, '💻 Code'),
           el('button', { class: 'btn btn-small', onClick: () => addBlock('list') }, '📋 List'),
           el('button', { class: 'btn btn-small', onClick: () => addBlock('quote') }, '💬 Quote'),
-          el('button', { class: 'btn btn-small', onClick: () => addBlock('divider') }, '➖ Divider')
+          el('button', { class: 'btn btn-small', onClick: () => addBlock('divider') }, '➖ Divider'),
+          el('button', { class: 'btn btn-small', onClick: () => addBlock('quiz') }, '❓ Quiz')
         )
       ),
       el('div', { id: 'blocks-container', style: 'min-height:200px;' })
@@ -1114,6 +1305,19 @@ export function renderCreateTraining(appEl) {
 
   appEl.appendChild(form)
 
+  training.getTrainings(user.email).then(existing => {
+    const select = document.getElementById('training-prerequisites')
+    if (!select) return
+    existing.filter(t => t.status !== 'draft').forEach(t => select.appendChild(el('option', { value: t.id }, t.title)))
+  })
+
+  // Suggest the categories already in use so trainings share them
+  training.getCatalog(user.email, { perPage: 1 }).then(res => {
+    const list = document.getElementById('training-categories')
+    if (!list || !res.ok) return
+    res.facets.categories.forEach(f => list.appendChild(el('option', { value: f.value })))
+  })
+
   let selectedThumbnailFile = null
   let thumbnailUrl = null
   let blocks = []
@@ -1160,12 +1364,13 @@ export function renderCreateTraining(appEl) {
     switch (type) {
       case 'title': return { text: '', level: 'h2' }
       case 'text': return { text: '' }
-      case 'video': return { url: '', file: null }
+      case 'video': return { url: '', file: null, captions: [] }
       case 'image': return { url: '', file: null, alt: '' }
       case 'code': return { code: '', language: 'javascript' }
       case 'list': return { items: [''], ordered: false }
       case 'quote': return { text: '', author: '' }
       case 'divider': return {}
+      case 'quiz': return { pass_percent: 70, max_attempts: 0, questions: [] }
       default: return {}
     }
   }
@@ -1189,6 +1394,130 @@ export function renderCreateTraining(appEl) {
     }
   }
 
+  // Lets a video or image block reuse an earlier upload from the media library
+  function mediaLibraryPicker(block) {
+    const list = el('div', { style: 'display:none;max-height:240px;overflow-y:auto;border:1px solid #e2e8f0;border-radius:4px;margin-bottom:0.5rem;' })
+    const message = (text) => el('p', { style: 'padding:0.5rem;margin:0;color:#718096;' }, text)
+    const button = el('button', {
+      class: 'btn btn-small',
+      style: 'margin-bottom:0.5rem;',
+      onClick: async () => {
+        if (list.style.display !== 'none') {
+          list.style.display = 'none'
+          return
+        }
+        list.replaceChildren(message('Loading...'))
+        list.style.display = 'block'
+        const media = await training.getMedia(user.email, { kind: block.type, scope: 'all' })
+        if (media.length === 0) {
+          list.replaceChildren(message(`No ${block.type}s have been uploaded yet`))
+          return
+        }
+        list.replaceChildren(...media.map(m => el('div', {
+          style: 'display:flex;align-items:center;gap:0.75rem;padding:0.5rem;cursor:pointer;border-bottom:1px solid #edf2f7;',
+          onClick: () => {
+            block.content.url = m.url
+            delete block.content.file
+            const urlInput = document.getElementById(`block-url-${block.id}`)
+            if (urlInput) urlInput.value = m.url
+            const previewEl = document.getElementById(`image-preview-${block.id}`)
+            if (previewEl) {
+              previewEl.src = imageSize(m.url, 'small')
+              previewEl.style.display = 'block'
+            }
+            list.style.display = 'none'
+          }
+        },
+          m.kind === 'image'
+            ? el('img', { src: imageSize(m.url, 'thumb'), alt: '', style: 'width:48px;height:48px;object-fit:cover;border-radius:4px;' })
+            : null,
+          el('span', { style: 'flex:1;word-break:break-all;' }, m.filename),
+          el('span', { style: 'color:#718096;font-size:0.85rem;white-space:nowrap;' }, `${(m.size / (1024 * 1024)).toFixed(1)} MB`)
+        )))
+      }
+    }, '📚 Choose from library')
+    return [button, list]
+  }
+
+  // Caption tracks for a video block: each upload is checked and converted
+  // to WebVTT by the server straight away, so problems show before saving
+  function captionEditor(block) {
+    if (!block.content.captions) block.content.captions = []
+    const list = el('div', {})
+    const inputStyle = 'padding:0.5rem;border-radius:4px;border:1px solid #e2e8f0;'
+    const language = el('input', { type: 'text', placeholder: 'Language (e.g. en)', style: `width:140px;${inputStyle}` })
+    const label = el('input', { type: 'text', placeholder: 'Label (e.g. English)', style: `width:180px;${inputStyle}` })
+    const kind = el('select', { style: inputStyle },
+      el('option', { value: 'captions' }, 'Captions'),
+      el('option', { value: 'subtitles' }, 'Subtitles')
+    )
+
+    const renderList = () => {
+      list.replaceChildren(...block.content.captions.map((track, i) => el('div', {
+        style: 'display:flex;align-items:center;gap:0.75rem;padding:0.4rem 0;border-bottom:1px solid #edf2f7;'
+      },
+        el('span', { style: 'flex:1;' }, `${track.label} (${track.language}, ${track.kind})`),
+        el('label', { style: 'font-size:0.85rem;color:#4a5568;' },
+          el('input', {
+            type: 'radio',
+            name: `caption-default-${block.id}`,
+            checked: track.default ? 'checked' : null,
+            onChange: () => block.content.captions.forEach((t, j) => { t.default = j === i })
+          }),
+          ' Default'
+        ),
+        el('button', {
+          class: 'btn btn-small',
+          onClick: () => {
+            block.content.captions.splice(i, 1)
+            renderList()
+          }
+        }, 'Remove')
+      )))
+    }
+    renderList()
+
+    const file = el('input', {
+      type: 'file',
+      accept: '.vtt,.srt,text/vtt',
+      style: 'flex:1;min-width:200px;',
+      onChange: async (e) => {
+        const selected = e.target.files[0]
+        e.target.value = ''
+        if (!selected) return
+        const lang = language.value.trim()
+        if (!/^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8}){0,3}$/.test(lang)) {
+          showToast('Enter the language of the captions first, such as en or pt-BR', 'error')
+          return
+        }
+        const res = await training.uploadCaptions(selected, user.email)
+        if (!res.ok) {
+          showToast(res.error || 'Failed to upload captions', 'error')
+          return
+        }
+        block.content.captions.push({
+          url: res.captions_url,
+          language: lang,
+          label: label.value.trim() || lang,
+          kind: kind.value,
+          default: block.content.captions.length === 0
+        })
+        language.value = ''
+        label.value = ''
+        renderList()
+        showToast(`Captions added (${res.cues} cues)`, 'success')
+      }
+    })
+
+    return el('div', { style: 'margin-top:0.75rem;' },
+      el('div', { style: 'font-weight:600;margin-bottom:0.25rem;' }, 'Captions'),
+      list,
+      el('div', { style: 'display:flex;gap:0.5rem;flex-wrap:wrap;align-items:center;margin-top:0.5rem;' },
+        language, label, kind, file
+      )
+    )
+  }
+
   function handleDragStart(e, index) {
     draggedElement = e.currentTarget
     draggedIndex = index
@@ -1275,6 +1604,109 @@ export function renderCreateTraining(appEl) {
     return blockEl
   }
 
+  function renderQuizEditor(block) {
+    const questions = block.content.questions || []
+    const inputStyle = 'width:100%;margin-bottom:0.5rem;padding:0.5rem;border-radius:4px;border:1px solid #e2e8f0;'
+    const setQuestion = (qIdx, field, value) => {
+      const updated = [...(block.content.questions || [])]
+      updated[qIdx] = { ...updated[qIdx], [field]: value }
+      updateBlock(block.id, 'content.questions', updated)
+    }
+
+    return el('div', {},
+      el('div', { style: 'display:flex;gap:1rem;margin-bottom:0.75rem;' },
+        el('label', { style: 'flex:1;' }, 'Pass mark (%)',
+          el('input', {
+            type: 'number', min: 0, max: 100,
+            value: block.content.pass_percent ?? 70,
+            onInput: (e) => updateBlock(block.id, 'content.pass_percent', parseInt(e.target.value) || 0),
+            style: inputStyle
+          })
+        ),
+        el('label', { style: 'flex:1;' }, 'Attempts allowed (0 = unlimited)',
+          el('input', {
+            type: 'number', min: 0,
+            value: block.content.max_attempts ?? 0,
+            onInput: (e) => updateBlock(block.id, 'content.max_attempts', parseInt(e.target.value) || 0),
+            style: inputStyle
+          })
+        )
+      ),
+      ...questions.map((q, qIdx) => el('div', { style: 'padding:0.75rem;margin-bottom:0.75rem;background:#fff;border:1px solid #e2e8f0;border-radius:6px;' },
+        el('div', { style: 'display:flex;gap:0.5rem;' },
+          el('select', {
+            value: q.type,
+            onChange: (e) => {
+              const updated = [...(block.content.questions || [])]
+              updated[qIdx] = { ...updated[qIdx], type: e.target.value, correct: e.target.value === 'true_false' ? [0] : [] }
+              updateBlock(block.id, 'content.questions', updated)
+              renderBlocks()
+            },
+            style: inputStyle + 'flex:1;'
+          },
+            el('option', { value: 'multiple_choice', selected: q.type === 'multiple_choice' || null }, 'Multiple choice'),
+            el('option', { value: 'multi_select', selected: q.type === 'multi_select' || null }, 'Multi-select'),
+            el('option', { value: 'true_false', selected: q.type === 'true_false' || null }, 'True / false'),
+            el('option', { value: 'short_answer', selected: q.type === 'short_answer' || null }, 'Short answer')
+          ),
+          el('button', {
+            class: 'btn btn-small',
+            onClick: () => {
+              const updated = [...(block.content.questions || [])]
+              updated.splice(qIdx, 1)
+              updateBlock(block.id, 'content.questions', updated)
+              renderBlocks()
+            }
+          }, '×')
+        ),
+        el('input', {
+          type: 'text',
+          placeholder: `Question ${qIdx + 1}`,
+          value: q.prompt || '',
+          onInput: (e) => setQuestion(qIdx, 'prompt', e.target.value),
+          style: inputStyle
+        }),
+        (q.type === 'multiple_choice' || q.type === 'multi_select') ? el('div', {},
+          el('textarea', {
+            placeholder: 'Options, one per line',
+            rows: 3,
+            value: (q.options || []).join('\n'),
+            onInput: (e) => setQuestion(qIdx, 'options', e.target.value.split('\n').filter(o => o.trim())),
+            style: inputStyle + 'resize:vertical;font-family:inherit;'
+          }),
+          el('input', {
+            type: 'text',
+            placeholder: q.type === 'multi_select' ? 'Correct option numbers, e.g. 1, 3' : 'Correct option number, e.g. 2',
+            value: (q.correct || []).map(c => c + 1).join(', '),
+            onInput: (e) => setQuestion(qIdx, 'correct', e.target.value.split(',').map(n => parseInt(n) - 1).filter(n => n >= 0)),
+            style: inputStyle
+          })
+        ) : null,
+        q.type === 'true_false' ? el('select', {
+          onChange: (e) => setQuestion(qIdx, 'correct', [parseInt(e.target.value)]),
+          style: inputStyle
+        },
+          el('option', { value: 0, selected: (q.correct || [0])[0] === 0 || null }, 'Answer: True'),
+          el('option', { value: 1, selected: (q.correct || [])[0] === 1 || null }, 'Answer: False')
+        ) : null,
+        q.type === 'short_answer' ? el('textarea', {
+          placeholder: 'Accepted answers, one per line (case-insensitive)',
+          rows: 2,
+          value: (q.accepted || []).join('\n'),
+          onInput: (e) => setQuestion(qIdx, 'accepted', e.target.value.split('\n').filter(a => a.trim())),
+          style: inputStyle + 'resize:vertical;font-family:inherit;'
+        }) : null
+      )),
+      el('button', {
+        class: 'btn btn-small',
+        onClick: () => {
+          updateBlock(block.id, 'content.questions', [...(block.content.questions || []), { id: `q-${Date.now()}`, type: 'multiple_choice', prompt: '', options: [], correct: [] }])
+          renderBlocks()
+        }
+      }, '+ Add Question')
+    )
+  }
+
   function getBlockIcon(type) {
     const icons = {
       title: '📝',
@@ -1284,7 +1716,8 @@ export function renderCreateTraining(appEl) {
       code: '💻',
       list: '📋',
       quote: '💬',
-      divider: '➖'
+      divider: '➖',
+      quiz: '❓'
     }
     return icons[type] || '📦'
   }
@@ -1313,7 +1746,7 @@ export function renderCreateTraining(appEl) {
         )
       case 'text':
         return el('textarea', {
-          placeholder: 'Enter text content',
+          placeholder: 'Enter text content (<b>, <i>, <a href> and other basic HTML formatting is allowed)',
           rows: 6,
           value: block.content.text || '',
           onInput: (e) => updateBlock(block.id, 'content.text', e.target.value),
@@ -1337,13 +1770,16 @@ export function renderCreateTraining(appEl) {
             },
             style: 'width:100%;margin-bottom:0.5rem;'
           }),
+          ...mediaLibraryPicker(block),
           el('input', {
+            id: `block-url-${block.id}`,
             type: 'text',
             placeholder: 'Or enter video URL',
             value: block.content.url || '',
             onInput: (e) => updateBlock(block.id, 'content.url', e.target.value),
             style: 'width:100%;padding:0.75rem;border-radius:4px;border:1px solid #e2e8f0;'
-          })
+          }),
+          captionEditor(block)
         )
       case 'image':
         return el('div', {},
@@ -1368,11 +1804,13 @@ export function renderCreateTraining(appEl) {
             },
             style: 'width:100%;margin-bottom:0.5rem;'
           }),
+          ...mediaLibraryPicker(block),
           el('img', {
             id: `image-preview-${block.id}`,
             style: 'width:100%;max-height:300px;object-fit:cover;border-radius:4px;margin-bottom:0.5rem;display:none;'
           }),
           el('input', {
+            id: `block-url-${block.id}`,
             type: 'text',
             placeholder: 'Or enter image URL',
             value: block.content.url || '',
@@ -1473,6 +1911,8 @@ export function renderCreateTraining(appEl) {
         return el('div', { style: 'text-align:center;padding:1rem;' },
           el('hr', { style: 'border:none;border-top:2px dashed #cbd5e1;' })
         )
+      case 'quiz':
+        return renderQuizEditor(block)
       default:
         return el('div', { class: 'muted' }, 'Unknown block type')
     }
@@ -1531,6 +1971,11 @@ export function renderCreateTraining(appEl) {
       title,
       description,
       thumbnail_url: thumbnailUrl,
+      category: document.getElementById('training-category').value.trim(),
+      tags: document.getElementById('training-tags').value.split(',').map(t => t.trim()).filter(t => t),
+      validity_days: parseInt(document.getElementById('training-validity').value) || 0,
+      prerequisites: [...document.getElementById('training-prerequisites').selectedOptions].map(o => o.value),
+      publish: true,
       blocks: processedBlocks.map((b, idx) => ({
         id: b.id,
         type: b.type,
@@ -1543,7 +1988,7 @@ export function renderCreateTraining(appEl) {
       showToast('Training created successfully!', 'success')
       navigate('/training')
     } else {
-      showToast(res.error || 'Failed to create training', 'error')
+      showToast(blockErrorMessage(res) || 'Failed to create training', 'error')
     }
   }
 
@@ -1558,7 +2003,7 @@ export function renderTrainingView(appEl, trainingData) {
 
   const header = el('div', { class: 'training-view-header' },
     el('button', { class: 'btn', onClick: () => navigate('/training') }, '← Back to Trainings'),
-    trainingData.thumbnail_url ? el('img', { src: trainingData.thumbnail_url, style: 'width:100%;max-width:600px;max-height:300px;object-fit:cover;border-radius:12px;margin-bottom:1.5rem;' }) : null,
+    trainingData.thumbnail_url ? el('img', { src: imageSize(trainingData.thumbnail_url, 'medium'), style: 'width:100%;max-width:600px;max-height:300px;object-fit:cover;border-radius:12px;margin-bottom:1.5rem;' }) : null,
     el('h1', {}, trainingData.title),
     el('p', { class: 'muted' }, trainingData.description || '')
   )
@@ -1567,7 +2012,11 @@ export function renderTrainingView(appEl, trainingData) {
   let content
   if (trainingData.blocks && trainingData.blocks.length > 0) {
     content = el('div', { class: 'training-view-content' },
-      ...trainingData.blocks.sort((a, b) => (a.order || 0) - (b.order || 0)).map(block => renderBlockView(block))
+      ...trainingData.blocks.sort((a, b) => (a.order || 0) - (b.order || 0)).map(block => {
+        const blockEl = renderBlockView(block, trainingData)
+        blockEl.dataset.blockId = block.id
+        return blockEl
+      })
     )
   } else {
     // Fallback for old format
@@ -1588,11 +2037,116 @@ export function renderTrainingView(appEl, trainingData) {
     )
   }
 
+  if (trainingData.created_by === user.email) {
+    header.appendChild(el('a', {
+      class: 'btn',
+      href: training.markdownExportUrl(trainingData.id, user.email),
+      download: ''
+    }, '⬇ Export Markdown'))
+    header.appendChild(el('a', {
+      class: 'btn',
+      href: training.packageExportUrl(trainingData.id, user.email),
+      download: ''
+    }, '📦 Export Package'))
+  }
+
   appEl.appendChild(header)
   appEl.appendChild(content)
+
+  // Authors preview their working copy, so only learners' progress is tracked
+  if (trainingData.created_by !== user.email) {
+    const progressEl = el('p', { class: 'muted', style: 'font-weight:600;' }, '')
+    header.appendChild(progressEl)
+    trackTrainingProgress(trainingData, user.email, content, progressEl)
+  }
+}
+
+// Reports blocks to the server as the learner scrolls to them, and video watch
+// positions as they play, resuming videos where the learner left off
+async function trackTrainingProgress(trainingData, email, content, progressEl) {
+  const showProgress = (progress) => {
+    if (!progress) return
+    progressEl.innerHTML = ''
+    progressEl.append(progress.completed_at ? '✅ Completed' : `Progress: ${progress.percent}%`)
+    if (progress.certificate_id) {
+      progressEl.append(' · ', el('a', {
+        href: training.certificateDownloadUrl(progress.certificate_id, email),
+        download: `certificate-${progress.certificate_id}.pdf`
+      }, '📜 Download certificate'))
+    }
+  }
+  const report = async (blockId, position = 0, duration = 0) => {
+    const res = await training.recordProgress(trainingData.id, email, blockId, position, duration)
+    if (res && res.ok) {
+      showProgress(res.progress)
+      if (res.completed) showToast('Training completed!', 'success')
+    }
+  }
+
+  // Quizzes report their own progress when passed
+  content.addEventListener('training-progress', (e) => {
+    showProgress(e.detail.progress)
+    if (e.detail.completed) showToast('Training completed!', 'success')
+  })
+
+  const progress = await training.getProgress(email, trainingData.id)
+  showProgress(progress)
+  if (!trainingData.blocks || trainingData.blocks.length === 0) {
+    report('')
+    return
+  }
+
+  const viewed = new Set(progress?.blocks_viewed || [])
+  const positions = progress?.video_positions || {}
+
+  const observer = new IntersectionObserver(entries => {
+    entries.forEach(entry => {
+      if (!entry.isIntersecting) return
+      const blockId = entry.target.dataset.blockId
+      observer.unobserve(entry.target)
+      if (!viewed.has(blockId)) {
+        viewed.add(blockId)
+        report(blockId)
+      }
+    })
+  }, { threshold: 0.5 })
+
+  content.querySelectorAll('[data-block-id]').forEach(blockEl => {
+    if (blockEl.querySelector('[data-question-id]')) return
+    const video = blockEl.querySelector('video')
+    if (!video) {
+      observer.observe(blockEl)
+      return
+    }
+
+    const blockId = blockEl.dataset.blockId
+    let lastReported = 0
+    const reportVideo = () => {
+      lastReported = Date.now()
+      report(blockId, video.currentTime, video.duration || 0)
+    }
+    video.addEventListener('loadedmetadata', () => {
+      const resumeAt = positions[blockId]
+      if (resumeAt && resumeAt < video.duration) video.currentTime = resumeAt
+    })
+    video.addEventListener('timeupdate', () => {
+      if (Date.now() - lastReported > 15000) reportVideo()
+    })
+    video.addEventListener('pause', reportVideo)
+    video.addEventListener('ended', reportVideo)
+  })
 }
 
-function renderBlockView(block) {
+// blockErrorMessage turns the first block validation error into a message
+// naming the block by its position in the editor
+function blockErrorMessage(res) {
+  const first = res.errors && res.errors[0]
+  const match = first && /^blocks\[(\d+)\]/.exec(first.field)
+  if (!match) return res.error
+  return `Block ${parseInt(match[1]) + 1}: ${first.message}`
+}
+
+function renderBlockView(block, trainingData) {
   switch (block.type) {
     case 'title':
       const level = block.content?.level || 'h2'
@@ -1602,21 +2156,39 @@ function renderBlockView(block) {
       titleEl.style.margin = '2rem 0 1rem 0'
       return titleEl
     case 'text':
-      return el('div', { class: 'card', style: 'margin-bottom:1.5rem;padding:1.5rem;' },
-        el('div', { style: 'white-space:pre-wrap;line-height:1.8;' }, block.content?.text || '')
-      )
+      // Text is HTML sanitized by the server
+      const textEl = el('div', { style: 'white-space:pre-wrap;line-height:1.8;' })
+      textEl.innerHTML = block.content?.text || ''
+      return el('div', { class: 'card', style: 'margin-bottom:1.5rem;padding:1.5rem;' }, textEl)
     case 'video':
+      const captions = block.content?.captions || []
+      const transcriptTrack = captions.find(t => t.default) || captions[0]
       return el('div', { class: 'card', style: 'margin-bottom:1.5rem;' },
         el('video', {
           src: block.content?.url || '',
           controls: true,
+          preload: 'metadata',
           style: 'width:100%;max-height:500px;border-radius:12px;display:block;'
-        })
+        },
+          ...captions.map(t => el('track', {
+            kind: t.kind || 'captions',
+            src: t.url,
+            srclang: t.language,
+            label: t.label,
+            default: t.default ? '' : null
+          }))
+        ),
+        transcriptTrack?.transcript ? el('details', { style: 'margin-top:0.75rem;' },
+          el('summary', { style: 'cursor:pointer;color:#4a5568;' }, 'Transcript'),
+          el('div', { style: 'white-space:pre-wrap;line-height:1.7;max-height:300px;overflow-y:auto;margin-top:0.5rem;' }, transcriptTrack.transcript)
+        ) : null
       )
     case 'image':
       return el('div', { class: 'card', style: 'margin-bottom:1.5rem;padding:0;overflow:hidden;' },
         el('img', {
-          src: block.content?.url || '',
+          src: imageSize(block.content?.url, 'medium') || '',
+          srcset: imageSrcset(block.content?.url),
+          sizes: imageSrcset(block.content?.url) ? '(max-width: 900px) 100vw, 900px' : null,
           alt: block.content?.alt || '',
           style: 'width:100%;max-height:500px;object-fit:cover;display:block;'
         })
@@ -1645,11 +2217,130 @@ function renderBlockView(block) {
       return el('div', { style: 'margin:2rem 0;text-align:center;' },
         el('hr', { style: 'border:none;border-top:2px dashed #cbd5e1;' })
       )
+    case 'quiz':
+      return renderQuizView(block, trainingData)
+    case 'scorm':
+      return renderScormView(block, trainingData)
     default:
       return el('div', { class: 'card', style: 'margin-bottom:1.5rem;padding:1.5rem;' }, 'Unknown block type')
   }
 }
 
+// Renders a quiz for the learner; answers are graded by the server
+function renderQuizView(block, trainingData) {
+  const user = auth.getCurrentUser()
+  const questions = block.content?.questions || []
+  const answers = {}
+  const resultEl = el('div', { style: 'margin-top:1rem;font-weight:600;' })
+
+  const questionEls = questions.map((q, qIdx) => {
+    const name = `${block.id}-${q.id}`
+    let input
+    if (q.type === 'short_answer') {
+      input = el('input', {
+        type: 'text',
+        placeholder: 'Your answer',
+        onInput: (e) => { answers[q.id] = { text: e.target.value } },
+        style: 'width:100%;padding:0.5rem;border-radius:4px;border:1px solid #e2e8f0;'
+      })
+    } else {
+      const options = q.type === 'true_false' ? ['True', 'False'] : (q.options || [])
+      const multi = q.type === 'multi_select'
+      input = el('div', {},
+        ...options.map((option, oIdx) => el('label', { style: 'display:block;margin:0.25rem 0;cursor:pointer;' },
+          el('input', {
+            type: multi ? 'checkbox' : 'radio',
+            name,
+            value: oIdx,
+            onChange: (e) => {
+              if (!multi) {
+                answers[q.id] = { selected: [oIdx] }
+                return
+              }
+              const selected = new Set(answers[q.id]?.selected || [])
+              if (e.target.checked) selected.add(oIdx)
+              else selected.delete(oIdx)
+              answers[q.id] = { selected: [...selected] }
+            }
+          }),
+          ` ${option}`
+        ))
+      )
+    }
+    return el('div', { 'data-question-id': q.id, style: 'margin-bottom:1rem;padding:0.75rem;border-radius:8px;border:1px solid #e2e8f0;' },
+      el('p', { style: 'margin:0 0 0.5rem 0;font-weight:600;' }, `${qIdx + 1}. ${q.prompt}`),
+      input
+    )
+  })
+
+  const container = el('div', { class: 'card', style: 'margin-bottom:1.5rem;padding:1.5rem;' },
+    el('h3', { style: 'margin-top:0;' }, block.content?.title || '❓ Quiz'),
+    el('p', { class: 'muted' },
+      `Pass mark: ${block.content?.pass_percent || 70}%` +
+      (block.content?.max_attempts ? ` · ${block.content.max_attempts} attempt(s) allowed` : '')
+    ),
+    ...questionEls,
+    el('button', {
+      class: 'btn primary',
+      onClick: async () => {
+        try {
+          const res = await training.submitQuiz(trainingData.id, user.email, block.id, answers)
+          if (!res.ok) {
+            showToast(res.error || 'Failed to submit quiz', 'error')
+            return
+          }
+          const attempt = res.attempt
+          attempt.results.forEach(r => {
+            const qEl = container.querySelector(`[data-question-id="${r.question_id}"]`)
+            if (qEl) qEl.style.borderColor = r.correct ? '#16a34a' : '#dc2626'
+          })
+          const remaining = res.attempts_remaining !== undefined ? ` · ${res.attempts_remaining} attempt(s) left` : ''
+          resultEl.textContent = `${attempt.passed ? '✅ Passed' : '❌ Not passed'}: ${attempt.percent}% (${attempt.score}/${attempt.max_score})${remaining}`
+          if (res.progress) {
+            container.dispatchEvent(new CustomEvent('training-progress', { bubbles: true, detail: res }))
+          }
+        } catch (e) {
+          showToast(e.message || 'Failed to submit quiz', 'error')
+        }
+      }
+    }, 'Submit Answers'),
+    resultEl
+  )
+  return container
+}
+
+// Plays a SCORM package in a sandboxed iframe, saving the runtime values it
+// commits
+function renderScormView(block, trainingData) {
+  const user = auth.getCurrentUser()
+  const frame = el('iframe', {
+    title: trainingData.title,
+    style: 'width:100%;height:640px;border:none;border-radius:12px;display:block;background:#fff;'
+  })
+  const container = el('div', { class: 'card', style: 'margin-bottom:1.5rem;padding:0;overflow:hidden;' }, frame)
+
+  training.getScormRuntime(trainingData.id, user.email, block.id).then(res => {
+    if (!res.ok) {
+      container.replaceChildren(el('p', { class: 'muted', style: 'padding:1.5rem;' }, res.error || 'This course could not be loaded'))
+      return
+    }
+    playScorm(frame, {
+      url: block.content.launch_url,
+      version: res.version,
+      values: res.values,
+      commit: async (values) => {
+        const saved = await training.commitScormRuntime(trainingData.id, user.email, block.id, values)
+        if (saved.ok && saved.progress) {
+          container.dispatchEvent(new CustomEvent('training-progress', { bubbles: true, detail: saved }))
+        } else if (!saved.ok) {
+          showToast(saved.error || 'Failed to save course progress', 'error')
+        }
+      }
+    })
+  })
+  return container
+}
+
 export async function renderRecyclingBin(appEl) {
   const user = auth.getCurrentUser()
   if (!user) { navigate('/login'); return }
@@ -1690,7 +2381,7 @@ export async function renderRecyclingBin(appEl) {
       el('h2', { style: 'margin-bottom:1rem;' }, 'Deleted Trainings'),
       el('div', { class: 'trainings-grid' },
         ...deletedTrainings.map(t => el('div', { class: 'card training-item-card', style: 'opacity:0.8;' },
-          t.thumbnail_url ? el('img', { src: t.thumbnail_url, style: 'width:100%;max-height:250px;object-fit:cover;border-radius:12px 12px 0 0;margin:-1rem -1rem 1rem -1rem;display:block;' }) : null,
+          t.thumbnail_url ? el('img', { src: imageSize(t.thumbnail_url, 'small'), style: 'width:100%;max-height:250px;object-fit:cover;border-radius:12px 12px 0 0;margin:-1rem -1rem 1rem -1rem;display:block;' }) : null,
           el('div', { class: 'training-item-content', style: 'padding:0;' },
             el('h3', { style: 'margin:0 0 0.5rem 0;' }, t.title),
             el('p', { class: 'muted', style: 'margin:0 0 1rem 0;' }, t.description || 'No description'),
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// reminderLeadTime is how long before the due date a "due soon" reminder goes out
+const reminderLeadTime = 48 * time.Hour
+
+// overdueReminderInterval is how often overdue assignments are re-sent a reminder
+const overdueReminderInterval = 24 * time.Hour
+
+// AssignmentHandlers contains all assignment-related HTTP handlers
+type AssignmentHandlers struct {
+	store     *AssignmentStore
+	trainings *TrainingStore
+	teams     *TeamStore
+	paths     *PathStore
+	users     *UserStore
+	notifier  Notifier
+}
+
+// NewAssignmentHandlers creates a new AssignmentHandlers instance
+func NewAssignmentHandlers(store *AssignmentStore, trainings *TrainingStore, teams *TeamStore, paths *PathStore, users *UserStore, notifier Notifier) *AssignmentHandlers {
+	return &AssignmentHandlers{store: store, trainings: trainings, teams: teams, paths: paths, users: users, notifier: notifier}
+}
+
+// HandleCreateAssignments handles assigning a training, or every training in
+// a learning path, to users and/or teams. Team assignments are expanded to
+// one assignment per current member, and only the team's lead or members
+// can assign to a team; users who already have a training open are skipped
+// for it.
+func (h *AssignmentHandlers) HandleCreateAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email      string   `json:"email"`
+		TrainingID string   `json:"training_id,omitempty"`
+		PathID     string   `json:"path_id,omitempty"` // Assign every training in the path instead
+		Assignees  []string `json:"assignees,omitempty"`
+		TeamIDs    []string `json:"team_ids,omitempty"`
+		Due        string   `json:"due"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	assigner := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, ok := h.users.get(assigner); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	trainingIDs := []string{req.TrainingID}
+	if req.PathID != "" {
+		path, ok := h.paths.get(req.PathID)
+		if !ok {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "learning path not found"})
+			return
+		}
+		trainingIDs = path.TrainingIDs
+	}
+	trainings := make([]Training, 0, len(trainingIDs))
+	for _, id := range trainingIDs {
+		training, ok := h.trainings.get(id)
+		if !ok || training.DeletedAt != nil || !training.isPublished() {
+			if req.PathID != "" {
+				continue // Paths skip trainings that have since been withdrawn
+			}
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found or not published"})
+			return
+		}
+		trainings = append(trainings, training)
+	}
+	if len(trainings) == 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "learning path has no published trainings"})
+		return
+	}
+
+	due, err := parseDateTime(req.Due, true)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "due: " + err.Error()})
+		return
+	}
+	now := time.Now()
+	if !due.After(now) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "due date must be in the future"})
+		return
+	}
+
+	// Work out who is being assigned, remembering which team they came from
+	targets := map[string]string{}
+	for _, e := range req.Assignees {
+		email := strings.ToLower(strings.TrimSpace(e))
+		if email == "" {
+			continue
+		}
+		if _, ok := h.users.get(email); !ok {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "user " + email + " not found"})
+			return
+		}
+		targets[email] = ""
+	}
+	for _, id := range req.TeamIDs {
+		team, ok := h.teams.get(id)
+		if !ok {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "team not found"})
+			return
+		}
+		if team.Lead != assigner && !team.hasMember(assigner) {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "only the team's lead and members can assign trainings to " + team.Name})
+			return
+		}
+		for _, member := range team.Members {
+			if _, direct := targets[member]; !direct {
+				targets[member] = team.ID
+			}
+		}
+	}
+	if len(targets) == 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "assignees or team_ids required"})
+		return
+	}
+
+	created := make([]Assignment, 0, len(targets)*len(trainings))
+	skipped := make([]string, 0)
+	for email, teamID := range targets {
+		for _, training := range trainings {
+			if _, open := h.store.openFor(email, training.ID); open {
+				if len(skipped) == 0 || skipped[len(skipped)-1] != email {
+					skipped = append(skipped, email)
+				}
+				continue
+			}
+			created = append(created, Assignment{
+				ID:            uuid.New().String(),
+				TrainingID:    training.ID,
+				TrainingTitle: training.Title,
+				Assignee:      email,
+				AssignedBy:    assigner,
+				TeamID:        teamID,
+				PathID:        req.PathID,
+				DueAt:         due,
+				Status:        assignmentAssigned,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+			})
+		}
+	}
+
+	if err := h.store.putAll(created); err != nil {
+		logError("failed to save assignments", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to create assignments"})
+		return
+	}
+
+	for _, a := range created {
+		h.notify(a, "assignment", fmt.Sprintf("New training assigned: %s", a.TrainingTitle),
+			fmt.Sprintf("%s assigned you \"%s\". Please complete it by %s.", a.AssignedBy, a.TrainingTitle, a.DueAt.Format("Mon Jan 2, 2006")))
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"assignments": viewAssignments(created, now),
+		"skipped":     skipped,
+	})
+}
+
+// HandleGetAssignments handles listing the assignments a trainer has made,
+// optionally for one training
+func (h *AssignmentHandlers) HandleGetAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	trainingID := r.URL.Query().Get("training_id")
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	assignments := h.store.find(func(a Assignment) bool {
+		return a.AssignedBy == email && (trainingID == "" || a.TrainingID == trainingID)
+	})
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"assignments": viewAssignments(assignments, time.Now()),
+	})
+}
+
+// HandleGetMyAssignments handles listing a learner's own assignments. By
+// default only open ones are returned; pass status=all for everything.
+func (h *AssignmentHandlers) HandleGetMyAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = assignmentAssigned
+	}
+
+	assignments := h.store.find(func(a Assignment) bool {
+		return a.Assignee == email && (status == "all" || a.Status == status)
+	})
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"assignments": viewAssignments(assignments, time.Now()),
+	})
+}
+
+// HandleGetOverdue handles listing overdue assignments a trainer has made
+func (h *AssignmentHandlers) HandleGetOverdue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	now := time.Now()
+	assignments := h.store.find(func(a Assignment) bool {
+		return a.AssignedBy == email && a.isOverdue(now)
+	})
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"assignments": viewAssignments(assignments, now),
+	})
+}
+
+// HandleCancelAssignment handles a trainer withdrawing an assignment
+func (h *AssignmentHandlers) HandleCancelAssignment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	a, ok := h.store.get(req.ID)
+	if !ok || a.AssignedBy != strings.ToLower(strings.TrimSpace(req.Email)) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "assignment not found"})
+		return
+	}
+	if a.Status != assignmentAssigned {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "assignment is already " + a.Status})
+		return
+	}
+
+	a.Status = assignmentCancelled
+	a.UpdatedAt = time.Now()
+	if err := h.store.put(a); err != nil {
+		logError("failed to cancel assignment", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to cancel assignment"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
+
+// HandleSendReminders handles running the reminder pass immediately, which
+// is handy for checking reminders locally without waiting for the scheduler
+func (h *AssignmentHandlers) HandleSendReminders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"sent": h.sendReminders(time.Now()),
+	})
+}
+
+// runReminders sends reminders on a fixed interval until the process exits
+func (h *AssignmentHandlers) runReminders(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if sent := h.sendReminders(now); sent > 0 {
+			log.Printf("Sent %d assignment reminders", sent)
+		}
+	}
+}
+
+// sendReminders notifies learners whose assignments are due soon (once) or
+// overdue (once per overdueReminderInterval) and returns how many were sent
+func (h *AssignmentHandlers) sendReminders(now time.Time) int {
+	sent := 0
+	for _, a := range h.store.find(func(a Assignment) bool { return a.Status == assignmentAssigned }) {
+		switch {
+		case a.isOverdue(now):
+			if a.LastRemindedAt != nil && a.LastRemindedAt.After(a.DueAt) && now.Sub(*a.LastRemindedAt) < overdueReminderInterval {
+				continue
+			}
+			h.notify(a, "reminder", fmt.Sprintf("Overdue training: %s", a.TrainingTitle),
+				fmt.Sprintf("\"%s\" was due %s. Please complete it as soon as possible.", a.TrainingTitle, a.DueAt.Format("Mon Jan 2, 2006")))
+		case a.DueAt.Sub(now) <= reminderLeadTime:
+			if a.RemindersSent > 0 {
+				continue
+			}
+			h.notify(a, "reminder", fmt.Sprintf("Training due soon: %s", a.TrainingTitle),
+				fmt.Sprintf("\"%s\" is due %s.", a.TrainingTitle, a.DueAt.Format("Mon Jan 2, 2006 3:04 PM")))
+		default:
+			continue
+		}
+
+		if err := h.store.markReminded(a.ID, now); err != nil {
+			logError("failed to record reminder", err)
+		}
+		sent++
+	}
+	return sent
+}
+
+// notify sends a notification about an assignment to its assignee
+func (h *AssignmentHandlers) notify(a Assignment, kind, subject, body string) {
+	err := h.notifier.Notify(Notification{
+		To:      a.Assignee,
+		Kind:    kind,
+		Subject: subject,
+		Body:    body,
+		Link:    "/training/view?id=" + a.TrainingID,
+	})
+	if err != nil {
+		logError("failed to send notification", err)
+	}
+}
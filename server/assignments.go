@@ -0,0 +1,186 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Assignment requires a user to complete a training by a due date
+type Assignment struct {
+	ID             string     `json:"id"`
+	TrainingID     string     `json:"training_id"`
+	TrainingTitle  string     `json:"training_title"`
+	Assignee       string     `json:"assignee"`    // Email of the learner
+	AssignedBy     string     `json:"assigned_by"` // Email of the trainer
+	TeamID         string     `json:"team_id,omitempty"`
+	PathID         string     `json:"path_id,omitempty"` // Set when assigned as part of a learning path
+	DueAt          time.Time  `json:"due_at"`
+	Status         string     `json:"status"` // assigned, completed, cancelled
+	CompletedAt    *time.Time `json:"completed_at,omitempty"`
+	RemindersSent  int        `json:"reminders_sent"`
+	LastRemindedAt *time.Time `json:"last_reminded_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// Assignment statuses
+const (
+	assignmentAssigned  = "assigned"
+	assignmentCompleted = "completed"
+	assignmentCancelled = "cancelled"
+)
+
+// isOverdue reports whether an open assignment is past its due date
+func (a Assignment) isOverdue(now time.Time) bool {
+	return a.Status == assignmentAssigned && now.After(a.DueAt)
+}
+
+// AssignmentView is an assignment with its overdue state worked out
+type AssignmentView struct {
+	Assignment
+	Overdue bool `json:"overdue"`
+}
+
+// viewAssignments adds overdue state to a list of assignments
+func viewAssignments(assignments []Assignment, now time.Time) []AssignmentView {
+	views := make([]AssignmentView, 0, len(assignments))
+	for _, a := range assignments {
+		views = append(views, AssignmentView{Assignment: a, Overdue: a.isOverdue(now)})
+	}
+	return views
+}
+
+// AssignmentStore manages training assignments
+type AssignmentStore struct {
+	mu          sync.Mutex
+	Assignments map[string]Assignment `json:"assignments"`
+	file        string
+}
+
+// NewAssignmentStore creates a new assignment store
+func NewAssignmentStore(path string) *AssignmentStore {
+	s := &AssignmentStore{Assignments: map[string]Assignment{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *AssignmentStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var assignments map[string]Assignment
+	if err := readJSONFile(s.file, &assignments); err != nil {
+		logError("failed to load assignments", err)
+		return
+	}
+	if assignments != nil {
+		s.Assignments = assignments
+	}
+}
+
+func (s *AssignmentStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Assignments)
+}
+
+func (s *AssignmentStore) get(id string) (Assignment, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.Assignments[id]
+	return a, ok
+}
+
+func (s *AssignmentStore) put(a Assignment) error {
+	s.mu.Lock()
+	s.Assignments[a.ID] = a
+	s.mu.Unlock()
+	return s.save()
+}
+
+// putAll stores several assignments with a single save
+func (s *AssignmentStore) putAll(assignments []Assignment) error {
+	s.mu.Lock()
+	for _, a := range assignments {
+		s.Assignments[a.ID] = a
+	}
+	s.mu.Unlock()
+	return s.save()
+}
+
+// find returns the assignments matching keep, ordered by due date
+func (s *AssignmentStore) find(keep func(Assignment) bool) []Assignment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	assignments := make([]Assignment, 0)
+	for _, a := range s.Assignments {
+		if keep(a) {
+			assignments = append(assignments, a)
+		}
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].DueAt.Before(assignments[j].DueAt) })
+	return assignments
+}
+
+// pathsFor returns the IDs of the learning paths a user has been assigned,
+// cancelled assignments aside
+func (s *AssignmentStore) pathsFor(assignee string) map[string]bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := map[string]bool{}
+	for _, a := range s.Assignments {
+		if a.Assignee == assignee && a.PathID != "" && a.Status != assignmentCancelled {
+			paths[a.PathID] = true
+		}
+	}
+	return paths
+}
+
+// openFor returns the user's open assignment for a training, if any
+func (s *AssignmentStore) openFor(assignee, trainingID string) (Assignment, bool) {
+	open := s.find(func(a Assignment) bool {
+		return a.Assignee == assignee && a.TrainingID == trainingID && a.Status == assignmentAssigned
+	})
+	if len(open) == 0 {
+		return Assignment{}, false
+	}
+	return open[0], true
+}
+
+// complete marks a user's open assignments for a training as completed and
+// returns them
+func (s *AssignmentStore) complete(assignee, trainingID string, at time.Time) ([]Assignment, error) {
+	s.mu.Lock()
+	completed := make([]Assignment, 0)
+	for id, a := range s.Assignments {
+		if a.Assignee == assignee && a.TrainingID == trainingID && a.Status == assignmentAssigned {
+			a.Status = assignmentCompleted
+			a.CompletedAt = &at
+			a.UpdatedAt = at
+			s.Assignments[id] = a
+			completed = append(completed, a)
+		}
+	}
+	s.mu.Unlock()
+	if len(completed) == 0 {
+		return completed, nil
+	}
+	return completed, s.save()
+}
+
+// markReminded records a reminder against an assignment, unless it was
+// completed or cancelled in the meantime
+func (s *AssignmentStore) markReminded(id string, at time.Time) error {
+	s.mu.Lock()
+	a, ok := s.Assignments[id]
+	if !ok || a.Status != assignmentAssigned {
+		s.mu.Unlock()
+		return nil
+	}
+	a.RemindersSent++
+	a.LastRemindedAt = &at
+	a.UpdatedAt = at
+	s.Assignments[id] = a
+	s.mu.Unlock()
+	return s.save()
+}
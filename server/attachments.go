@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Attachment is a photo, manual or receipt attached to an inventory item
+type Attachment struct {
+	ID           string    `json:"id"`
+	Kind         string    `json:"kind"`     // image, document
+	Filename     string    `json:"filename"` // Original filename as uploaded
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"` // Only set for images
+	UploadedBy   string    `json:"uploaded_by"`
+	UploadedAt   time.Time `json:"uploaded_at"`
+}
+
+// attachmentTypes maps the content types detectUpload allows for attachments
+// to a kind and file extension
+var attachmentTypes = map[string]struct{ kind, ext string }{
+	"image/jpeg":      {"image", ".jpg"},
+	"image/png":       {"image", ".png"},
+	"image/gif":       {"image", ".gif"},
+	"image/webp":      {"image", ".webp"},
+	"application/pdf": {"document", ".pdf"},
+}
+
+// maxAttachmentsPerItem limits how many files can be attached to one item
+const maxAttachmentsPerItem = 20
+
+// HandleGetAttachments handles listing the attachments of an inventory item
+func (h *Handlers) HandleGetAttachments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	itemID := r.URL.Query().Get("item_id")
+	if email == "" || itemID == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email and item_id required"})
+		return
+	}
+
+	u, ok := h.store.get(email)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	item, ok := findItem(&u, itemID)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "item not found"})
+		return
+	}
+
+	attachments := item.Attachments
+	if attachments == nil {
+		attachments = []Attachment{}
+	}
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"attachments": attachments,
+	})
+}
+
+// HandleUploadAttachment handles uploading an image or PDF to an inventory item
+func (h *Handlers) HandleUploadAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	itemID := r.URL.Query().Get("item_id")
+	if email == "" || itemID == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email and item_id required"})
+		return
+	}
+
+	// Parse multipart form (max 20MB for attachments)
+	err := r.ParseMultipartForm(20 << 20)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "file too large or invalid"})
+		return
+	}
+
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "no file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	// Validate file type from its content
+	detected, err := detectUpload(file, handler.Size, uploadKindAttachment, handler.Header.Get("Content-Type"), handler.Filename)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	contentType := detected.MIME
+	fileType := attachmentTypes[contentType]
+
+	u, ok := h.store.get(email)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	item, ok := findItem(&u, itemID)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "item not found"})
+		return
+	}
+
+	if len(item.Attachments) >= maxAttachmentsPerItem {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("too many attachments (max %d per item)", maxAttachmentsPerItem)})
+		return
+	}
+
+	// Generate unique filename
+	id := uuid.New().String()
+	filename := id + fileType.ext
+	uploadFilePath := filepath.Join(h.attachmentPath, filename)
+
+	// Create file
+	dst, err := os.Create(uploadFilePath)
+	if err != nil {
+		logError("failed to create file", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save file"})
+		return
+	}
+
+	// Copy file
+	size, err := io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
+		logError("failed to copy file", err)
+		os.Remove(uploadFilePath)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save file"})
+		return
+	}
+
+	attachment := Attachment{
+		ID:          id,
+		Kind:        fileType.kind,
+		Filename:    displayFilename(handler.Filename),
+		ContentType: contentType,
+		Size:        size,
+		URL:         fmt.Sprintf("/uploads/attachments/%s", filename),
+		UploadedBy:  email,
+		UploadedAt:  time.Now(),
+	}
+
+	// Generate a thumbnail for images; a failure here doesn't reject the upload
+	if fileType.kind == "image" {
+		thumbName := id + ".jpg"
+		if err := generateThumbnail(uploadFilePath, filepath.Join(h.attachmentPath, "thumbs", thumbName), thumbnailSize); err != nil {
+			logError("failed to generate thumbnail", err)
+		} else {
+			attachment.ThumbnailURL = fmt.Sprintf("/uploads/attachments/thumbs/%s", thumbName)
+		}
+	}
+
+	// The item may have changed while the file was saved, so it is checked
+	// again under the lock
+	var attachErr error // Reported to the user, unlike failures to save
+	err = h.store.transact([]string{email}, func(users []*User) error {
+		item, ok := findItem(users[0], itemID)
+		if !ok {
+			attachErr = fmt.Errorf("item not found")
+			return attachErr
+		}
+		if len(item.Attachments) >= maxAttachmentsPerItem {
+			attachErr = fmt.Errorf("too many attachments (max %d per item)", maxAttachmentsPerItem)
+			return attachErr
+		}
+		item.Attachments = append(item.Attachments, attachment)
+		item.UpdatedAt = time.Now()
+		users[0].UpdatedAt = time.Now()
+		return nil
+	})
+	if err != nil {
+		h.removeAttachmentFiles([]Attachment{attachment})
+		if attachErr != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": attachErr.Error()})
+			return
+		}
+		logError("failed to save attachment", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save attachment"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":         true,
+		"attachment": attachment,
+	})
+}
+
+// HandleDeleteAttachment handles removing an attachment from an inventory item
+func (h *Handlers) HandleDeleteAttachment(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email        string `json:"email"`
+		ItemID       string `json:"item_id"`
+		AttachmentID string `json:"attachment_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, ok := h.store.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	var removed Attachment
+	var deleteErr error // Reported to the user, unlike failures to save
+	err := h.store.transact([]string{email}, func(users []*User) error {
+		item, ok := findItem(users[0], req.ItemID)
+		if !ok {
+			deleteErr = fmt.Errorf("item not found")
+			return deleteErr
+		}
+
+		idx := -1
+		for i, a := range item.Attachments {
+			if a.ID == req.AttachmentID {
+				idx = i
+				break
+			}
+		}
+		if idx == -1 {
+			deleteErr = fmt.Errorf("attachment not found")
+			return deleteErr
+		}
+
+		removed = item.Attachments[idx]
+		item.Attachments = append(item.Attachments[:idx], item.Attachments[idx+1:]...)
+		item.UpdatedAt = time.Now()
+		users[0].UpdatedAt = time.Now()
+		return nil
+	})
+	if deleteErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": deleteErr.Error()})
+		return
+	}
+	if err != nil {
+		logError("failed to delete attachment", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to delete attachment"})
+		return
+	}
+
+	h.removeAttachmentFiles([]Attachment{removed})
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
+
+// removeAttachmentFiles deletes the stored files (and thumbnails) of attachments
+func (h *Handlers) removeAttachmentFiles(attachments []Attachment) {
+	for _, a := range attachments {
+		for _, url := range []string{a.URL, a.ThumbnailURL} {
+			rel := strings.TrimPrefix(url, "/uploads/attachments/")
+			if url == "" || rel == url {
+				continue
+			}
+			if err := os.Remove(filepath.Join(h.attachmentPath, filepath.FromSlash(rel))); err != nil && !os.IsNotExist(err) {
+				logError("failed to remove attachment file", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Captions are uploaded as WebVTT or SubRip (SRT) files and stored as WebVTT,
+// which is what browsers play through <track>. Every file is parsed in full
+// and written back out, so what is served has been checked cue by cue: cue
+// text keeps only the markup WebVTT defines and STYLE, REGION and NOTE blocks
+// are dropped. The plain text of the cues is kept on the video block as a
+// transcript, for reading alongside the video and for search.
+
+const (
+	maxCaptionFileSize  = 2 << 20
+	maxCaptionCues      = 20000
+	maxCaptionTracks    = 20
+	maxTranscriptLength = 200000
+	uploadKindCaption   = "caption" // Captions are uploaded separately from other media; see HandleUploadCaptions
+)
+
+// Caption kinds, as in the kind attribute of <track>
+const (
+	captionKindSubtitles = "subtitles" // Translation of the dialogue
+	captionKindCaptions  = "captions"  // Dialogue and sound effects, for viewers who can't hear them
+)
+
+// captionCue is one timed piece of caption text
+type captionCue struct {
+	ID       string
+	Start    time.Duration
+	End      time.Duration
+	Settings string
+	Text     string // WebVTT cue text
+}
+
+var (
+	// mm:ss.ttt or hh:mm:ss.ttt; SRT uses a comma before the milliseconds
+	captionTimestamp = regexp.MustCompile(`^(?:(\d{1,3}):)?([0-5]\d):([0-5]\d)[.,](\d{3})$`)
+	// Anything that looks like a tag in cue text: a timestamp, as used by
+	// karaoke style cues, a named tag, or other markup
+	captionTag          = regexp.MustCompile(`<(?:\d{1,3}:)?[0-5]\d:[0-5]\d\.\d{3}>|<(/?)([a-zA-Z]+)([^>\n]*)>|<[^>\n]*>`)
+	captionTimestampTag = regexp.MustCompile(`^<(?:\d{1,3}:)?[0-5]\d:[0-5]\d\.\d{3}>$`)
+	// Cue settings WebVTT defines
+	captionSetting = regexp.MustCompile(`^(vertical|line|position|size|align|region):\S+$`)
+	// Tag classes and voice names are kept to simple values
+	captionClass = regexp.MustCompile(`^(\.[a-zA-Z0-9_-]+)*$`)
+	// BCP 47 language tags such as en, pt-BR or zh-Hant
+	captionLanguage = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8}){0,3}$`)
+)
+
+// captionTags are the cue text tags WebVTT allows
+var captionTags = map[string]bool{"b": true, "i": true, "u": true, "c": true, "v": true, "lang": true, "ruby": true, "rt": true}
+
+// parseCaptionTimestamp reads a WebVTT or SRT timestamp
+func parseCaptionTimestamp(s string) (time.Duration, bool) {
+	m := captionTimestamp.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(m[1])
+	minutes, _ := strconv.Atoi(m[2])
+	seconds, _ := strconv.Atoi(m[3])
+	millis, _ := strconv.Atoi(m[4])
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds)*time.Second + time.Duration(millis)*time.Millisecond, true
+}
+
+// formatCaptionTimestamp writes a WebVTT timestamp
+func formatCaptionTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// cleanCueText keeps the WebVTT tags in cue text and drops any other markup,
+// such as the <font> tags common in SRT files. Text outside tags is escaped
+// so that stray ampersands and angle brackets are shown as written.
+func cleanCueText(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, m := range captionTag.FindAllStringSubmatchIndex(text, -1) {
+		b.WriteString(html.EscapeString(html.UnescapeString(text[last:m[0]])))
+		last = m[1]
+		tag := text[m[0]:m[1]]
+		if m[4] < 0 {
+			if captionTimestampTag.MatchString(tag) {
+				b.WriteString(tag)
+			}
+			continue
+		}
+		closing, name, rest := text[m[2]:m[3]] == "/", strings.ToLower(text[m[4]:m[5]]), text[m[6]:m[7]]
+		if !captionTags[name] {
+			continue
+		}
+		switch {
+		case closing:
+			b.WriteString("</" + name + ">")
+		case name == "v" || name == "lang":
+			// The annotation is a voice name or language; keep it as text
+			class, annotation, _ := strings.Cut(rest, " ")
+			if !captionClass.MatchString(class) {
+				class = ""
+			}
+			b.WriteString("<" + name + class + " " + html.EscapeString(html.UnescapeString(strings.TrimSpace(annotation))) + ">")
+		default:
+			if !captionClass.MatchString(rest) {
+				rest = ""
+			}
+			b.WriteString("<" + name + rest + ">")
+		}
+	}
+	b.WriteString(html.EscapeString(html.UnescapeString(text[last:])))
+	// html.EscapeString also escapes quotes, which cue text shows literally
+	return strings.NewReplacer("&#39;", "'", "&#34;", `"`).Replace(b.String())
+}
+
+// cuePlainText is cue text without its markup
+func cuePlainText(text string) string {
+	return strings.TrimSpace(html.UnescapeString(captionTag.ReplaceAllString(text, "")))
+}
+
+// parseCaptions reads a WebVTT or SRT file into cues ordered by start time.
+// The returned error is suitable to show to the user.
+func parseCaptions(data []byte) ([]captionCue, error) {
+	if len(data) > maxCaptionFileSize {
+		return nil, fmt.Errorf("caption file is too large (max %dMB)", maxCaptionFileSize>>20)
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if !utf8.Valid(data) {
+		return nil, fmt.Errorf("caption file must be UTF-8 text")
+	}
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+	lines := strings.Split(text, "\n")
+
+	vtt := strings.HasPrefix(text, "WEBVTT") && (len(text) == 6 || strings.ContainsRune(" \t\n", rune(text[6])))
+	i := 0
+	if vtt {
+		// Skip the header up to the first blank line
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			i++
+		}
+	}
+
+	cues := []captionCue{}
+	for i < len(lines) {
+		if strings.TrimSpace(lines[i]) == "" {
+			i++
+			continue
+		}
+		start := i
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" {
+			i++
+		}
+		block := lines[start:i]
+
+		if vtt {
+			first := strings.TrimSpace(block[0])
+			if first == "NOTE" || strings.HasPrefix(first, "NOTE ") || strings.HasPrefix(first, "NOTE\t") || first == "STYLE" || first == "REGION" {
+				continue
+			}
+		}
+		cue := captionCue{}
+		timing := 0
+		if !strings.Contains(block[0], "-->") {
+			// A cue identifier (WebVTT) or sequence number (SRT)
+			if !vtt {
+				if _, err := strconv.Atoi(strings.TrimSpace(block[0])); err != nil {
+					if len(cues) == 0 {
+						return nil, fmt.Errorf("not a WebVTT (.vtt) or SubRip (.srt) caption file")
+					}
+					return nil, fmt.Errorf("line %d: expected a cue number or timing", start+1)
+				}
+			} else {
+				cue.ID = strings.TrimSpace(block[0])
+			}
+			timing = 1
+		}
+		if timing >= len(block) || !strings.Contains(block[timing], "-->") {
+			return nil, fmt.Errorf("line %d: expected a cue timing such as 00:00:01.000 --> 00:00:04.000", start+timing+1)
+		}
+		from, to, _ := strings.Cut(block[timing], "-->")
+		fields := strings.Fields(to)
+		var ok bool
+		if cue.Start, ok = parseCaptionTimestamp(from); !ok {
+			return nil, fmt.Errorf("line %d: invalid start time %q", start+timing+1, strings.TrimSpace(from))
+		}
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("line %d: missing end time", start+timing+1)
+		}
+		if cue.End, ok = parseCaptionTimestamp(fields[0]); !ok {
+			return nil, fmt.Errorf("line %d: invalid end time %q", start+timing+1, fields[0])
+		}
+		if cue.End <= cue.Start {
+			return nil, fmt.Errorf("line %d: cue ends before it starts", start+timing+1)
+		}
+		if vtt {
+			settings := []string{}
+			for _, s := range fields[1:] {
+				if captionSetting.MatchString(s) {
+					settings = append(settings, s)
+				}
+			}
+			cue.Settings = strings.Join(settings, " ")
+		}
+		// SRT files put any position coordinates after the times; they have
+		// no WebVTT equivalent and are dropped
+
+		textLines := []string{}
+		for _, line := range block[timing+1:] {
+			textLines = append(textLines, cleanCueText(strings.TrimRight(line, " \t")))
+		}
+		cue.Text = strings.Join(textLines, "\n")
+		if cuePlainText(cue.Text) == "" {
+			continue
+		}
+		cues = append(cues, cue)
+		if len(cues) > maxCaptionCues {
+			return nil, fmt.Errorf("too many cues (max %d)", maxCaptionCues)
+		}
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no captions found; upload a WebVTT (.vtt) or SubRip (.srt) file")
+	}
+
+	sort.SliceStable(cues, func(a, b int) bool { return cues[a].Start < cues[b].Start })
+	return cues, nil
+}
+
+// formatWebVTT writes cues as a WebVTT file
+func formatWebVTT(cues []captionCue) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n")
+	for _, cue := range cues {
+		b.WriteString("\n")
+		if cue.ID != "" {
+			b.WriteString(cue.ID + "\n")
+		}
+		b.WriteString(formatCaptionTimestamp(cue.Start) + " --> " + formatCaptionTimestamp(cue.End))
+		if cue.Settings != "" {
+			b.WriteString(" " + cue.Settings)
+		}
+		b.WriteString("\n" + cue.Text + "\n")
+	}
+	return b.Bytes()
+}
+
+// captionTranscript is the text of the cues as plain text, one cue per line.
+// Lines repeated by roll-up captions are only included once.
+func captionTranscript(cues []captionCue) string {
+	lines := []string{}
+	for _, cue := range cues {
+		for _, line := range strings.Split(cuePlainText(cue.Text), "\n") {
+			line = strings.TrimSpace(line)
+			if line != "" && (len(lines) == 0 || lines[len(lines)-1] != line) {
+				lines = append(lines, line)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// withTranscripts fills in the transcripts of the caption tracks on video
+// blocks from the caption files in dir. Blocks must already be validated.
+func withTranscripts(blocks []ContentBlock, dir string) ([]ContentBlock, []ValidationError) {
+	errs := []ValidationError{}
+	for i, block := range blocks {
+		if block.Type != "video" || block.Content["captions"] == nil {
+			continue
+		}
+		var video VideoContent
+		checker := blockChecker{index: i}
+		if !checker.decode(block.Content, &video) {
+			errs = append(errs, checker.errs...)
+			continue
+		}
+		for j := range video.Captions {
+			data, err := os.ReadFile(filepath.Join(dir, path.Base(video.Captions[j].URL)))
+			var cues []captionCue
+			if err == nil {
+				cues, err = parseCaptions(data)
+			}
+			if err != nil {
+				checker.fail(fmt.Sprintf("captions[%d].url", j), "caption file could not be read")
+				continue
+			}
+			transcript := captionTranscript(cues)
+			if len(transcript) > maxTranscriptLength {
+				cut := strings.LastIndexByte(transcript[:maxTranscriptLength], '\n')
+				transcript = transcript[:max(cut, 0)]
+			}
+			video.Captions[j].Transcript = transcript
+		}
+		errs = append(errs, checker.errs...)
+		blocks[i].Content = toContent(video)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return blocks, nil
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The catalog is how trainings are browsed: filtered by category, tag and
+// author, sorted and a page at a time, with counts of what each filter would
+// leave so the client can show them next to the filters. A training has at
+// most one category and a handful of tags; categories keep the spelling they
+// were first given, and tags are lowercase.
+
+const (
+	maxCategoryLength      = 50
+	maxTags                = 10
+	maxTagLength           = 30
+	defaultCatalogPageSize = 20
+	maxCatalogPageSize     = 100
+)
+
+// Catalog sort orders
+const (
+	catalogNewest    = "newest"    // Most recently published first
+	catalogCompleted = "completed" // Most learners completed first
+	catalogTitle     = "title"
+)
+
+// tagPattern matches tags: words of letters and numbers joined by single
+// spaces or hyphens
+var tagPattern = regexp.MustCompile(`^[\p{L}\p{N}]+([ -][\p{L}\p{N}]+)*$`)
+
+// cleanTags lowercases tags, collapses their spacing and drops empty and
+// repeated ones
+func cleanTags(tags []string) ([]string, error) {
+	cleaned := make([]string, 0, len(tags))
+	seen := map[string]bool{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.Join(strings.Fields(tag), " "))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		if len(tag) > maxTagLength {
+			return nil, fmt.Errorf("tag %q is too long (max %d characters)", tag, maxTagLength)
+		}
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("tag %q may only contain letters, numbers, spaces and hyphens", tag)
+		}
+		seen[tag] = true
+		cleaned = append(cleaned, tag)
+	}
+	if len(cleaned) > maxTags {
+		return nil, fmt.Errorf("too many tags (max %d)", maxTags)
+	}
+	return cleaned, nil
+}
+
+// cleanCategory collapses the spacing of a category for training id and
+// gives it the spelling other trainings already use, so "safety" and
+// "Safety" are one category
+func (s *TrainingStore) cleanCategory(id, category string) (string, error) {
+	category = strings.Join(strings.Fields(category), " ")
+	if len(category) > maxCategoryLength {
+		return "", fmt.Errorf("category is too long (max %d characters)", maxCategoryLength)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.Trainings {
+		if t.ID != id && t.DeletedAt == nil && strings.EqualFold(t.Category, category) {
+			return t.Category, nil
+		}
+	}
+	return category, nil
+}
+
+// catalogFacet is a value a catalog filter can take and how many trainings
+// it matches
+type catalogFacet struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// countFacets tallies values into facets, most common first
+func countFacets(counts map[string]int) []catalogFacet {
+	facets := make([]catalogFacet, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, catalogFacet{Value: value, Count: count})
+	}
+	sort.Slice(facets, func(i, j int) bool {
+		if facets[i].Count != facets[j].Count {
+			return facets[i].Count > facets[j].Count
+		}
+		return facets[i].Value < facets[j].Value
+	})
+	return facets
+}
+
+// listedAt is when a training joined the catalog: when it was last
+// published, or for drafts when it was created
+func listedAt(t Training) time.Time {
+	if t.PublishedAt != nil {
+		return *t.PublishedAt
+	}
+	return t.CreatedAt
+}
+
+// HandleGetCatalog handles browsing trainings. Filters are category, tag
+// (repeatable; trainings must have every tag given) and author; sort is
+// newest (the default), completed or title; page counts from 1. Category
+// and author facets count the trainings matching the other filters, so
+// picking a category still shows how many trainings every other category
+// has; tag facets count the trainings in the results, since tags narrow them
+// further. Trainings are shown as the user would see them, as in
+// HandleGetTrainings.
+func (h *TrainingHandlers) HandleGetCatalog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	email := query.Get("email")
+	category := strings.Join(strings.Fields(query.Get("category")), " ")
+	author := strings.TrimSpace(query.Get("author"))
+	tags, err := cleanTags(query["tag"])
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	sortBy := query.Get("sort")
+	switch sortBy {
+	case "":
+		sortBy = catalogNewest
+	case catalogNewest, catalogCompleted, catalogTitle:
+	default:
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "sort must be newest, completed or title"})
+		return
+	}
+	page, perPage := 1, defaultCatalogPageSize
+	if s := query.Get("page"); s != "" {
+		if page, err = strconv.Atoi(s); err != nil || page < 1 {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "page must be a positive number"})
+			return
+		}
+	}
+	if s := query.Get("per_page"); s != "" {
+		if perPage, err = strconv.Atoi(s); err != nil || perPage < 1 || perPage > maxCatalogPageSize {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("per_page must be between 1 and %d", maxCatalogPageSize)})
+			return
+		}
+	}
+
+	// matches applies every filter except the one named by ignore
+	matches := func(t Training, ignore string) bool {
+		if ignore != "category" && category != "" && !strings.EqualFold(t.Category, category) {
+			return false
+		}
+		if ignore != "author" && author != "" && !strings.EqualFold(t.CreatedBy, author) {
+			return false
+		}
+		for _, tag := range tags {
+			found := false
+			for _, have := range t.Tags {
+				found = found || have == tag
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+
+	now := time.Now()
+	views := []Training{}
+	for _, t := range h.store.getAll() {
+		if view, ok := h.contentFor(t, email, now); ok {
+			views = append(views, view)
+		}
+	}
+	categories, tagCounts, authors := map[string]int{}, map[string]int{}, map[string]int{}
+	results := []Training{}
+	for _, t := range views {
+		if t.Category != "" && matches(t, "category") {
+			categories[t.Category]++
+		}
+		if matches(t, "author") {
+			authors[t.CreatedBy]++
+		}
+		if matches(t, "") {
+			for _, tag := range t.Tags {
+				tagCounts[tag]++
+			}
+			results = append(results, t)
+		}
+	}
+
+	completions := h.progress.completions()
+	sort.Slice(results, func(i, j int) bool {
+		a, b := results[i], results[j]
+		switch sortBy {
+		case catalogNewest:
+			if !listedAt(a).Equal(listedAt(b)) {
+				return listedAt(a).After(listedAt(b))
+			}
+		case catalogCompleted:
+			if completions[a.ID] != completions[b.ID] {
+				return completions[a.ID] > completions[b.ID]
+			}
+		}
+		if titleA, titleB := strings.ToLower(a.Title), strings.ToLower(b.Title); titleA != titleB {
+			return titleA < titleB
+		}
+		return a.ID < b.ID
+	})
+
+	total := len(results)
+	start := total
+	if page-1 <= total/perPage {
+		start = min((page-1)*perPage, total)
+	}
+	end := min(start+perPage, total)
+	trainings := make([]Training, 0, end-start)
+	completed := map[string]int{}
+	for _, t := range results[start:end] {
+		trainings = append(trainings, t)
+		completed[t.ID] = completions[t.ID]
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"trainings":   trainings,
+		"completions": completed, // Learners who have completed each training on the page
+		"total":       total,
+		"page":        page,
+		"per_page":    perPage,
+		"pages":       int(math.Ceil(float64(total) / float64(perPage))),
+		"facets": map[string]interface{}{
+			"categories": countFacets(categories),
+			"tags":       countFacets(tagCounts),
+			"authors":    countFacets(authors),
+		},
+	})
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CertificateHandlers contains the certificate download and verification handlers
+type CertificateHandlers struct {
+	store     *CertificateStore
+	trainings *TrainingStore
+}
+
+// NewCertificateHandlers creates a new CertificateHandlers instance
+func NewCertificateHandlers(store *CertificateStore, trainings *TrainingStore) *CertificateHandlers {
+	return &CertificateHandlers{store: store, trainings: trainings}
+}
+
+// HandleGetCertificates handles listing a learner's certificates
+func (h *CertificateHandlers) HandleGetCertificates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":           true,
+		"certificates": h.store.getForUser(email),
+	})
+}
+
+// HandleDownloadCertificate handles downloading a certificate as a PDF. The
+// learner it was issued to and the training's author may download it.
+func (h *CertificateHandlers) HandleDownloadCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	c, ok := h.store.get(r.URL.Query().Get("id"))
+	if !ok || email == "" {
+		respondError(w, "certificate not found", http.StatusNotFound)
+		return
+	}
+	if c.Email != email {
+		if t, ok := h.trainings.get(c.TrainingID); !ok || t.CreatedBy != email {
+			respondError(w, "certificate not found", http.StatusNotFound)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/pdf")
+	w.Header().Set("Content-Disposition", `attachment; filename="certificate-`+c.ID+`.pdf"`)
+	if _, err := w.Write(certificatePDF(c)); err != nil {
+		logError("failed to write certificate", err)
+	}
+}
+
+// HandleVerifyCertificate handles the public check that a certificate ID is
+// genuine. Only the details printed on the certificate are returned.
+func (h *CertificateHandlers) HandleVerifyCertificate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	c, ok := h.store.get(r.URL.Query().Get("id"))
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": true, "valid": false})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"valid":       true,
+		"certificate": c.public(),
+	})
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Certificate is issued to a learner when they complete a training or a
+// learning path
+type Certificate struct {
+	ID            string     `json:"id"` // Verification ID printed on the certificate
+	TrainingID    string     `json:"training_id,omitempty"`
+	PathID        string     `json:"path_id,omitempty"`
+	TrainingTitle string     `json:"training_title"` // Title of the training or path
+	Revision      int        `json:"revision,omitempty"`
+	Email         string     `json:"email"`
+	Name          string     `json:"name"`
+	CompletedAt   time.Time  `json:"completed_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"` // Unset for trainings that never need redoing
+	LapsedAt      *time.Time `json:"lapsed_at,omitempty"`  // When the expiry was acted on
+	IssuedAt      time.Time  `json:"issued_at"`
+}
+
+// isExpired reports whether the certificate has passed its expiry date
+func (c Certificate) isExpired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+// newCertificateID returns a random verification ID such as TH-7K2M-QX4D-93PA
+func newCertificateID() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)[:12]
+	return "TH-" + code[0:4] + "-" + code[4:8] + "-" + code[8:12], nil
+}
+
+// normalizeCertificateID makes IDs typed in by hand comparable
+func normalizeCertificateID(id string) string {
+	return strings.ToUpper(strings.TrimSpace(id))
+}
+
+// CertificatePublic is what the verification endpoint reveals about a certificate
+type CertificatePublic struct {
+	ID            string     `json:"id"`
+	Name          string     `json:"name"`
+	TrainingTitle string     `json:"training_title"`
+	CompletedAt   time.Time  `json:"completed_at"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Expired       bool       `json:"expired"`
+}
+
+// public returns the details that can be shown to anyone holding the ID
+func (c Certificate) public() CertificatePublic {
+	return CertificatePublic{
+		ID:            c.ID,
+		Name:          c.Name,
+		TrainingTitle: c.TrainingTitle,
+		CompletedAt:   c.CompletedAt,
+		ExpiresAt:     c.ExpiresAt,
+		Expired:       c.isExpired(time.Now()),
+	}
+}
+
+// certificatePDF lays out a certificate on a landscape A4 page
+func certificatePDF(c Certificate) []byte {
+	page := newPDFPage(842, 595)
+	margin := 60.0
+
+	page.setColor(37, 99, 235)
+	page.rect(24, 24, 842-48, 595-48, 4)
+	page.rect(34, 34, 842-68, 595-68, 1)
+
+	page.centeredText("F2", 36, 450, margin, "Certificate of Completion")
+	page.setColor(100, 116, 139)
+	page.centeredText("F1", 16, 400, margin, "This certifies that")
+
+	page.setColor(15, 23, 42)
+	page.centeredText("F2", 32, 345, margin, c.Name)
+	page.setColor(203, 213, 225)
+	page.line(221, 330, 621, 330, 1)
+
+	page.setColor(100, 116, 139)
+	completed := "has successfully completed"
+	if c.PathID != "" {
+		completed = "has successfully completed the learning path"
+	}
+	page.centeredText("F1", 16, 295, margin, completed)
+	page.setColor(15, 23, 42)
+	page.centeredText("F2", 24, 250, margin, c.TrainingTitle)
+
+	page.setColor(100, 116, 139)
+	page.centeredText("F1", 14, 200, margin, "Completed on "+c.CompletedAt.Format("January 2, 2006"))
+	if c.ExpiresAt != nil {
+		page.centeredText("F1", 12, 178, margin, "Valid until "+c.ExpiresAt.Format("January 2, 2006"))
+	}
+
+	page.setColor(100, 116, 139)
+	page.text("F1", 10, 60, 60, "Certificate ID: "+c.ID)
+	page.text("F1", 10, 60, 46, "Verify at /api/certificates/verify?id="+c.ID)
+	page.text("F2", 12, 842-60-textWidth("F2", 12, "Train Hub"), 60, "Train Hub")
+
+	return page.render("Certificate of Completion - " + c.TrainingTitle)
+}
+
+// CertificateStore manages issued certificates, keyed by verification ID
+type CertificateStore struct {
+	mu           sync.Mutex
+	Certificates map[string]Certificate `json:"certificates"`
+	file         string
+}
+
+// NewCertificateStore creates a new certificate store
+func NewCertificateStore(path string) *CertificateStore {
+	s := &CertificateStore{Certificates: map[string]Certificate{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *CertificateStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var certificates map[string]Certificate
+	if err := readJSONFile(s.file, &certificates); err != nil {
+		logError("failed to load certificates", err)
+		return
+	}
+	if certificates != nil {
+		s.Certificates = certificates
+	}
+}
+
+func (s *CertificateStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Certificates)
+}
+
+func (s *CertificateStore) get(id string) (Certificate, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.Certificates[normalizeCertificateID(id)]
+	return c, ok
+}
+
+func (s *CertificateStore) put(c Certificate) error {
+	s.mu.Lock()
+	s.Certificates[c.ID] = c
+	s.mu.Unlock()
+	return s.save()
+}
+
+// getForUser returns a learner's certificates, newest first
+func (s *CertificateStore) getForUser(email string) []Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	certificates := make([]Certificate, 0)
+	for _, c := range s.Certificates {
+		if c.Email == email {
+			certificates = append(certificates, c)
+		}
+	}
+	sort.Slice(certificates, func(i, j int) bool { return certificates[i].CompletedAt.After(certificates[j].CompletedAt) })
+	return certificates
+}
+
+// issue creates a certificate for a completed training
+func (s *CertificateStore) issue(t Training, email, name string, completedAt time.Time) (Certificate, error) {
+	id, err := newCertificateID()
+	if err != nil {
+		return Certificate{}, err
+	}
+	if name == "" {
+		name = email
+	}
+	c := Certificate{
+		ID:            id,
+		TrainingID:    t.ID,
+		TrainingTitle: t.Title,
+		Revision:      t.Revision,
+		Email:         email,
+		Name:          name,
+		CompletedAt:   completedAt,
+		IssuedAt:      time.Now(),
+	}
+	if t.ValidityDays > 0 {
+		expires := completedAt.AddDate(0, 0, t.ValidityDays)
+		c.ExpiresAt = &expires
+	}
+	return c, s.put(c)
+}
+
+// current returns each learner's latest certificate per training, which is
+// the one that decides whether they are certified
+func (s *CertificateStore) current() []Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	latest := map[string]Certificate{}
+	for _, c := range s.Certificates {
+		key := c.TrainingID + "|" + c.PathID + "|" + c.Email
+		if prev, ok := latest[key]; !ok || c.CompletedAt.After(prev.CompletedAt) {
+			latest[key] = c
+		}
+	}
+	certificates := make([]Certificate, 0, len(latest))
+	for _, c := range latest {
+		certificates = append(certificates, c)
+	}
+	sort.Slice(certificates, func(i, j int) bool { return certificates[i].CompletedAt.Before(certificates[j].CompletedAt) })
+	return certificates
+}
+
+// lapsedAt returns when the learner's latest certification on a training
+// lapsed, if it has. Work from before then, such as quiz attempts, belongs to
+// the previous certification.
+func (s *CertificateStore) lapsedAt(trainingID, email string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *Certificate
+	for _, c := range s.Certificates {
+		if c.TrainingID == trainingID && c.PathID == "" && c.Email == email && (latest == nil || c.CompletedAt.After(latest.CompletedAt)) {
+			latest = &c
+		}
+	}
+	if latest == nil || latest.LapsedAt == nil {
+		return time.Time{}, false
+	}
+	return *latest.LapsedAt, true
+}
+
+// issuePath creates a certificate for a completed learning path
+func (s *CertificateStore) issuePath(p LearningPath, email, name string, completedAt time.Time) (Certificate, error) {
+	id, err := newCertificateID()
+	if err != nil {
+		return Certificate{}, err
+	}
+	if name == "" {
+		name = email
+	}
+	c := Certificate{
+		ID:            id,
+		PathID:        p.ID,
+		TrainingTitle: p.Title,
+		Email:         email,
+		Name:          name,
+		CompletedAt:   completedAt,
+		IssuedAt:      time.Now(),
+	}
+	return c, s.put(c)
+}
+
+// forPath returns a learner's latest certificate for a learning path, if they
+// have one
+func (s *CertificateStore) forPath(pathID, email string) (Certificate, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var latest *Certificate
+	for _, c := range s.Certificates {
+		if c.PathID == pathID && c.Email == email && (latest == nil || c.CompletedAt.After(latest.CompletedAt)) {
+			latest = &c
+		}
+	}
+	if latest == nil {
+		return Certificate{}, false
+	}
+	return *latest, true
+}
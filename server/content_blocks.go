@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Block content is stored as a free-form map, so each block type has a typed
+// schema that content is decoded into, checked against and re-encoded from
+// before a training is saved. Fields outside the schema are dropped.
+
+// Limits on block content
+const (
+	maxBlocks       = 500
+	maxTitleLength  = 200
+	maxTextLength   = 50000
+	maxCodeLength   = 100000
+	maxListItems    = 200
+	maxShortText    = 1000 // List items, alt text, quote authors
+	defaultLanguage = "plaintext"
+)
+
+// titleLevels are the heading levels a title block can use
+var titleLevels = map[string]bool{"h1": true, "h2": true, "h3": true, "h4": true}
+
+// codeLanguagePattern matches language names such as go, c++, c# or objective-c
+var codeLanguagePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9+#._-]{0,29}$`)
+
+// TitleContent is the content of a title block
+type TitleContent struct {
+	Text  string `json:"text"`
+	Level string `json:"level"` // h1-h4, defaults to h2
+}
+
+// TextContent is the content of a text block; Text is sanitized HTML
+type TextContent struct {
+	Text string `json:"text"`
+}
+
+// VideoContent is the content of a video block
+type VideoContent struct {
+	URL      string         `json:"url"` // Must be an uploaded video
+	Captions []CaptionTrack `json:"captions,omitempty"`
+}
+
+// CaptionTrack is a caption or subtitle file for a video block
+type CaptionTrack struct {
+	URL        string `json:"url"`      // Must be uploaded captions
+	Language   string `json:"language"` // BCP 47 tag, such as en or pt-BR
+	Label      string `json:"label"`    // Shown in the player's caption menu; defaults to the language
+	Kind       string `json:"kind"`     // subtitles or captions, defaults to captions
+	Default    bool   `json:"default,omitempty"`
+	Transcript string `json:"transcript,omitempty"` // Text of the captions, filled in from the file when saved
+}
+
+// ImageContent is the content of an image block
+type ImageContent struct {
+	URL string `json:"url"` // Must be an uploaded image
+	Alt string `json:"alt"`
+}
+
+// CodeContent is the content of a code block
+type CodeContent struct {
+	Code     string `json:"code"`
+	Language string `json:"language"`
+}
+
+// ListContent is the content of a list block
+type ListContent struct {
+	Items   []string `json:"items"`
+	Ordered bool     `json:"ordered"`
+}
+
+// ScormContent is the content of a scorm block, which plays an imported package
+type ScormContent struct {
+	PackageID string `json:"package_id"`
+	LaunchURL string `json:"launch_url"`
+	Version   string `json:"version"`
+}
+
+// QuoteContent is the content of a quote block
+type QuoteContent struct {
+	Text   string `json:"text"`
+	Author string `json:"author"`
+}
+
+// blockChecker collects the validation errors for one block
+type blockChecker struct {
+	index int
+	errs  []ValidationError
+}
+
+// fail records an error against a field of the block's content, or the
+// content as a whole if field is empty
+func (c *blockChecker) fail(field, format string, args ...interface{}) {
+	path := fmt.Sprintf("blocks[%d].content", c.index)
+	if field != "" {
+		path += "." + field
+	}
+	c.errs = append(c.errs, ValidationError{Field: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// decode reads block content into its typed schema
+func (c *blockChecker) decode(content map[string]interface{}, v interface{}) bool {
+	data, err := json.Marshal(content)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) && typeErr.Field != "" {
+			c.fail(typeErr.Field, "must be %s", jsonKind(typeErr.Type.Kind()))
+		} else {
+			c.fail("", "invalid content: %v", err)
+		}
+		return false
+	}
+	return true
+}
+
+// jsonKind describes a Go kind in JSON terms for error messages
+func jsonKind(k reflect.Kind) string {
+	switch k {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "true or false"
+	case reflect.Slice, reflect.Array:
+		return "a list"
+	case reflect.Map, reflect.Struct:
+		return "an object"
+	}
+	return "a number"
+}
+
+// plainText strips markup from a plain text field and checks its length
+func (c *blockChecker) plainText(field, value string, required bool, max int) string {
+	value = strings.TrimSpace(stripTags(value))
+	if required && value == "" {
+		c.fail(field, "%s is required", field)
+	}
+	if len(value) > max {
+		c.fail(field, "%s is too long (max %d characters)", field, max)
+	}
+	return value
+}
+
+// uploadURL checks that a URL points at a file uploaded to the given
+// directory under /uploads. A signed URL handed out for playback is saved
+// without its signature.
+func (c *blockChecker) uploadURL(field, value, dir string) string {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "/uploads/") {
+		value, _, _ = strings.Cut(value, "?")
+	}
+	prefix := "/uploads/" + dir + "/"
+	name := strings.TrimPrefix(value, prefix)
+	switch {
+	case value == "":
+		c.fail(field, "%s is required", field)
+	case !strings.HasPrefix(value, prefix) || name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\?#"):
+		c.fail(field, "%s must point at an uploaded %s", field, strings.TrimSuffix(dir, "s"))
+	}
+	return value
+}
+
+// captionTracks checks a video's caption tracks. Transcripts are cleared;
+// they are filled in from the caption files once the blocks are valid.
+func (c *blockChecker) captionTracks(tracks []CaptionTrack) []CaptionTrack {
+	if len(tracks) > maxCaptionTracks {
+		c.fail("captions", "too many caption tracks (max %d)", maxCaptionTracks)
+		return nil
+	}
+	seen := map[string]bool{}
+	defaults := 0
+	for i := range tracks {
+		t := &tracks[i]
+		field := fmt.Sprintf("captions[%d]", i)
+		t.URL = c.uploadURL(field+".url", t.URL, "captions")
+		t.Language = strings.TrimSpace(t.Language)
+		if !captionLanguage.MatchString(t.Language) {
+			c.fail(field+".language", "language must be a language code such as en or pt-BR")
+		}
+		if t.Kind == "" {
+			t.Kind = captionKindCaptions
+		}
+		if t.Kind != captionKindCaptions && t.Kind != captionKindSubtitles {
+			c.fail(field+".kind", "kind must be captions or subtitles")
+		}
+		if key := strings.ToLower(t.Language) + " " + t.Kind; seen[key] {
+			c.fail(field+".language", "there is already %s in %s", t.Kind, t.Language)
+		} else {
+			seen[key] = true
+		}
+		t.Label = c.plainText(field+".label", t.Label, false, maxShortText)
+		if t.Label == "" {
+			t.Label = t.Language
+		}
+		if t.Default {
+			defaults++
+		}
+		t.Transcript = ""
+	}
+	if defaults > 1 {
+		c.fail("captions", "only one caption track can be the default")
+	}
+	return tracks
+}
+
+// toContent re-encodes a typed schema as block content
+func toContent(v interface{}) map[string]interface{} {
+	data, _ := json.Marshal(v)
+	content := map[string]interface{}{}
+	json.Unmarshal(data, &content)
+	return content
+}
+
+// checkContent validates a block's content against the schema for its type
+// and returns the cleaned content
+func (c *blockChecker) checkContent(block ContentBlock) map[string]interface{} {
+	switch block.Type {
+	case "title":
+		var title TitleContent
+		if !c.decode(block.Content, &title) {
+			return nil
+		}
+		title.Text = c.plainText("text", title.Text, true, maxTitleLength)
+		if title.Level == "" {
+			title.Level = "h2"
+		}
+		if !titleLevels[title.Level] {
+			c.fail("level", "level must be one of h1, h2, h3 or h4")
+		}
+		return toContent(title)
+
+	case "text":
+		var text TextContent
+		if !c.decode(block.Content, &text) {
+			return nil
+		}
+		text.Text = strings.TrimSpace(sanitizeHTML(text.Text))
+		if strings.TrimSpace(stripTags(text.Text)) == "" {
+			c.fail("text", "text is required")
+		}
+		if len(text.Text) > maxTextLength {
+			c.fail("text", "text is too long (max %d characters)", maxTextLength)
+		}
+		return toContent(text)
+
+	case "video":
+		var video VideoContent
+		if !c.decode(block.Content, &video) {
+			return nil
+		}
+		video.URL = c.uploadURL("url", video.URL, "videos")
+		video.Captions = c.captionTracks(video.Captions)
+		return toContent(video)
+
+	case "image":
+		var image ImageContent
+		if !c.decode(block.Content, &image) {
+			return nil
+		}
+		image.URL = c.uploadURL("url", image.URL, "images")
+		image.Alt = c.plainText("alt", image.Alt, false, maxShortText)
+		return toContent(image)
+
+	case "code":
+		var code CodeContent
+		if !c.decode(block.Content, &code) {
+			return nil
+		}
+		if strings.TrimSpace(code.Code) == "" {
+			c.fail("code", "code is required")
+		}
+		if len(code.Code) > maxCodeLength {
+			c.fail("code", "code is too long (max %d characters)", maxCodeLength)
+		}
+		code.Language = strings.ToLower(strings.TrimSpace(code.Language))
+		if code.Language == "" {
+			code.Language = defaultLanguage
+		}
+		if !codeLanguagePattern.MatchString(code.Language) {
+			c.fail("language", "language %q is not a valid language name", code.Language)
+		}
+		return toContent(code)
+
+	case "list":
+		var list ListContent
+		if !c.decode(block.Content, &list) {
+			return nil
+		}
+		items := make([]string, 0, len(list.Items))
+		for i, item := range list.Items {
+			item = c.plainText(fmt.Sprintf("items[%d]", i), item, false, maxShortText)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		if len(items) == 0 {
+			c.fail("items", "list needs at least one item")
+		}
+		if len(items) > maxListItems {
+			c.fail("items", "list has too many items (max %d)", maxListItems)
+		}
+		list.Items = items
+		return toContent(list)
+
+	case "quote":
+		var quote QuoteContent
+		if !c.decode(block.Content, &quote) {
+			return nil
+		}
+		quote.Text = c.plainText("text", quote.Text, true, maxTextLength)
+		quote.Author = c.plainText("author", quote.Author, false, maxShortText)
+		return toContent(quote)
+
+	case "divider":
+		return map[string]interface{}{}
+
+	case "scorm":
+		var scorm ScormContent
+		if !c.decode(block.Content, &scorm) {
+			return nil
+		}
+		scorm.LaunchURL = unsignScorm(strings.TrimSpace(scorm.LaunchURL))
+		if scorm.PackageID == "" || !strings.HasPrefix(scorm.LaunchURL, "/uploads/scorm/"+scorm.PackageID+"/") {
+			c.fail("launch_url", "launch_url must point at an imported SCORM package")
+		}
+		if scorm.Version != scorm12 && scorm.Version != scorm2004 {
+			c.fail("version", "version must be %s or %s", scorm12, scorm2004)
+		}
+		return toContent(scorm)
+
+	case "quiz":
+		quiz, err := parseQuiz(block)
+		if err != nil {
+			c.fail("", "%v", err)
+			return nil
+		}
+		quiz.Title = stripTags(quiz.Title)
+		for i := range quiz.Questions {
+			q := &quiz.Questions[i]
+			q.Prompt = stripTags(q.Prompt)
+			for j := range q.Options {
+				q.Options[j] = stripTags(q.Options[j])
+			}
+			for j := range q.Accepted {
+				q.Accepted[j] = stripTags(q.Accepted[j])
+			}
+		}
+		if err := quiz.validate(); err != nil {
+			c.fail("questions", "%v", err)
+		}
+		return toContent(quiz)
+	}
+
+	c.errs = append(c.errs, ValidationError{
+		Field:   fmt.Sprintf("blocks[%d].type", c.index),
+		Message: fmt.Sprintf("unknown block type %q", block.Type),
+	})
+	return nil
+}
+
+// validateBlocks checks the content blocks of a training before it is saved.
+// It returns the blocks with their content cleaned up, or every problem found
+// with the path of the offending field.
+func validateBlocks(blocks []ContentBlock) ([]ContentBlock, []ValidationError) {
+	if len(blocks) > maxBlocks {
+		return nil, []ValidationError{{Field: "blocks", Message: fmt.Sprintf("too many blocks (max %d)", maxBlocks)}}
+	}
+
+	cleaned := make([]ContentBlock, 0, len(blocks))
+	errs := make([]ValidationError, 0)
+	ids := map[string]bool{}
+	for i, block := range blocks {
+		block.ID = strings.TrimSpace(block.ID)
+		if block.ID == "" {
+			block.ID = uuid.New().String()
+		}
+		if ids[block.ID] {
+			errs = append(errs, ValidationError{Field: fmt.Sprintf("blocks[%d].id", i), Message: fmt.Sprintf("duplicate block id %q", block.ID)})
+		}
+		ids[block.ID] = true
+
+		checker := blockChecker{index: i}
+		block.Content = checker.checkContent(block)
+		errs = append(errs, checker.errs...)
+		cleaned = append(cleaned, block)
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cleaned, nil
+}
+
+// blockErrorResponse is the response for blocks that failed validation
+func blockErrorResponse(errs []ValidationError) map[string]interface{} {
+	return map[string]interface{}{
+		"ok":     false,
+		"error":  errs[0].Field + ": " + errs[0].Message,
+		"errors": errs,
+	}
+}
+
+// sanitizeTextBlocks returns a copy of the blocks with the HTML in text
+// blocks sanitized, for content saved before validation existed
+func sanitizeTextBlocks(blocks []ContentBlock) []ContentBlock {
+	sanitized := make([]ContentBlock, len(blocks))
+	for i, block := range blocks {
+		sanitized[i] = block
+		text, ok := block.Content["text"].(string)
+		if block.Type != "text" || !ok {
+			continue
+		}
+		content := make(map[string]interface{}, len(block.Content))
+		for k, v := range block.Content {
+			content[k] = v
+		}
+		content["text"] = sanitizeHTML(text)
+		sanitized[i].Content = content
+	}
+	return sanitized
+}
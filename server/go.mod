@@ -4,4 +4,6 @@ go 1.24.3
 
 require golang.org/x/crypto v0.45.0
 
-require github.com/google/uuid v1.6.0 // indirect
+require github.com/google/uuid v1.6.0
+
+require golang.org/x/image v0.33.0
@@ -2,7 +2,11 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
@@ -34,16 +38,20 @@ type UserUpdateRequest struct {
 	Email            string          `json:"email"`
 	Inventory        []InventoryItem `json:"inventory,omitempty"`
 	DeletedInventory []InventoryItem `json:"deleted_inventory,omitempty"`
+	Version          *int            `json:"version"` // The version of the user the client last loaded
 }
 
 // Handlers contains all HTTP handlers
 type Handlers struct {
-	store *UserStore
+	store          *UserStore
+	attachmentPath string
 }
 
 // NewHandlers creates a new Handlers instance
-func NewHandlers(store *UserStore) *Handlers {
-	return &Handlers{store: store}
+func NewHandlers(store *UserStore, attachmentPath string) *Handlers {
+	// Ensure attachment directories exist
+	os.MkdirAll(filepath.Join(attachmentPath, "thumbs"), 0755)
+	return &Handlers{store: store, attachmentPath: attachmentPath}
 }
 
 // HandleSignup handles user registration
@@ -173,6 +181,24 @@ func (h *Handlers) HandleGetUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Items saved before IDs existed get one now so they can be referenced.
+	// Saving bumps the version, so the user is returned as saved.
+	missingID := func(item InventoryItem) bool { return item.ID == "" }
+	if slices.ContainsFunc(u.Inventory, missingID) || slices.ContainsFunc(u.DeletedInventory, missingID) {
+		var saved *User
+		err := h.store.transact([]string{email}, func(users []*User) error {
+			assignItemIDs(users[0].Inventory)
+			assignItemIDs(users[0].DeletedInventory)
+			saved = users[0]
+			return nil
+		})
+		if err != nil {
+			logError("failed to assign item IDs", err)
+		} else {
+			u = *saved
+		}
+	}
+
 	// Return in format expected by client
 	respondJSON(w, map[string]interface{}{
 		"ok": true,
@@ -181,6 +207,7 @@ func (h *Handlers) HandleGetUser(w http.ResponseWriter, r *http.Request) {
 			"email":             u.Email,
 			"inventory":         u.Inventory,
 			"deleted_inventory": u.DeletedInventory,
+			"version":           u.Version,
 		},
 	})
 }
@@ -210,30 +237,72 @@ func (h *Handlers) HandleUpdateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get user
-	u, ok := h.store.get(email)
-	if !ok {
+	if _, ok := h.store.get(email); !ok {
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
 		return
 	}
 
-	// Update inventory
-	if req.Inventory != nil {
-		u.Inventory = req.Inventory
-	}
-	// Update deleted inventory
-	if req.DeletedInventory != nil {
-		u.DeletedInventory = req.DeletedInventory
-	}
-	u.UpdatedAt = time.Now()
+	// The client sends its whole inventory, so it must have seen every change
+	// the server made since, such as items received by transfer or stock
+	// checked out, or saving would undo them
+	var prevItems map[string]InventoryItem
+	var saved *User
+	var updateErr error // Reported to the user, unlike failures to save
+	err := h.store.transact([]string{email}, func(users []*User) error {
+		u := users[0]
+		if req.Version == nil || *req.Version != u.Version {
+			updateErr = fmt.Errorf("your inventory has changed since it was loaded; reload it and try again")
+			return updateErr
+		}
+		prevItems = itemsByID(*u)
+
+		// An existing item's unit is only changed on the server, so its
+		// quantities are checked against the stored unit
+		for _, items := range [][]InventoryItem{req.Inventory, req.DeletedInventory} {
+			for _, item := range items {
+				if old, ok := prevItems[item.ID]; ok {
+					item.Unit = old.Unit
+				}
+				if updateErr = validateStockLevels(item); updateErr != nil {
+					updateErr = fmt.Errorf("%s: %v", item.Description, updateErr)
+					return updateErr
+				}
+			}
+		}
 
-	if err := h.store.put(u); err != nil {
+		if req.Inventory != nil {
+			assignItemIDs(req.Inventory)
+			preserveServerFields(prevItems, req.Inventory)
+			u.Inventory = req.Inventory
+		}
+		if req.DeletedInventory != nil {
+			assignItemIDs(req.DeletedInventory)
+			preserveServerFields(prevItems, req.DeletedInventory)
+			u.DeletedInventory = req.DeletedInventory
+		}
+		u.UpdatedAt = time.Now()
+		saved = u
+		return nil
+	})
+	if updateErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": updateErr.Error()})
+		return
+	}
+	if err != nil {
 		logError("failed to update user", err)
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to update"})
 		return
 	}
 
-	respondJSON(w, map[string]interface{}{"ok": true})
+	// Items missing from both lists were permanently deleted; clean up their files
+	currentItems := itemsByID(*saved)
+	for id, item := range prevItems {
+		if _, ok := currentItems[id]; !ok {
+			h.removeAttachmentFiles(item.Attachments)
+		}
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true, "version": saved.Version})
 }
 
 // HandleGetAllInventories handles getting all users' inventories
@@ -343,11 +412,15 @@ func (h *Handlers) HandleDeleteUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Perform deletion
-	if err := h.store.Delete(email); err != nil {
+	deleted, err := h.store.Delete(email)
+	if err != nil {
 		logError("failed to delete user", err)
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to delete account"})
 		return
 	}
+	for _, item := range itemsByID(deleted) {
+		h.removeAttachmentFiles(item.Attachments)
+	}
 
 	respondJSON(w, map[string]interface{}{"ok": true})
 }
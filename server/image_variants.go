@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+// Uploaded images are decoded and re-encoded, which drops EXIF and other
+// metadata such as GPS positions, after turning them the right way up. Each
+// is stored at no more than maxImageDimension, with smaller copies at the
+// widths in imageVariants beside it. Any image URL can ask for a size with
+// ?size=, by name or width in pixels; the smallest copy at least that wide
+// is served, or the image itself if it is no wider.
+
+const (
+	maxImageDimension = 3840
+	maxImagePixels    = 50_000_000 // Refuse to decode anything bigger
+	maxGIFFrames      = 1000       // Every frame of a GIF is decoded at once, so these are counted first
+	imageQuality      = 85
+)
+
+// ImageVariant is a resized copy of an uploaded image
+type ImageVariant struct {
+	Name  string `json:"name"`
+	Width int    `json:"width"`
+	URL   string `json:"url"`
+}
+
+// imageVariants are the widths resized copies are made at, smallest first
+var imageVariants = []struct {
+	Name  string
+	Width int
+}{
+	{"thumb", 320},
+	{"small", 640},
+	{"medium", 1280},
+	{"large", 1920},
+}
+
+// variantFilename is where the copy of an image at width is stored. Copies
+// of JPEGs are JPEGs; everything else becomes PNG to keep transparency.
+func variantFilename(filename string, width int) string {
+	ext := filepath.Ext(filename)
+	variantExt := ".png"
+	if ext == ".jpg" {
+		variantExt = ".jpg"
+	}
+	return fmt.Sprintf("%s.w%d%s", strings.TrimSuffix(filename, ext), width, variantExt)
+}
+
+// exifOrientation returns the EXIF orientation (1 to 8) recorded in a JPEG,
+// PNG or WebP file, or 1 if there is none
+func exifOrientation(data []byte) int {
+	var tiff []byte
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		// JPEG segments up to the image data; EXIF is in an APP1 segment
+		for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+			marker := data[i+1]
+			length := int(binary.BigEndian.Uint16(data[i+2:]))
+			if marker == 0xDA || i+2+length > len(data) {
+				break
+			}
+			segment := data[i+4 : i+2+length]
+			if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+				tiff = segment[6:]
+				break
+			}
+			i += 2 + length
+		}
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		for i := 8; i+12 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[i:]))
+			if length < 0 || i+12+length > len(data) {
+				break
+			}
+			if string(data[i+4:i+8]) == "eXIf" {
+				tiff = data[i+8 : i+8+length]
+				break
+			}
+			i += 12 + length
+		}
+	case len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for i := 12; i+8 <= len(data); {
+			length := int(binary.LittleEndian.Uint32(data[i+4:]))
+			if length < 0 || i+8+length > len(data) {
+				break
+			}
+			if string(data[i:i+4]) == "EXIF" {
+				tiff = bytes.TrimPrefix(data[i+8:i+8+length], []byte("Exif\x00\x00"))
+				break
+			}
+			i += 8 + length + length%2
+		}
+	}
+	if len(tiff) < 8 {
+		return 1
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := ifd + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient turns an image the right way up for its EXIF orientation
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // Upside down and mirrored
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Needs turning clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transversed
+				sx, sy = w-1-y, h-1-x
+			case 8: // Needs turning anticlockwise
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+// resizeToWidth scales img down to width, keeping its aspect ratio
+func resizeToWidth(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// isOpaque reports whether an image has no transparent pixels
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+// writeImage encodes img to path as JPEG or PNG, by the path's extension
+func writeImage(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if filepath.Ext(path) == ".jpg" {
+		err = jpeg.Encode(f, img, &jpeg.Options{Quality: imageQuality})
+	} else {
+		err = png.Encode(f, img)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// gifFrames counts the frames of a GIF without decoding them, stopping once
+// it has counted more than limit. Malformed data is counted as far as it can
+// be read, and left for the decoder to reject.
+func gifFrames(data []byte, limit int) int {
+	if len(data) < 13 {
+		return 0
+	}
+	pos := 13 // Header and logical screen descriptor
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1) // Global color table
+	}
+	// skipSubBlocks moves past a run of data sub-blocks and its terminator
+	skipSubBlocks := func() {
+		for pos < len(data) && data[pos] != 0 {
+			pos += int(data[pos]) + 1
+		}
+		pos++
+	}
+	frames := 0
+	for pos < len(data) && frames <= limit {
+		switch data[pos] {
+		case 0x21: // Extension: label, then sub-blocks
+			pos += 2
+			skipSubBlocks()
+		case 0x2C: // Image descriptor, local color table, LZW code size, then sub-blocks
+			if pos+10 > len(data) {
+				return frames
+			}
+			frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			pos++
+			skipSubBlocks()
+		default: // Trailer, or not a GIF block
+			return frames
+		}
+	}
+	return frames
+}
+
+// checkDecodable refuses images too large to decode safely, going by the
+// dimensions and, for GIFs, the frame count in their headers
+func checkDecodable(data []byte) error {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("image could not be read")
+	}
+	if config.Width*config.Height > maxImagePixels {
+		return fmt.Errorf("image is too large (%dx%d)", config.Width, config.Height)
+	}
+	if format == "gif" {
+		frames := gifFrames(data, maxGIFFrames)
+		if frames > maxGIFFrames || frames*config.Width*config.Height > maxImagePixels {
+			return fmt.Errorf("animation is too large (%dx%d, %d frames)", config.Width, config.Height, frames)
+		}
+	}
+	return nil
+}
+
+// processImage re-encodes an uploaded image of the given detected type in
+// place of the file at path, upright, without metadata and within
+// maxImageDimension, and writes its resized copies. WebP images are stored
+// as JPEG, or PNG if they have transparency, since there is no WebP encoder.
+// It returns the name the image ended up under and its copies.
+func processImage(path string, fileType FileType) (string, []string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	if err := checkDecodable(data); err != nil {
+		return "", nil, err
+	}
+
+	var img image.Image
+	switch fileType {
+	case fileTypeGIF:
+		// Re-encoding keeps animation but drops comments and other extensions
+		anim, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return "", nil, fmt.Errorf("image could not be read")
+		}
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, anim); err != nil {
+			return "", nil, err
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return "", nil, err
+		}
+		if len(anim.Image) > 1 {
+			// Resized copies would lose the animation
+			return path, nil, nil
+		}
+		img = anim.Image[0]
+	case fileTypeWebP:
+		if img, err = webp.Decode(bytes.NewReader(data)); err != nil {
+			return "", nil, fmt.Errorf("image could not be read")
+		}
+	default:
+		if img, _, err = image.Decode(bytes.NewReader(data)); err != nil {
+			return "", nil, fmt.Errorf("image could not be read")
+		}
+	}
+	img = orient(img, exifOrientation(data))
+
+	if fileType != fileTypeGIF {
+		if b := img.Bounds(); b.Dx() > maxImageDimension || b.Dy() > maxImageDimension {
+			img = resizeToFit(img, maxImageDimension)
+		}
+		processed := path
+		if fileType == fileTypeWebP {
+			ext := ".png"
+			if isOpaque(img) {
+				ext = ".jpg"
+			}
+			processed = strings.TrimSuffix(path, filepath.Ext(path)) + ext
+		}
+		if err := writeImage(processed, img); err != nil {
+			return "", nil, err
+		}
+		if processed != path {
+			os.Remove(path)
+			path = processed
+		}
+	}
+
+	variants := []string{}
+	for _, v := range imageVariants {
+		if img.Bounds().Dx() <= v.Width {
+			break
+		}
+		name := variantFilename(path, v.Width)
+		if err := writeImage(name, resizeToWidth(img, v.Width)); err != nil {
+			for _, written := range variants {
+				os.Remove(written)
+			}
+			return "", nil, err
+		}
+		variants = append(variants, name)
+	}
+	return path, variants, nil
+}
+
+// removeWithVariants deletes an uploaded file along with any resized copies
+func removeWithVariants(name string) {
+	os.Remove(name)
+	for _, v := range imageVariants {
+		os.Remove(variantFilename(name, v.Width))
+	}
+}
+
+// imageVariantURLs lists the resized copies available for an image URL
+func imageVariantURLs(dir, url string) []ImageVariant {
+	variants := []ImageVariant{}
+	filename := strings.TrimPrefix(url, "/uploads/images/")
+	for _, v := range imageVariants {
+		name := variantFilename(filename, v.Width)
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			variants = append(variants, ImageVariant{Name: v.Name, Width: v.Width, URL: "/uploads/images/" + name})
+		}
+	}
+	return variants
+}
+
+// imageServer serves uploaded images, picking a resized copy when the
+// request asks for a size
+func imageServer(dir string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		size := r.URL.Query().Get("size")
+		if size == "" {
+			serveMedia(w, r, dir, "/uploads/images/", publicMediaMaxAge, false)
+			return
+		}
+		width, err := strconv.Atoi(size)
+		if err != nil {
+			width = 0
+			for _, v := range imageVariants {
+				if v.Name == size {
+					width = v.Width
+				}
+			}
+		}
+		if width <= 0 {
+			http.Error(w, "unknown size", http.StatusBadRequest)
+			return
+		}
+
+		filename := strings.TrimPrefix(path.Clean(r.URL.Path), "/uploads/images/")
+		for _, v := range imageVariants {
+			if v.Width < width {
+				continue
+			}
+			name := variantFilename(filename, v.Width)
+			if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+				r.URL.Path = "/uploads/images/" + name
+				break
+			}
+		}
+		serveMedia(w, r, dir, "/uploads/images/", publicMediaMaxAge, false)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	_ "image/gif" // register GIF decoder
+	"image/jpeg"
+	_ "image/png" // register PNG decoder
+	"os"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // register WebP decoder
+)
+
+// thumbnailSize is the longest edge, in pixels, of generated thumbnails
+const thumbnailSize = 320
+
+// resizeToFit scales img down so its longest edge is at most maxDim pixels.
+// Images that already fit are returned unchanged.
+func resizeToFit(img image.Image, maxDim int) image.Image {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w <= maxDim && h <= maxDim {
+		return img
+	}
+
+	if w >= h {
+		h = h * maxDim / w
+		w = maxDim
+	} else {
+		w = w * maxDim / h
+		h = maxDim
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, b, draw.Src, nil)
+	return dst
+}
+
+// generateThumbnail decodes the image at srcPath and writes a JPEG thumbnail
+// no larger than maxDim on either edge to dstPath
+func generateThumbnail(srcPath, dstPath string, maxDim int) error {
+	data, err := os.ReadFile(srcPath)
+	if err != nil {
+		return err
+	}
+	if err := checkDecodable(data); err != nil {
+		return err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.Create(dstPath)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	return jpeg.Encode(dst, flatten(resizeToFit(img, maxDim)), &jpeg.Options{Quality: 80})
+}
+
+// flatten draws img over a white background, since JPEG has no transparency
+func flatten(img image.Image) image.Image {
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Over)
+	return dst
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Item and unit statuses
+const (
+	statusAvailable     = ""
+	statusInMaintenance = "in_maintenance"
+)
+
+// History actions written by the server
+const (
+	actionCheckedOut  = "checked_out"
+	actionServiced    = "serviced"
+	actionTransferOut = "transferred_out"
+	actionTransferIn  = "transferred_in"
+	actionReceived    = "received"
+	actionConsumed    = "consumed"
+	actionReturned    = "returned"
+)
+
+// serverActions are the history actions only the server records
+var serverActions = map[string]bool{
+	actionCheckedOut:  true,
+	actionServiced:    true,
+	actionTransferOut: true,
+	actionTransferIn:  true,
+	actionReceived:    true,
+	actionConsumed:    true,
+	actionReturned:    true,
+}
+
+// SerializedUnit is a single tracked unit of an inventory item, such as one
+// specific meter out of several of the same model
+type SerializedUnit struct {
+	SerialNumber string `json:"serial_number"`
+	Status       string `json:"status,omitempty"` // "" (available), "in_maintenance"
+}
+
+// findUnit looks up a serialized unit of an item by serial number
+func findUnit(item *InventoryItem, serial string) (*SerializedUnit, bool) {
+	for i := range item.Units {
+		if item.Units[i].SerialNumber == serial {
+			return &item.Units[i], true
+		}
+	}
+	return nil, false
+}
+
+// availableForCheckout returns how much of an item can be checked out,
+// excluding anything that is in maintenance
+func availableForCheckout(item InventoryItem) float64 {
+	if item.Status == statusInMaintenance {
+		return 0
+	}
+	available := item.Quantity
+	for _, unit := range item.Units {
+		if unit.Status == statusInMaintenance {
+			available--
+		}
+	}
+	if available < 0 {
+		return 0
+	}
+	return available
+}
+
+// checkoutCount counts how many times an item has been checked out
+func checkoutCount(item InventoryItem) int {
+	count := 0
+	for _, e := range item.History {
+		if e.Action == actionCheckedOut {
+			count++
+		}
+	}
+	return count
+}
+
+// assignItemIDs gives every inventory item without an ID a new one and
+// reports whether any were assigned
+func assignItemIDs(items []InventoryItem) bool {
+	assigned := false
+	for i := range items {
+		if items[i].ID == "" {
+			items[i].ID = uuid.New().String()
+			assigned = true
+		}
+	}
+	return assigned
+}
+
+// findItem looks up an item by ID in a user's active or deleted inventory
+func findItem(u *User, id string) (*InventoryItem, bool) {
+	if id == "" {
+		return nil, false
+	}
+	for i := range u.Inventory {
+		if u.Inventory[i].ID == id {
+			return &u.Inventory[i], true
+		}
+	}
+	for i := range u.DeletedInventory {
+		if u.DeletedInventory[i].ID == id {
+			return &u.DeletedInventory[i], true
+		}
+	}
+	return nil, false
+}
+
+// findActiveItem looks up an item by ID in a user's active inventory only
+func findActiveItem(u *User, id string) (*InventoryItem, bool) {
+	return findInventoryItem(u.Inventory, id)
+}
+
+// findInventoryItem looks up an item by ID in an inventory
+func findInventoryItem(items []InventoryItem, id string) (*InventoryItem, bool) {
+	for i := range items {
+		if id != "" && items[i].ID == id {
+			return &items[i], true
+		}
+	}
+	return nil, false
+}
+
+// cloneItems copies an inventory along with each item's history, packs,
+// units and attachments, so changes to the copy never reach the original
+func cloneItems(items []InventoryItem) []InventoryItem {
+	if items == nil {
+		return nil
+	}
+	cloned := make([]InventoryItem, len(items))
+	for i, item := range items {
+		item.History = slices.Clone(item.History)
+		item.PackSizes = slices.Clone(item.PackSizes)
+		item.Units = slices.Clone(item.Units)
+		item.Attachments = slices.Clone(item.Attachments)
+		cloned[i] = item
+	}
+	return cloned
+}
+
+// itemsByID indexes a user's active and deleted inventory by item ID
+func itemsByID(u User) map[string]InventoryItem {
+	items := make(map[string]InventoryItem, len(u.Inventory)+len(u.DeletedInventory))
+	for _, item := range u.Inventory {
+		if item.ID != "" {
+			items[item.ID] = item
+		}
+	}
+	for _, item := range u.DeletedInventory {
+		if item.ID != "" {
+			items[item.ID] = item
+		}
+	}
+	return items
+}
+
+// preserveServerFields copies fields that are only ever written by the server
+// (such as attachments) from the stored items onto items sent by the client,
+// so a client saving a stale copy of its inventory doesn't drop them. History
+// is merged: the client records its own edits there, but the entries the
+// server recorded are kept as stored, since check-out counts for maintenance
+// are taken from them.
+func preserveServerFields(prev map[string]InventoryItem, items []InventoryItem) {
+	for i := range items {
+		old, ok := prev[items[i].ID]
+		if !ok {
+			items[i].History = clientHistory(items[i].History)
+			continue
+		}
+		items[i].History = mergeHistory(old.History, items[i].History)
+		items[i].Status = old.Status
+		items[i].Unit = old.Unit
+		items[i].PackSizes = old.PackSizes
+		items[i].Units = old.Units
+		items[i].Attachments = old.Attachments
+	}
+}
+
+// clientHistory drops entries with server-only actions from history sent by
+// a client
+func clientHistory(history []HistoryEntry) []HistoryEntry {
+	kept := make([]HistoryEntry, 0, len(history))
+	for _, e := range history {
+		if !serverActions[e.Action] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// mergeHistory combines the server-recorded entries of an item's stored
+// history with the client's entries from sent, oldest first
+func mergeHistory(stored, sent []HistoryEntry) []HistoryEntry {
+	merged := clientHistory(sent)
+	for _, e := range stored {
+		if serverActions[e.Action] {
+			merged = append(merged, e)
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool {
+		return merged[i].Timestamp.Before(merged[j].Timestamp)
+	})
+	return merged
+}
+
+// checkOutItem takes qty (in the base unit) of an item off the shelf and
+// records who took it
+func checkOutItem(item *InventoryItem, qty float64, by, note string) error {
+	if err := validateQuantity(*item, qty); err != nil {
+		return err
+	}
+	if available := availableForCheckout(*item); qty > available {
+		return fmt.Errorf("only %s available for check-out", formatQuantity(available, item.Unit))
+	}
+
+	now := time.Now()
+	item.History = append(item.History, HistoryEntry{
+		Timestamp:       now,
+		Action:          actionCheckedOut,
+		Field:           "quantity",
+		OldValue:        formatQuantity(item.Quantity, ""),
+		NewValue:        formatQuantity(roundQuantity(item.Quantity-qty), ""),
+		ItemDescription: item.Description,
+		User:            by,
+		Note:            note,
+		Unit:            itemUnit(*item).Code,
+	})
+	item.Quantity = roundQuantity(item.Quantity - qty)
+	item.UpdatedAt = now
+	return nil
+}
+
+// returnItem puts qty (in the base unit) of a checked-out item back on the
+// shelf and records who returned it
+func returnItem(item *InventoryItem, qty float64, by, note string) {
+	now := time.Now()
+	item.History = append(item.History, HistoryEntry{
+		Timestamp:       now,
+		Action:          actionReturned,
+		Field:           "quantity",
+		OldValue:        formatQuantity(item.Quantity, ""),
+		NewValue:        formatQuantity(roundQuantity(item.Quantity+qty), ""),
+		ItemDescription: item.Description,
+		User:            by,
+		Note:            note,
+		Unit:            itemUnit(*item).Code,
+	})
+	item.Quantity = roundQuantity(item.Quantity + qty)
+	item.UpdatedAt = now
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// StockRequest represents a receive or consume request. Unit may be the
+// item's base unit or the name of one of its packs.
+type StockRequest struct {
+	Email    string  `json:"email"`
+	ItemID   string  `json:"item_id"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit,omitempty"`
+	Note     string  `json:"note,omitempty"`
+}
+
+// HandleGetUnits handles listing the supported units of measure
+func (h *Handlers) HandleGetUnits(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":    true,
+		"units": sortedUnits(),
+	})
+}
+
+// HandleSetItemUnit handles setting an item's unit of measure and pack sizes.
+// Quantities are kept as they are, not converted, since reservations,
+// transfers and history refer to them in the base unit; the change is refused
+// if they couldn't be counted in the new unit.
+func (h *Handlers) HandleSetItemUnit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email     string     `json:"email"`
+		ItemID    string     `json:"item_id"`
+		Unit      string     `json:"unit"`
+		PackSizes []PackSize `json:"pack_sizes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	unitCode := strings.ToLower(strings.TrimSpace(req.Unit))
+	if unitCode == "" {
+		unitCode = defaultUnit
+	}
+	unit, ok := unitsOfMeasure[unitCode]
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("unknown unit %q", req.Unit)})
+		return
+	}
+	if err := validatePackSizes(req.PackSizes, unit); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	for i := range req.PackSizes {
+		req.PackSizes[i].Name = strings.TrimSpace(req.PackSizes[i].Name)
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, ok := h.store.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	var item InventoryItem
+	var unitErr error // Reported to the user, unlike failures to save
+	err := h.store.transact([]string{email}, func(users []*User) error {
+		found, ok := findItem(users[0], req.ItemID)
+		if !ok {
+			unitErr = fmt.Errorf("item not found")
+			return unitErr
+		}
+
+		changed := *found
+		changed.Unit = unit.Code
+		if err := validateStockLevels(changed); err != nil {
+			unitErr = fmt.Errorf("%v; adjust it first", err)
+			return unitErr
+		}
+
+		found.Unit = unit.Code
+		found.PackSizes = req.PackSizes
+		found.UpdatedAt = time.Now()
+		users[0].UpdatedAt = time.Now()
+		item = *found
+		return nil
+	})
+	if unitErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": unitErr.Error()})
+		return
+	}
+	if err != nil {
+		logError("failed to update item unit", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to update item"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"item": item,
+	})
+}
+
+// HandleReceiveStock handles adding stock to an item, e.g. receiving 2 boxes
+func (h *Handlers) HandleReceiveStock(w http.ResponseWriter, r *http.Request) {
+	h.adjustStock(w, r, actionReceived, 1)
+}
+
+// HandleConsumeStock handles using up stock of an item, e.g. 12.5 ft of wire
+func (h *Handlers) HandleConsumeStock(w http.ResponseWriter, r *http.Request) {
+	h.adjustStock(w, r, actionConsumed, -1)
+}
+
+// adjustStock converts a receive/consume request to the item's base unit,
+// applies it and records the unit it was entered in
+func (h *Handlers) adjustStock(w http.ResponseWriter, r *http.Request, action string, sign float64) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req StockRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, ok := h.store.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	var item InventoryItem
+	var stockErr error // Reported to the user, unlike failures to save
+	err := h.store.transact([]string{email}, func(users []*User) error {
+		found, ok := findItem(users[0], req.ItemID)
+		if !ok {
+			stockErr = fmt.Errorf("item not found")
+			return stockErr
+		}
+
+		qty, err := toBaseQuantity(*found, req.Quantity, req.Unit)
+		if err != nil {
+			stockErr = err
+			return stockErr
+		}
+		if err := validateQuantity(*found, qty); err != nil {
+			stockErr = err
+			return stockErr
+		}
+		if sign < 0 && qty > found.Quantity {
+			stockErr = fmt.Errorf("only %s in stock", formatQuantity(found.Quantity, itemUnit(*found).Code))
+			return stockErr
+		}
+
+		base := itemUnit(*found).Code
+		unit := strings.TrimSpace(req.Unit)
+		if unit == "" {
+			unit = base
+		}
+		note := strings.TrimSpace(req.Note)
+		if unit != base {
+			converted := fmt.Sprintf("%s = %s", formatQuantity(req.Quantity, unit), formatQuantity(qty, base))
+			if note != "" {
+				note = converted + "; " + note
+			} else {
+				note = converted
+			}
+		}
+
+		now := time.Now()
+		newQuantity := roundQuantity(found.Quantity + sign*qty)
+		found.History = append(found.History, HistoryEntry{
+			Timestamp:       now,
+			Action:          action,
+			Field:           "quantity",
+			OldValue:        formatQuantity(found.Quantity, ""),
+			NewValue:        formatQuantity(newQuantity, ""),
+			ItemDescription: found.Description,
+			User:            email,
+			Note:            note,
+			Unit:            unit,
+		})
+		found.Quantity = newQuantity
+		found.UpdatedAt = now
+		users[0].UpdatedAt = now
+		item = *found
+		return nil
+	})
+	if stockErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": stockErr.Error()})
+		return
+	}
+	if err != nil {
+		logError("failed to update stock", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to update stock"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"item": item,
+	})
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LearningPath is an ordered sequence of trainings completed as a programme
+type LearningPath struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	TrainingIDs []string  `json:"training_ids"` // In the order learners should take them
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// includes reports whether the path contains a training
+func (p LearningPath) includes(trainingID string) bool {
+	for _, id := range p.TrainingIDs {
+		if id == trainingID {
+			return true
+		}
+	}
+	return false
+}
+
+// PathStep is one training in a learner's view of a path
+type PathStep struct {
+	TrainingID  string     `json:"training_id"`
+	Title       string     `json:"title"`
+	Percent     int        `json:"percent"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Locked      bool       `json:"locked"`
+}
+
+// PathProgress is a learner's progress through a path
+type PathProgress struct {
+	PathID        string     `json:"path_id"`
+	Email         string     `json:"email"`
+	Steps         []PathStep `json:"steps"`
+	Completed     int        `json:"completed"`
+	Total         int        `json:"total"`
+	Percent       int        `json:"percent"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty"`
+	CertificateID string     `json:"certificate_id,omitempty"`
+}
+
+// missingPrerequisites returns the trainings the learner must complete before
+// taking a training and has not: its prerequisites and, since paths are taken
+// in order, the trainings before it on the paths the learner was assigned.
+// Paths they were not assigned don't bind them. Trainings that have since
+// been deleted or unpublished no longer count, since the learner could not
+// take them.
+func missingPrerequisites(t Training, email string, trainings *TrainingStore, paths *PathStore, assignments *AssignmentStore, progress *ProgressStore) []Training {
+	required := append([]string{}, t.Prerequisites...)
+	assigned := assignments.pathsFor(email)
+	for _, path := range paths.containing(t.ID) {
+		if !assigned[path.ID] {
+			continue
+		}
+		for _, id := range path.TrainingIDs {
+			if id == t.ID {
+				break
+			}
+			required = append(required, id)
+		}
+	}
+
+	missing := make([]Training, 0)
+	seen := map[string]bool{}
+	for _, id := range required {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		prereq, ok := trainings.get(id)
+		if !ok || prereq.DeletedAt != nil || !prereq.isPublished() {
+			continue
+		}
+		if p, ok := progress.get(id, email); !ok || p.CompletedAt == nil {
+			missing = append(missing, prereq)
+		}
+	}
+	return missing
+}
+
+// pathProgress works out a learner's progress through the published
+// trainings of a path from their progress on each
+func pathProgress(path LearningPath, email string, trainings *TrainingStore, revisions *RevisionStore, paths *PathStore, assignments *AssignmentStore, progress *ProgressStore) PathProgress {
+	result := PathProgress{PathID: path.ID, Email: email, Steps: make([]PathStep, 0, len(path.TrainingIDs))}
+	var lastCompleted *time.Time
+	for _, id := range path.TrainingIDs {
+		stored, ok := trainings.get(id)
+		if !ok || stored.DeletedAt != nil {
+			continue
+		}
+		t, ok := revisions.published(stored)
+		if !ok {
+			continue
+		}
+		step := PathStep{TrainingID: t.ID, Title: t.Title}
+		if p, ok := progress.get(t.ID, email); ok {
+			step.Percent = p.Percent
+			step.CompletedAt = p.CompletedAt
+		}
+		if step.CompletedAt != nil {
+			result.Completed++
+			if lastCompleted == nil || step.CompletedAt.After(*lastCompleted) {
+				lastCompleted = step.CompletedAt
+			}
+		} else {
+			step.Locked = len(missingPrerequisites(t, email, trainings, paths, assignments, progress)) > 0
+		}
+		result.Steps = append(result.Steps, step)
+	}
+	result.Total = len(result.Steps)
+	if result.Total > 0 {
+		result.Percent = result.Completed * 100 / result.Total
+		if result.Completed == result.Total {
+			result.CompletedAt = lastCompleted
+		}
+	}
+	return result
+}
+
+// validatePrerequisites checks that prerequisites exist and don't form a
+// cycle back to the training, returning them without duplicates
+func (s *TrainingStore) validatePrerequisites(trainingID string, prerequisites []string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cleaned := make([]string, 0, len(prerequisites))
+	seen := map[string]bool{}
+	for _, id := range prerequisites {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if id == trainingID {
+			return nil, fmt.Errorf("a training cannot be its own prerequisite")
+		}
+		if t, ok := s.Trainings[id]; !ok || t.DeletedAt != nil {
+			return nil, fmt.Errorf("prerequisite %s not found", id)
+		}
+		cleaned = append(cleaned, id)
+	}
+
+	// Walk the prerequisite graph looking for a path back to this training
+	visited := map[string]bool{}
+	var reaches func(id string) bool
+	reaches = func(id string) bool {
+		if id == trainingID {
+			return true
+		}
+		if visited[id] {
+			return false
+		}
+		visited[id] = true
+		for _, next := range s.Trainings[id].Prerequisites {
+			if reaches(next) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, id := range cleaned {
+		if reaches(id) {
+			return nil, fmt.Errorf("prerequisite %q would create a cycle", s.Trainings[id].Title)
+		}
+	}
+	return cleaned, nil
+}
+
+// orderCycle looks for trainings no learner could ever start because their
+// prerequisites and the order of the paths they're on each require the other
+// to be completed first, returning a training on such a cycle
+func orderCycle(trainings []Training, paths []LearningPath) (Training, bool) {
+	byID := make(map[string]Training, len(trainings))
+	before := map[string][]string{}
+	for _, t := range trainings {
+		byID[t.ID] = t
+		before[t.ID] = append(before[t.ID], t.Prerequisites...)
+	}
+	for _, p := range paths {
+		for i := 1; i < len(p.TrainingIDs); i++ {
+			before[p.TrainingIDs[i]] = append(before[p.TrainingIDs[i]], p.TrainingIDs[i-1])
+		}
+	}
+
+	// Depth-first search; reaching a training still being visited closes a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	var onCycle string
+	var walk func(id string) bool
+	walk = func(id string) bool {
+		switch state[id] {
+		case visiting:
+			onCycle = id
+			return true
+		case visited:
+			return false
+		}
+		state[id] = visiting
+		for _, next := range before[id] {
+			if walk(next) {
+				return true
+			}
+		}
+		state[id] = visited
+		return false
+	}
+	sort.Slice(trainings, func(i, j int) bool { return trainings[i].Title < trainings[j].Title })
+	for _, t := range trainings {
+		if walk(t.ID) {
+			return byID[onCycle], true
+		}
+	}
+	return Training{}, false
+}
+
+// PathStore manages learning paths
+type PathStore struct {
+	mu    sync.Mutex
+	Paths map[string]LearningPath `json:"paths"`
+	file  string
+}
+
+// NewPathStore creates a new learning path store
+func NewPathStore(path string) *PathStore {
+	s := &PathStore{Paths: map[string]LearningPath{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *PathStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var paths map[string]LearningPath
+	if err := readJSONFile(s.file, &paths); err != nil {
+		logError("failed to load learning paths", err)
+		return
+	}
+	if paths != nil {
+		s.Paths = paths
+	}
+}
+
+func (s *PathStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Paths)
+}
+
+func (s *PathStore) get(id string) (LearningPath, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Paths[id]
+	return p, ok
+}
+
+func (s *PathStore) put(p LearningPath) error {
+	s.mu.Lock()
+	s.Paths[p.ID] = p
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *PathStore) delete(id string) error {
+	s.mu.Lock()
+	delete(s.Paths, id)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// getAll returns every path, by title
+func (s *PathStore) getAll() []LearningPath {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	paths := make([]LearningPath, 0, len(s.Paths))
+	for _, p := range s.Paths {
+		paths = append(paths, p)
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].Title < paths[j].Title })
+	return paths
+}
+
+// containing returns the paths a training belongs to
+func (s *PathStore) containing(trainingID string) []LearningPath {
+	paths := make([]LearningPath, 0)
+	for _, p := range s.getAll() {
+		if p.includes(trainingID) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -13,22 +14,32 @@ import (
 
 type HistoryEntry struct {
 	Timestamp       time.Time `json:"timestamp"`
-	Action          string    `json:"action"` // "added", "removed", "quantity_changed", "target_changed", "restored"
+	Action          string    `json:"action"` // "added", "removed", "quantity_changed", "target_changed", "restored", "checked_out", "serviced", "received", "consumed", "transferred_out", "transferred_in"
 	Field           string    `json:"field,omitempty"`
 	OldValue        string    `json:"old_value,omitempty"`
 	NewValue        string    `json:"new_value,omitempty"`
 	ItemDescription string    `json:"item_description"`
+	User            string    `json:"user,omitempty"` // Who made the change, for server-recorded entries
+	Note            string    `json:"note,omitempty"`
+	Unit            string    `json:"unit,omitempty"`      // Unit or pack the change was entered in
+	Reference       string    `json:"reference,omitempty"` // Links related entries, e.g. both sides of a transfer
 }
 
 type InventoryItem struct {
-	Description    string         `json:"description"`
-	UPC            string         `json:"upc"`
-	Number         string         `json:"number"`
-	Quantity       int            `json:"quantity"`
-	TargetQuantity int            `json:"target_quantity"`
-	History        []HistoryEntry `json:"history,omitempty"`
-	CreatedAt      time.Time      `json:"created_at,omitempty"`
-	UpdatedAt      time.Time      `json:"updated_at,omitempty"`
+	ID             string           `json:"id,omitempty"`
+	Description    string           `json:"description"`
+	UPC            string           `json:"upc"`
+	Number         string           `json:"number"`
+	Quantity       float64          `json:"quantity"` // In the item's base unit
+	TargetQuantity float64          `json:"target_quantity"`
+	Unit           string           `json:"unit,omitempty"`       // Base unit of measure, e.g. "ea", "ft"
+	PackSizes      []PackSize       `json:"pack_sizes,omitempty"` // Packs the item is received or used in
+	History        []HistoryEntry   `json:"history,omitempty"`
+	Status         string           `json:"status,omitempty"`      // "" (available), "in_maintenance"
+	Units          []SerializedUnit `json:"units,omitempty"`       // Individually tracked units, by serial number
+	Attachments    []Attachment     `json:"attachments,omitempty"` // Photos, manuals and receipts
+	CreatedAt      time.Time        `json:"created_at,omitempty"`
+	UpdatedAt      time.Time        `json:"updated_at,omitempty"`
 }
 
 type User struct {
@@ -37,6 +48,7 @@ type User struct {
 	HashedPassword   string          `json:"hashed_password"`
 	Inventory        []InventoryItem `json:"inventory"`
 	DeletedInventory []InventoryItem `json:"deleted_inventory,omitempty"`
+	Version          int             `json:"version,omitempty"` // Bumped on every save, so stale inventory saves can be refused
 	CreatedAt        time.Time       `json:"created_at,omitempty"`
 	UpdatedAt        time.Time       `json:"updated_at,omitempty"`
 }
@@ -45,6 +57,7 @@ type UserStore struct {
 	mu    sync.Mutex
 	Users map[string]User `json:"users"`
 	file  string
+	index *SearchIndex // Kept up to date on every write, once set
 }
 
 func NewUserStore(path string) *UserStore {
@@ -70,6 +83,11 @@ func (s *UserStore) load() {
 func (s *UserStore) save() error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.saveLocked()
+}
+
+// saveLocked writes users to disk; the caller must hold s.mu
+func (s *UserStore) saveLocked() error {
 	data, err := json.MarshalIndent(s.Users, "", "  ")
 	if err != nil {
 		return err
@@ -86,16 +104,87 @@ func (s *UserStore) get(email string) (User, bool) {
 
 func (s *UserStore) put(u User) error {
 	s.mu.Lock()
+	u.Version = s.Users[u.Email].Version + 1
 	s.Users[u.Email] = u
+	if s.index != nil {
+		s.index.putUser(u)
+	}
 	s.mu.Unlock()
 	return s.save()
 }
 
-func (s *UserStore) Delete(email string) error {
+// transact applies fn to several users at once and saves them together, so
+// either every change is stored or none is
+func (s *UserStore) transact(emails []string, fn func(users []*User) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	users := make([]*User, len(emails))
+	for i, email := range emails {
+		u, ok := s.Users[email]
+		if !ok {
+			return fmt.Errorf("user %s not found", email)
+		}
+		// fn works on copies, so if it fails or the save does, the stored
+		// users are left untouched
+		u.Inventory = cloneItems(u.Inventory)
+		u.DeletedInventory = cloneItems(u.DeletedInventory)
+		users[i] = &u
+	}
+
+	if err := fn(users); err != nil {
+		return err
+	}
+
+	prev := make(map[string]User, len(users))
+	for _, u := range users {
+		prev[u.Email] = s.Users[u.Email]
+		u.Version = prev[u.Email].Version + 1
+		s.Users[u.Email] = *u
+	}
+	if err := s.saveLocked(); err != nil {
+		for email, u := range prev {
+			s.Users[email] = u
+		}
+		return err
+	}
+	if s.index != nil {
+		for _, u := range users {
+			s.index.putUser(*u)
+		}
+	}
+	return nil
+}
+
+// Delete removes a user, returning them as they were when removed so their
+// files can be cleaned up
+func (s *UserStore) Delete(email string) (User, error) {
 	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Users[email]
+	if !ok {
+		return User{}, fmt.Errorf("user %s not found", email)
+	}
 	delete(s.Users, email)
-	s.mu.Unlock()
-	return s.save()
+	if err := s.saveLocked(); err != nil {
+		s.Users[email] = u
+		return User{}, err
+	}
+	if s.index != nil {
+		s.index.removeUser(email)
+	}
+	return u, nil
+}
+
+// indexWith indexes every user's inventory for search and keeps index up to
+// date with later writes
+func (s *UserStore) indexWith(index *SearchIndex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index = index
+	for _, u := range s.Users {
+		index.putUser(u)
+	}
 }
 
 func (s *UserStore) getAllUsers() []User {
@@ -141,7 +230,11 @@ func main() {
 	store := NewUserStore(usersFile)
 
 	// Initialize handlers
-	handlers := NewHandlers(store)
+	attachmentUploadPath := filepath.Join(getCurrentDir(), "uploads", "attachments")
+	handlers := NewHandlers(store, attachmentUploadPath)
+
+	// Serve inventory attachments
+	http.Handle("/uploads/attachments/", http.StripPrefix("/uploads/attachments/", http.FileServer(http.Dir(attachmentUploadPath))))
 
 	// Register API routes with middleware
 	http.HandleFunc("/api/signup", chainMiddleware(
@@ -189,17 +282,474 @@ func main() {
 		loggingMiddleware,
 	))
 
+	http.HandleFunc("/api/units", chainMiddleware(
+		handlers.HandleGetUnits,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/inventory/unit", chainMiddleware(
+		handlers.HandleSetItemUnit,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/inventory/receive", chainMiddleware(
+		handlers.HandleReceiveStock,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/inventory/consume", chainMiddleware(
+		handlers.HandleConsumeStock,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/inventory/attachments", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				handlers.HandleGetAttachments(w, r)
+			case http.MethodPost:
+				handlers.HandleUploadAttachment(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/inventory/attachments/delete", chainMiddleware(
+		handlers.HandleDeleteAttachment,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize maintenance store and handlers
+	maintenanceFile := filepath.Join(getCurrentDir(), "maintenance.json")
+	maintenanceStore := NewMaintenanceStore(maintenanceFile)
+	maintenanceHandlers := NewMaintenanceHandlers(maintenanceStore, store)
+
+	http.HandleFunc("/api/maintenance/plans", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				maintenanceHandlers.HandleGetPlans(w, r)
+			case http.MethodPost:
+				maintenanceHandlers.HandleCreatePlan(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/maintenance/plans/delete", chainMiddleware(
+		maintenanceHandlers.HandleDeletePlan,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/maintenance/due", chainMiddleware(
+		maintenanceHandlers.HandleGetDue,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/maintenance/start", chainMiddleware(
+		maintenanceHandlers.HandleStartService,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/maintenance/complete", chainMiddleware(
+		maintenanceHandlers.HandleCompleteService,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/maintenance/records", chainMiddleware(
+		maintenanceHandlers.HandleGetRecords,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize reservation store and handlers
+	reservationsFile := filepath.Join(getCurrentDir(), "reservations.json")
+	reservationStore := NewReservationStore(reservationsFile)
+	reservationHandlers := NewReservationHandlers(reservationStore, store)
+
+	http.HandleFunc("/api/reservations", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				reservationHandlers.HandleGetReservations(w, r)
+			case http.MethodPost:
+				reservationHandlers.HandleCreateReservation(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/reservations/cancel", chainMiddleware(
+		reservationHandlers.HandleCancelReservation,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/reservations/checkout", chainMiddleware(
+		reservationHandlers.HandleCheckOutReservation,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/reservations/return", chainMiddleware(
+		reservationHandlers.HandleReturnReservation,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/inventory/availability", chainMiddleware(
+		reservationHandlers.HandleGetAvailability,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize teams, whose inventories transfers can move stock in and out of
+	teamsFile := filepath.Join(getCurrentDir(), "teams.json")
+	teamStore := NewTeamStore(teamsFile)
+
+	// Initialize transfer store and handlers
+	transfersFile := filepath.Join(getCurrentDir(), "transfers.json")
+	transferStore := NewTransferStore(transfersFile)
+	transferHandlers := NewTransferHandlers(transferStore, store, teamStore, reservationStore, maintenanceStore)
+
+	http.HandleFunc("/api/transfers", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				transferHandlers.HandleGetTransfers(w, r)
+			case http.MethodPost:
+				transferHandlers.HandleCreateTransfer(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/transfers/accept", chainMiddleware(
+		transferHandlers.HandleAcceptTransfer,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/transfers/reject", chainMiddleware(
+		transferHandlers.HandleRejectTransfer,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/transfers/cancel", chainMiddleware(
+		transferHandlers.HandleCancelTransfer,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
 	// Initialize training store and handlers
 	trainingsFile := filepath.Join(getCurrentDir(), "trainings.json")
 	trainingStore := NewTrainingStore(trainingsFile)
+	revisionsFile := filepath.Join(getCurrentDir(), "training_revisions.json")
+	revisionStore := NewRevisionStore(revisionsFile)
+	if err := migrateUnrevisioned(trainingStore, revisionStore); err != nil {
+		logError("failed to migrate trainings to revisions", err)
+	}
+	progressFile := filepath.Join(getCurrentDir(), "progress.json")
+	progressStore := NewProgressStore(progressFile)
 	videoUploadPath := filepath.Join(getCurrentDir(), "uploads", "videos")
 	imageUploadPath := filepath.Join(getCurrentDir(), "uploads", "images")
-	trainingHandlers := NewTrainingHandlers(trainingStore, videoUploadPath, imageUploadPath)
+	captionUploadPath := filepath.Join(getCurrentDir(), "uploads", "captions")
+	mediaFile := filepath.Join(getCurrentDir(), "media.json")
+	mediaStore := NewMediaStore(mediaFile)
+	mediaSigner, err := newMediaSigner(filepath.Join(getCurrentDir(), "media_signing.key"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	pathsFile := filepath.Join(getCurrentDir(), "learning_paths.json")
+	pathStore := NewPathStore(pathsFile)
+	assignmentsFile := filepath.Join(getCurrentDir(), "assignments.json")
+	assignmentStore := NewAssignmentStore(assignmentsFile)
+	trainingHandlers := NewTrainingHandlers(trainingStore, revisionStore, progressStore, pathStore, assignmentStore, mediaStore, mediaSigner, videoUploadPath, imageUploadPath, captionUploadPath)
+
+	// Initialize team handlers, notifications and training assignments
+	teamHandlers := NewTeamHandlers(teamStore, store)
+	notificationsFile := filepath.Join(getCurrentDir(), "notifications.json")
+	notificationStore := NewNotificationStore(notificationsFile)
+	notificationHandlers := NewNotificationHandlers(notificationStore)
+	notifier := newNotifier(notificationStore)
+	assignmentHandlers := NewAssignmentHandlers(assignmentStore, trainingStore, teamStore, pathStore, store, notifier)
+
+	// Check for due and overdue assignments in the background
+	go assignmentHandlers.runReminders(time.Hour)
+
+	http.HandleFunc("/api/teams", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				teamHandlers.HandleGetTeams(w, r)
+			case http.MethodPost:
+				teamHandlers.HandleCreateTeam(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/teams/members", chainMiddleware(
+		teamHandlers.HandleUpdateMembers,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/notifications", chainMiddleware(
+		notificationHandlers.HandleGetNotifications,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/notifications/read", chainMiddleware(
+		notificationHandlers.HandleMarkRead,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/assignments", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				assignmentHandlers.HandleGetAssignments(w, r)
+			case http.MethodPost:
+				assignmentHandlers.HandleCreateAssignments(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/assignments/mine", chainMiddleware(
+		assignmentHandlers.HandleGetMyAssignments,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/assignments/overdue", chainMiddleware(
+		assignmentHandlers.HandleGetOverdue,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/assignments/cancel", chainMiddleware(
+		assignmentHandlers.HandleCancelAssignment,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/assignments/remind", chainMiddleware(
+		assignmentHandlers.HandleSendReminders,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize the xAPI Learning Record Store; learning activity is
+	// recorded there as statements
+	statementsFile := filepath.Join(getCurrentDir(), "xapi_statements.json")
+	statementStore := NewStatementStore(statementsFile)
+	xapiEmitter := newXAPIEmitter(statementStore, store)
+	xapiHandlers := NewXAPIHandlers(statementStore, xapiEmitter)
+	if !xapiHandlers.enabled() {
+		log.Printf("xAPI LRS endpoints are disabled; set XAPI_USERNAME and XAPI_PASSWORD to enable them")
+	}
+
+	http.HandleFunc("/api/xapi/statements", chainMiddleware(
+		xapiHandlers.HandleStatements,
+		xapiHandlers.middleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/xapi/about", chainMiddleware(
+		xapiHandlers.HandleAbout,
+		xapiHandlers.middleware,
+		loggingMiddleware,
+	))
+
+	// Initialize learner progress tracking and completion certificates
+	certificatesFile := filepath.Join(getCurrentDir(), "certificates.json")
+	certificateStore := NewCertificateStore(certificatesFile)
+	certificateHandlers := NewCertificateHandlers(certificateStore, trainingStore)
+	progressHandlers := NewProgressHandlers(progressStore, trainingStore, revisionStore, pathStore, assignmentStore, certificateStore, store, xapiEmitter)
+
+	http.HandleFunc("/api/progress", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				progressHandlers.HandleGetProgress(w, r)
+			case http.MethodPost:
+				progressHandlers.HandleRecordProgress(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/progress/report", chainMiddleware(
+		progressHandlers.HandleGetReport,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/certificates", chainMiddleware(
+		certificateHandlers.HandleGetCertificates,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/certificates/download", chainMiddleware(
+		certificateHandlers.HandleDownloadCertificate,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/certificates/verify", chainMiddleware(
+		certificateHandlers.HandleVerifyCertificate,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Learning paths
+	pathHandlers := NewPathHandlers(pathStore, trainingStore, revisionStore, progressStore, assignmentStore, certificateStore)
+
+	http.HandleFunc("/api/paths", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				pathHandlers.HandleGetPaths(w, r)
+			case http.MethodPost:
+				pathHandlers.HandleCreatePath(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/paths/update", chainMiddleware(
+		pathHandlers.HandleUpdatePath,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/paths/delete", chainMiddleware(
+		pathHandlers.HandleDeletePath,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/paths/progress", chainMiddleware(
+		pathHandlers.HandleGetPathProgress,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Expire certifications once a training's validity period passes
+	recertificationHandlers := NewRecertificationHandlers(certificateStore, progressHandlers, assignmentStore, trainingStore, pathStore, notifier)
+	go recertificationHandlers.runExpiry(time.Hour)
+
+	http.HandleFunc("/api/certifications/expiring", chainMiddleware(
+		recertificationHandlers.HandleGetExpiring,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/certifications/process", chainMiddleware(
+		recertificationHandlers.HandleProcessExpired,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize quiz grading and attempt history
+	quizFile := filepath.Join(getCurrentDir(), "quiz_attempts.json")
+	quizStore := NewQuizStore(quizFile)
+	quizHandlers := NewQuizHandlers(quizStore, trainingStore, revisionStore, progressHandlers, store)
+
+	http.HandleFunc("/api/quiz/submit", chainMiddleware(
+		quizHandlers.HandleSubmitQuiz,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/quiz/attempts", chainMiddleware(
+		quizHandlers.HandleGetAttempts,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize SCORM package import and runtime
+	scormPackagesFile := filepath.Join(getCurrentDir(), "scorm_packages.json")
+	scormPackageStore := NewScormPackageStore(scormPackagesFile)
+	scormAttemptsFile := filepath.Join(getCurrentDir(), "scorm_attempts.json")
+	scormAttemptStore := NewScormAttemptStore(scormAttemptsFile)
+	scormUploadPath := filepath.Join(getCurrentDir(), "uploads", "scorm")
+	scormHandlers := NewScormHandlers(scormPackageStore, scormAttemptStore, trainingHandlers, progressHandlers, store, scormUploadPath)
+
+	http.HandleFunc("/api/scorm/import", chainMiddleware(
+		scormHandlers.HandleImportScorm,
+		corsMiddleware,
+		loggingMiddleware,
+	))
 
-	// Serve uploaded videos
-	http.Handle("/uploads/videos/", http.StripPrefix("/uploads/videos/", http.FileServer(http.Dir(videoUploadPath))))
-	// Serve uploaded images
-	http.Handle("/uploads/images/", http.StripPrefix("/uploads/images/", http.FileServer(http.Dir(imageUploadPath))))
+	http.HandleFunc("/api/scorm/runtime", chainMiddleware(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodGet:
+				scormHandlers.HandleGetRuntime(w, r)
+			case http.MethodPost:
+				scormHandlers.HandleCommitRuntime(w, r)
+			default:
+				respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+			}
+		},
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/scorm/attempts", chainMiddleware(
+		scormHandlers.HandleGetAttempts,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Serve extracted SCORM packages, sandboxed, to holders of a signed link
+	http.Handle(scormPrefix, scormServer(scormUploadPath, mediaSigner))
+
+	// Serve uploaded videos and their captions to holders of a signed link
+	http.Handle("/uploads/videos/", signedMediaServer(videoUploadPath, "/uploads/videos/", mediaSigner))
+	http.Handle("/uploads/captions/", signedMediaServer(captionUploadPath, "/uploads/captions/", mediaSigner))
+	// Serve uploaded images, resized with ?size=
+	http.Handle("/uploads/images/", imageServer(imageUploadPath))
 
 	// Training API routes
 	http.HandleFunc("/api/trainings", chainMiddleware(
@@ -217,6 +767,12 @@ func main() {
 		loggingMiddleware,
 	))
 
+	http.HandleFunc("/api/trainings/catalog", chainMiddleware(
+		trainingHandlers.HandleGetCatalog,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
 	http.HandleFunc("/api/training", chainMiddleware(
 		trainingHandlers.HandleGetTraining,
 		corsMiddleware,
@@ -229,6 +785,24 @@ func main() {
 		loggingMiddleware,
 	))
 
+	http.HandleFunc("/api/training/publish", chainMiddleware(
+		trainingHandlers.HandlePublishTraining,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/training/revisions", chainMiddleware(
+		trainingHandlers.HandleGetRevisions,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/training/rollback", chainMiddleware(
+		trainingHandlers.HandleRollbackTraining,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
 	http.HandleFunc("/api/training/delete", chainMiddleware(
 		trainingHandlers.HandleDeleteTraining,
 		corsMiddleware,
@@ -253,6 +827,49 @@ func main() {
 		loggingMiddleware,
 	))
 
+	http.HandleFunc("/api/training/import/markdown", chainMiddleware(
+		trainingHandlers.HandleImportMarkdown,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/training/export/markdown", chainMiddleware(
+		trainingHandlers.HandleExportMarkdown,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/training/import/package", chainMiddleware(
+		trainingHandlers.HandleImportPackage,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/training/export/package", chainMiddleware(
+		trainingHandlers.HandleExportPackage,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize resumable video uploads; chunks are kept out of the served
+	// upload directories until the upload is complete
+	uploadsFile := filepath.Join(getCurrentDir(), "uploads.json")
+	uploadStore := NewUploadStore(uploadsFile)
+	partialUploadPath := filepath.Join(getCurrentDir(), "uploads", "partial")
+	uploadHandlers := NewUploadHandlers(uploadStore, store, mediaStore, partialUploadPath, videoUploadPath)
+
+	http.HandleFunc("/api/uploads/videos", chainMiddleware(
+		uploadHandlers.HandleCreateUpload,
+		uploadHandlers.middleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/uploads/videos/", chainMiddleware(
+		uploadHandlers.HandleUpload,
+		uploadHandlers.middleware,
+		loggingMiddleware,
+	))
+
 	http.HandleFunc("/api/upload-video", chainMiddleware(
 		trainingHandlers.HandleUploadVideo,
 		corsMiddleware,
@@ -265,6 +882,53 @@ func main() {
 		loggingMiddleware,
 	))
 
+	http.HandleFunc("/api/upload-captions", chainMiddleware(
+		trainingHandlers.HandleUploadCaptions,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize the media library; uploads no training uses are removed
+	// in the background after a grace period
+	mediaHandlers := NewMediaHandlers(mediaStore, trainingStore, revisionStore, store, videoUploadPath, imageUploadPath, captionUploadPath)
+	go mediaHandlers.runCollection(mediaCollectInterval)
+
+	http.HandleFunc("/api/media", chainMiddleware(
+		mediaHandlers.HandleGetMedia,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/media/delete", chainMiddleware(
+		mediaHandlers.HandleDeleteMedia,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/media/sign", chainMiddleware(
+		trainingHandlers.HandleSignMedia,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	http.HandleFunc("/api/media/collect", chainMiddleware(
+		mediaHandlers.HandleCollectMedia,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
+	// Initialize search; the stores keep the index up to date as they change
+	searchIndex := NewSearchIndex(revisionStore)
+	store.indexWith(searchIndex)
+	trainingStore.indexWith(searchIndex)
+	searchHandlers := NewSearchHandlers(searchIndex, store)
+
+	http.HandleFunc("/api/search", chainMiddleware(
+		searchHandlers.HandleSearch,
+		corsMiddleware,
+		loggingMiddleware,
+	))
+
 	// Start the server
 	port := os.Getenv("PORT")
 	if port == "" {
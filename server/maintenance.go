@@ -0,0 +1,225 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MaintenancePlan schedules periodic service for an item or a serialized unit
+type MaintenancePlan struct {
+	ID                string     `json:"id"`
+	Owner             string     `json:"owner"` // Email of the inventory owner
+	ItemID            string     `json:"item_id"`
+	SerialNumber      string     `json:"serial_number,omitempty"` // Empty when the plan covers the whole item
+	Name              string     `json:"name"`                    // e.g. "Annual calibration"
+	IntervalDays      int        `json:"interval_days,omitempty"`
+	IntervalCheckouts int        `json:"interval_checkouts,omitempty"`
+	Notes             string     `json:"notes,omitempty"`
+	LastServicedAt    *time.Time `json:"last_serviced_at,omitempty"`
+	CheckoutsAtLast   int        `json:"checkouts_at_last_service"` // Check-out count when last serviced
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// ServiceRecord records a completed maintenance service
+type ServiceRecord struct {
+	ID           string    `json:"id"`
+	PlanID       string    `json:"plan_id"`
+	Owner        string    `json:"owner"`
+	ItemID       string    `json:"item_id"`
+	SerialNumber string    `json:"serial_number,omitempty"`
+	PerformedBy  string    `json:"performed_by"`
+	Notes        string    `json:"notes,omitempty"`
+	Cost         float64   `json:"cost"`
+	CompletedAt  time.Time `json:"completed_at"`
+}
+
+// MaintenanceDue describes where a plan stands against its schedule
+type MaintenanceDue struct {
+	Plan            MaintenancePlan `json:"plan"`
+	ItemDescription string          `json:"item_description"`
+	Status          string          `json:"status"` // ok, due, overdue
+	DueAt           *time.Time      `json:"due_at,omitempty"`
+	CheckoutsLeft   *int            `json:"checkouts_left,omitempty"`
+	InMaintenance   bool            `json:"in_maintenance"`
+}
+
+// dueSoonWindow is how far ahead a date-based plan is reported as due
+const dueSoonWindow = 7 * 24 * time.Hour
+
+// dueStatus works out whether a plan is ok, due or overdue. A plan is due when
+// it falls inside the due-soon window (or has one check-out left) and overdue
+// once its interval has been exceeded.
+func (p MaintenancePlan) dueStatus(item InventoryItem, now time.Time) MaintenanceDue {
+	d := MaintenanceDue{Plan: p, ItemDescription: item.Description, Status: "ok"}
+	worsen := func(status string) {
+		if status == "overdue" || (status == "due" && d.Status == "ok") {
+			d.Status = status
+		}
+	}
+
+	if p.IntervalDays > 0 {
+		since := p.CreatedAt
+		if p.LastServicedAt != nil {
+			since = *p.LastServicedAt
+		}
+		due := since.AddDate(0, 0, p.IntervalDays)
+		d.DueAt = &due
+		switch {
+		case !now.Before(due):
+			worsen("overdue")
+		case due.Sub(now) <= dueSoonWindow:
+			worsen("due")
+		}
+	}
+
+	if p.IntervalCheckouts > 0 {
+		left := p.IntervalCheckouts - (checkoutCount(item) - p.CheckoutsAtLast)
+		if left < 0 {
+			left = 0
+		}
+		d.CheckoutsLeft = &left
+		switch {
+		case left == 0:
+			worsen("overdue")
+		case left == 1 && p.IntervalCheckouts > 1:
+			worsen("due")
+		}
+	}
+
+	if p.SerialNumber != "" {
+		if unit, ok := findUnit(&item, p.SerialNumber); ok {
+			d.InMaintenance = unit.Status == statusInMaintenance
+		}
+	} else {
+		d.InMaintenance = item.Status == statusInMaintenance
+	}
+	return d
+}
+
+// MaintenanceStore manages maintenance plans and service records
+type MaintenanceStore struct {
+	mu      sync.Mutex
+	Plans   map[string]MaintenancePlan `json:"plans"`
+	Records []ServiceRecord            `json:"records"`
+	file    string
+}
+
+// NewMaintenanceStore creates a new maintenance store
+func NewMaintenanceStore(path string) *MaintenanceStore {
+	s := &MaintenanceStore{Plans: map[string]MaintenancePlan{}, Records: []ServiceRecord{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *MaintenanceStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := readJSONFile(s.file, s); err != nil {
+		logError("failed to load maintenance data", err)
+	}
+	if s.Plans == nil {
+		s.Plans = map[string]MaintenancePlan{}
+	}
+}
+
+func (s *MaintenanceStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s)
+}
+
+func (s *MaintenanceStore) getPlan(id string) (MaintenancePlan, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Plans[id]
+	return p, ok
+}
+
+func (s *MaintenanceStore) getPlansByOwner(owner string) []MaintenancePlan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plans := make([]MaintenancePlan, 0)
+	for _, p := range s.Plans {
+		if p.Owner == owner {
+			plans = append(plans, p)
+		}
+	}
+	sort.Slice(plans, func(i, j int) bool { return plans[i].CreatedAt.Before(plans[j].CreatedAt) })
+	return plans
+}
+
+func (s *MaintenanceStore) putPlan(p MaintenancePlan) error {
+	s.mu.Lock()
+	s.Plans[p.ID] = p
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *MaintenanceStore) deletePlan(id string) error {
+	s.mu.Lock()
+	delete(s.Plans, id)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// getUnitPlans returns an owner's plans for the given serialized units of an item
+func (s *MaintenanceStore) getUnitPlans(owner, itemID string, serials []string) []MaintenancePlan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	plans := make([]MaintenancePlan, 0)
+	for _, p := range s.Plans {
+		if p.Owner == owner && p.ItemID == itemID && p.SerialNumber != "" && slices.Contains(serials, p.SerialNumber) {
+			plans = append(plans, p)
+		}
+	}
+	return plans
+}
+
+// putPlans stores several plans in one save
+func (s *MaintenanceStore) putPlans(plans []MaintenancePlan) error {
+	s.mu.Lock()
+	for _, p := range plans {
+		s.Plans[p.ID] = p
+	}
+	s.mu.Unlock()
+	return s.save()
+}
+
+// completeService stores a service record and the plan it resets in one save
+func (s *MaintenanceStore) completeService(p MaintenancePlan, rec ServiceRecord) error {
+	s.mu.Lock()
+	s.Plans[p.ID] = p
+	s.Records = append(s.Records, rec)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// undoService puts a plan back as it was before a service and drops the
+// service's record, for when the item's status couldn't be saved with them
+func (s *MaintenanceStore) undoService(prev MaintenancePlan, recordID string) error {
+	s.mu.Lock()
+	s.Plans[prev.ID] = prev
+	for i, rec := range s.Records {
+		if rec.ID == recordID {
+			s.Records = append(s.Records[:i], s.Records[i+1:]...)
+			break
+		}
+	}
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *MaintenanceStore) getRecords(owner, itemID string) []ServiceRecord {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]ServiceRecord, 0)
+	for _, rec := range s.Records {
+		if rec.Owner == owner && (itemID == "" || rec.ItemID == itemID) {
+			records = append(records, rec)
+		}
+	}
+	return records
+}
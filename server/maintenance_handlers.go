@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// MaintenanceHandlers contains all maintenance-related HTTP handlers
+type MaintenanceHandlers struct {
+	store *MaintenanceStore
+	users *UserStore
+}
+
+// NewMaintenanceHandlers creates a new MaintenanceHandlers instance
+func NewMaintenanceHandlers(store *MaintenanceStore, users *UserStore) *MaintenanceHandlers {
+	return &MaintenanceHandlers{store: store, users: users}
+}
+
+// HandleGetPlans handles listing a user's maintenance plans
+func (h *MaintenanceHandlers) HandleGetPlans(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":    true,
+		"plans": h.store.getPlansByOwner(email),
+	})
+}
+
+// HandleCreatePlan handles creating a maintenance plan for an item or serialized unit
+func (h *MaintenanceHandlers) HandleCreatePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email             string `json:"email"`
+		ItemID            string `json:"item_id"`
+		SerialNumber      string `json:"serial_number,omitempty"`
+		Name              string `json:"name"`
+		IntervalDays      int    `json:"interval_days,omitempty"`
+		IntervalCheckouts int    `json:"interval_checkouts,omitempty"`
+		Notes             string `json:"notes,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	name := strings.TrimSpace(req.Name)
+	serial := strings.TrimSpace(req.SerialNumber)
+
+	if name == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "name is required"})
+		return
+	}
+	if req.IntervalDays < 0 || req.IntervalCheckouts < 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "intervals cannot be negative"})
+		return
+	}
+	if req.IntervalDays == 0 && req.IntervalCheckouts == 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "interval_days or interval_checkouts is required"})
+		return
+	}
+
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	var plan MaintenancePlan
+	var planErr error // Reported to the user, unlike failures to save
+	saved := false
+	err := h.users.transact([]string{email}, func(users []*User) error {
+		item, ok := findItem(users[0], req.ItemID)
+		if !ok {
+			planErr = fmt.Errorf("item not found")
+			return planErr
+		}
+
+		// Start tracking the unit if this is the first plan that names it
+		if serial != "" {
+			if _, ok := findUnit(item, serial); !ok {
+				if float64(len(item.Units)) >= item.Quantity {
+					planErr = fmt.Errorf("every unit of this item already has a serial number")
+					return planErr
+				}
+				item.Units = append(item.Units, SerializedUnit{SerialNumber: serial})
+				item.UpdatedAt = time.Now()
+			}
+		}
+
+		now := time.Now()
+		plan = MaintenancePlan{
+			ID:                uuid.New().String(),
+			Owner:             email,
+			ItemID:            item.ID,
+			SerialNumber:      serial,
+			Name:              name,
+			IntervalDays:      req.IntervalDays,
+			IntervalCheckouts: req.IntervalCheckouts,
+			Notes:             strings.TrimSpace(req.Notes),
+			CheckoutsAtLast:   checkoutCount(*item),
+			CreatedAt:         now,
+			UpdatedAt:         now,
+		}
+
+		// Save the plan before the unit it names, and remove it if the unit
+		// can't be saved
+		if err := h.store.putPlan(plan); err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	if planErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": planErr.Error()})
+		return
+	}
+	if err != nil {
+		logError("failed to save maintenance plan", err)
+		if saved {
+			if err := h.store.deletePlan(plan.ID); err != nil {
+				logError("failed to remove maintenance plan", err)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to create plan"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"plan": plan,
+	})
+}
+
+// HandleDeletePlan handles removing a maintenance plan
+func (h *MaintenanceHandlers) HandleDeletePlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	plan, ok := h.store.getPlan(req.ID)
+	if !ok || plan.Owner != strings.ToLower(strings.TrimSpace(req.Email)) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "plan not found"})
+		return
+	}
+
+	if err := h.store.deletePlan(plan.ID); err != nil {
+		logError("failed to delete maintenance plan", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to delete plan"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
+
+// HandleGetDue handles listing plans that are due or overdue for service.
+// Pass all=true to include plans that are not yet due.
+func (h *MaintenanceHandlers) HandleGetDue(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+	includeAll, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+	u, ok := h.users.get(email)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	now := time.Now()
+	due := make([]MaintenanceDue, 0)
+	for _, plan := range h.store.getPlansByOwner(email) {
+		item, ok := findItem(&u, plan.ItemID)
+		if !ok {
+			continue
+		}
+		d := plan.dueStatus(*item, now)
+		if d.Status != "ok" || d.InMaintenance || includeAll {
+			due = append(due, d)
+		}
+	}
+
+	// Overdue first, then due, then by due date
+	rank := map[string]int{"overdue": 0, "due": 1, "ok": 2}
+	sort.SliceStable(due, func(i, j int) bool {
+		if rank[due[i].Status] != rank[due[j].Status] {
+			return rank[due[i].Status] < rank[due[j].Status]
+		}
+		if due[i].DueAt != nil && due[j].DueAt != nil {
+			return due[i].DueAt.Before(*due[j].DueAt)
+		}
+		return due[i].DueAt != nil
+	})
+
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"maintenance": due,
+	})
+}
+
+// HandleStartService handles taking an item or unit out of service for maintenance
+func (h *MaintenanceHandlers) HandleStartService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		PlanID string `json:"plan_id"`
+		Email  string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	plan, ok := h.store.getPlan(req.PlanID)
+	if !ok || plan.Owner != strings.ToLower(strings.TrimSpace(req.Email)) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "plan not found"})
+		return
+	}
+
+	if err := h.setServiceStatus(plan, statusInMaintenance); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
+
+// HandleCompleteService handles recording a completed service and returning
+// the item or unit to service
+func (h *MaintenanceHandlers) HandleCompleteService(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		PlanID      string  `json:"plan_id"`
+		Email       string  `json:"email"`
+		PerformedBy string  `json:"performed_by,omitempty"`
+		Notes       string  `json:"notes,omitempty"`
+		Cost        float64 `json:"cost,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	plan, ok := h.store.getPlan(req.PlanID)
+	if !ok || plan.Owner != email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "plan not found"})
+		return
+	}
+	if req.Cost < 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "cost cannot be negative"})
+		return
+	}
+
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	performedBy := strings.TrimSpace(req.PerformedBy)
+	if performedBy == "" {
+		performedBy = email
+	}
+
+	now := time.Now()
+	record := ServiceRecord{
+		ID:           uuid.New().String(),
+		PlanID:       plan.ID,
+		Owner:        email,
+		ItemID:       plan.ItemID,
+		SerialNumber: plan.SerialNumber,
+		PerformedBy:  performedBy,
+		Notes:        strings.TrimSpace(req.Notes),
+		Cost:         req.Cost,
+		CompletedAt:  now,
+	}
+
+	prev := plan
+	var serviceErr error // Reported to the user, unlike failures to save
+	saved := false
+	err := h.users.transact([]string{email}, func(users []*User) error {
+		item, ok := findItem(users[0], plan.ItemID)
+		if !ok {
+			serviceErr = fmt.Errorf("item not found")
+			return serviceErr
+		}
+
+		// Back in service, with a history entry on the item
+		if serviceErr = setItemStatus(item, plan.SerialNumber, statusAvailable); serviceErr != nil {
+			return serviceErr
+		}
+		newValue := plan.Name
+		if plan.SerialNumber != "" {
+			newValue = fmt.Sprintf("%s (S/N %s)", plan.Name, plan.SerialNumber)
+		}
+		item.History = append(item.History, HistoryEntry{
+			Timestamp:       now,
+			Action:          actionServiced,
+			NewValue:        newValue,
+			ItemDescription: item.Description,
+		})
+		users[0].UpdatedAt = now
+
+		plan.LastServicedAt = &now
+		plan.CheckoutsAtLast = checkoutCount(*item)
+		plan.UpdatedAt = now
+
+		// Save the record before the item, and undo it if the item can't be
+		// saved
+		if err := h.store.completeService(plan, record); err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	if serviceErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": serviceErr.Error()})
+		return
+	}
+	if err != nil {
+		logError("failed to record service", err)
+		if saved {
+			if err := h.store.undoService(prev, record.ID); err != nil {
+				logError("failed to undo service record", err)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to record service"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":     true,
+		"plan":   plan,
+		"record": record,
+	})
+}
+
+// HandleGetRecords handles listing completed services, optionally for one item
+func (h *MaintenanceHandlers) HandleGetRecords(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":      true,
+		"records": h.store.getRecords(email, r.URL.Query().Get("item_id")),
+	})
+}
+
+// setServiceStatus sets the status of the item or unit a plan covers
+func (h *MaintenanceHandlers) setServiceStatus(plan MaintenancePlan, status string) error {
+	if _, ok := h.users.get(plan.Owner); !ok {
+		return fmt.Errorf("user not found")
+	}
+	var statusErr error // Reported to the user, unlike failures to save
+	err := h.users.transact([]string{plan.Owner}, func(users []*User) error {
+		item, ok := findItem(users[0], plan.ItemID)
+		if !ok {
+			statusErr = fmt.Errorf("item not found")
+			return statusErr
+		}
+		if statusErr = setItemStatus(item, plan.SerialNumber, status); statusErr != nil {
+			return statusErr
+		}
+		users[0].UpdatedAt = time.Now()
+		return nil
+	})
+	if statusErr != nil {
+		return statusErr
+	}
+	if err != nil {
+		logError("failed to update item status", err)
+		return fmt.Errorf("failed to update item status")
+	}
+	return nil
+}
+
+// setItemStatus sets the status of an item, or of one of its units when a
+// serial number is given
+func setItemStatus(item *InventoryItem, serial, status string) error {
+	if serial != "" {
+		unit, ok := findUnit(item, serial)
+		if !ok {
+			return fmt.Errorf("unit %s not found", serial)
+		}
+		unit.Status = status
+	} else {
+		item.Status = status
+	}
+	item.UpdatedAt = time.Now()
+	return nil
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Trainings convert to and from Markdown so authors can write them in a text
+// editor. Headings become title blocks, fenced code becomes code blocks,
+// lists, blockquotes, images and thematic breaks map to their block types and
+// everything else is text. Videos are written as images pointing at an
+// uploaded video, and quizzes as a fenced "quiz" block holding their JSON.
+// The training's title and description go in front matter:
+//
+//	---
+//	title: "Forklift Basics"
+//	description: "Daily checks and safe operation"
+//	---
+
+var (
+	mdHeading     = regexp.MustCompile(`^ {0,3}(#{1,6})\s+(.*?)(?:\s+#+)?\s*$`)
+	mdDivider     = regexp.MustCompile(`^ {0,3}([-*_])(?:\s*([-*_])){2,}\s*$`)
+	mdImage       = regexp.MustCompile(`^ {0,3}!\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)\s*$`)
+	mdFence       = regexp.MustCompile("^ {0,3}(`{3,}|~{3,})\\s*([^`\\s]*)")
+	mdBullet      = regexp.MustCompile(`^ {0,3}[-*+]\s+(.*)$`)
+	mdNumbered    = regexp.MustCompile(`^ {0,3}\d{1,9}[.)]\s+(.*)$`)
+	mdQuoteAuthor = regexp.MustCompile(`^(?:—|–|--)\s*(.+)$`)
+	mdCodeSpan    = regexp.MustCompile("`([^`]+)`")
+	mdLink        = regexp.MustCompile(`\[([^\]]+)\]\(\s*<?([^)\s>]+)>?\s*\)`)
+	mdStrong      = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdEmphasis    = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*|\b_([^_]+)_\b`)
+)
+
+// markdownReader builds blocks from Markdown a line at a time
+type markdownReader struct {
+	lines      []string
+	pos        int
+	blocks     []ContentBlock
+	paragraphs []string // Text waiting to become a text block
+	paragraph  []string // Lines of the paragraph being read
+}
+
+func (m *markdownReader) endParagraph() {
+	if len(m.paragraph) > 0 {
+		m.paragraphs = append(m.paragraphs, strings.Join(m.paragraph, "\n"))
+		m.paragraph = nil
+	}
+}
+
+// add appends a block, first turning any paragraphs before it into a text block
+func (m *markdownReader) add(blockType string, content map[string]interface{}) {
+	m.endParagraph()
+	if len(m.paragraphs) > 0 {
+		text := strings.Join(m.paragraphs, "\n\n")
+		m.paragraphs = nil
+		m.add("text", map[string]interface{}{"text": markdownInlineToHTML(text)})
+	}
+	if blockType == "" {
+		return
+	}
+	m.blocks = append(m.blocks, ContentBlock{
+		ID:      uuid.New().String(),
+		Type:    blockType,
+		Order:   len(m.blocks),
+		Content: content,
+	})
+}
+
+// frontMatter reads the title and description from front matter at the top
+// of the document, if there is any
+func (m *markdownReader) frontMatter() (title, description string) {
+	if len(m.lines) == 0 || strings.TrimSpace(m.lines[0]) != "---" {
+		return "", ""
+	}
+	fields := map[string]string{}
+	for i := 1; i < len(m.lines); i++ {
+		line := strings.TrimSpace(m.lines[i])
+		if line == "---" {
+			m.pos = i + 1
+			return fields["title"], fields["description"]
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return "", "" // Not front matter; the first line is a divider
+		}
+		value = strings.TrimSpace(value)
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		}
+		fields[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+	return "", ""
+}
+
+// list reads consecutive items of a bulleted or numbered list
+func (m *markdownReader) list(item *regexp.Regexp) []interface{} {
+	items := []interface{}{}
+	for m.pos < len(m.lines) {
+		line := m.lines[m.pos]
+		if match := item.FindStringSubmatch(line); match != nil && !mdDivider.MatchString(line) {
+			items = append(items, markdownInlineToText(match[1]))
+			m.pos++
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			// A blank line only ends the list if the next item isn't part of it
+			next := m.pos + 1
+			for next < len(m.lines) && strings.TrimSpace(m.lines[next]) == "" {
+				next++
+			}
+			if next < len(m.lines) && item.MatchString(m.lines[next]) {
+				m.pos = next
+				continue
+			}
+			break
+		}
+		if (strings.HasPrefix(line, "  ") || strings.HasPrefix(line, "\t")) && len(items) > 0 {
+			// Continuation of the previous item
+			last := len(items) - 1
+			items[last] = items[last].(string) + " " + markdownInlineToText(strings.TrimSpace(line))
+			m.pos++
+			continue
+		}
+		break
+	}
+	return items
+}
+
+// trainingFromMarkdown converts a Markdown document into a training's title,
+// description and blocks. Without front matter a leading level 1 heading is
+// used as the title.
+func trainingFromMarkdown(src string) (title, description string, blocks []ContentBlock, err error) {
+	src = strings.TrimPrefix(strings.ReplaceAll(src, "\r\n", "\n"), "\ufeff")
+	m := &markdownReader{lines: strings.Split(src, "\n")}
+	title, description = m.frontMatter()
+
+	for m.pos < len(m.lines) {
+		line := m.lines[m.pos]
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" {
+			m.endParagraph()
+			m.pos++
+			continue
+		}
+
+		if match := mdFence.FindStringSubmatch(line); match != nil {
+			start := m.pos + 1
+			fence := match[1]
+			language := strings.ToLower(match[2])
+			m.pos++
+			code := []string{}
+			for m.pos < len(m.lines) {
+				closing := strings.TrimSpace(m.lines[m.pos])
+				if strings.HasPrefix(closing, fence) && strings.Trim(closing, fence[:1]) == "" {
+					break
+				}
+				code = append(code, m.lines[m.pos])
+				m.pos++
+			}
+			m.pos++
+
+			if language == "quiz" {
+				var content map[string]interface{}
+				if err := json.Unmarshal([]byte(strings.Join(code, "\n")), &content); err != nil {
+					return "", "", nil, fmt.Errorf("line %d: invalid quiz: %v", start, err)
+				}
+				m.add("quiz", content)
+				continue
+			}
+			m.add("code", map[string]interface{}{"code": strings.Join(code, "\n"), "language": language})
+			continue
+		}
+
+		if match := mdHeading.FindStringSubmatch(line); match != nil {
+			m.pos++
+			if match[1] == "#" && title == "" && len(m.blocks) == 0 && len(m.paragraphs) == 0 && len(m.paragraph) == 0 {
+				title = markdownInlineToText(match[2])
+				continue
+			}
+			level := len(match[1])
+			if level > 4 {
+				level = 4
+			}
+			m.add("title", map[string]interface{}{"text": markdownInlineToText(match[2]), "level": fmt.Sprintf("h%d", level)})
+			continue
+		}
+
+		if mdDivider.MatchString(line) {
+			m.pos++
+			m.add("divider", map[string]interface{}{})
+			continue
+		}
+
+		if match := mdImage.FindStringSubmatch(line); match != nil {
+			m.pos++
+			if strings.HasPrefix(match[2], "/uploads/videos/") {
+				m.add("video", map[string]interface{}{"url": match[2]})
+			} else {
+				m.add("image", map[string]interface{}{"url": match[2], "alt": match[1]})
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, ">") {
+			quote := []string{}
+			for m.pos < len(m.lines) && strings.HasPrefix(strings.TrimSpace(m.lines[m.pos]), ">") {
+				text := strings.TrimPrefix(strings.TrimSpace(m.lines[m.pos]), ">")
+				quote = append(quote, strings.TrimPrefix(text, " "))
+				m.pos++
+			}
+			author := ""
+			if match := mdQuoteAuthor.FindStringSubmatch(strings.TrimSpace(quote[len(quote)-1])); match != nil && len(quote) > 1 {
+				author = match[1]
+				quote = quote[:len(quote)-1]
+			}
+			m.add("quote", map[string]interface{}{"text": strings.TrimSpace(strings.Join(quote, "\n")), "author": author})
+			continue
+		}
+
+		if mdBullet.MatchString(line) {
+			m.add("list", map[string]interface{}{"items": m.list(mdBullet), "ordered": false})
+			continue
+		}
+		if mdNumbered.MatchString(line) && len(m.paragraph) == 0 {
+			m.add("list", map[string]interface{}{"items": m.list(mdNumbered), "ordered": true})
+			continue
+		}
+
+		m.paragraph = append(m.paragraph, trimmed)
+		m.pos++
+	}
+	m.add("", nil)
+
+	return strings.TrimSpace(title), strings.TrimSpace(description), m.blocks, nil
+}
+
+// markdownInlineToHTML converts inline Markdown (code spans, links, strong and
+// emphasis) to the HTML used in text blocks. Code spans are converted first
+// and left alone by the other rules.
+func markdownInlineToHTML(s string) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range mdCodeSpan.FindAllStringSubmatchIndex(s, -1) {
+		sb.WriteString(markdownFormatting(s[last:loc[0]]))
+		sb.WriteString("<code>" + html.EscapeString(s[loc[2]:loc[3]]) + "</code>")
+		last = loc[1]
+	}
+	sb.WriteString(markdownFormatting(s[last:]))
+	return sb.String()
+}
+
+func markdownFormatting(s string) string {
+	s = mdLink.ReplaceAllStringFunc(s, func(link string) string {
+		match := mdLink.FindStringSubmatch(link)
+		return `<a href="` + html.EscapeString(match[2]) + `">` + match[1] + "</a>"
+	})
+	s = mdStrong.ReplaceAllString(s, "<strong>$1$2</strong>")
+	return mdEmphasis.ReplaceAllString(s, "<em>$1$2</em>")
+}
+
+// markdownInlineToText drops inline Markdown from text shown as plain text
+func markdownInlineToText(s string) string {
+	s = mdLink.ReplaceAllString(s, "$1")
+	s = mdCodeSpan.ReplaceAllString(s, "$1")
+	return strings.TrimSpace(mdStrong.ReplaceAllString(s, "$1$2"))
+}
+
+var (
+	htmlStrong = regexp.MustCompile(`</?(?:strong|b)>`)
+	htmlEm     = regexp.MustCompile(`</?(?:em|i)>`)
+	htmlCode   = regexp.MustCompile(`<code>(.*?)</code>`)
+	htmlLink   = regexp.MustCompile(`<a href="([^"]*)"[^>]*>(.*?)</a>`)
+	htmlBreak  = regexp.MustCompile(`<br>\n?`)
+	htmlPara   = regexp.MustCompile(`\s*</?p>\s*`)
+)
+
+// htmlToMarkdown converts the sanitized HTML of a text block back to
+// Markdown. Tags without a Markdown equivalent are kept as inline HTML.
+func htmlToMarkdown(s string) string {
+	s = htmlCode.ReplaceAllStringFunc(s, func(code string) string {
+		return "`" + html.UnescapeString(htmlCode.FindStringSubmatch(code)[1]) + "`"
+	})
+	s = htmlLink.ReplaceAllString(s, "[$2]($1)")
+	s = htmlStrong.ReplaceAllString(s, "**")
+	s = htmlEm.ReplaceAllString(s, "*")
+	s = htmlBreak.ReplaceAllString(s, "\n")
+	s = htmlPara.ReplaceAllString(s, "\n\n")
+	return strings.TrimSpace(s)
+}
+
+// markdownFenceFor returns a code fence longer than any run of backticks in code
+func markdownFenceFor(code string) string {
+	longest, run := 0, 0
+	for _, c := range code {
+		if c == '`' {
+			run++
+			if run > longest {
+				longest = run
+			}
+		} else {
+			run = 0
+		}
+	}
+	if longest < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", longest+1)
+}
+
+// trainingToMarkdown writes a training out as Markdown that
+// trainingFromMarkdown reads back into the same blocks
+func trainingToMarkdown(t Training) string {
+	var sb strings.Builder
+	sb.WriteString("---\n")
+	sb.WriteString("title: " + strconv.Quote(t.Title) + "\n")
+	if t.Description != "" {
+		sb.WriteString("description: " + strconv.Quote(strings.Join(strings.Fields(t.Description), " ")) + "\n")
+	}
+	sb.WriteString("---\n")
+
+	str := func(block ContentBlock, key string) string {
+		s, _ := block.Content[key].(string)
+		return s
+	}
+	for _, block := range t.Blocks {
+		sb.WriteString("\n")
+		switch block.Type {
+		case "title":
+			level := 2
+			fmt.Sscanf(str(block, "level"), "h%d", &level)
+			sb.WriteString(strings.Repeat("#", level) + " " + str(block, "text") + "\n")
+		case "text":
+			sb.WriteString(htmlToMarkdown(str(block, "text")) + "\n")
+		case "video":
+			sb.WriteString("![](" + str(block, "url") + ")\n")
+		case "image":
+			sb.WriteString("![" + str(block, "alt") + "](" + str(block, "url") + ")\n")
+		case "code":
+			fence := markdownFenceFor(str(block, "code"))
+			language := str(block, "language")
+			if language == defaultLanguage {
+				language = ""
+			}
+			sb.WriteString(fence + language + "\n" + str(block, "code") + "\n" + fence + "\n")
+		case "list":
+			items, _ := block.Content["items"].([]interface{})
+			ordered, _ := block.Content["ordered"].(bool)
+			for i, item := range items {
+				if ordered {
+					sb.WriteString(fmt.Sprintf("%d. %v\n", i+1, item))
+				} else {
+					sb.WriteString(fmt.Sprintf("- %v\n", item))
+				}
+			}
+		case "quote":
+			for _, line := range strings.Split(str(block, "text"), "\n") {
+				sb.WriteString(strings.TrimRight("> "+line, " ") + "\n")
+			}
+			if author := str(block, "author"); author != "" {
+				sb.WriteString(">\n> — " + author + "\n")
+			}
+		case "divider":
+			sb.WriteString("---\n")
+		case "quiz":
+			data, _ := json.MarshalIndent(block.Content, "", "  ")
+			sb.WriteString("```quiz\n" + string(data) + "\n```\n")
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// The media registry records every uploaded video, image and caption file:
+// who uploaded it, what it is, and which trainings use it, counting
+// soft-deleted trainings and every published revision, since learners may
+// still be shown those. Files no training uses are removed once they have gone
+// unused for mediaGracePeriod.
+
+const (
+	mediaGracePeriod     = 7 * 24 * time.Hour
+	mediaCollectInterval = time.Hour
+)
+
+// MediaItem is an uploaded video, image or caption file
+type MediaItem struct {
+	ID                string         `json:"id"`
+	URL               string         `json:"url"`
+	Kind              string         `json:"kind"` // video, image or caption
+	Filename          string         `json:"filename"`
+	ContentType       string         `json:"content_type"`
+	Size              int64          `json:"size"`
+	Owner             string         `json:"owner,omitempty"` // Empty for files found on disk that predate the registry
+	UploadedAt        time.Time      `json:"uploaded_at"`
+	References        []string       `json:"references"` // IDs of the trainings using it
+	UnreferencedSince *time.Time     `json:"unreferenced_since,omitempty"`
+	Variants          []ImageVariant `json:"variants,omitempty"`
+}
+
+// expiresAt is when an unused item becomes eligible for removal
+func (m MediaItem) expiresAt() *time.Time {
+	if m.UnreferencedSince == nil {
+		return nil
+	}
+	at := m.UnreferencedSince.Add(mediaGracePeriod)
+	return &at
+}
+
+// newMediaItem describes an upload of kind saved at path. Its content type
+// is taken from the extension, which uploads are always stored under.
+func newMediaItem(kind, path, original, owner string, now time.Time) MediaItem {
+	name := filepath.Base(path)
+	item := MediaItem{
+		ID:          uuid.New().String(),
+		URL:         "/uploads/" + kind + "s/" + name,
+		Kind:        kind,
+		Filename:    displayFilename(original),
+		ContentType: extensionTypes[strings.ToLower(filepath.Ext(name))].MIME,
+		Owner:       strings.ToLower(strings.TrimSpace(owner)),
+		UploadedAt:  now,
+	}
+	if info, err := os.Stat(path); err == nil {
+		item.Size = info.Size()
+	}
+	if kind == uploadKindImage {
+		item.Variants = imageVariantURLs(filepath.Dir(path), item.URL)
+	}
+	return item
+}
+
+// mediaContent is the text a training's references to media are looked for
+// in: its working copy and published revisions. Searching the whole of it
+// rather than known fields also finds uploads linked from text blocks.
+func mediaContent(t Training, revisions []TrainingRevision) string {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(t)
+	enc.Encode(revisions)
+	return buf.String()
+}
+
+// refersTo reports whether content refers to the item, directly or through
+// one of its resized copies
+func (m MediaItem) refersTo(content string) bool {
+	if strings.Contains(content, m.URL) {
+		return true
+	}
+	for _, v := range m.Variants {
+		if strings.Contains(content, v.URL) {
+			return true
+		}
+	}
+	return false
+}
+
+// MediaStore is the media registry, keyed by media ID
+type MediaStore struct {
+	mu    sync.Mutex
+	Items map[string]MediaItem `json:"items"`
+	file  string
+}
+
+// NewMediaStore creates a new media store
+func NewMediaStore(path string) *MediaStore {
+	s := &MediaStore{Items: map[string]MediaItem{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *MediaStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var items map[string]MediaItem
+	if err := readJSONFile(s.file, &items); err != nil {
+		logError("failed to load media", err)
+		return
+	}
+	if items != nil {
+		s.Items = items
+	}
+}
+
+func (s *MediaStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Items)
+}
+
+// register records a new upload. It starts out unused until a training
+// refers to it.
+func (s *MediaStore) register(item MediaItem) error {
+	if item.References == nil {
+		item.References = []string{}
+	}
+	if item.UnreferencedSince == nil {
+		item.UnreferencedSince = &item.UploadedAt
+	}
+	s.mu.Lock()
+	// A collection pass may have come across the file first
+	for id, existing := range s.Items {
+		if existing.URL == item.URL {
+			delete(s.Items, id)
+		}
+	}
+	s.Items[item.ID] = item
+	s.mu.Unlock()
+	return s.save()
+}
+
+// get returns an item by ID
+func (s *MediaStore) get(id string) (MediaItem, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.Items[id]
+	return item, ok
+}
+
+// remove deletes an item's record
+func (s *MediaStore) remove(id string) error {
+	s.mu.Lock()
+	delete(s.Items, id)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// find returns the items matching keep, most recently uploaded first
+func (s *MediaStore) find(keep func(MediaItem) bool) []MediaItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	items := make([]MediaItem, 0)
+	for _, item := range s.Items {
+		if keep(item) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].UploadedAt.After(items[j].UploadedAt)
+	})
+	return items
+}
+
+// update applies fn to every item under one lock and saves once
+func (s *MediaStore) update(fn func(items map[string]MediaItem)) error {
+	s.mu.Lock()
+	fn(s.Items)
+	s.mu.Unlock()
+	return s.save()
+}
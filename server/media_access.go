@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Uploaded videos and their captions are only served to someone holding a
+// signed URL: the path with an expiry time and an HMAC of both. Trainings
+// are handed out with these URLs signed for whoever may view them, and
+// authors can ask for longer lived URLs to embed a video elsewhere. Images
+// stay public, since thumbnails show in the catalog to everyone.
+//
+// SCORM packages are folders of pages that link to each other relatively,
+// so their signature goes in the path and covers the whole package:
+// /uploads/scorm/<package>/<expires>.<signature>/<file>. Package content is
+// someone else's code, so its pages are also sandboxed into an origin of
+// their own, and reach the runtime API through the bridge script added to
+// each page.
+//
+// Uploads are never changed once saved (every upload gets a new name), so
+// they are served with strong ETags and cached for as long as the URL is
+// valid, or a year for public files.
+
+const (
+	mediaURLLifetime    = 6 * time.Hour       // Long enough to watch a training in one sitting
+	maxEmbedURLLifetime = 30 * 24 * time.Hour // The longest an embed link can be made for
+	publicMediaMaxAge   = 365 * 24 * time.Hour
+)
+
+// MediaSigner signs and checks expiring media URLs
+type MediaSigner struct {
+	key []byte
+}
+
+// newMediaSigner creates a signer with the key in MEDIA_SIGNING_KEY, or
+// failing that the key kept in keyFile, which is generated the first time.
+// The key has to outlive restarts for embed links to keep working.
+func newMediaSigner(keyFile string) (*MediaSigner, error) {
+	if key := os.Getenv("MEDIA_SIGNING_KEY"); key != "" {
+		return &MediaSigner{key: []byte(key)}, nil
+	}
+	data, err := os.ReadFile(keyFile)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) < 32 {
+			return nil, fmt.Errorf("invalid media signing key in %s", keyFile)
+		}
+		return &MediaSigner{key: key}, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	return &MediaSigner{key: key}, nil
+}
+
+func (s *MediaSigner) signature(urlPath string, expires int64) string {
+	mac := hmac.New(sha256.New, s.key)
+	fmt.Fprintf(mac, "%s\n%d", urlPath, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// signedMediaPrefixes are the upload URLs that need a signature
+var signedMediaPrefixes = []string{"/uploads/videos/", "/uploads/captions/"}
+
+// isSignedMedia reports whether a URL is an upload only served signed
+func isSignedMedia(mediaURL string) bool {
+	for _, prefix := range signedMediaPrefixes {
+		if strings.HasPrefix(mediaURL, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sign returns the URL of an uploaded video or caption file with a
+// signature valid until expires. Other URLs are returned as they are.
+func (s *MediaSigner) sign(mediaURL string, expires time.Time) string {
+	if !isSignedMedia(mediaURL) {
+		return mediaURL
+	}
+	urlPath, _, _ := strings.Cut(mediaURL, "?")
+	return fmt.Sprintf("%s?expires=%d&signature=%s", urlPath, expires.Unix(), s.signature(urlPath, expires.Unix()))
+}
+
+// scormPrefix is where extracted SCORM packages are served
+const scormPrefix = "/uploads/scorm/"
+
+// scormToken matches the <expires>.<signature> part of a signed package URL
+var scormToken = regexp.MustCompile(`^[0-9]+\.[A-Za-z0-9_-]{43}$`)
+
+// signScorm returns a package URL signed for every file in the package
+// until expires
+func (s *MediaSigner) signScorm(launchURL string, expires time.Time) string {
+	launchURL = unsignScorm(launchURL)
+	id, rest, ok := strings.Cut(strings.TrimPrefix(launchURL, scormPrefix), "/")
+	if !strings.HasPrefix(launchURL, scormPrefix) || !ok {
+		return launchURL
+	}
+	pkgPath := scormPrefix + id + "/"
+	return fmt.Sprintf("%s%d.%s/%s", pkgPath, expires.Unix(), s.signature(pkgPath, expires.Unix()), rest)
+}
+
+// unsignScorm strips the signature from a package URL handed out for playback
+func unsignScorm(launchURL string) string {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(launchURL, scormPrefix), "/")
+	token, file, ok := strings.Cut(rest, "/")
+	if !strings.HasPrefix(launchURL, scormPrefix) || !ok || !scormToken.MatchString(token) {
+		return launchURL
+	}
+	return scormPrefix + id + "/" + file
+}
+
+// verify checks the signature on a request for urlPath and returns when it
+// expires
+func (s *MediaSigner) verify(urlPath string, query url.Values, now time.Time) (time.Time, bool) {
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || now.Unix() > expires {
+		return time.Time{}, false
+	}
+	given, err := base64.RawURLEncoding.DecodeString(query.Get("signature"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	expected, _ := base64.RawURLEncoding.DecodeString(s.signature(urlPath, expires))
+	if !hmac.Equal(given, expected) {
+		return time.Time{}, false
+	}
+	return time.Unix(expires, 0), true
+}
+
+// signTraining returns a copy of a training with the URLs of its videos,
+// their captions and its SCORM packages signed for playback
+func (s *MediaSigner) signTraining(t Training, now time.Time) Training {
+	expires := now.Add(mediaURLLifetime)
+	signURL := func(content map[string]interface{}) map[string]interface{} {
+		signed := make(map[string]interface{}, len(content))
+		for k, v := range content {
+			signed[k] = v
+		}
+		if url, ok := content["url"].(string); ok {
+			signed["url"] = s.sign(url, expires)
+		}
+		return signed
+	}
+	blocks := make([]ContentBlock, len(t.Blocks))
+	for i, block := range t.Blocks {
+		blocks[i] = block
+		if block.Type == "scorm" {
+			blocks[i].Content = signURL(block.Content)
+			if launchURL, ok := block.Content["launch_url"].(string); ok {
+				blocks[i].Content["launch_url"] = s.signScorm(launchURL, expires)
+			}
+		}
+		if block.Type != "video" {
+			continue
+		}
+		blocks[i].Content = signURL(block.Content)
+		if captions, ok := block.Content["captions"].([]interface{}); ok {
+			tracks := make([]interface{}, len(captions))
+			for j, track := range captions {
+				tracks[j] = track
+				if content, ok := track.(map[string]interface{}); ok {
+					tracks[j] = signURL(content)
+				}
+			}
+			blocks[i].Content["captions"] = tracks
+		}
+	}
+	t.Blocks = blocks
+	return t
+}
+
+// checkMediaAccess refuses a training that uses a video or caption its
+// author may not watch: one they neither uploaded nor can already play in a
+// training they can view. Trainings are handed out with these URLs signed,
+// so otherwise linking to someone else's private upload would publish it.
+// URLs the stored training already uses are left alone.
+func (h *TrainingHandlers) checkMediaAccess(t Training, email string) error {
+	var existing []string
+	if stored, ok := h.store.get(t.ID); ok {
+		existing = mediaURLs(stored)
+	}
+	var viewable map[string]bool
+	for _, mediaURL := range mediaURLs(t) {
+		if !isSignedMedia(mediaURL) || slices.Contains(existing, mediaURL) {
+			continue
+		}
+		owned := false
+		for _, item := range h.media.find(func(item MediaItem) bool { return item.URL == mediaURL }) {
+			owned = owned || item.Owner == strings.ToLower(strings.TrimSpace(email))
+		}
+		if owned {
+			continue
+		}
+		if viewable == nil {
+			viewable = map[string]bool{}
+			for _, other := range h.store.getAll() {
+				if view, ok := h.viewFor(other, email); ok && !view.Locked && other.ID != t.ID {
+					for _, u := range mediaURLs(view) {
+						viewable[u] = true
+					}
+				}
+			}
+		}
+		if !viewable[mediaURL] {
+			return fmt.Errorf("%s is not one of your uploads or used by a training you can view", path.Base(mediaURL))
+		}
+	}
+	return nil
+}
+
+// mediaETag is a strong validator for an upload. Size and modification time
+// identify its content because uploads are never rewritten in place.
+func mediaETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano())
+}
+
+// serveMedia serves an upload from dir for the file named in the request
+// path after prefix. http.ServeContent answers range and conditional
+// requests, including If-Range against the ETag, so seeking in a video only
+// fetches the part needed.
+func serveMedia(w http.ResponseWriter, r *http.Request, dir, prefix string, maxAge time.Duration, private bool) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean(r.URL.Path), prefix)
+	if name == "" || strings.ContainsAny(name, "/\\") || strings.HasPrefix(name, ".") {
+		http.NotFound(w, r)
+		return
+	}
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(w, r)
+		return
+	}
+
+	scope := "public"
+	if private {
+		scope = "private"
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d, immutable", scope, int(maxAge.Seconds())))
+	w.Header().Set("ETag", mediaETag(info))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if t, ok := extensionTypes[strings.ToLower(filepath.Ext(name))]; ok {
+		w.Header().Set("Content-Type", t.MIME)
+	}
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// signedMediaServer serves the uploads in dir, under prefix, to requests
+// with a valid signature
+func signedMediaServer(dir, prefix string, signer *MediaSigner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		expires, ok := signer.verify(path.Clean(r.URL.Path), r.URL.Query(), time.Now())
+		if !ok {
+			http.Error(w, "this link is invalid or has expired", http.StatusForbidden)
+			return
+		}
+		// Cached copies must not outlive the link
+		serveMedia(w, r, dir, prefix, time.Until(expires), true)
+	})
+}
+
+// scormSandbox confines package pages to an origin of their own, so they
+// can't reach the app's storage or act as the learner
+const scormSandbox = "sandbox allow-scripts allow-forms allow-popups"
+
+// scormBridge loads the runtime API into a package page
+const scormBridge = `<script src="/js/scorm-frame.js"></script>`
+
+var (
+	htmlHeadTag = regexp.MustCompile(`(?i)<head(\s[^>]*)?>`)
+	htmlOpenTag = regexp.MustCompile(`(?i)<html(\s[^>]*)?>`)
+)
+
+// withScormBridge adds the bridge script to a page ahead of the package's
+// own scripts
+func withScormBridge(page []byte) []byte {
+	at := 0
+	if loc := htmlHeadTag.FindIndex(page); loc != nil {
+		at = loc[1]
+	} else if loc := htmlOpenTag.FindIndex(page); loc != nil {
+		at = loc[1]
+	}
+	out := make([]byte, 0, len(page)+len(scormBridge))
+	out = append(out, page[:at]...)
+	out = append(out, scormBridge...)
+	return append(out, page[at:]...)
+}
+
+// scormServer serves the SCORM packages extracted in dir to requests signed
+// for the package, sandboxed, with the bridge added to their pages
+func scormServer(dir string, signer *MediaSigner) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		urlPath := path.Clean(r.URL.Path)
+		id, rest, _ := strings.Cut(strings.TrimPrefix(urlPath, scormPrefix), "/")
+		token, name, _ := strings.Cut(rest, "/")
+		expiresAt, signature, _ := strings.Cut(token, ".")
+		expires, ok := signer.verify(scormPrefix+id+"/", url.Values{"expires": {expiresAt}, "signature": {signature}}, time.Now())
+		if !strings.HasPrefix(urlPath, scormPrefix) || !ok {
+			http.Error(w, "this link is invalid or has expired", http.StatusForbidden)
+			return
+		}
+		if id == "" || name == "" {
+			http.NotFound(w, r)
+			return
+		}
+		f, err := os.Open(filepath.Join(dir, id, filepath.FromSlash(name)))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil || info.IsDir() {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("Content-Security-Policy", scormSandbox)
+		w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(time.Until(expires).Seconds())))
+		w.Header().Set("ETag", mediaETag(info))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		switch strings.ToLower(path.Ext(name)) {
+		case ".html", ".htm":
+			page, err := io.ReadAll(f)
+			if err != nil {
+				http.Error(w, "failed to read page", http.StatusInternalServerError)
+				return
+			}
+			http.ServeContent(w, r, name, info.ModTime(), bytes.NewReader(withScormBridge(page)))
+		default:
+			http.ServeContent(w, r, name, info.ModTime(), f)
+		}
+	})
+}
+
+// HandleSignMedia handles making a signed URL for an uploaded video, for
+// embedding it outside the app. The user has to be able to view a training
+// that uses the video, or have uploaded it. expires_in is in seconds and
+// defaults to the playback lifetime.
+func (h *TrainingHandlers) HandleSignMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email      string `json:"email"`
+		URL        string `json:"url"`
+		TrainingID string `json:"training_id,omitempty"`
+		ExpiresIn  int64  `json:"expires_in,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	mediaURL, _, _ := strings.Cut(strings.TrimSpace(req.URL), "?")
+	if !strings.HasPrefix(mediaURL, "/uploads/videos/") {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "url must point at an uploaded video"})
+		return
+	}
+	lifetime := mediaURLLifetime
+	if req.ExpiresIn != 0 {
+		lifetime = time.Duration(req.ExpiresIn) * time.Second
+		if lifetime <= 0 || lifetime > maxEmbedURLLifetime {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("expires_in must be between 1 and %d seconds", int(maxEmbedURLLifetime.Seconds()))})
+			return
+		}
+	}
+
+	allowed := false
+	if email != "" {
+		for _, item := range h.media.find(func(item MediaItem) bool { return item.URL == mediaURL }) {
+			allowed = allowed || item.Owner == email
+		}
+		if stored, ok := h.store.get(req.TrainingID); ok && !allowed && stored.DeletedAt == nil {
+			view, ok := h.viewFor(stored, email)
+			allowed = ok && !view.Locked && slices.Contains(mediaURLs(view), mediaURL)
+		}
+	}
+	if !allowed {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "you do not have access to this video"})
+		return
+	}
+
+	expires := time.Now().Add(lifetime)
+	respondJSON(w, map[string]interface{}{
+		"ok":         true,
+		"url":        h.signer.sign(mediaURL, expires),
+		"expires_at": expires.UTC(),
+	})
+}
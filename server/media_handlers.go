@@ -0,0 +1,326 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MediaHandlers contains the media library handlers
+type MediaHandlers struct {
+	mu        sync.Mutex // Serialises reference passes and removals
+	store     *MediaStore
+	trainings *TrainingStore
+	revisions *RevisionStore
+	users     *UserStore
+	dirs      map[string]string // Upload directory for each kind of media
+}
+
+// NewMediaHandlers creates a new MediaHandlers instance
+func NewMediaHandlers(store *MediaStore, trainings *TrainingStore, revisions *RevisionStore, users *UserStore, videoUploadPath, imageUploadPath, captionUploadPath string) *MediaHandlers {
+	return &MediaHandlers{
+		store:     store,
+		trainings: trainings,
+		revisions: revisions,
+		users:     users,
+		dirs: map[string]string{
+			uploadKindVideo:   videoUploadPath,
+			uploadKindImage:   imageUploadPath,
+			uploadKindCaption: captionUploadPath,
+		},
+	}
+}
+
+// mediaReference is a training that uses an item in the library
+type mediaReference struct {
+	ID      string `json:"id"`
+	Title   string `json:"title"`
+	Deleted bool   `json:"deleted,omitempty"` // In the trash
+}
+
+// HandleGetMedia handles listing the media library. By default only the
+// user's own uploads are listed; scope=all adds other users' uploads that
+// published trainings use, for reuse.
+// kind filters to videos, images or captions and unused=true to
+// unreferenced items.
+func (h *MediaHandlers) HandleGetMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if _, exists := h.users.get(email); !exists {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	if _, known := h.dirs[kind]; kind != "" && !known {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "kind must be video, image or caption"})
+		return
+	}
+	all := r.URL.Query().Get("scope") == "all"
+	unused := r.URL.Query().Get("unused") == "true"
+
+	if err := h.refresh(time.Now()); err != nil {
+		logError("failed to update media references", err)
+	}
+	items := h.store.find(func(item MediaItem) bool {
+		return (all || item.Owner == email) &&
+			(kind == "" || item.Kind == kind) &&
+			(!unused || len(item.References) == 0)
+	})
+
+	// Other users' trainings only show up as references through their
+	// published content; their drafts and trash stay private
+	trainings := map[string]Training{}
+	published := map[string]string{}
+	for _, t := range h.trainings.getAllIncludingDeleted() {
+		trainings[t.ID] = t
+		if view, ok := h.revisions.published(t); ok && t.DeletedAt == nil && t.CreatedBy != email {
+			published[t.ID] = mediaContent(view, nil)
+		}
+	}
+	result := make([]map[string]interface{}, 0, len(items))
+	var totalSize int64
+	for _, item := range items {
+		references := make([]mediaReference, 0, len(item.References))
+		for _, id := range item.References {
+			t, ok := trainings[id]
+			if !ok || (t.CreatedBy != email && !item.refersTo(published[id])) {
+				continue
+			}
+			references = append(references, mediaReference{ID: t.ID, Title: t.Title, Deleted: t.DeletedAt != nil})
+		}
+		// Someone else's upload is only listed for reuse once a training
+		// anyone can take uses it
+		if item.Owner != email && len(references) == 0 {
+			continue
+		}
+		totalSize += item.Size
+		result = append(result, map[string]interface{}{
+			"id":                 item.ID,
+			"url":                item.URL,
+			"kind":               item.Kind,
+			"filename":           item.Filename,
+			"content_type":       item.ContentType,
+			"size":               item.Size,
+			"owner":              item.Owner,
+			"uploaded_at":        item.UploadedAt,
+			"variants":           item.Variants,
+			"references":         references,
+			"unreferenced_since": item.UnreferencedSince,
+			"expires_at":         item.expiresAt(),
+		})
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":         true,
+		"media":      result,
+		"total_size": totalSize,
+	})
+}
+
+// HandleDeleteMedia handles removing one of the user's own uploads straight
+// away instead of waiting for collection. Media a training uses cannot be
+// removed.
+func (h *MediaHandlers) HandleDeleteMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email string `json:"email"`
+		ID    string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "invalid request"})
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	if err := h.refresh(time.Now()); err != nil {
+		logError("failed to update media references", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to delete media"})
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	item, exists := h.store.get(req.ID)
+	if !exists {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "media not found"})
+		return
+	}
+	if item.Owner != email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "only the uploader can delete this media"})
+		return
+	}
+	if len(item.References) > 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "media is used by a training"})
+		return
+	}
+	if err := h.removeItem(item); err != nil {
+		logError("failed to delete media", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to delete media"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
+
+// HandleCollectMedia handles running a collection pass immediately, which
+// is handy for checking cleanup locally without waiting for the scheduler
+func (h *MediaHandlers) HandleCollectMedia(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	removed, err := h.collect(time.Now())
+	if err != nil {
+		logError("failed to collect media", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to collect media"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":      true,
+		"removed": removed,
+	})
+}
+
+// runCollection removes unused media on a fixed interval until the process
+// exits
+func (h *MediaHandlers) runCollection(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		removed, err := h.collect(now)
+		if err != nil {
+			logError("failed to collect media", err)
+		} else if removed > 0 {
+			log.Printf("Removed %d unused media files", removed)
+		}
+	}
+}
+
+// collect brings references up to date, then removes media that has gone
+// unused for longer than mediaGracePeriod and returns how many were removed
+func (h *MediaHandlers) collect(now time.Time) (int, error) {
+	if err := h.refresh(now); err != nil {
+		return 0, err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	removed := 0
+	for _, item := range h.store.find(func(item MediaItem) bool {
+		expires := item.expiresAt()
+		return len(item.References) == 0 && expires != nil && now.After(*expires)
+	}) {
+		if err := h.removeItem(item); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// removeItem deletes an item's file, its resized copies and its record
+func (h *MediaHandlers) removeItem(item MediaItem) error {
+	dir := h.dirs[item.Kind]
+	removeWithVariants(filepath.Join(dir, filepath.Base(item.URL)))
+	return h.store.remove(item.ID)
+}
+
+// refresh reconciles the registry with the upload directories and records
+// which trainings use each item. Files with no record, such as those
+// uploaded before the registry existed, are adopted without an owner;
+// records whose file has gone are dropped.
+func (h *MediaHandlers) refresh(now time.Time) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	onDisk := map[string]os.DirEntry{}
+	kinds := map[string]string{}
+	for kind, dir := range h.dirs {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		// Resized copies belong to their image rather than being media
+		// in their own right
+		variants := map[string]bool{}
+		for _, entry := range entries {
+			for _, v := range imageVariants {
+				variants[variantFilename(entry.Name(), v.Width)] = true
+			}
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if entry.IsDir() || variants[name] || strings.HasSuffix(name, ".part") || strings.HasPrefix(name, ".") {
+				continue
+			}
+			url := "/uploads/" + kind + "s/" + name
+			onDisk[url] = entry
+			kinds[url] = kind
+		}
+	}
+
+	contents := map[string]string{}
+	for _, t := range h.trainings.getAllIncludingDeleted() {
+		contents[t.ID] = mediaContent(t, h.revisions.list(t.ID))
+	}
+
+	return h.store.update(func(items map[string]MediaItem) {
+		known := map[string]bool{}
+		for id, item := range items {
+			if _, ok := onDisk[item.URL]; !ok {
+				delete(items, id)
+				continue
+			}
+			known[item.URL] = true
+		}
+		for url, entry := range onDisk {
+			if known[url] {
+				continue
+			}
+			kind := kinds[url]
+			item := newMediaItem(kind, filepath.Join(h.dirs[kind], entry.Name()), entry.Name(), "", now)
+			if info, err := entry.Info(); err == nil {
+				item.UploadedAt = info.ModTime()
+			}
+			items[item.ID] = item
+		}
+
+		for id, item := range items {
+			if item.Kind == uploadKindImage {
+				item.Variants = imageVariantURLs(h.dirs[item.Kind], item.URL)
+			}
+			item.References = []string{}
+			for trainingID, content := range contents {
+				if item.refersTo(content) {
+					item.References = append(item.References, trainingID)
+				}
+			}
+			sort.Strings(item.References)
+			switch {
+			case len(item.References) > 0:
+				item.UnreferencedSince = nil
+			case item.UnreferencedSince == nil:
+				// The grace period runs from when it was first seen unused
+				unused := now
+				item.UnreferencedSince = &unused
+			}
+			items[id] = item
+		}
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Notification is a message delivered to a user, such as a training reminder
+type Notification struct {
+	ID        string     `json:"id"`
+	To        string     `json:"to"` // Recipient email
+	Kind      string     `json:"kind"`
+	Subject   string     `json:"subject"`
+	Body      string     `json:"body"`
+	Link      string     `json:"link,omitempty"` // Client route the notification is about
+	CreatedAt time.Time  `json:"created_at"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+}
+
+// Notifier delivers notifications over some channel
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// multiNotifier delivers to several channels, reporting the first failure
+type multiNotifier []Notifier
+
+func (m multiNotifier) Notify(n Notification) error {
+	var firstErr error
+	for _, notifier := range m {
+		if err := notifier.Notify(n); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// smtpNotifier emails notifications through an SMTP relay
+type smtpNotifier struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// headerValue makes text safe to put in a mail header. Subjects include
+// training titles, so line breaks are removed to stop them adding headers,
+// and anything beyond ASCII is encoded.
+func headerValue(text string) string {
+	return mime.QEncoding.Encode("utf-8", strings.Join(strings.Fields(text), " "))
+}
+
+func (s smtpNotifier) Notify(n Notification) error {
+	to := strings.Join(strings.Fields(n.To), "")
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s\r\n",
+		s.from, to, headerValue(n.Subject), n.Body)
+	return smtp.SendMail(s.addr, s.auth, s.from, []string{to}, []byte(msg))
+}
+
+// newNotifier builds the notification channels. Notifications always go to
+// the in-app inbox, which can be read locally through /api/notifications;
+// setting SMTP_HOST also emails them.
+func newNotifier(inbox *NotificationStore) Notifier {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		return inbox
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+	from := os.Getenv("SMTP_FROM")
+	if from == "" {
+		from = "no-reply@" + host
+	}
+	var auth smtp.Auth
+	if user := os.Getenv("SMTP_USER"); user != "" {
+		auth = smtp.PlainAuth("", user, os.Getenv("SMTP_PASSWORD"), host)
+	}
+	return multiNotifier{inbox, smtpNotifier{addr: host + ":" + port, auth: auth, from: from}}
+}
+
+// NotificationStore is the in-app inbox; it also acts as a Notifier
+type NotificationStore struct {
+	mu            sync.Mutex
+	Notifications map[string]Notification `json:"notifications"`
+	file          string
+}
+
+// NewNotificationStore creates a new notification store
+func NewNotificationStore(path string) *NotificationStore {
+	s := &NotificationStore{Notifications: map[string]Notification{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *NotificationStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var notifications map[string]Notification
+	if err := readJSONFile(s.file, &notifications); err != nil {
+		logError("failed to load notifications", err)
+		return
+	}
+	if notifications != nil {
+		s.Notifications = notifications
+	}
+}
+
+func (s *NotificationStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Notifications)
+}
+
+// Notify stores a notification in the recipient's inbox
+func (s *NotificationStore) Notify(n Notification) error {
+	if n.ID == "" {
+		n.ID = uuid.New().String()
+	}
+	if n.CreatedAt.IsZero() {
+		n.CreatedAt = time.Now()
+	}
+	s.mu.Lock()
+	s.Notifications[n.ID] = n
+	s.mu.Unlock()
+	return s.save()
+}
+
+// getForUser returns a user's notifications, newest first
+func (s *NotificationStore) getForUser(email string, unreadOnly bool) []Notification {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	notifications := make([]Notification, 0)
+	for _, n := range s.Notifications {
+		if n.To == email && (!unreadOnly || n.ReadAt == nil) {
+			notifications = append(notifications, n)
+		}
+	}
+	sort.Slice(notifications, func(i, j int) bool { return notifications[i].CreatedAt.After(notifications[j].CreatedAt) })
+	return notifications
+}
+
+// markRead marks the given notifications (or all, if ids is empty) as read
+func (s *NotificationStore) markRead(email string, ids []string) error {
+	now := time.Now()
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+	s.mu.Lock()
+	for id, n := range s.Notifications {
+		if n.To == email && n.ReadAt == nil && (len(ids) == 0 || wanted[id]) {
+			n.ReadAt = &now
+			s.Notifications[id] = n
+		}
+	}
+	s.mu.Unlock()
+	return s.save()
+}
+
+// NotificationHandlers contains the in-app inbox HTTP handlers
+type NotificationHandlers struct {
+	store *NotificationStore
+}
+
+// NewNotificationHandlers creates a new NotificationHandlers instance
+func NewNotificationHandlers(store *NotificationStore) *NotificationHandlers {
+	return &NotificationHandlers{store: store}
+}
+
+// HandleGetNotifications handles listing a user's notifications (?unread=true for unread only)
+func (h *NotificationHandlers) HandleGetNotifications(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":            true,
+		"notifications": h.store.getForUser(email, r.URL.Query().Get("unread") == "true"),
+	})
+}
+
+// HandleMarkRead handles marking notifications as read
+func (h *NotificationHandlers) HandleMarkRead(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email string   `json:"email"`
+		IDs   []string `json:"ids,omitempty"` // Empty marks everything read
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if err := h.store.markRead(email, req.IDs); err != nil {
+		logError("failed to mark notifications read", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to update notifications"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
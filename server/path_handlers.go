@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PathRequest is the payload for creating or updating a learning path
+type PathRequest struct {
+	ID          string   `json:"id,omitempty"`
+	Email       string   `json:"email"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	TrainingIDs []string `json:"training_ids"`
+}
+
+// PathHandlers contains all learning path HTTP handlers
+type PathHandlers struct {
+	store        *PathStore
+	trainings    *TrainingStore
+	revisions    *RevisionStore
+	progress     *ProgressStore
+	assignments  *AssignmentStore
+	certificates *CertificateStore
+}
+
+// NewPathHandlers creates a new PathHandlers instance
+func NewPathHandlers(store *PathStore, trainings *TrainingStore, revisions *RevisionStore, progress *ProgressStore, assignments *AssignmentStore, certificates *CertificateStore) *PathHandlers {
+	return &PathHandlers{store: store, trainings: trainings, revisions: revisions, progress: progress, assignments: assignments, certificates: certificates}
+}
+
+// validateTrainings checks that a path's trainings are published and listed
+// once, and that taking them in order doesn't conflict with the prerequisites
+// and other paths they're on
+func (h *PathHandlers) validateTrainings(pathID string, ids []string) error {
+	if len(ids) == 0 {
+		return fmt.Errorf("a learning path needs at least one training")
+	}
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if seen[id] {
+			return fmt.Errorf("training %s is listed twice", id)
+		}
+		seen[id] = true
+		t, ok := h.trainings.get(id)
+		if !ok || t.DeletedAt != nil {
+			return fmt.Errorf("training %s not found", id)
+		}
+		if !t.isPublished() {
+			return fmt.Errorf("training %q is not published", t.Title)
+		}
+	}
+
+	paths := []LearningPath{{ID: pathID, TrainingIDs: ids}}
+	for _, p := range h.store.getAll() {
+		if p.ID != pathID {
+			paths = append(paths, p)
+		}
+	}
+	if t, ok := orderCycle(h.trainings.getAll(), paths); ok {
+		return fmt.Errorf("this order would leave %q waiting on itself through its prerequisites or other paths", t.Title)
+	}
+	return nil
+}
+
+// HandleGetPaths handles listing learning paths. With ?email= each path
+// includes that learner's progress through it.
+func (h *PathHandlers) HandleGetPaths(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	type pathView struct {
+		LearningPath
+		Progress *PathProgress `json:"progress,omitempty"`
+	}
+	paths := make([]pathView, 0)
+	for _, p := range h.store.getAll() {
+		view := pathView{LearningPath: p}
+		if email != "" {
+			progress := h.learnerProgress(p, email)
+			view.Progress = &progress
+		}
+		paths = append(paths, view)
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":    true,
+		"paths": paths,
+	})
+}
+
+// HandleCreatePath handles creating a learning path
+func (h *PathHandlers) HandleCreatePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	title := strings.TrimSpace(req.Title)
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+	if title == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "title is required"})
+		return
+	}
+	id := uuid.New().String()
+	if err := h.validateTrainings(id, req.TrainingIDs); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	path := LearningPath{
+		ID:          id,
+		Title:       title,
+		Description: strings.TrimSpace(req.Description),
+		TrainingIDs: req.TrainingIDs,
+		CreatedBy:   email,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	if err := h.store.put(path); err != nil {
+		logError("failed to save learning path", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to create learning path"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"path": path,
+	})
+}
+
+// HandleUpdatePath handles the path's author changing its details or trainings
+func (h *PathHandlers) HandleUpdatePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	path, ok := h.store.get(req.ID)
+	if !ok || path.CreatedBy != strings.ToLower(strings.TrimSpace(req.Email)) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "learning path not found"})
+		return
+	}
+
+	if title := strings.TrimSpace(req.Title); title != "" {
+		path.Title = title
+	}
+	if req.Description != "" {
+		path.Description = strings.TrimSpace(req.Description)
+	}
+	if req.TrainingIDs != nil {
+		if err := h.validateTrainings(path.ID, req.TrainingIDs); err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+			return
+		}
+		path.TrainingIDs = req.TrainingIDs
+	}
+	path.UpdatedAt = time.Now()
+
+	if err := h.store.put(path); err != nil {
+		logError("failed to update learning path", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to update learning path"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"path": path,
+	})
+}
+
+// HandleDeletePath handles the path's author deleting it. The trainings in
+// it and any certificates already issued are kept.
+func (h *PathHandlers) HandleDeletePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	path, ok := h.store.get(req.ID)
+	if !ok || path.CreatedBy != strings.ToLower(strings.TrimSpace(req.Email)) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "learning path not found"})
+		return
+	}
+
+	if err := h.store.delete(path.ID); err != nil {
+		logError("failed to delete learning path", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to delete learning path"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
+
+// HandleGetPathProgress handles getting a learner's progress through a path
+func (h *PathHandlers) HandleGetPathProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+	path, ok := h.store.get(r.URL.Query().Get("id"))
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "learning path not found"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"path":     path,
+		"progress": h.learnerProgress(path, email),
+	})
+}
+
+// learnerProgress works out a learner's progress through a path, with the
+// path certificate if they hold one that hasn't lapsed
+func (h *PathHandlers) learnerProgress(path LearningPath, email string) PathProgress {
+	progress := pathProgress(path, email, h.trainings, h.revisions, h.store, h.assignments, h.progress)
+	if c, ok := h.certificates.forPath(path.ID, email); ok && c.LapsedAt == nil {
+		progress.CertificateID = c.ID
+	}
+	return progress
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// A minimal single-page PDF writer using the standard Helvetica fonts, which
+// every PDF reader has built in. It covers what certificates need (text,
+// rectangles and lines) without pulling in a PDF library.
+
+// pdfFonts maps the resource names used in content streams to base fonts
+var pdfFonts = []struct{ name, base string }{
+	{"F1", "Helvetica"},
+	{"F2", "Helvetica-Bold"},
+}
+
+// Glyph widths for characters 32-126, in thousandths of the font size, from the Adobe AFM files
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+var helveticaBoldWidths = [95]int{
+	278, 333, 474, 556, 556, 889, 722, 238, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 333, 333, 584, 584, 584, 611,
+	975, 722, 722, 722, 722, 667, 611, 778, 722, 278, 556, 722, 611, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 333, 278, 333, 584, 556,
+	333, 556, 611, 556, 611, 556, 333, 611, 611, 278, 278, 556, 278, 889, 611, 611,
+	611, 611, 389, 556, 333, 611, 556, 778, 556, 556, 500, 389, 280, 389, 584,
+}
+
+// pdfPage builds the content stream of a page
+type pdfPage struct {
+	width, height float64
+	content       bytes.Buffer
+}
+
+// newPDFPage starts a page of the given size in points
+func newPDFPage(width, height float64) *pdfPage {
+	return &pdfPage{width: width, height: height}
+}
+
+// winAnsiPunctuation maps the typographic characters WinAnsi places below 0xA0
+var winAnsiPunctuation = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94,
+	'•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfEncode converts text to the single-byte WinAnsi encoding used by the
+// standard fonts; other characters become '?'
+func pdfEncode(s string) []byte {
+	out := make([]byte, 0, len(s))
+	for _, r := range s {
+		if b, ok := winAnsiPunctuation[r]; ok {
+			out = append(out, b)
+			continue
+		}
+		if r > 0xFF || r < 0x20 || (r >= 0x7F && r < 0xA0) {
+			r = '?'
+		}
+		out = append(out, byte(r))
+	}
+	return out
+}
+
+// pdfEscape escapes text for a PDF string literal
+func pdfEscape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		if c == '(' || c == ')' || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(c)
+	}
+	return sb.String()
+}
+
+// textWidth returns the width of text in points
+func textWidth(font string, size float64, s string) float64 {
+	widths := &helveticaWidths
+	if font == "F2" {
+		widths = &helveticaBoldWidths
+	}
+	total := 0
+	for _, c := range pdfEncode(s) {
+		switch {
+		case c >= 32 && c <= 126:
+			total += widths[c-32]
+		case c == 0x97 || c == 0x85 || c == 0x99:
+			total += 1000
+		default:
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
+
+// setColor sets the fill and stroke colour from 0-255 RGB components
+func (p *pdfPage) setColor(r, g, b int) {
+	fmt.Fprintf(&p.content, "%.3f %.3f %.3f rg %.3f %.3f %.3f RG\n",
+		float64(r)/255, float64(g)/255, float64(b)/255,
+		float64(r)/255, float64(g)/255, float64(b)/255)
+}
+
+// text draws text with its baseline starting at x, y (from the bottom left)
+func (p *pdfPage) text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(&p.content, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(pdfEncode(s)))
+}
+
+// centeredText draws text centred horizontally on the page, shrinking it to fit the margins
+func (p *pdfPage) centeredText(font string, size, y, margin float64, s string) {
+	maxWidth := p.width - 2*margin
+	if w := textWidth(font, size, s); w > maxWidth {
+		size = size * maxWidth / w
+	}
+	p.text(font, size, (p.width-textWidth(font, size, s))/2, y, s)
+}
+
+// rect strokes a rectangle
+func (p *pdfPage) rect(x, y, w, h, lineWidth float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f %.2f %.2f re S\n", lineWidth, x, y, w, h)
+}
+
+// line strokes a straight line
+func (p *pdfPage) line(x1, y1, x2, y2, lineWidth float64) {
+	fmt.Fprintf(&p.content, "%.2f w %.2f %.2f m %.2f %.2f l S\n", lineWidth, x1, y1, x2, y2)
+}
+
+// render writes out a complete PDF document containing the page
+func (p *pdfPage) render(title string) []byte {
+	var buf bytes.Buffer
+	offsets := []int{}
+	object := func(body string) {
+		offsets = append(offsets, buf.Len())
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	fontRefs := make([]string, 0, len(pdfFonts))
+	for i, f := range pdfFonts {
+		fontRefs = append(fontRefs, fmt.Sprintf("/%s %d 0 R", f.name, 5+i))
+	}
+
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] /Resources << /Font << %s >> >> /Contents 4 0 R >>",
+		p.width, p.height, strings.Join(fontRefs, " ")))
+	object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", p.content.Len(), p.content.String()))
+	for _, f := range pdfFonts {
+		object(fmt.Sprintf("<< /Type /Font /Subtype /Type1 /BaseFont /%s /Encoding /WinAnsiEncoding >>", f.base))
+	}
+	object(fmt.Sprintf("<< /Title (%s) /Producer (Train Hub) >>", pdfEscape(pdfEncode(title))))
+
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, len(offsets), xref)
+	return buf.Bytes()
+}
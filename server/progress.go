@@ -0,0 +1,155 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// videoWatchedRatio is how much of a video must be watched for its block to count as viewed
+const videoWatchedRatio = 0.9
+
+// TrainingProgress records how far a learner has got through a training
+type TrainingProgress struct {
+	TrainingID     string             `json:"training_id"`
+	Email          string             `json:"email"`
+	Revision       int                `json:"revision,omitempty"` // Published revision last viewed
+	BlocksViewed   []string           `json:"blocks_viewed"`
+	TotalBlocks    int                `json:"total_blocks"`
+	Percent        int                `json:"percent"`
+	VideoPositions map[string]float64 `json:"video_positions,omitempty"` // Seconds watched, keyed by block ID
+	StartedAt      time.Time          `json:"started_at"`
+	LastViewedAt   time.Time          `json:"last_viewed_at"`
+	CompletedAt    *time.Time         `json:"completed_at,omitempty"`
+	CertificateID  string             `json:"certificate_id,omitempty"`
+}
+
+// hasViewed reports whether the learner has viewed a block
+func (p TrainingProgress) hasViewed(blockID string) bool {
+	for _, id := range p.BlocksViewed {
+		if id == blockID {
+			return true
+		}
+	}
+	return false
+}
+
+// recalculate works out the percentage of the training's current blocks the
+// learner has viewed. Blocks removed by a later revision no longer count.
+func (p *TrainingProgress) recalculate(t Training) {
+	p.TotalBlocks = len(t.Blocks)
+	if p.TotalBlocks == 0 {
+		p.Percent = 0
+		if p.CompletedAt != nil {
+			p.Percent = 100
+		}
+		return
+	}
+	viewed := 0
+	for _, b := range t.Blocks {
+		if p.hasViewed(b.ID) {
+			viewed++
+		}
+	}
+	p.Percent = viewed * 100 / p.TotalBlocks
+}
+
+// ProgressStore manages learner progress, keyed by training ID then learner email
+type ProgressStore struct {
+	mu       sync.Mutex
+	Progress map[string]map[string]TrainingProgress `json:"progress"`
+	file     string
+}
+
+// NewProgressStore creates a new progress store
+func NewProgressStore(path string) *ProgressStore {
+	s := &ProgressStore{Progress: map[string]map[string]TrainingProgress{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *ProgressStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var progress map[string]map[string]TrainingProgress
+	if err := readJSONFile(s.file, &progress); err != nil {
+		logError("failed to load progress", err)
+		return
+	}
+	if progress != nil {
+		s.Progress = progress
+	}
+}
+
+func (s *ProgressStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Progress)
+}
+
+func (s *ProgressStore) get(trainingID, email string) (TrainingProgress, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Progress[trainingID][email]
+	return p, ok
+}
+
+func (s *ProgressStore) put(p TrainingProgress) error {
+	s.mu.Lock()
+	if s.Progress[p.TrainingID] == nil {
+		s.Progress[p.TrainingID] = map[string]TrainingProgress{}
+	}
+	s.Progress[p.TrainingID][p.Email] = p
+	s.mu.Unlock()
+	return s.save()
+}
+
+// forTraining returns every learner's progress on a training, by email
+func (s *ProgressStore) forTraining(trainingID string) []TrainingProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	progress := make([]TrainingProgress, 0, len(s.Progress[trainingID]))
+	for _, p := range s.Progress[trainingID] {
+		progress = append(progress, p)
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].Email < progress[j].Email })
+	return progress
+}
+
+// forUser returns a learner's progress on every training they have started,
+// most recently viewed first
+func (s *ProgressStore) forUser(email string) []TrainingProgress {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	progress := make([]TrainingProgress, 0)
+	for _, byUser := range s.Progress {
+		if p, ok := byUser[email]; ok {
+			progress = append(progress, p)
+		}
+	}
+	sort.Slice(progress, func(i, j int) bool { return progress[i].LastViewedAt.After(progress[j].LastViewedAt) })
+	return progress
+}
+
+// completions counts the learners who have completed each training
+func (s *ProgressStore) completions() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts := make(map[string]int, len(s.Progress))
+	for trainingID, byUser := range s.Progress {
+		for _, p := range byUser {
+			if p.CompletedAt != nil {
+				counts[trainingID]++
+			}
+		}
+	}
+	return counts
+}
+
+// reset clears a learner's progress on a training so they start it afresh
+func (s *ProgressStore) reset(trainingID, email string) error {
+	s.mu.Lock()
+	delete(s.Progress[trainingID], email)
+	s.mu.Unlock()
+	return s.save()
+}
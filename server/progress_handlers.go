@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProgressRequest is what the client reports as a learner works through a training
+type ProgressRequest struct {
+	Email      string  `json:"email"`
+	TrainingID string  `json:"training_id"`
+	BlockID    string  `json:"block_id,omitempty"` // Block the learner has reached
+	Position   float64 `json:"position,omitempty"` // Video watch position in seconds, for video blocks
+	Duration   float64 `json:"duration,omitempty"` // Video length in seconds, for video blocks
+}
+
+// ProgressHandlers contains the learner progress and completion report handlers
+type ProgressHandlers struct {
+	mu           sync.Mutex // Serialises read-modify-write of progress records
+	store        *ProgressStore
+	trainings    *TrainingStore
+	revisions    *RevisionStore
+	paths        *PathStore
+	assignments  *AssignmentStore
+	certificates *CertificateStore
+	users        *UserStore
+	xapi         *XAPIEmitter
+}
+
+// NewProgressHandlers creates a new ProgressHandlers instance
+func NewProgressHandlers(store *ProgressStore, trainings *TrainingStore, revisions *RevisionStore, paths *PathStore, assignments *AssignmentStore, certificates *CertificateStore, users *UserStore, xapi *XAPIEmitter) *ProgressHandlers {
+	return &ProgressHandlers{store: store, trainings: trainings, revisions: revisions, paths: paths, assignments: assignments, certificates: certificates, users: users, xapi: xapi}
+}
+
+// HandleRecordProgress handles a learner viewing a block or watching part of a
+// video. The training is complete once every block has been viewed; video
+// blocks count as viewed once most of the video has been watched.
+func (h *ProgressHandlers) HandleRecordProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ProgressRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	stored, ok := h.trainings.get(req.TrainingID)
+	if !ok || stored.DeletedAt != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+	training, ok := h.revisions.published(stored)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+	if len(missingPrerequisites(training, email, h.trainings, h.paths, h.assignments, h.store)) > 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "complete the prerequisites first"})
+		return
+	}
+
+	var block *ContentBlock
+	if req.BlockID != "" {
+		for i := range training.Blocks {
+			if training.Blocks[i].ID == req.BlockID {
+				block = &training.Blocks[i]
+				break
+			}
+		}
+		if block == nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "block not found"})
+			return
+		}
+	}
+	if req.Position < 0 || req.Duration < 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "position and duration must not be negative"})
+		return
+	}
+
+	// Quizzes only count once they have been passed, and SCORM content once
+	// it reports completion
+	if block != nil && (block.Type == "quiz" || block.Type == "scorm") {
+		block = nil
+	}
+
+	progress, justCompleted, err := h.record(training, email, func(p *TrainingProgress) {
+		if block == nil {
+			return
+		}
+		viewed := true
+		if block.Type == "video" {
+			if p.VideoPositions == nil {
+				p.VideoPositions = map[string]float64{}
+			}
+			p.VideoPositions[block.ID] = req.Position
+			viewed = req.Duration > 0 && req.Position >= req.Duration*videoWatchedRatio
+		}
+		if viewed && !p.hasViewed(block.ID) {
+			p.BlocksViewed = append(p.BlocksViewed, block.ID)
+		}
+	})
+	if err != nil {
+		logError("failed to save progress", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save progress"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":        true,
+		"progress":  progress,
+		"completed": justCompleted,
+	})
+}
+
+// record applies an update to a learner's progress on the published content
+// of a training. When the update finishes the training, the learner's open
+// assignments for it are completed, a certificate is issued, and any
+// learning paths it finishes are completed as well. xAPI statements are
+// emitted for the learner launching, progressing through and completing it.
+func (h *ProgressHandlers) record(training Training, email string, update func(p *TrainingProgress)) (TrainingProgress, bool, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	progress, started := h.store.get(training.ID, email)
+	previousPercent := progress.Percent
+	if !started {
+		progress = TrainingProgress{
+			TrainingID:   training.ID,
+			Email:        email,
+			BlocksViewed: []string{},
+			StartedAt:    now,
+		}
+	}
+	progress.Revision = training.Revision
+	progress.LastViewedAt = now
+	update(&progress)
+
+	// A training with no blocks is finished as soon as it is opened
+	justCompleted := false
+	progress.recalculate(training)
+	if progress.CompletedAt == nil && (progress.Percent == 100 || progress.TotalBlocks == 0) {
+		progress.CompletedAt = &now
+		progress.Percent = 100
+		justCompleted = true
+
+		user, _ := h.users.get(email)
+		certificate, err := h.certificates.issue(training, email, user.Name, now)
+		if err != nil {
+			logError("failed to issue certificate", err)
+		} else {
+			progress.CertificateID = certificate.ID
+		}
+	}
+
+	if err := h.store.put(progress); err != nil {
+		return progress, false, err
+	}
+
+	activity := h.xapi.trainingActivity(training)
+	if !started {
+		h.xapi.emit(email, xapiVerbLaunched, activity, nil, nil)
+	}
+	if justCompleted {
+		completion := true
+		h.xapi.emit(email, xapiVerbCompleted, activity, &XAPIResult{
+			Completion: &completion,
+			Duration:   fmt.Sprintf("PT%dS", int(now.Sub(progress.StartedAt).Seconds())),
+		}, nil)
+	} else if progress.Percent > previousPercent {
+		h.xapi.emit(email, xapiVerbProgressed, activity, &XAPIResult{
+			Extensions: map[string]interface{}{xapiExtensionProgress: progress.Percent},
+		}, nil)
+	}
+
+	if justCompleted {
+		if _, err := h.assignments.complete(email, training.ID, now); err != nil {
+			logError("failed to complete assignments", err)
+		}
+		h.completePaths(training.ID, email)
+	}
+	return progress, justCompleted, nil
+}
+
+// reset clears a learner's progress on a training under the same lock as
+// record, so an update in flight can't save the old progress back over it
+func (h *ProgressHandlers) reset(trainingID, email string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.store.reset(trainingID, email)
+}
+
+// completePaths issues path certificates for any learning paths containing
+// the training that the learner has now finished, unless they already hold
+// one that hasn't lapsed
+func (h *ProgressHandlers) completePaths(trainingID, email string) {
+	user, _ := h.users.get(email)
+	for _, path := range h.paths.containing(trainingID) {
+		if c, ok := h.certificates.forPath(path.ID, email); ok && c.LapsedAt == nil {
+			continue
+		}
+		progress := pathProgress(path, email, h.trainings, h.revisions, h.paths, h.assignments, h.store)
+		if progress.CompletedAt == nil {
+			continue
+		}
+		if _, err := h.certificates.issuePath(path, email, user.Name, *progress.CompletedAt); err != nil {
+			logError("failed to issue path certificate", err)
+		}
+	}
+}
+
+// HandleGetProgress handles a learner fetching their progress on one
+// training, or on every training they have started when training_id is omitted
+func (h *ProgressHandlers) HandleGetProgress(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	trainingID := r.URL.Query().Get("training_id")
+	if trainingID == "" {
+		respondJSON(w, map[string]interface{}{
+			"ok":       true,
+			"progress": h.store.forUser(email),
+		})
+		return
+	}
+
+	progress, ok := h.store.get(trainingID, email)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": true, "progress": nil})
+		return
+	}
+	respondJSON(w, map[string]interface{}{"ok": true, "progress": progress})
+}
+
+// TrainingCompletion summarises how learners are getting on with a training
+type TrainingCompletion struct {
+	TrainingID     string `json:"training_id"`
+	Title          string `json:"title"`
+	Started        int    `json:"started"`
+	Completed      int    `json:"completed"`
+	AveragePercent int    `json:"average_percent"`
+}
+
+// HandleGetReport handles the trainer-facing completion report. With
+// training_id it lists every learner on that training; with user it lists
+// that learner's progress on the trainer's trainings; with neither it
+// summarises completion across all of the trainer's trainings.
+func (h *ProgressHandlers) HandleGetReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	if trainingID := r.URL.Query().Get("training_id"); trainingID != "" {
+		t, ok := h.trainings.get(trainingID)
+		if !ok || t.CreatedBy != email {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+			return
+		}
+		respondJSON(w, map[string]interface{}{
+			"ok":       true,
+			"training": h.summarise(t),
+			"learners": h.store.forTraining(t.ID),
+		})
+		return
+	}
+
+	owned := map[string]bool{}
+	trainings := make([]Training, 0)
+	for _, t := range h.trainings.getAll() {
+		if t.CreatedBy == email {
+			owned[t.ID] = true
+			trainings = append(trainings, t)
+		}
+	}
+	sort.Slice(trainings, func(i, j int) bool { return trainings[i].Title < trainings[j].Title })
+
+	if learner := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("user"))); learner != "" {
+		progress := make([]TrainingProgress, 0)
+		for _, p := range h.store.forUser(learner) {
+			if owned[p.TrainingID] {
+				progress = append(progress, p)
+			}
+		}
+		respondJSON(w, map[string]interface{}{
+			"ok":       true,
+			"user":     learner,
+			"progress": progress,
+		})
+		return
+	}
+
+	summaries := make([]TrainingCompletion, 0, len(trainings))
+	for _, t := range trainings {
+		summaries = append(summaries, h.summarise(t))
+	}
+	respondJSON(w, map[string]interface{}{
+		"ok":        true,
+		"trainings": summaries,
+	})
+}
+
+// summarise counts how many learners have started and completed a training
+func (h *ProgressHandlers) summarise(t Training) TrainingCompletion {
+	summary := TrainingCompletion{TrainingID: t.ID, Title: t.Title}
+	total := 0
+	for _, p := range h.store.forTraining(t.ID) {
+		summary.Started++
+		if p.CompletedAt != nil {
+			summary.Completed++
+		}
+		total += p.Percent
+	}
+	if summary.Started > 0 {
+		summary.AveragePercent = total / summary.Started
+	}
+	return summary
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultPassPercent is the pass mark for quizzes that don't set one
+const defaultPassPercent = 70
+
+// Quiz question types
+const (
+	questionMultipleChoice = "multiple_choice"
+	questionMultiSelect    = "multi_select"
+	questionTrueFalse      = "true_false"
+	questionShortAnswer    = "short_answer"
+)
+
+// Quiz is the content of a quiz block
+type Quiz struct {
+	Title       string         `json:"title,omitempty"`
+	PassPercent int            `json:"pass_percent,omitempty"` // Score needed to pass, defaults to defaultPassPercent
+	MaxAttempts int            `json:"max_attempts,omitempty"` // 0 allows unlimited attempts
+	Questions   []QuizQuestion `json:"questions"`
+}
+
+// QuizQuestion is a single question in a quiz. True/false questions have the
+// implicit options True (0) and False (1).
+type QuizQuestion struct {
+	ID       string   `json:"id"`
+	Type     string   `json:"type"` // multiple_choice, multi_select, true_false, short_answer
+	Prompt   string   `json:"prompt"`
+	Options  []string `json:"options,omitempty"`
+	Correct  []int    `json:"correct,omitempty"`  // Indexes of the correct options; never sent to learners
+	Accepted []string `json:"accepted,omitempty"` // Accepted short answers; never sent to learners
+	Points   int      `json:"points,omitempty"`   // Defaults to 1
+}
+
+// quizAnswerKeys are the question fields that give the answers away
+var quizAnswerKeys = []string{"correct", "accepted"}
+
+// QuizAnswer is a learner's answer to one question
+type QuizAnswer struct {
+	Selected []int  `json:"selected,omitempty"` // Chosen option indexes
+	Text     string `json:"text,omitempty"`     // Short answer text
+}
+
+// QuestionResult records whether a question was answered correctly
+type QuestionResult struct {
+	QuestionID string `json:"question_id"`
+	Correct    bool   `json:"correct"`
+	Points     int    `json:"points"`
+}
+
+// QuizAttempt is a graded quiz submission
+type QuizAttempt struct {
+	ID          string                `json:"id"`
+	TrainingID  string                `json:"training_id"`
+	BlockID     string                `json:"block_id"`
+	Revision    int                   `json:"revision,omitempty"`
+	Email       string                `json:"email"`
+	Answers     map[string]QuizAnswer `json:"answers"`
+	Results     []QuestionResult      `json:"results"`
+	Score       int                   `json:"score"`
+	MaxScore    int                   `json:"max_score"`
+	Percent     int                   `json:"percent"`
+	Passed      bool                  `json:"passed"`
+	SubmittedAt time.Time             `json:"submitted_at"`
+}
+
+// parseQuiz reads the quiz out of a quiz block's content
+func parseQuiz(block ContentBlock) (Quiz, error) {
+	var quiz Quiz
+	data, err := json.Marshal(block.Content)
+	if err != nil {
+		return quiz, err
+	}
+	if err := json.Unmarshal(data, &quiz); err != nil {
+		return quiz, fmt.Errorf("invalid quiz: %v", err)
+	}
+	if quiz.PassPercent == 0 {
+		quiz.PassPercent = defaultPassPercent
+	}
+	return quiz, nil
+}
+
+// validate checks that a quiz can be answered and graded
+func (q Quiz) validate() error {
+	if len(q.Questions) == 0 {
+		return fmt.Errorf("quiz needs at least one question")
+	}
+	if q.PassPercent < 0 || q.PassPercent > 100 {
+		return fmt.Errorf("pass_percent must be between 0 and 100")
+	}
+	if q.MaxAttempts < 0 {
+		return fmt.Errorf("max_attempts must not be negative")
+	}
+
+	seen := map[string]bool{}
+	for i, question := range q.Questions {
+		label := fmt.Sprintf("question %d", i+1)
+		if question.ID == "" {
+			return fmt.Errorf("%s: id is required", label)
+		}
+		if seen[question.ID] {
+			return fmt.Errorf("%s: duplicate id %q", label, question.ID)
+		}
+		seen[question.ID] = true
+		if strings.TrimSpace(question.Prompt) == "" {
+			return fmt.Errorf("%s: prompt is required", label)
+		}
+		if question.Points < 0 {
+			return fmt.Errorf("%s: points must not be negative", label)
+		}
+
+		switch question.Type {
+		case questionMultipleChoice, questionMultiSelect:
+			if len(question.Options) < 2 {
+				return fmt.Errorf("%s: at least two options are required", label)
+			}
+			if len(question.Correct) == 0 {
+				return fmt.Errorf("%s: mark the correct option", label)
+			}
+			if question.Type == questionMultipleChoice && len(question.Correct) != 1 {
+				return fmt.Errorf("%s: multiple choice questions have exactly one correct option", label)
+			}
+			for _, c := range question.Correct {
+				if c < 0 || c >= len(question.Options) {
+					return fmt.Errorf("%s: correct option %d does not exist", label, c)
+				}
+			}
+		case questionTrueFalse:
+			if len(question.Correct) != 1 || question.Correct[0] < 0 || question.Correct[0] > 1 {
+				return fmt.Errorf("%s: correct must be [0] for true or [1] for false", label)
+			}
+		case questionShortAnswer:
+			if len(question.Accepted) == 0 {
+				return fmt.Errorf("%s: at least one accepted answer is required", label)
+			}
+		default:
+			return fmt.Errorf("%s: unknown question type %q", label, question.Type)
+		}
+	}
+	return nil
+}
+
+// points returns what a question is worth
+func (question QuizQuestion) points() int {
+	if question.Points == 0 {
+		return 1
+	}
+	return question.Points
+}
+
+// isCorrect grades a single answer
+func (question QuizQuestion) isCorrect(answer QuizAnswer) bool {
+	if question.Type == questionShortAnswer {
+		given := normalizeAnswer(answer.Text)
+		for _, accepted := range question.Accepted {
+			if given != "" && given == normalizeAnswer(accepted) {
+				return true
+			}
+		}
+		return false
+	}
+
+	chosen := map[int]bool{}
+	for _, s := range answer.Selected {
+		chosen[s] = true
+	}
+	if len(chosen) != len(question.Correct) {
+		return false
+	}
+	for _, c := range question.Correct {
+		if !chosen[c] {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeAnswer makes short answers comparable regardless of case and spacing
+func normalizeAnswer(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// grade scores a set of answers against the quiz
+func (q Quiz) grade(answers map[string]QuizAnswer) (results []QuestionResult, score, maxScore, percent int, passed bool) {
+	results = make([]QuestionResult, 0, len(q.Questions))
+	for _, question := range q.Questions {
+		result := QuestionResult{QuestionID: question.ID}
+		if answer, ok := answers[question.ID]; ok && question.isCorrect(answer) {
+			result.Correct = true
+			result.Points = question.points()
+			score += result.Points
+		}
+		maxScore += question.points()
+		results = append(results, result)
+	}
+	if maxScore > 0 {
+		percent = score * 100 / maxScore
+	}
+	return results, score, maxScore, percent, percent >= q.PassPercent
+}
+
+// hideQuizAnswers returns a copy of the blocks with the answers taken out of
+// any quizzes. The blocks passed in are left untouched since they may belong
+// to a stored revision.
+func hideQuizAnswers(blocks []ContentBlock) []ContentBlock {
+	hidden := make([]ContentBlock, len(blocks))
+	for i, block := range blocks {
+		hidden[i] = block
+		if block.Type != "quiz" {
+			continue
+		}
+
+		content := make(map[string]interface{}, len(block.Content))
+		for k, v := range block.Content {
+			content[k] = v
+		}
+		if questions, ok := block.Content["questions"].([]interface{}); ok {
+			stripped := make([]interface{}, 0, len(questions))
+			for _, q := range questions {
+				fields, ok := q.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				copied := make(map[string]interface{}, len(fields))
+				for k, v := range fields {
+					copied[k] = v
+				}
+				for _, key := range quizAnswerKeys {
+					delete(copied, key)
+				}
+				stripped = append(stripped, copied)
+			}
+			content["questions"] = stripped
+		}
+		hidden[i].Content = content
+	}
+	return hidden
+}
+
+// QuizStore keeps the history of quiz attempts, keyed by training ID
+type QuizStore struct {
+	mu       sync.Mutex
+	Attempts map[string][]QuizAttempt `json:"attempts"`
+	file     string
+}
+
+// NewQuizStore creates a new quiz attempt store
+func NewQuizStore(path string) *QuizStore {
+	s := &QuizStore{Attempts: map[string][]QuizAttempt{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *QuizStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var attempts map[string][]QuizAttempt
+	if err := readJSONFile(s.file, &attempts); err != nil {
+		logError("failed to load quiz attempts", err)
+		return
+	}
+	if attempts != nil {
+		s.Attempts = attempts
+	}
+}
+
+func (s *QuizStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Attempts)
+}
+
+// add records an attempt
+func (s *QuizStore) add(a QuizAttempt) error {
+	s.mu.Lock()
+	s.Attempts[a.TrainingID] = append(s.Attempts[a.TrainingID], a)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// find returns the attempts on a training matching keep, oldest first
+func (s *QuizStore) find(trainingID string, keep func(QuizAttempt) bool) []QuizAttempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := make([]QuizAttempt, 0)
+	for _, a := range s.Attempts[trainingID] {
+		if keep(a) {
+			attempts = append(attempts, a)
+		}
+	}
+	return attempts
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// QuizHandlers contains the quiz submission and attempt history handlers
+type QuizHandlers struct {
+	mu        sync.Mutex // Serialises submissions so attempt limits hold
+	store     *QuizStore
+	trainings *TrainingStore
+	revisions *RevisionStore
+	progress  *ProgressHandlers
+	users     *UserStore
+}
+
+// NewQuizHandlers creates a new QuizHandlers instance
+func NewQuizHandlers(store *QuizStore, trainings *TrainingStore, revisions *RevisionStore, progress *ProgressHandlers, users *UserStore) *QuizHandlers {
+	return &QuizHandlers{store: store, trainings: trainings, revisions: revisions, progress: progress, users: users}
+}
+
+// HandleSubmitQuiz handles a learner submitting answers to a quiz block. The
+// answers are graded against the published revision; passing marks the block
+// as viewed in the learner's progress.
+func (h *QuizHandlers) HandleSubmitQuiz(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email      string                `json:"email"`
+		TrainingID string                `json:"training_id"`
+		BlockID    string                `json:"block_id"`
+		Answers    map[string]QuizAnswer `json:"answers"` // Keyed by question ID
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	stored, ok := h.trainings.get(req.TrainingID)
+	if !ok || stored.DeletedAt != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+	training, ok := h.revisions.published(stored)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+	if len(missingPrerequisites(training, email, h.trainings, h.progress.paths, h.progress.assignments, h.progress.store)) > 0 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "complete the prerequisites first"})
+		return
+	}
+
+	var block *ContentBlock
+	for i := range training.Blocks {
+		if training.Blocks[i].ID == req.BlockID && training.Blocks[i].Type == "quiz" {
+			block = &training.Blocks[i]
+			break
+		}
+	}
+	if block == nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "quiz not found"})
+		return
+	}
+	quiz, err := parseQuiz(*block)
+	if err != nil {
+		logError(fmt.Sprintf("training %s has an unreadable quiz", training.ID), err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "quiz is not available"})
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Attempts made for a certification that has since lapsed don't count
+	// against recertifying
+	lapsed, _ := h.progress.certificates.lapsedAt(training.ID, email)
+	previous := h.store.find(training.ID, func(a QuizAttempt) bool {
+		return a.BlockID == block.ID && a.Email == email && a.SubmittedAt.After(lapsed)
+	})
+	if quiz.MaxAttempts > 0 && len(previous) >= quiz.MaxAttempts {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("no attempts left (limit %d)", quiz.MaxAttempts)})
+		return
+	}
+
+	results, score, maxScore, percent, passed := quiz.grade(req.Answers)
+	attempt := QuizAttempt{
+		ID:          uuid.New().String(),
+		TrainingID:  training.ID,
+		BlockID:     block.ID,
+		Revision:    training.Revision,
+		Email:       email,
+		Answers:     req.Answers,
+		Results:     results,
+		Score:       score,
+		MaxScore:    maxScore,
+		Percent:     percent,
+		Passed:      passed,
+		SubmittedAt: time.Now(),
+	}
+	if err := h.store.add(attempt); err != nil {
+		logError("failed to save quiz attempt", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to submit quiz"})
+		return
+	}
+
+	verb := xapiVerbFailed
+	if passed {
+		verb = xapiVerbPassed
+	}
+	completion := true
+	scaled, raw, max, min := float64(percent)/100, float64(score), float64(maxScore), 0.0
+	h.progress.xapi.emit(email, verb, h.progress.xapi.quizActivity(training, block.ID, quiz.Title), &XAPIResult{
+		Score:      &XAPIScore{Scaled: &scaled, Raw: &raw, Min: &min, Max: &max},
+		Success:    &passed,
+		Completion: &completion,
+	}, h.progress.xapi.trainingContext(training))
+
+	response := map[string]interface{}{
+		"ok":           true,
+		"attempt":      attempt,
+		"pass_percent": quiz.PassPercent,
+	}
+	if quiz.MaxAttempts > 0 {
+		response["attempts_remaining"] = quiz.MaxAttempts - len(previous) - 1
+	}
+
+	if passed {
+		progress, completed, err := h.progress.record(training, email, func(p *TrainingProgress) {
+			if !p.hasViewed(block.ID) {
+				p.BlocksViewed = append(p.BlocksViewed, block.ID)
+			}
+		})
+		if err != nil {
+			logError("failed to save progress", err)
+		} else {
+			response["progress"] = progress
+			response["completed"] = completed
+		}
+	}
+
+	respondJSON(w, response)
+}
+
+// HandleGetAttempts handles listing quiz attempts on a training, optionally
+// for one block. Learners see their own attempts; the training's author sees
+// every learner's, or one learner's with ?user=.
+func (h *QuizHandlers) HandleGetAttempts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	trainingID := r.URL.Query().Get("training_id")
+	blockID := r.URL.Query().Get("block_id")
+	if email == "" || trainingID == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email and training_id required"})
+		return
+	}
+
+	t, ok := h.trainings.get(trainingID)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+
+	learner := email
+	if t.CreatedBy == email {
+		learner = strings.ToLower(strings.TrimSpace(r.URL.Query().Get("user")))
+	}
+
+	attempts := h.store.find(trainingID, func(a QuizAttempt) bool {
+		return (learner == "" || a.Email == learner) && (blockID == "" || a.BlockID == blockID)
+	})
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"attempts": attempts,
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// recertificationDueDays is how long a learner has to redo a training once their certification lapses
+const recertificationDueDays = 30
+
+// defaultExpiringWindowDays is how far ahead the expiring report looks by default
+const defaultExpiringWindowDays = 30
+
+// ExpiringCertification is a learner whose certification is about to lapse
+type ExpiringCertification struct {
+	Email         string    `json:"email"`
+	Name          string    `json:"name"`
+	TrainingID    string    `json:"training_id"`
+	TrainingTitle string    `json:"training_title"`
+	CertificateID string    `json:"certificate_id"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	DaysLeft      int       `json:"days_left"`
+}
+
+// RecertificationHandlers expires completions once a training's validity
+// period has passed and re-assigns the training to the learner
+type RecertificationHandlers struct {
+	certificates *CertificateStore
+	progress     *ProgressHandlers
+	assignments  *AssignmentStore
+	trainings    *TrainingStore
+	paths        *PathStore
+	notifier     Notifier
+}
+
+// NewRecertificationHandlers creates a new RecertificationHandlers instance
+func NewRecertificationHandlers(certificates *CertificateStore, progress *ProgressHandlers, assignments *AssignmentStore, trainings *TrainingStore, paths *PathStore, notifier Notifier) *RecertificationHandlers {
+	return &RecertificationHandlers{certificates: certificates, progress: progress, assignments: assignments, trainings: trainings, paths: paths, notifier: notifier}
+}
+
+// HandleGetExpiring handles listing learners whose certifications on the
+// requesting trainer's trainings expire within the next ?days= days
+func (h *RecertificationHandlers) HandleGetExpiring(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+	days := defaultExpiringWindowDays
+	if v := r.URL.Query().Get("days"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "days must be a non-negative number"})
+			return
+		}
+		days = n
+	}
+
+	now := time.Now()
+	until := now.AddDate(0, 0, days)
+	expiring := make([]ExpiringCertification, 0)
+	for _, c := range h.certificates.current() {
+		if c.ExpiresAt == nil || c.isExpired(now) || c.ExpiresAt.After(until) {
+			continue
+		}
+		if t, ok := h.trainings.get(c.TrainingID); !ok || t.CreatedBy != email {
+			continue
+		}
+		expiring = append(expiring, ExpiringCertification{
+			Email:         c.Email,
+			Name:          c.Name,
+			TrainingID:    c.TrainingID,
+			TrainingTitle: c.TrainingTitle,
+			CertificateID: c.ID,
+			ExpiresAt:     *c.ExpiresAt,
+			DaysLeft:      int(c.ExpiresAt.Sub(now).Hours() / 24),
+		})
+	}
+	sort.Slice(expiring, func(i, j int) bool { return expiring[i].ExpiresAt.Before(expiring[j].ExpiresAt) })
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"days":     days,
+		"expiring": expiring,
+	})
+}
+
+// HandleProcessExpired handles running the expiry pass immediately rather
+// than waiting for the scheduler
+func (h *RecertificationHandlers) HandleProcessExpired(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":     true,
+		"lapsed": h.processExpired(time.Now()),
+	})
+}
+
+// runExpiry processes lapsed certifications on a fixed interval until the process exits
+func (h *RecertificationHandlers) runExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		if lapsed := h.processExpired(now); lapsed > 0 {
+			log.Printf("Processed %d lapsed certifications", lapsed)
+		}
+	}
+}
+
+// processExpired resets the progress of learners whose latest certificate has
+// expired and assigns them the training again. It returns how many
+// certifications lapsed.
+func (h *RecertificationHandlers) processExpired(now time.Time) int {
+	lapsed := 0
+	for _, c := range h.certificates.current() {
+		// Path certificates lapse along with the trainings on the path
+		if c.PathID != "" || !c.isExpired(now) || c.LapsedAt != nil {
+			continue
+		}
+
+		if err := h.progress.reset(c.TrainingID, c.Email); err != nil {
+			logError("failed to reset progress", err)
+			continue
+		}
+		if err := h.reassign(c, now); err != nil {
+			logError("failed to re-assign training", err)
+		}
+
+		c.LapsedAt = &now
+		if err := h.certificates.put(c); err != nil {
+			logError("failed to record lapsed certification", err)
+		}
+		h.lapsePaths(c, now)
+		lapsed++
+	}
+	return lapsed
+}
+
+// lapsePaths expires the learner's certificates for the learning paths
+// containing a training whose certification has lapsed. A new one is issued
+// once they complete the path again.
+func (h *RecertificationHandlers) lapsePaths(c Certificate, now time.Time) {
+	for _, path := range h.paths.containing(c.TrainingID) {
+		pc, ok := h.certificates.forPath(path.ID, c.Email)
+		if !ok || pc.LapsedAt != nil {
+			continue
+		}
+		pc.ExpiresAt = &now
+		pc.LapsedAt = &now
+		if err := h.certificates.put(pc); err != nil {
+			logError("failed to record lapsed path certificate", err)
+		}
+	}
+}
+
+// reassign gives the learner a new assignment to redo the training, unless
+// the training has gone or they already have one open
+func (h *RecertificationHandlers) reassign(c Certificate, now time.Time) error {
+	t, ok := h.trainings.get(c.TrainingID)
+	if !ok || t.DeletedAt != nil || !t.isPublished() {
+		return nil
+	}
+	if _, open := h.assignments.openFor(c.Email, t.ID); open {
+		return nil
+	}
+
+	a := Assignment{
+		ID:            uuid.New().String(),
+		TrainingID:    t.ID,
+		TrainingTitle: t.Title,
+		Assignee:      c.Email,
+		AssignedBy:    t.CreatedBy,
+		DueAt:         now.AddDate(0, 0, recertificationDueDays),
+		Status:        assignmentAssigned,
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if err := h.assignments.put(a); err != nil {
+		return err
+	}
+
+	err := h.notifier.Notify(Notification{
+		To:      c.Email,
+		Kind:    "recertification",
+		Subject: fmt.Sprintf("Recertification required: %s", t.Title),
+		Body: fmt.Sprintf("Your certification for \"%s\" expired on %s. Please complete the training again by %s.",
+			t.Title, c.ExpiresAt.Format("Mon Jan 2, 2006"), a.DueAt.Format("Mon Jan 2, 2006")),
+		Link: "/training/view?id=" + t.ID,
+	})
+	if err != nil {
+		logError("failed to send notification", err)
+	}
+	return nil
+}
@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReservationHandlers contains all reservation-related HTTP handlers
+type ReservationHandlers struct {
+	store *ReservationStore
+	users *UserStore
+	mu    sync.Mutex // Serializes changes so availability checks can't race each other
+}
+
+// NewReservationHandlers creates a new ReservationHandlers instance
+func NewReservationHandlers(store *ReservationStore, users *UserStore) *ReservationHandlers {
+	return &ReservationHandlers{store: store, users: users}
+}
+
+// HandleGetReservations handles listing a user's reservations, optionally for one item
+func (h *ReservationHandlers) HandleGetReservations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":           true,
+		"reservations": h.store.getByOwner(email, r.URL.Query().Get("item_id")),
+	})
+}
+
+// HandleCreateReservation handles reserving stock of an item for a date range.
+// Reservations that would exceed available stock are rejected with the
+// conflicting reservations listed.
+func (h *ReservationHandlers) HandleCreateReservation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email      string  `json:"email"`
+		ItemID     string  `json:"item_id"`
+		Quantity   float64 `json:"quantity"`
+		Start      string  `json:"start"`
+		End        string  `json:"end"`
+		ReservedBy string  `json:"reserved_by,omitempty"`
+		Job        string  `json:"job,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	start, err := parseDateTime(req.Start, false)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "start: " + err.Error()})
+		return
+	}
+	end, err := parseDateTime(req.End, true)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "end: " + err.Error()})
+		return
+	}
+	if !start.Before(end) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "end must be after start"})
+		return
+	}
+
+	// Hold the lock from the availability check until the reservation is
+	// saved, so two requests can't both promise the last of the stock
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	u, ok := h.users.get(email)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	item, ok := findItem(&u, req.ItemID)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "item not found"})
+		return
+	}
+
+	if err := validateQuantity(*item, req.Quantity); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	// Conflict detection
+	atp, _, conflicts := h.store.availableToPromise(email, *item, start, end)
+	if req.Quantity > atp {
+		if atp < 0 {
+			atp = 0
+		}
+		respondJSON(w, map[string]interface{}{
+			"ok":                   false,
+			"error":                fmt.Sprintf("only %s available for that period", formatQuantity(atp, item.Unit)),
+			"available_to_promise": atp,
+			"conflicts":            conflicts,
+		})
+		return
+	}
+
+	reservedBy := strings.TrimSpace(req.ReservedBy)
+	if reservedBy == "" {
+		reservedBy = email
+	}
+
+	now := time.Now()
+	reservation := Reservation{
+		ID:         uuid.New().String(),
+		Owner:      email,
+		ItemID:     item.ID,
+		Quantity:   req.Quantity,
+		Start:      start,
+		End:        end,
+		ReservedBy: reservedBy,
+		Job:        strings.TrimSpace(req.Job),
+		Status:     reservationActive,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+
+	if err := h.store.put(reservation); err != nil {
+		logError("failed to save reservation", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to create reservation"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"reservation": reservation,
+	})
+}
+
+// HandleCancelReservation handles releasing a reservation
+func (h *ReservationHandlers) HandleCancelReservation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	reservation, ok := h.store.get(req.ID)
+	if !ok || reservation.Owner != strings.ToLower(strings.TrimSpace(req.Email)) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "reservation not found"})
+		return
+	}
+	if reservation.Status != reservationActive {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "reservation is already " + reservation.Status})
+		return
+	}
+
+	reservation.Status = reservationCancelled
+	reservation.UpdatedAt = time.Now()
+
+	if err := h.store.put(reservation); err != nil {
+		logError("failed to cancel reservation", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to cancel reservation"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{"ok": true})
+}
+
+// HandleCheckOutReservation handles converting a reservation into a check-out
+// of the reserved stock. The reservation is marked converted as part of the
+// check-out, so it is either both checked out and converted or neither.
+func (h *ReservationHandlers) HandleCheckOutReservation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	reservation, ok := h.store.get(req.ID)
+	if !ok || reservation.Owner != email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "reservation not found"})
+		return
+	}
+	if reservation.Status != reservationActive {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "reservation is already " + reservation.Status})
+		return
+	}
+
+	now := time.Now()
+	if !now.Before(reservation.End) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "reservation has expired"})
+		return
+	}
+
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	active := reservation
+	reservation.Status = reservationConverted
+	reservation.CheckedOutAt = &now
+	reservation.UpdatedAt = now
+
+	var item InventoryItem
+	var checkoutErr error // Reported to the user, unlike failures to save
+	saved := false
+	err := h.users.transact([]string{email}, func(users []*User) error {
+		found, ok := findItem(users[0], reservation.ItemID)
+		if !ok {
+			checkoutErr = fmt.Errorf("item not found")
+			return checkoutErr
+		}
+		if checkoutErr = checkOutItem(found, reservation.Quantity, reservation.ReservedBy, reservation.Job); checkoutErr != nil {
+			return checkoutErr
+		}
+		users[0].UpdatedAt = now
+		item = *found
+
+		// Save the reservation as converted before the stock, and put it back
+		// if the stock can't be saved
+		if err := h.store.put(reservation); err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	if checkoutErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": checkoutErr.Error()})
+		return
+	}
+	if err != nil {
+		logError("failed to check out reservation", err)
+		if saved {
+			if err := h.store.put(active); err != nil {
+				logError("failed to restore reservation", err)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to check out"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"reservation": reservation,
+		"item":        item,
+	})
+}
+
+// HandleReturnReservation handles bringing checked-out stock back, which
+// adds it to the item's quantity again. A smaller quantity than was checked
+// out can be returned when some of it was used up.
+func (h *ReservationHandlers) HandleReturnReservation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID       string   `json:"id"`
+		Email    string   `json:"email"`
+		Quantity *float64 `json:"quantity,omitempty"` // Defaults to everything checked out
+		Note     string   `json:"note,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	reservation, ok := h.store.get(req.ID)
+	if !ok || reservation.Owner != email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "reservation not found"})
+		return
+	}
+	if reservation.Status != reservationConverted {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "only checked-out reservations can be returned"})
+		return
+	}
+	qty := reservation.Quantity
+	if req.Quantity != nil {
+		qty = *req.Quantity
+	}
+	if qty < 0 || qty > reservation.Quantity {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("quantity must be between 0 and the %s checked out", formatQuantity(reservation.Quantity, ""))})
+		return
+	}
+
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	now := time.Now()
+	converted := reservation
+	reservation.Status = reservationReturned
+	reservation.ReturnedAt = &now
+	reservation.Returned = qty
+	reservation.UpdatedAt = now
+
+	var item InventoryItem
+	var returnErr error // Reported to the user, unlike failures to save
+	saved := false
+	err := h.users.transact([]string{email}, func(users []*User) error {
+		found, ok := findItem(users[0], reservation.ItemID)
+		if !ok {
+			returnErr = fmt.Errorf("item not found")
+			return returnErr
+		}
+		if qty > 0 {
+			if returnErr = validateQuantity(*found, qty); returnErr != nil {
+				return returnErr
+			}
+			returnItem(found, qty, reservation.ReservedBy, strings.TrimSpace(req.Note))
+		}
+		users[0].UpdatedAt = now
+		item = *found
+
+		// Save the reservation as returned before the stock, and put it back
+		// if the stock can't be saved
+		if err := h.store.put(reservation); err != nil {
+			return err
+		}
+		saved = true
+		return nil
+	})
+	if returnErr != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": returnErr.Error()})
+		return
+	}
+	if err != nil {
+		logError("failed to return reservation", err)
+		if saved {
+			if err := h.store.put(converted); err != nil {
+				logError("failed to restore reservation", err)
+			}
+		}
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to return stock"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":          true,
+		"reservation": reservation,
+		"item":        item,
+	})
+}
+
+// HandleGetAvailability handles computing available-to-promise stock for an
+// item over a date range (defaults to the next 24 hours)
+func (h *ReservationHandlers) HandleGetAvailability(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	email := strings.ToLower(strings.TrimSpace(q.Get("email")))
+	if email == "" || q.Get("item_id") == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email and item_id required"})
+		return
+	}
+
+	start, end := time.Now(), time.Now().Add(24*time.Hour)
+	var err error
+	if q.Get("start") != "" {
+		if start, err = parseDateTime(q.Get("start"), false); err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "start: " + err.Error()})
+			return
+		}
+	}
+	if q.Get("end") != "" {
+		if end, err = parseDateTime(q.Get("end"), true); err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "end: " + err.Error()})
+			return
+		}
+	}
+	if !start.Before(end) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "end must be after start"})
+		return
+	}
+
+	u, ok := h.users.get(email)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	item, ok := findItem(&u, q.Get("item_id"))
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "item not found"})
+		return
+	}
+
+	atp, reserved, reservations := h.store.availableToPromise(email, *item, start, end)
+	respondJSON(w, map[string]interface{}{
+		"ok":                   true,
+		"on_hand":              item.Quantity,
+		"available":            availableForCheckout(*item),
+		"reserved":             reserved,
+		"available_to_promise": atp,
+		"reservations":         reservations,
+	})
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reservation holds stock of an inventory item for a scheduled job
+type Reservation struct {
+	ID           string     `json:"id"`
+	Owner        string     `json:"owner"` // Email of the inventory owner
+	ItemID       string     `json:"item_id"`
+	Quantity     float64    `json:"quantity"` // In the item's base unit
+	Start        time.Time  `json:"start"`
+	End          time.Time  `json:"end"`         // Exclusive
+	ReservedBy   string     `json:"reserved_by"` // Person the stock is held for
+	Job          string     `json:"job,omitempty"`
+	Status       string     `json:"status"` // active, converted, cancelled, returned
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	CheckedOutAt *time.Time `json:"checked_out_at,omitempty"`
+	ReturnedAt   *time.Time `json:"returned_at,omitempty"`
+	Returned     float64    `json:"returned,omitempty"` // Quantity brought back; the rest was used up
+}
+
+// Reservation statuses
+const (
+	reservationActive    = "active"
+	reservationConverted = "converted"
+	reservationCancelled = "cancelled"
+	reservationReturned  = "returned" // Converted, and the stock brought back
+)
+
+// overlaps reports whether the reservation holds stock at any point in [start, end)
+func (r Reservation) overlaps(start, end time.Time) bool {
+	return r.Start.Before(end) && start.Before(r.End)
+}
+
+// peakReserved returns the largest quantity held at any one moment in
+// [start, end) by the given reservations
+func peakReserved(reservations []Reservation, start, end time.Time) float64 {
+	type event struct {
+		at    time.Time
+		delta float64
+	}
+	events := make([]event, 0, len(reservations)*2)
+	for _, r := range reservations {
+		if !r.overlaps(start, end) {
+			continue
+		}
+		from := r.Start
+		if from.Before(start) {
+			from = start
+		}
+		events = append(events, event{from, r.Quantity}, event{r.End, -r.Quantity})
+	}
+	// Releases sort before holds at the same instant so back-to-back
+	// reservations don't count as overlapping
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].at.Equal(events[j].at) {
+			return events[i].delta < events[j].delta
+		}
+		return events[i].at.Before(events[j].at)
+	})
+
+	peak, current := 0.0, 0.0
+	for _, e := range events {
+		current += e.delta
+		if current > peak {
+			peak = current
+		}
+	}
+	return roundQuantity(peak)
+}
+
+// ReservationStore manages stock reservations
+type ReservationStore struct {
+	mu           sync.Mutex
+	Reservations map[string]Reservation `json:"reservations"`
+	file         string
+}
+
+// NewReservationStore creates a new reservation store
+func NewReservationStore(path string) *ReservationStore {
+	s := &ReservationStore{Reservations: map[string]Reservation{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *ReservationStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var reservations map[string]Reservation
+	if err := readJSONFile(s.file, &reservations); err != nil {
+		logError("failed to load reservations", err)
+		return
+	}
+	if reservations != nil {
+		s.Reservations = reservations
+	}
+}
+
+func (s *ReservationStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Reservations)
+}
+
+func (s *ReservationStore) get(id string) (Reservation, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, ok := s.Reservations[id]
+	return r, ok
+}
+
+func (s *ReservationStore) put(r Reservation) error {
+	s.mu.Lock()
+	s.Reservations[r.ID] = r
+	s.mu.Unlock()
+	return s.save()
+}
+
+// getByOwner returns an owner's reservations, optionally for a single item,
+// ordered by start time
+func (s *ReservationStore) getByOwner(owner, itemID string) []Reservation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reservations := make([]Reservation, 0)
+	for _, r := range s.Reservations {
+		if r.Owner == owner && (itemID == "" || r.ItemID == itemID) {
+			reservations = append(reservations, r)
+		}
+	}
+	sort.Slice(reservations, func(i, j int) bool { return reservations[i].Start.Before(reservations[j].Start) })
+	return reservations
+}
+
+// getActive returns the active reservations against an item that overlap [start, end)
+func (s *ReservationStore) getActive(owner, itemID string, start, end time.Time) []Reservation {
+	active := make([]Reservation, 0)
+	for _, r := range s.getByOwner(owner, itemID) {
+		if r.Status == reservationActive && r.overlaps(start, end) {
+			active = append(active, r)
+		}
+	}
+	return active
+}
+
+// availableToPromise returns on-hand stock minus the peak quantity reserved
+// during [start, end), along with the reservations that were counted
+func (s *ReservationStore) availableToPromise(owner string, item InventoryItem, start, end time.Time) (float64, float64, []Reservation) {
+	active := s.getActive(owner, item.ID, start, end)
+	reserved := peakReserved(active, start, end)
+	return roundQuantity(availableForCheckout(item) - reserved), reserved, active
+}
+
+// reservedFrom returns the largest quantity of an item that active
+// reservations hold at any one moment from the given time on
+func (s *ReservationStore) reservedFrom(owner, itemID string, from time.Time) float64 {
+	end := from
+	for _, r := range s.getByOwner(owner, itemID) {
+		if r.Status == reservationActive && r.End.After(end) {
+			end = r.End
+		}
+	}
+	return peakReserved(s.getActive(owner, itemID, from, end), from, end)
+}
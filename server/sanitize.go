@@ -0,0 +1,235 @@
+package main
+
+import (
+	"html"
+	"strings"
+)
+
+// Rich text in text blocks is HTML limited to simple formatting. Anything
+// else is removed so the content can be rendered as markup without letting
+// authors inject scripts, styles or event handlers.
+
+// allowedTags are the elements kept by sanitizeHTML; void elements have no closing tag
+var allowedTags = map[string]bool{
+	"p": false, "br": true, "b": false, "strong": false, "i": false, "em": false,
+	"u": false, "s": false, "sub": false, "sup": false, "code": false, "pre": false,
+	"ul": false, "ol": false, "li": false, "blockquote": false, "a": false,
+}
+
+// droppedTags are removed together with everything inside them
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "iframe": true, "object": true, "embed": true,
+	"template": true, "noscript": true, "textarea": true, "title": true, "svg": true, "math": true,
+}
+
+// htmlTag is a tag read from the input
+type htmlTag struct {
+	name    string
+	closing bool
+	attrs   map[string]string
+	end     int // Index just past the closing '>'
+}
+
+// sanitizeHTML keeps the allowed formatting tags and links from s, removes
+// every other tag and attribute, and closes anything left open
+func sanitizeHTML(s string) string {
+	var sb strings.Builder
+	open := []string{}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		if c != '<' {
+			if c == '>' {
+				sb.WriteString("&gt;")
+			} else {
+				sb.WriteByte(c)
+			}
+			i++
+			continue
+		}
+
+		if strings.HasPrefix(s[i:], "<!--") {
+			end := strings.Index(s[i+4:], "-->")
+			if end < 0 {
+				break
+			}
+			i += 4 + end + 3
+			continue
+		}
+
+		tag, ok := parseHTMLTag(s, i)
+		if !ok {
+			sb.WriteString("&lt;")
+			i++
+			continue
+		}
+		i = tag.end
+
+		if droppedTags[tag.name] {
+			if !tag.closing {
+				i = skipElement(s, i, tag.name)
+			}
+			continue
+		}
+		void, allowed := allowedTags[tag.name]
+		if !allowed {
+			continue
+		}
+
+		if tag.closing {
+			// Close back to the matching open tag; stray closing tags are dropped
+			for j := len(open) - 1; j >= 0; j-- {
+				if open[j] == tag.name {
+					for k := len(open) - 1; k >= j; k-- {
+						sb.WriteString("</" + open[k] + ">")
+					}
+					open = open[:j]
+					break
+				}
+			}
+			continue
+		}
+
+		sb.WriteString("<" + tag.name)
+		if tag.name == "a" {
+			if href, ok := safeHref(tag.attrs["href"]); ok {
+				sb.WriteString(` href="` + html.EscapeString(href) + `" rel="noopener noreferrer"`)
+			}
+		}
+		sb.WriteString(">")
+		if !void {
+			open = append(open, tag.name)
+		}
+	}
+
+	for k := len(open) - 1; k >= 0; k-- {
+		sb.WriteString("</" + open[k] + ">")
+	}
+	return sb.String()
+}
+
+// parseHTMLTag reads the tag starting at s[start], which is '<'
+func parseHTMLTag(s string, start int) (htmlTag, bool) {
+	tag := htmlTag{attrs: map[string]string{}}
+	i := start + 1
+	if i < len(s) && s[i] == '/' {
+		tag.closing = true
+		i++
+	}
+
+	nameStart := i
+	for i < len(s) && isASCIILetterOrDigit(s[i]) {
+		i++
+	}
+	if i == nameStart || !isASCIILetter(s[nameStart]) {
+		return tag, false
+	}
+	tag.name = strings.ToLower(s[nameStart:i])
+
+	for i < len(s) {
+		switch c := s[i]; {
+		case c == '>':
+			tag.end = i + 1
+			return tag, true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f' || c == '/':
+			i++
+		default:
+			nameStart := i
+			for i < len(s) && !strings.ContainsRune(" \t\n\r\f/>=", rune(s[i])) {
+				i++
+			}
+			name := strings.ToLower(s[nameStart:i])
+			value := ""
+			if i < len(s) && s[i] == '=' {
+				i++
+				if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+					quote := s[i]
+					end := strings.IndexByte(s[i+1:], quote)
+					if end < 0 {
+						return tag, false
+					}
+					value = s[i+1 : i+1+end]
+					i += end + 2
+				} else {
+					valueStart := i
+					for i < len(s) && !strings.ContainsRune(" \t\n\r\f>", rune(s[i])) {
+						i++
+					}
+					value = s[valueStart:i]
+				}
+			}
+			if _, seen := tag.attrs[name]; !seen {
+				tag.attrs[name] = html.UnescapeString(value)
+			}
+		}
+	}
+	return tag, false
+}
+
+// skipElement returns the index just past the closing tag of the element
+// whose content starts at s[from], or the end of s if it is never closed
+func skipElement(s string, from int, name string) int {
+	lower := strings.ToLower(s[from:])
+	end := strings.Index(lower, "</"+name)
+	if end < 0 {
+		return len(s)
+	}
+	if gt := strings.IndexByte(lower[end:], '>'); gt >= 0 {
+		return from + end + gt + 1
+	}
+	return len(s)
+}
+
+// safeHref returns the link target if it is a web, mail or site-relative link
+func safeHref(href string) (string, bool) {
+	// Browsers ignore whitespace and control characters inside schemes
+	cleaned := strings.Map(func(r rune) rune {
+		if r <= ' ' || r == 0x7F {
+			return -1
+		}
+		return r
+	}, href)
+	lower := strings.ToLower(cleaned)
+	switch {
+	case cleaned == "":
+		return "", false
+	case strings.HasPrefix(lower, "https://"), strings.HasPrefix(lower, "http://"), strings.HasPrefix(lower, "mailto:"):
+		return strings.TrimSpace(href), true
+	case strings.HasPrefix(cleaned, "#"), strings.HasPrefix(cleaned, "/") && !strings.HasPrefix(cleaned, "//"):
+		return strings.TrimSpace(href), true
+	}
+	return "", false
+}
+
+// stripTags removes all markup from text that is shown as plain text
+func stripTags(s string) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] == '<' {
+			if strings.HasPrefix(s[i:], "<!--") {
+				if end := strings.Index(s[i+4:], "-->"); end >= 0 {
+					i += 4 + end + 3
+					continue
+				}
+			}
+			if tag, ok := parseHTMLTag(s, i); ok {
+				i = tag.end
+				if droppedTags[tag.name] && !tag.closing {
+					i = skipElement(s, i, tag.name)
+				}
+				continue
+			}
+		}
+		sb.WriteByte(s[i])
+		i++
+	}
+	return sb.String()
+}
+
+func isASCIILetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isASCIILetterOrDigit(c byte) bool {
+	return isASCIILetter(c) || (c >= '0' && c <= '9')
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SCORM packages are zips of HTML content with an imsmanifest.xml describing
+// where to launch it. The content is extracted under uploads/scorm/<id>/ and
+// played in a sandboxed iframe inside a "scorm" block; a bridge script added
+// to the package's pages provides the SCORM runtime API, and the client
+// saves the learner's cmi data through the runtime endpoint.
+// Only the first launchable item of the default organization is played.
+
+// SCORM versions
+const (
+	scorm12   = "1.2"
+	scorm2004 = "2004"
+)
+
+// Limits on uploaded packages
+const (
+	maxScormUploadSize   = 1 << 30
+	maxScormExtractSize  = 2 << 30
+	maxScormFiles        = 10000
+	maxScormValueLength  = 64000 // SCORM 2004 allows 64000 characters of suspend_data
+	maxScormValues       = 2000
+	scormManifestFile    = "imsmanifest.xml"
+	scormManifestMaxSize = 10 << 20
+)
+
+// ScormPackage is an imported SCORM package
+type ScormPackage struct {
+	ID          string    `json:"id"`
+	Title       string    `json:"title"`
+	Version     string    `json:"version"`    // 1.2 or 2004
+	LaunchURL   string    `json:"launch_url"` // Under /uploads/scorm/<id>/
+	DataFromLMS string    `json:"data_from_lms,omitempty"`
+	TrainingID  string    `json:"training_id"`
+	Size        int64     `json:"size"`
+	UploadedBy  string    `json:"uploaded_by"`
+	UploadedAt  time.Time `json:"uploaded_at"`
+}
+
+// ScormAttempt is a learner's runtime data for a package
+type ScormAttempt struct {
+	PackageID   string            `json:"package_id"`
+	TrainingID  string            `json:"training_id"`
+	Email       string            `json:"email"`
+	Values      map[string]string `json:"values"` // cmi data model elements set by the content
+	Completed   bool              `json:"completed"`
+	Success     string            `json:"success,omitempty"` // passed, failed or unknown
+	Score       *float64          `json:"score,omitempty"`   // Percent where the content reports one
+	StartedAt   time.Time         `json:"started_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+	CompletedAt *time.Time        `json:"completed_at,omitempty"`
+}
+
+// evaluate works out completion, success and score from the cmi data
+func (a *ScormAttempt) evaluate(version string) {
+	var completion, success, scaled, raw, max string
+	if version == scorm2004 {
+		completion = a.Values["cmi.completion_status"]
+		success = a.Values["cmi.success_status"]
+		scaled = a.Values["cmi.score.scaled"]
+		raw, max = a.Values["cmi.score.raw"], a.Values["cmi.score.max"]
+	} else {
+		// SCORM 1.2 folds completion and success into lesson_status
+		switch status := a.Values["cmi.core.lesson_status"]; status {
+		case "passed", "failed":
+			completion, success = "completed", status
+		default:
+			completion = status
+		}
+		raw, max = a.Values["cmi.core.score.raw"], a.Values["cmi.core.score.max"]
+	}
+
+	// Content that only reports passing is treated as complete
+	a.Completed = (completion == "completed" || success == "passed") && success != "failed"
+	a.Success = success
+
+	a.Score = nil
+	if v, err := strconv.ParseFloat(scaled, 64); err == nil {
+		percent := v * 100
+		a.Score = &percent
+	} else if v, err := strconv.ParseFloat(raw, 64); err == nil {
+		if m, err := strconv.ParseFloat(max, 64); err == nil && m > 0 {
+			v = v * 100 / m
+		}
+		a.Score = &v
+	}
+}
+
+// scormReadOnly are the elements the LMS provides and content cannot set
+var scormReadOnly = map[string]bool{
+	"cmi.core.student_id": true, "cmi.core.student_name": true, "cmi.core.credit": true,
+	"cmi.core.entry": true, "cmi.core.lesson_mode": true, "cmi.core.total_time": true, "cmi.launch_data": true,
+	"cmi.learner_id": true, "cmi.learner_name": true, "cmi.credit": true, "cmi.entry": true,
+	"cmi.mode": true, "cmi.total_time": true,
+}
+
+// initialValues returns the cmi data handed to content when it starts:
+// what it saved before plus the elements the LMS provides
+func (a ScormAttempt) initialValues(pkg ScormPackage, name string) map[string]string {
+	values := make(map[string]string, len(a.Values)+8)
+	for k, v := range a.Values {
+		values[k] = v
+	}
+
+	entry := "ab-initio"
+	if a.Values["cmi.suspend_data"] != "" || a.Values["cmi.core.lesson_location"] != "" || a.Values["cmi.location"] != "" {
+		entry = "resume"
+	}
+	if pkg.Version == scorm2004 {
+		values["cmi.learner_id"] = a.Email
+		values["cmi.learner_name"] = name
+		values["cmi.mode"] = "normal"
+		values["cmi.credit"] = "credit"
+		values["cmi.entry"] = entry
+		values["cmi.launch_data"] = pkg.DataFromLMS
+		setDefault(values, "cmi.completion_status", "unknown")
+		setDefault(values, "cmi.success_status", "unknown")
+	} else {
+		values["cmi.core.student_id"] = a.Email
+		values["cmi.core.student_name"] = name
+		values["cmi.core.lesson_mode"] = "normal"
+		values["cmi.core.credit"] = "credit"
+		values["cmi.core.entry"] = entry
+		values["cmi.launch_data"] = pkg.DataFromLMS
+		setDefault(values, "cmi.core.lesson_status", "not attempted")
+	}
+	return values
+}
+
+func setDefault(values map[string]string, key, value string) {
+	if values[key] == "" {
+		values[key] = value
+	}
+}
+
+// scormManifest is the part of imsmanifest.xml needed to launch a package
+type scormManifest struct {
+	Metadata struct {
+		SchemaVersion string `xml:"schemaversion"`
+	} `xml:"metadata"`
+	Organizations struct {
+		Default       string              `xml:"default,attr"`
+		Organizations []scormOrganization `xml:"organization"`
+	} `xml:"organizations"`
+	Resources struct {
+		Base      string          `xml:"base,attr"`
+		Resources []scormResource `xml:"resource"`
+	} `xml:"resources"`
+	Namespaces []xml.Attr `xml:",any,attr"`
+}
+
+type scormOrganization struct {
+	Identifier string      `xml:"identifier,attr"`
+	Title      string      `xml:"title"`
+	Items      []scormItem `xml:"item"`
+}
+
+type scormItem struct {
+	IdentifierRef   string      `xml:"identifierref,attr"`
+	Parameters      string      `xml:"parameters,attr"`
+	Title           string      `xml:"title"`
+	DataFromLMS     string      `xml:"datafromlms"` // SCORM 1.2
+	DataFromLMS2004 string      `xml:"dataFromLMS"`
+	Items           []scormItem `xml:"item"`
+}
+
+type scormResource struct {
+	Identifier string `xml:"identifier,attr"`
+	Href       string `xml:"href,attr"`
+	Base       string `xml:"base,attr"`
+}
+
+// version works out which SCORM version the manifest is written for
+func (m scormManifest) version() string {
+	schema := strings.ToLower(m.Metadata.SchemaVersion)
+	switch {
+	case strings.Contains(schema, "1.2"):
+		return scorm12
+	case strings.Contains(schema, "2004"), strings.Contains(schema, "1.3"):
+		return scorm2004
+	}
+	for _, ns := range m.Namespaces {
+		if strings.Contains(ns.Value, "adlcp_v1p3") {
+			return scorm2004
+		}
+	}
+	return scorm12
+}
+
+// launch finds the first launchable item of the default organization,
+// returning the organization title, launch path and launch data
+func (m scormManifest) launch() (title, href, data string, err error) {
+	orgs := m.Organizations.Organizations
+	if len(orgs) == 0 {
+		return "", "", "", fmt.Errorf("manifest has no organizations")
+	}
+	org := orgs[0]
+	for _, o := range orgs {
+		if o.Identifier == m.Organizations.Default {
+			org = o
+		}
+	}
+	resources := map[string]scormResource{}
+	for _, r := range m.Resources.Resources {
+		resources[r.Identifier] = r
+	}
+
+	var find func(items []scormItem) (scormItem, scormResource, bool)
+	find = func(items []scormItem) (scormItem, scormResource, bool) {
+		for _, item := range items {
+			if r, ok := resources[item.IdentifierRef]; ok && r.Href != "" {
+				return item, r, true
+			}
+			if item, r, ok := find(item.Items); ok {
+				return item, r, true
+			}
+		}
+		return scormItem{}, scormResource{}, false
+	}
+	item, resource, ok := find(org.Items)
+	if !ok {
+		return "", "", "", fmt.Errorf("manifest has nothing to launch")
+	}
+
+	href = m.Resources.Base + resource.Base + resource.Href
+	if p := strings.TrimSpace(item.Parameters); p != "" {
+		switch {
+		case strings.HasPrefix(p, "?") && strings.Contains(href, "?"):
+			href += "&" + p[1:]
+		case strings.HasPrefix(p, "?"), strings.HasPrefix(p, "#"):
+			href += p
+		default:
+			href += "?" + p
+		}
+	}
+
+	title = strings.TrimSpace(org.Title)
+	if title == "" {
+		title = strings.TrimSpace(item.Title)
+	}
+	data = item.DataFromLMS
+	if data == "" {
+		data = item.DataFromLMS2004
+	}
+	return title, href, strings.TrimSpace(data), nil
+}
+
+// readScormManifest reads imsmanifest.xml from the root of a package
+func readScormManifest(zr *zip.Reader) (scormManifest, error) {
+	var manifest scormManifest
+	for _, f := range zr.File {
+		if f.Name != scormManifestFile {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return manifest, err
+		}
+		defer rc.Close()
+		if err := xml.NewDecoder(io.LimitReader(rc, scormManifestMaxSize)).Decode(&manifest); err != nil {
+			return manifest, fmt.Errorf("invalid %s: %v", scormManifestFile, err)
+		}
+		return manifest, nil
+	}
+	return manifest, fmt.Errorf("not a SCORM package: %s is missing", scormManifestFile)
+}
+
+// extractScorm unpacks a package into dir, refusing entries that would land
+// outside it. It returns the total size of the files written.
+func extractScorm(zr *zip.Reader, dir string) (int64, error) {
+	if len(zr.File) > maxScormFiles {
+		return 0, fmt.Errorf("package has too many files (max %d)", maxScormFiles)
+	}
+	root := filepath.Clean(dir) + string(filepath.Separator)
+	var total int64
+	for _, f := range zr.File {
+		name := path.Clean("/" + strings.ReplaceAll(f.Name, "\\", "/"))
+		target := filepath.Join(dir, filepath.FromSlash(name))
+		if !strings.HasPrefix(target, root) {
+			return total, fmt.Errorf("unsafe path %q in package", f.Name)
+		}
+		if f.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return total, err
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return total, err
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return total, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		dst, err := os.Create(target)
+		if err != nil {
+			rc.Close()
+			return total, err
+		}
+		// Count actual bytes rather than trusting the sizes in the zip header
+		n, err := io.Copy(dst, io.LimitReader(rc, maxScormExtractSize-total+1))
+		rc.Close()
+		dst.Close()
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if total > maxScormExtractSize {
+			return total, fmt.Errorf("package is too large when extracted")
+		}
+	}
+	return total, nil
+}
+
+// ScormPackageStore manages imported SCORM packages
+type ScormPackageStore struct {
+	mu       sync.Mutex
+	Packages map[string]ScormPackage `json:"packages"`
+	file     string
+}
+
+// NewScormPackageStore creates a new SCORM package store
+func NewScormPackageStore(path string) *ScormPackageStore {
+	s := &ScormPackageStore{Packages: map[string]ScormPackage{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *ScormPackageStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var packages map[string]ScormPackage
+	if err := readJSONFile(s.file, &packages); err != nil {
+		logError("failed to load SCORM packages", err)
+		return
+	}
+	if packages != nil {
+		s.Packages = packages
+	}
+}
+
+func (s *ScormPackageStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Packages)
+}
+
+func (s *ScormPackageStore) get(id string) (ScormPackage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.Packages[id]
+	return p, ok
+}
+
+func (s *ScormPackageStore) put(p ScormPackage) error {
+	s.mu.Lock()
+	s.Packages[p.ID] = p
+	s.mu.Unlock()
+	return s.save()
+}
+
+func (s *ScormPackageStore) delete(id string) error {
+	s.mu.Lock()
+	delete(s.Packages, id)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// ScormAttemptStore manages learners' runtime data, keyed by package ID then email
+type ScormAttemptStore struct {
+	mu       sync.Mutex
+	Attempts map[string]map[string]ScormAttempt `json:"attempts"`
+	file     string
+}
+
+// NewScormAttemptStore creates a new SCORM runtime data store
+func NewScormAttemptStore(path string) *ScormAttemptStore {
+	s := &ScormAttemptStore{Attempts: map[string]map[string]ScormAttempt{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *ScormAttemptStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var attempts map[string]map[string]ScormAttempt
+	if err := readJSONFile(s.file, &attempts); err != nil {
+		logError("failed to load SCORM attempts", err)
+		return
+	}
+	if attempts != nil {
+		s.Attempts = attempts
+	}
+}
+
+func (s *ScormAttemptStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Attempts)
+}
+
+func (s *ScormAttemptStore) get(packageID, email string) (ScormAttempt, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.Attempts[packageID][email]
+	return a, ok
+}
+
+func (s *ScormAttemptStore) put(a ScormAttempt) error {
+	s.mu.Lock()
+	if s.Attempts[a.PackageID] == nil {
+		s.Attempts[a.PackageID] = map[string]ScormAttempt{}
+	}
+	s.Attempts[a.PackageID][a.Email] = a
+	s.mu.Unlock()
+	return s.save()
+}
+
+// forPackage returns every learner's attempt on a package
+func (s *ScormAttemptStore) forPackage(packageID string) []ScormAttempt {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts := make([]ScormAttempt, 0, len(s.Attempts[packageID]))
+	for _, a := range s.Attempts[packageID] {
+		attempts = append(attempts, a)
+	}
+	return attempts
+}
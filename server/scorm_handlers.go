@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ScormHandlers contains the SCORM import and runtime handlers
+type ScormHandlers struct {
+	mu         sync.Mutex // Serialises runtime commits so values merge cleanly
+	packages   *ScormPackageStore
+	attempts   *ScormAttemptStore
+	trainings  *TrainingHandlers
+	progress   *ProgressHandlers
+	users      *UserStore
+	uploadPath string
+}
+
+// NewScormHandlers creates a new ScormHandlers instance
+func NewScormHandlers(packages *ScormPackageStore, attempts *ScormAttemptStore, trainings *TrainingHandlers, progress *ProgressHandlers, users *UserStore, uploadPath string) *ScormHandlers {
+	os.MkdirAll(uploadPath, 0755)
+	return &ScormHandlers{
+		packages:   packages,
+		attempts:   attempts,
+		trainings:  trainings,
+		progress:   progress,
+		users:      users,
+		uploadPath: uploadPath,
+	}
+}
+
+// HandleImportScorm handles uploading a SCORM 1.2 or 2004 package. The
+// package is extracted and served under /uploads/scorm/, and a training with
+// a single scorm block is created for it, as a draft unless the form sets
+// publish=true. Package content is only served sandboxed, to learners
+// holding a signed link.
+func (h *ScormHandlers) HandleImportScorm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxScormUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "file too large or invalid"})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, handler, err := r.FormFile("package")
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "no file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	zr, err := zip.NewReader(file, handler.Size)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "file must be a SCORM zip package"})
+		return
+	}
+	manifest, err := readScormManifest(zr)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	title, launch, data, err := manifest.launch()
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	launchFile := strings.SplitN(strings.SplitN(launch, "?", 2)[0], "#", 2)[0]
+	launchQuery := launch[len(launchFile):]
+	launchFile = strings.TrimPrefix(path.Clean("/"+launchFile), "/")
+	if _, err := zr.Open(launchFile); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("launch file %s is not in the package", launchFile)})
+		return
+	}
+	if title == "" {
+		title = strings.TrimSuffix(handler.Filename, filepath.Ext(handler.Filename))
+	}
+
+	pkg := ScormPackage{
+		ID:          uuid.New().String(),
+		Title:       title,
+		Version:     manifest.version(),
+		DataFromLMS: data,
+		UploadedBy:  email,
+		UploadedAt:  time.Now(),
+	}
+	pkg.LaunchURL = "/uploads/scorm/" + pkg.ID + "/" + launchFile + launchQuery
+	dir := filepath.Join(h.uploadPath, pkg.ID)
+	if pkg.Size, err = extractScorm(zr, dir); err != nil {
+		os.RemoveAll(dir)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "invalid package: " + err.Error()})
+		return
+	}
+
+	training, failure := h.trainings.create(email, TrainingRequest{
+		Title:       pkg.Title,
+		Description: fmt.Sprintf("SCORM %s course", pkg.Version),
+		Blocks: []ContentBlock{{
+			Type: "scorm",
+			Content: map[string]interface{}{
+				"package_id": pkg.ID,
+				"launch_url": pkg.LaunchURL,
+				"version":    pkg.Version,
+			},
+		}},
+		Publish: r.FormValue("publish") == "true",
+	})
+	if failure != nil {
+		os.RemoveAll(dir)
+		respondJSON(w, failure)
+		return
+	}
+
+	pkg.TrainingID = training.ID
+	if err := h.packages.put(pkg); err != nil {
+		logError("failed to save SCORM package", err)
+		if err := h.packages.delete(pkg.ID); err != nil {
+			logError("failed to remove SCORM package", err)
+		}
+		if err := h.trainings.store.delete(training.ID); err != nil {
+			logError("failed to remove SCORM training", err)
+		}
+		if err := h.trainings.revisions.deleteAll(training.ID); err != nil {
+			logError("failed to remove SCORM training revisions", err)
+		}
+		os.RemoveAll(dir)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save package"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"package":  pkg,
+		"training": training,
+	})
+}
+
+// scormBlock finds a scorm block in the version of a training the learner
+// sees, or returns the error to report
+func (h *ScormHandlers) scormBlock(trainingID, blockID, email string) (Training, *ContentBlock, ScormPackage, string) {
+	stored, ok := h.trainings.store.get(trainingID)
+	if !ok || stored.DeletedAt != nil {
+		return Training{}, nil, ScormPackage{}, "training not found"
+	}
+	training, ok := h.trainings.viewFor(stored, email)
+	if !ok {
+		return Training{}, nil, ScormPackage{}, "training not found"
+	}
+	if training.Locked {
+		return Training{}, nil, ScormPackage{}, "complete the prerequisites first"
+	}
+	for i := range training.Blocks {
+		block := &training.Blocks[i]
+		if block.ID != blockID || block.Type != "scorm" {
+			continue
+		}
+		id, _ := block.Content["package_id"].(string)
+		if pkg, ok := h.packages.get(id); ok {
+			return training, block, pkg, ""
+		}
+	}
+	return Training{}, nil, ScormPackage{}, "SCORM content not found"
+}
+
+// currentAttempt returns the learner's attempt on a package. Completed
+// attempts are started afresh if the learner's progress on the training has
+// since been reset, for example when a certification lapses.
+func (h *ScormHandlers) currentAttempt(pkg ScormPackage, training Training, blockID, email string) ScormAttempt {
+	attempt, ok := h.attempts.get(pkg.ID, email)
+	if ok && attempt.Completed {
+		p, found := h.progress.store.get(training.ID, email)
+		ok = found && p.hasViewed(blockID)
+	}
+	if !ok {
+		attempt = ScormAttempt{
+			PackageID:  pkg.ID,
+			TrainingID: training.ID,
+			Email:      email,
+			Values:     map[string]string{},
+			StartedAt:  time.Now(),
+		}
+	}
+	return attempt
+}
+
+// HandleGetRuntime handles content starting up: it returns the cmi data the
+// learner saved before along with the values the LMS provides
+func (h *ScormHandlers) HandleGetRuntime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	user, ok := h.users.get(email)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	training, block, pkg, problem := h.scormBlock(r.URL.Query().Get("training_id"), r.URL.Query().Get("block_id"), email)
+	if problem != "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": problem})
+		return
+	}
+
+	h.mu.Lock()
+	attempt := h.currentAttempt(pkg, training, block.ID, email)
+	h.mu.Unlock()
+
+	respondJSON(w, map[string]interface{}{
+		"ok":      true,
+		"version": pkg.Version,
+		"values":  attempt.initialValues(pkg, user.Name),
+	})
+}
+
+// HandleCommitRuntime handles content saving cmi data. Values are merged into
+// the learner's attempt; once the content reports completion the block counts
+// as viewed in the learner's progress.
+func (h *ScormHandlers) HandleCommitRuntime(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email      string            `json:"email"`
+		TrainingID string            `json:"training_id"`
+		BlockID    string            `json:"block_id"`
+		Values     map[string]string `json:"values"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4<<20)).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+	if _, ok := h.users.get(email); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	training, block, pkg, problem := h.scormBlock(req.TrainingID, req.BlockID, email)
+	if problem != "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": problem})
+		return
+	}
+
+	h.mu.Lock()
+	attempt := h.currentAttempt(pkg, training, block.ID, email)
+	for key, value := range req.Values {
+		if !strings.HasPrefix(key, "cmi.") || scormReadOnly[key] ||
+			strings.HasSuffix(key, "._children") || strings.HasSuffix(key, "._count") {
+			continue
+		}
+		if len(value) > maxScormValueLength {
+			h.mu.Unlock()
+			respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("%s is too long", key)})
+			return
+		}
+		if _, exists := attempt.Values[key]; !exists && len(attempt.Values) >= maxScormValues {
+			h.mu.Unlock()
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "too many values"})
+			return
+		}
+		attempt.Values[key] = value
+	}
+	now := time.Now()
+	attempt.UpdatedAt = now
+	wasCompleted := attempt.Completed
+	attempt.evaluate(pkg.Version)
+	if attempt.Completed && !wasCompleted {
+		attempt.CompletedAt = &now
+	}
+	err := h.attempts.put(attempt)
+	h.mu.Unlock()
+	if err != nil {
+		logError("failed to save SCORM attempt", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save"})
+		return
+	}
+
+	response := map[string]interface{}{
+		"ok":      true,
+		"attempt": attempt,
+	}
+	if attempt.Completed {
+		progress, completed, err := h.progress.record(training, email, func(p *TrainingProgress) {
+			if !p.hasViewed(block.ID) {
+				p.BlocksViewed = append(p.BlocksViewed, block.ID)
+			}
+		})
+		if err != nil {
+			logError("failed to save progress", err)
+		} else {
+			response["progress"] = progress
+			response["completed"] = completed
+		}
+	}
+
+	respondJSON(w, response)
+}
+
+// HandleGetAttempts handles the author of a SCORM training listing learners'
+// completion, success and scores
+func (h *ScormHandlers) HandleGetAttempts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	pkg, ok := h.packages.get(r.URL.Query().Get("package_id"))
+	if !ok || email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "package not found"})
+		return
+	}
+	if t, ok := h.trainings.store.get(pkg.TrainingID); !ok || t.CreatedBy != email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "package not found"})
+		return
+	}
+
+	attempts := h.attempts.forPackage(pkg.ID)
+	for i := range attempts {
+		attempts[i].Values = nil // Raw cmi data is the content's business
+	}
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"package":  pkg,
+		"attempts": attempts,
+	})
+}
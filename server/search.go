@@ -0,0 +1,509 @@
+package main
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The search index is an inverted index over trainings and inventory items,
+// kept in memory and updated by the training and user stores on every write,
+// so results always reflect what is stored without reindexing everything.
+//
+// Trainings are indexed twice: the working copy, which only its author
+// searches, and the published content, which everyone else searches. Query
+// words match whole terms, the start of a term (for search as you type) or,
+// failing those, terms a letter or two off (for typos). Every query word has
+// to match. Results are ranked with BM25, with matches in titles and item
+// numbers counting for more than matches in body text.
+
+// Kinds of searchable document
+const (
+	searchTraining  = "training"  // A training's working copy, for its author
+	searchPublished = "published" // A training's published content, for learners
+	searchItem      = "item"      // An inventory item
+)
+
+// Weights of the fields a document's text is drawn from
+const (
+	searchWeightTitle       = 4.0 // Training titles, item descriptions
+	searchWeightIdentifier  = 4.0 // UPCs and item numbers
+	searchWeightDescription = 2.0
+	searchWeightBody        = 1.0 // Block text, including caption transcripts
+)
+
+// How strongly a term that isn't the query word itself counts
+const (
+	searchPrefixMatch = 0.75
+	searchFuzzyMatch  = 0.5
+)
+
+// BM25 parameters
+const (
+	searchK1 = 1.2
+	searchB  = 0.75
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+	searchSnippetRunes = 160
+)
+
+// searchField is a piece of a document's text and how much matches in it count
+type searchField struct {
+	Name   string
+	Text   string
+	Weight float64
+}
+
+// searchDoc is an indexed training or inventory item
+type searchDoc struct {
+	Key    string
+	Kind   string
+	ID     string // Training or item ID
+	Owner  string // Training author or item owner
+	Fields []searchField
+	Item   InventoryItem // For items, what the result shows
+	terms  map[string]float64
+	length float64
+}
+
+// searchHit is a document matching a query, with its score
+type searchHit struct {
+	Doc   *searchDoc
+	Score float64
+	Terms []string // Indexed terms that matched, for snippets
+}
+
+// SearchIndex is the full-text index
+type SearchIndex struct {
+	mu          sync.Mutex
+	revisions   *RevisionStore
+	docs        map[string]*searchDoc
+	postings    map[string]map[string]float64 // Term to weighted frequency in each document
+	terms       []string                      // Every indexed term, sorted, for prefix lookups
+	owned       map[string]map[string]bool    // Owner to the keys of their items
+	totalLength float64
+}
+
+// NewSearchIndex creates an empty search index. Published content is read
+// from revisions when a training is indexed.
+func NewSearchIndex(revisions *RevisionStore) *SearchIndex {
+	return &SearchIndex{
+		revisions: revisions,
+		docs:      map[string]*searchDoc{},
+		postings:  map[string]map[string]float64{},
+		owned:     map[string]map[string]bool{},
+	}
+}
+
+// searchTerms splits text into lowercase words
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// identifierTerms are the terms for a UPC or item number: its parts, and
+// the whole of it without punctuation, so "AB-1234" is found by "ab1234"
+// as well as "1234"
+func identifierTerms(id string) []string {
+	terms := searchTerms(id)
+	if len(terms) > 1 {
+		terms = append(terms, strings.Join(terms, ""))
+	}
+	return terms
+}
+
+// blockText collects the readable text of a block: headings, paragraphs,
+// list items, quotes, code, alt text, quiz questions and options, and
+// caption transcripts. Answers to quiz questions are left out.
+func blockText(block ContentBlock) string {
+	var parts []string
+	var walk func(key string, v interface{})
+	walk = func(key string, v interface{}) {
+		switch v := v.(type) {
+		case string:
+			switch key {
+			case "text":
+				parts = append(parts, stripTags(v))
+			case "items", "code", "alt", "author", "title", "prompt", "options", "transcript":
+				parts = append(parts, v)
+			}
+		case []interface{}:
+			for _, item := range v {
+				walk(key, item)
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(v))
+			for k := range v {
+				if k != "accepted" {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(k, v[k])
+			}
+		}
+	}
+	walk("", block.Content)
+	return strings.Join(parts, "\n")
+}
+
+// trainingDoc describes a training's content for the index
+func trainingDoc(kind string, t Training) *searchDoc {
+	blocks := make([]ContentBlock, len(t.Blocks))
+	copy(blocks, t.Blocks)
+	sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].Order < blocks[j].Order })
+	body := make([]string, 0, len(blocks))
+	for _, block := range blocks {
+		if text := strings.TrimSpace(blockText(block)); text != "" {
+			body = append(body, text)
+		}
+	}
+	return &searchDoc{
+		Key:   kind + ":" + t.ID,
+		Kind:  kind,
+		ID:    t.ID,
+		Owner: t.CreatedBy,
+		Fields: []searchField{
+			{Name: "title", Text: t.Title, Weight: searchWeightTitle},
+			{Name: "description", Text: t.Description, Weight: searchWeightDescription},
+			{Name: "category", Text: t.Category, Weight: searchWeightDescription},
+			{Name: "tags", Text: strings.Join(t.Tags, "\n"), Weight: searchWeightDescription},
+			{Name: "content", Text: strings.Join(body, "\n"), Weight: searchWeightBody},
+		},
+	}
+}
+
+// itemDoc describes an inventory item for the index
+func itemDoc(owner string, item InventoryItem) *searchDoc {
+	return &searchDoc{
+		Key:   searchItem + ":" + owner + ":" + item.ID,
+		Kind:  searchItem,
+		ID:    item.ID,
+		Owner: owner,
+		Item:  item,
+		Fields: []searchField{
+			{Name: "description", Text: item.Description, Weight: searchWeightTitle},
+			{Name: "upc", Text: item.UPC, Weight: searchWeightIdentifier},
+			{Name: "number", Text: item.Number, Weight: searchWeightIdentifier},
+		},
+	}
+}
+
+// addLocked indexes a document; the caller must hold s.mu
+func (s *SearchIndex) addLocked(doc *searchDoc) {
+	s.removeLocked(doc.Key)
+	doc.terms = map[string]float64{}
+	for _, field := range doc.Fields {
+		terms := searchTerms(field.Text)
+		if field.Name == "upc" || field.Name == "number" {
+			terms = identifierTerms(field.Text)
+		}
+		for _, term := range terms {
+			doc.terms[term] += field.Weight
+			doc.length += field.Weight
+		}
+	}
+	for term, freq := range doc.terms {
+		postings, ok := s.postings[term]
+		if !ok {
+			postings = map[string]float64{}
+			s.postings[term] = postings
+			i, _ := slices.BinarySearch(s.terms, term)
+			s.terms = slices.Insert(s.terms, i, term)
+		}
+		postings[doc.Key] = freq
+	}
+	s.docs[doc.Key] = doc
+	s.totalLength += doc.length
+	if doc.Kind == searchItem {
+		if s.owned[doc.Owner] == nil {
+			s.owned[doc.Owner] = map[string]bool{}
+		}
+		s.owned[doc.Owner][doc.Key] = true
+	}
+}
+
+// removeLocked drops a document from the index; the caller must hold s.mu
+func (s *SearchIndex) removeLocked(key string) {
+	doc, ok := s.docs[key]
+	if !ok {
+		return
+	}
+	for term := range doc.terms {
+		delete(s.postings[term], key)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+			if i, found := slices.BinarySearch(s.terms, term); found {
+				s.terms = slices.Delete(s.terms, i, i+1)
+			}
+		}
+	}
+	delete(s.docs, key)
+	s.totalLength -= doc.length
+	if doc.Kind == searchItem {
+		delete(s.owned[doc.Owner], key)
+		if len(s.owned[doc.Owner]) == 0 {
+			delete(s.owned, doc.Owner)
+		}
+	}
+}
+
+// putTraining indexes the working copy and published content of a training,
+// replacing what was indexed for it before. Trainings in the trash are not
+// searchable.
+func (s *SearchIndex) putTraining(t Training) {
+	var published Training
+	live := false
+	if t.DeletedAt == nil {
+		published, live = s.revisions.published(t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(searchTraining + ":" + t.ID)
+	s.removeLocked(searchPublished + ":" + t.ID)
+	if t.DeletedAt != nil {
+		return
+	}
+	s.addLocked(trainingDoc(searchTraining, t))
+	if live {
+		s.addLocked(trainingDoc(searchPublished, published))
+	}
+}
+
+// removeTraining drops a training from the index
+func (s *SearchIndex) removeTraining(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeLocked(searchTraining + ":" + id)
+	s.removeLocked(searchPublished + ":" + id)
+}
+
+// putUser indexes a user's active inventory, replacing what was indexed for
+// them before. Items without an ID yet are picked up once they are given one.
+func (s *SearchIndex) putUser(u User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeUserLocked(u.Email)
+	for _, item := range u.Inventory {
+		if item.ID != "" {
+			s.addLocked(itemDoc(u.Email, item))
+		}
+	}
+}
+
+// removeUser drops a user's inventory from the index
+func (s *SearchIndex) removeUser(email string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeUserLocked(email)
+}
+
+func (s *SearchIndex) removeUserLocked(email string) {
+	for key := range s.owned[email] {
+		s.removeLocked(key)
+	}
+}
+
+// fuzzyDistance is how many edits a query word may be from a term: none for
+// short words, where a single edit makes a different word, one for medium
+// ones and two for long ones
+func fuzzyDistance(word string) int {
+	switch n := len([]rune(word)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	}
+	return 0
+}
+
+// editDistance is the Levenshtein distance between a and b, giving up once
+// it is over limit
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// expandLocked finds the indexed terms a query word matches and how strongly
+// each counts; the caller must hold s.mu
+func (s *SearchIndex) expandLocked(word string) map[string]float64 {
+	matches := map[string]float64{}
+	if _, ok := s.postings[word]; ok {
+		matches[word] = 1
+	}
+	for i, _ := slices.BinarySearch(s.terms, word); i < len(s.terms) && strings.HasPrefix(s.terms[i], word); i++ {
+		if s.terms[i] != word {
+			matches[s.terms[i]] = searchPrefixMatch
+		}
+	}
+	if len(matches) > 0 {
+		return matches
+	}
+	if limit := fuzzyDistance(word); limit > 0 {
+		for _, term := range s.terms {
+			if editDistance(word, term, limit) <= limit {
+				matches[term] = searchFuzzyMatch
+			}
+		}
+	}
+	return matches
+}
+
+// search returns the documents keep accepts that match every word of query,
+// best first
+func (s *SearchIndex) search(query string, keep func(*searchDoc) bool) []searchHit {
+	words := searchTerms(query)
+	if len(words) == 0 {
+		return []searchHit{}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.docs) == 0 {
+		return []searchHit{}
+	}
+	count := float64(len(s.docs))
+	avgLength := s.totalLength / count
+
+	var scores map[string]float64
+	matched := map[string][]string{}
+	for _, word := range words {
+		// A document scores by the best term each word matches, so a short
+		// prefix matching many terms doesn't outrank an exact match
+		best := map[string]float64{}
+		bestTerm := map[string]string{}
+		for term, strength := range s.expandLocked(word) {
+			postings := s.postings[term]
+			idf := math.Log(1 + (count-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+			for key, freq := range postings {
+				if scores != nil {
+					if _, ok := scores[key]; !ok {
+						continue
+					}
+				}
+				norm := searchK1 * (1 - searchB + searchB*s.docs[key].length/avgLength)
+				score := strength * idf * freq * (searchK1 + 1) / (freq + norm)
+				if score > best[key] {
+					best[key] = score
+					bestTerm[key] = term
+				}
+			}
+		}
+		next := make(map[string]float64, len(best))
+		for key, score := range best {
+			next[key] = scores[key] + score
+			matched[key] = append(matched[key], bestTerm[key])
+		}
+		scores = next
+		if len(scores) == 0 {
+			return []searchHit{}
+		}
+	}
+
+	hits := make([]searchHit, 0, len(scores))
+	for key, score := range scores {
+		doc := s.docs[key]
+		if keep(doc) {
+			hits = append(hits, searchHit{Doc: doc, Score: score, Terms: matched[key]})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Doc.Key < hits[j].Doc.Key
+	})
+	return hits
+}
+
+// field returns the text of one of a document's fields
+func (d *searchDoc) field(name string) string {
+	for _, f := range d.Fields {
+		if f.Name == name {
+			return f.Text
+		}
+	}
+	return ""
+}
+
+// snippet is a short excerpt of a document's body text around the first of
+// the matched terms found in it, or the start of its description
+func (h searchHit) snippet() string {
+	for _, name := range []string{"content", "description"} {
+		text := strings.Join(strings.Fields(h.Doc.field(name)), " ")
+		lower := strings.ToLower(text)
+		at := -1
+		for _, term := range h.Terms {
+			if i := strings.Index(lower, term); i >= 0 && (at < 0 || i < at) {
+				at = i
+			}
+		}
+		if at < 0 {
+			continue
+		}
+		// Lowercasing can change the length of some text, in which case the
+		// offset can't be trusted
+		if len(lower) != len(text) {
+			at = 0
+		}
+		return excerpt(text, at)
+	}
+	return excerpt(strings.Join(strings.Fields(h.Doc.field("description")), " "), 0)
+}
+
+// excerpt cuts about searchSnippetRunes of text, starting a little before
+// byte offset at, on word boundaries
+func excerpt(text string, at int) string {
+	runes := []rune(text)
+	pos := utf8.RuneCountInString(text[:at])
+	start := max(pos-searchSnippetRunes/4, 0)
+	for start > 0 && start < pos && runes[start-1] != ' ' {
+		start++
+	}
+	end := min(start+searchSnippetRunes, len(runes))
+	for end < len(runes) && end > pos && runes[end] != ' ' {
+		end--
+	}
+	out := strings.TrimSpace(string(runes[start:end]))
+	if start > 0 {
+		out = "…" + out
+	}
+	if end < len(runes) {
+		out += "…"
+	}
+	return out
+}
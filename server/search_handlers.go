@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxSearchQueryLength limits the length of a search query
+const maxSearchQueryLength = 200
+
+// SearchHandlers contains the search handlers
+type SearchHandlers struct {
+	index *SearchIndex
+	users *UserStore
+}
+
+// NewSearchHandlers creates a new SearchHandlers instance
+func NewSearchHandlers(index *SearchIndex, users *UserStore) *SearchHandlers {
+	return &SearchHandlers{index: index, users: users}
+}
+
+// HandleSearch handles searching trainings and inventory, best matches
+// first. type=training or type=inventory limits the search to one of them.
+// Trainings are searched as the user would see them: their own as they are
+// editing them, everyone else's as published. Only the user's own inventory
+// is searched unless scope=all.
+func (h *SearchHandlers) HandleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	email := strings.ToLower(strings.TrimSpace(query.Get("email")))
+	if _, exists := h.users.get(email); !exists {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	q := strings.TrimSpace(query.Get("q"))
+	if q == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "q required"})
+		return
+	}
+	if len(q) > maxSearchQueryLength {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("q is too long (max %d characters)", maxSearchQueryLength)})
+		return
+	}
+	kind := query.Get("type")
+	if kind != "" && kind != "training" && kind != "inventory" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "type must be training or inventory"})
+		return
+	}
+	all := query.Get("scope") == "all"
+	limit := defaultSearchLimit
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit)})
+			return
+		}
+		limit = n
+	}
+
+	hits := h.index.search(q, func(doc *searchDoc) bool {
+		switch doc.Kind {
+		case searchTraining:
+			return kind != "inventory" && doc.Owner == email
+		case searchPublished:
+			return kind != "inventory" && doc.Owner != email
+		case searchItem:
+			return kind != "training" && (all || doc.Owner == email)
+		}
+		return false
+	})
+	total := len(hits)
+	if len(hits) > limit {
+		hits = hits[:limit]
+	}
+
+	results := make([]map[string]interface{}, 0, len(hits))
+	for _, hit := range hits {
+		result := map[string]interface{}{
+			"id":    hit.Doc.ID,
+			"score": math.Round(hit.Score*1000) / 1000,
+		}
+		if hit.Doc.Kind == searchItem {
+			item := hit.Doc.Item
+			result["type"] = "item"
+			result["owner"] = hit.Doc.Owner
+			result["description"] = item.Description
+			result["upc"] = item.UPC
+			result["number"] = item.Number
+			result["quantity"] = item.Quantity
+			result["target_quantity"] = item.TargetQuantity
+			result["unit"] = item.Unit
+		} else {
+			result["type"] = "training"
+			result["created_by"] = hit.Doc.Owner
+			result["title"] = hit.Doc.field("title")
+			result["description"] = hit.Doc.field("description")
+			result["snippet"] = hit.snippet()
+		}
+		results = append(results, result)
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":      true,
+		"query":   q,
+		"results": results,
+		"total":   total,
+	})
+}
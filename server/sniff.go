@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Uploaded files are identified from their content rather than the type or
+// name the client sends. Each kind of upload allows a fixed set of types,
+// files whose content contradicts the type they were sent as are rejected,
+// and so are files that parse as one type while carrying markup or an
+// archive that a browser or another tool could pick up instead.
+
+// FileType is a type of file the server recognises
+type FileType struct {
+	MIME string
+	Ext  string
+	Name string // For error messages
+}
+
+var (
+	fileTypeJPEG = FileType{"image/jpeg", ".jpg", "JPEG"}
+	fileTypePNG  = FileType{"image/png", ".png", "PNG"}
+	fileTypeGIF  = FileType{"image/gif", ".gif", "GIF"}
+	fileTypeWebP = FileType{"image/webp", ".webp", "WebP"}
+	fileTypePDF  = FileType{"application/pdf", ".pdf", "PDF"}
+	fileTypeMP4  = FileType{"video/mp4", ".mp4", "MP4"}
+	fileTypeMOV  = FileType{"video/quicktime", ".mov", "QuickTime"}
+	fileTypeWebM = FileType{"video/webm", ".webm", "WebM"}
+	fileTypeOgg  = FileType{"video/ogg", ".ogv", "Ogg"}
+	fileTypeVTT  = FileType{"text/vtt", ".vtt", "WebVTT"} // Only produced by parseCaptions, never sniffed
+)
+
+// Upload kinds and the file types each accepts
+const (
+	uploadKindVideo      = "video"
+	uploadKindImage      = "image"
+	uploadKindAttachment = "attachment"
+)
+
+var uploadAllowlist = map[string][]FileType{
+	uploadKindVideo:      {fileTypeMP4, fileTypeMOV, fileTypeWebM, fileTypeOgg},
+	uploadKindImage:      {fileTypeJPEG, fileTypePNG, fileTypeGIF, fileTypeWebP},
+	uploadKindAttachment: {fileTypeJPEG, fileTypePNG, fileTypeGIF, fileTypeWebP, fileTypePDF},
+}
+
+// Content types and extensions clients use for the types above that differ
+// from the canonical ones
+var mimeAliases = map[string]string{
+	"image/jpg":       "image/jpeg",
+	"image/pjpeg":     "image/jpeg",
+	"video/x-m4v":     "video/mp4",
+	"application/ogg": "video/ogg",
+}
+
+var extensionTypes = map[string]FileType{
+	".jpg": fileTypeJPEG, ".jpeg": fileTypeJPEG, ".jpe": fileTypeJPEG,
+	".png": fileTypePNG, ".gif": fileTypeGIF, ".webp": fileTypeWebP,
+	".pdf": fileTypePDF,
+	".mp4": fileTypeMP4, ".m4v": fileTypeMP4, ".mov": fileTypeMOV, ".qt": fileTypeMOV,
+	".webm": fileTypeWebM, ".ogv": fileTypeOgg, ".ogg": fileTypeOgg,
+	".vtt": fileTypeVTT,
+}
+
+const (
+	sniffLength       = 512     // What browsers look at when they sniff content
+	markupScanLength  = 1 << 20 // Images are scanned for markup up to this size
+	archiveTailLength = 66 << 10
+)
+
+// sniffFileType identifies a file from its first bytes
+func sniffFileType(head []byte) (FileType, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return fileTypeJPEG, true
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return fileTypePNG, true
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return fileTypeGIF, true
+	case len(head) >= 12 && string(head[:4]) == "RIFF" && string(head[8:12]) == "WEBP":
+		return fileTypeWebP, true
+	case bytes.HasPrefix(head, []byte("%PDF-")):
+		return fileTypePDF, true
+	case len(head) >= 12 && string(head[4:8]) == "ftyp":
+		if string(head[8:12]) == "qt  " {
+			return fileTypeMOV, true
+		}
+		return fileTypeMP4, true
+	case bytes.HasPrefix(head, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		// Matroska; only its WebM profile plays in browsers
+		if bytes.Contains(head[:min(len(head), 64)], []byte("webm")) {
+			return fileTypeWebM, true
+		}
+	case bytes.HasPrefix(head, []byte("OggS")):
+		return fileTypeOgg, true
+	}
+	return FileType{}, false
+}
+
+// Markup that makes a file dangerous to serve from this site if a browser
+// or plugin were to sniff it as a document
+var polyglotMarkers = [][]byte{
+	[]byte("<!doctype html"), []byte("<html"), []byte("<head"), []byte("<body"),
+	[]byte("<script"), []byte("<iframe"), []byte("<svg"), []byte("<?php"),
+	[]byte("<?xml-stylesheet"), []byte("javascript:"),
+}
+
+// containsMarkup reports the first markup marker found in data
+func containsMarkup(data []byte) string {
+	lower := bytes.ToLower(data)
+	for _, marker := range polyglotMarkers {
+		if bytes.Contains(lower, marker) {
+			return string(marker)
+		}
+	}
+	return ""
+}
+
+// detectUpload identifies an uploaded file and checks it may be uploaded as
+// kind. declared is the content type the client sent and filename the name
+// it gave; either may be empty. The returned error is suitable to show to
+// the user.
+func detectUpload(r io.ReaderAt, size int64, kind, declared, filename string) (FileType, error) {
+	head := make([]byte, sniffLength)
+	n, err := r.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return FileType{}, fmt.Errorf("could not read file")
+	}
+	head = head[:n]
+
+	allowed := uploadAllowlist[kind]
+	names := make([]string, len(allowed))
+	for i, t := range allowed {
+		names[i] = t.Name
+	}
+	expected := strings.Join(names, ", ")
+
+	detected, ok := sniffFileType(head)
+	if !ok {
+		return FileType{}, fmt.Errorf("file type not recognised; upload %s", expected)
+	}
+	permitted := false
+	for _, t := range allowed {
+		permitted = permitted || t == detected
+	}
+	if !permitted {
+		return FileType{}, fmt.Errorf("%s files are not allowed here; upload %s", detected.Name, expected)
+	}
+
+	// What the client claims has to agree with the content
+	if mediaType, _, err := mime.ParseMediaType(declared); err == nil && mediaType != "application/octet-stream" {
+		if alias, ok := mimeAliases[mediaType]; ok {
+			mediaType = alias
+		}
+		if mediaType != detected.MIME {
+			return FileType{}, fmt.Errorf("file content is %s but it was sent as %s", detected.Name, mediaType)
+		}
+	}
+	if t, ok := extensionTypes[strings.ToLower(filepath.Ext(filename))]; ok && t.MIME != detected.MIME {
+		return FileType{}, fmt.Errorf("file content is %s but its name ends in %s", detected.Name, filepath.Ext(filename))
+	}
+
+	// Images and PDFs are small enough to check in full for markup hidden in
+	// metadata or trailing data; for videos the part browsers sniff is checked
+	scan := head
+	if kind != uploadKindVideo {
+		scan = make([]byte, min(size, markupScanLength))
+		n, err := r.ReadAt(scan, 0)
+		if err != nil && err != io.EOF {
+			return FileType{}, fmt.Errorf("could not read file")
+		}
+		scan = scan[:n]
+	}
+	if detected != fileTypePDF {
+		// PDFs legitimately hold scripts and links; browsers show them in a
+		// sandboxed viewer rather than as pages
+		if marker := containsMarkup(scan); marker != "" {
+			return FileType{}, fmt.Errorf("%s file contains %s markup and was rejected as unsafe", detected.Name, marker)
+		}
+	}
+	if kind != uploadKindVideo {
+		// A zip's directory sits at its end, so an image with a zip appended
+		// opens as both
+		tail := make([]byte, min(size, archiveTailLength))
+		n, err := r.ReadAt(tail, size-int64(len(tail)))
+		if err != nil && err != io.EOF {
+			return FileType{}, fmt.Errorf("could not read file")
+		}
+		if bytes.Contains(tail[:n], []byte("PK\x05\x06")) {
+			return FileType{}, fmt.Errorf("%s file has an archive attached and was rejected as unsafe", detected.Name)
+		}
+	}
+	return detected, nil
+}
+
+// uploadFilename generates the name an upload is stored under: a UUID to
+// make it unique, a readable slug of the original name, and the extension
+// of the detected type
+func uploadFilename(original string, t FileType) string {
+	base := displayFilename(original)
+	base = strings.TrimSuffix(base, filepath.Ext(base))
+	return uuid.New().String() + "_" + slugify(base, "file") + t.Ext
+}
+
+// displayFilename reduces a client supplied file name to its base name
+// without control characters, for showing to users
+func displayFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." || name == "/" {
+		return "upload"
+	}
+	return name
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TeamHandlers contains all team-related HTTP handlers
+type TeamHandlers struct {
+	store *TeamStore
+	users *UserStore
+}
+
+// NewTeamHandlers creates a new TeamHandlers instance
+func NewTeamHandlers(store *TeamStore, users *UserStore) *TeamHandlers {
+	return &TeamHandlers{store: store, users: users}
+}
+
+// HandleGetTeams handles listing the teams a user leads or belongs to
+func (h *TeamHandlers) HandleGetTeams(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":    true,
+		"teams": h.store.getForUser(email),
+	})
+}
+
+// HandleCreateTeam handles creating a team led by the requesting user
+func (h *TeamHandlers) HandleCreateTeam(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email   string   `json:"email"`
+		Name    string   `json:"name"`
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	lead := strings.ToLower(strings.TrimSpace(req.Email))
+	name := strings.TrimSpace(req.Name)
+	if _, ok := h.users.get(lead); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	if name == "" || len(name) > 100 {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "name must be between 1 and 100 characters"})
+		return
+	}
+
+	members, err := h.normalizeMembers(req.Members)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	team := Team{
+		ID:        uuid.New().String(),
+		Name:      name,
+		Lead:      lead,
+		Members:   members,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	if err := h.store.put(team); err != nil {
+		logError("failed to save team", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to create team"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"team": team,
+	})
+}
+
+// HandleUpdateMembers handles replacing a team's member list (team lead only)
+func (h *TeamHandlers) HandleUpdateMembers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID      string   `json:"id"`
+		Email   string   `json:"email"`
+		Members []string `json:"members"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	team, ok := h.store.get(req.ID)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "team not found"})
+		return
+	}
+	if team.Lead != strings.ToLower(strings.TrimSpace(req.Email)) {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "only the team lead can change members"})
+		return
+	}
+
+	members, err := h.normalizeMembers(req.Members)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	// Change the stored team rather than put the copy read above, which may
+	// be missing a transfer accepted since
+	err = h.store.transact([]string{team.ID}, func(teams []*Team) error {
+		teams[0].Members = members
+		teams[0].UpdatedAt = time.Now()
+		team = *teams[0]
+		return nil
+	})
+	if err != nil {
+		logError("failed to update team", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to update team"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":   true,
+		"team": team,
+	})
+}
+
+// normalizeMembers lowercases and de-duplicates member emails and checks
+// that each one is a registered user
+func (h *TeamHandlers) normalizeMembers(emails []string) ([]string, error) {
+	seen := map[string]bool{}
+	members := make([]string, 0, len(emails))
+	for _, e := range emails {
+		email := strings.ToLower(strings.TrimSpace(e))
+		if email == "" || seen[email] {
+			continue
+		}
+		if _, ok := h.users.get(email); !ok {
+			return nil, &ValidationError{Field: "members", Message: "user " + email + " not found"}
+		}
+		seen[email] = true
+		members = append(members, email)
+	}
+	return members, nil
+}
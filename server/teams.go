@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Team is a named group of users, such as a crew, that trainings can be assigned to
+type Team struct {
+	ID        string          `json:"id"`
+	Name      string          `json:"name"`
+	Lead      string          `json:"lead"` // Email of the team lead, who manages membership
+	Members   []string        `json:"members"`
+	Inventory []InventoryItem `json:"inventory,omitempty"` // Stock the team holds, filled by transfers
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// hasMember reports whether email belongs to the team
+func (t Team) hasMember(email string) bool {
+	for _, m := range t.Members {
+		if m == email {
+			return true
+		}
+	}
+	return false
+}
+
+// TeamStore manages teams
+type TeamStore struct {
+	mu    sync.Mutex
+	Teams map[string]Team `json:"teams"`
+	file  string
+}
+
+// NewTeamStore creates a new team store
+func NewTeamStore(path string) *TeamStore {
+	s := &TeamStore{Teams: map[string]Team{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *TeamStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var teams map[string]Team
+	if err := readJSONFile(s.file, &teams); err != nil {
+		logError("failed to load teams", err)
+		return
+	}
+	if teams != nil {
+		s.Teams = teams
+	}
+}
+
+func (s *TeamStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Teams)
+}
+
+func (s *TeamStore) get(id string) (Team, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.Teams[id]
+	return t, ok
+}
+
+func (s *TeamStore) put(t Team) error {
+	s.mu.Lock()
+	s.Teams[t.ID] = t
+	s.mu.Unlock()
+	return s.save()
+}
+
+// getForUser returns the teams a user leads or belongs to, by name
+func (s *TeamStore) getForUser(email string) []Team {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	teams := make([]Team, 0)
+	for _, t := range s.Teams {
+		if t.Lead == email || t.hasMember(email) {
+			teams = append(teams, t)
+		}
+	}
+	sort.Slice(teams, func(i, j int) bool { return teams[i].Name < teams[j].Name })
+	return teams
+}
+
+// transact applies fn to several teams at once and saves them together, like
+// UserStore.transact
+func (s *TeamStore) transact(ids []string, fn func(teams []*Team) error) error {
+	if len(ids) == 0 {
+		return fn(nil) // Nothing to save
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	teams := make([]*Team, len(ids))
+	for i, id := range ids {
+		t, ok := s.Teams[id]
+		if !ok {
+			return fmt.Errorf("team %s not found", id)
+		}
+		t.Inventory = cloneItems(t.Inventory)
+		teams[i] = &t
+	}
+
+	if err := fn(teams); err != nil {
+		return err
+	}
+
+	prev := make(map[string]Team, len(teams))
+	for _, t := range teams {
+		prev[t.ID] = s.Teams[t.ID]
+		s.Teams[t.ID] = *t
+	}
+	if err := writeJSONFile(s.file, s.Teams); err != nil {
+		for id, t := range prev {
+			s.Teams[id] = t
+		}
+		return err
+	}
+	return nil
+}
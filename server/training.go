@@ -10,22 +10,44 @@ import (
 // ContentBlock represents a single content element in a training
 type ContentBlock struct {
 	ID      string                 `json:"id"`
-	Type    string                 `json:"type"` // title, text, video, image, code, list, quote, divider
+	Type    string                 `json:"type"` // title, text, video, image, code, list, quote, divider, quiz, scorm
 	Order   int                    `json:"order"`
 	Content map[string]interface{} `json:"content"` // Flexible content based on type
 }
 
-// Training represents a training module
+// Training represents a training module. The stored training is the author's
+// working copy; learners see the content of its published revision.
 type Training struct {
-	ID           string         `json:"id"`
-	Title        string         `json:"title"`
-	Description  string         `json:"description"`
-	ThumbnailURL string         `json:"thumbnail_url,omitempty"` // Path to uploaded thumbnail image
-	Blocks       []ContentBlock `json:"blocks,omitempty"`        // Array of ordered content blocks
-	CreatedBy    string         `json:"created_by"`              // Email of creator
-	CreatedAt    time.Time      `json:"created_at"`
-	UpdatedAt    time.Time      `json:"updated_at"`
-	DeletedAt    *time.Time     `json:"deleted_at,omitempty"` // Soft delete timestamp
+	ID                    string         `json:"id"`
+	Title                 string         `json:"title"`
+	Description           string         `json:"description"`
+	ThumbnailURL          string         `json:"thumbnail_url,omitempty"` // Path to uploaded thumbnail image
+	Category              string         `json:"category,omitempty"`      // One category the catalog groups it under
+	Tags                  []string       `json:"tags,omitempty"`          // Lowercase keywords for browsing
+	Blocks                []ContentBlock `json:"blocks,omitempty"`        // Array of ordered content blocks
+	Status                string         `json:"status,omitempty"`        // draft, published ("" for trainings created before publishing existed)
+	Revision              int            `json:"revision,omitempty"`      // Number of the published revision
+	HasUnpublishedChanges bool           `json:"has_unpublished_changes,omitempty"`
+	PublishedAt           *time.Time     `json:"published_at,omitempty"`
+	ValidityDays          int            `json:"validity_days,omitempty"` // How long a completion stays valid; 0 never expires
+	Prerequisites         []string       `json:"prerequisites,omitempty"` // Trainings to complete before this one unlocks
+	Locked                bool           `json:"locked,omitempty"`        // Set on learner views while prerequisites are outstanding
+	CreatedBy             string         `json:"created_by"`              // Email of creator
+	CreatedAt             time.Time      `json:"created_at"`
+	UpdatedAt             time.Time      `json:"updated_at"`
+	DeletedAt             *time.Time     `json:"deleted_at,omitempty"` // Soft delete timestamp
+}
+
+// Training statuses
+const (
+	trainingDraft     = "draft"
+	trainingPublished = "published"
+)
+
+// isPublished reports whether learners can see the training. Trainings saved
+// before drafts existed have no status and were always live.
+func (t Training) isPublished() bool {
+	return t.Status != trainingDraft
 }
 
 // TrainingStore manages training data
@@ -33,6 +55,7 @@ type TrainingStore struct {
 	mu        sync.Mutex
 	Trainings map[string]Training `json:"trainings"`
 	file      string
+	index     *SearchIndex // Kept up to date on every write, once set
 }
 
 // NewTrainingStore creates a new training store
@@ -102,6 +125,9 @@ func (s *TrainingStore) getDeletedByUser(email string) []Training {
 func (s *TrainingStore) put(t Training) error {
 	s.mu.Lock()
 	s.Trainings[t.ID] = t
+	if s.index != nil {
+		s.index.putTraining(t)
+	}
 	s.mu.Unlock()
 	return s.save()
 }
@@ -109,6 +135,31 @@ func (s *TrainingStore) put(t Training) error {
 func (s *TrainingStore) delete(id string) error {
 	s.mu.Lock()
 	delete(s.Trainings, id)
+	if s.index != nil {
+		s.index.removeTraining(id)
+	}
 	s.mu.Unlock()
 	return s.save()
 }
+
+// getAllIncludingDeleted returns every training, including those in the trash
+func (s *TrainingStore) getAllIncludingDeleted() []Training {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	trainings := make([]Training, 0, len(s.Trainings))
+	for _, t := range s.Trainings {
+		trainings = append(trainings, t)
+	}
+	return trainings
+}
+
+// indexWith indexes every training for search and keeps index up to date
+// with later writes
+func (s *TrainingStore) indexWith(index *SearchIndex) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.index = index
+	for _, t := range s.Trainings {
+		index.putTraining(t)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,31 +16,115 @@ import (
 
 // TrainingRequest represents a training creation/update request
 type TrainingRequest struct {
-	Title        string         `json:"title"`
-	Description  string         `json:"description"`
-	ThumbnailURL string         `json:"thumbnail_url,omitempty"`
-	Blocks       []ContentBlock `json:"blocks,omitempty"`
+	Title         string         `json:"title"`
+	Description   string         `json:"description"`
+	ThumbnailURL  string         `json:"thumbnail_url,omitempty"`
+	Category      string         `json:"category,omitempty"`
+	Tags          []string       `json:"tags,omitempty"`
+	Blocks        []ContentBlock `json:"blocks,omitempty"`
+	ValidityDays  int            `json:"validity_days,omitempty"`
+	Prerequisites []string       `json:"prerequisites,omitempty"`
+	Publish       bool           `json:"publish,omitempty"` // Publish immediately instead of saving a draft
 }
 
 // TrainingHandlers contains all training-related HTTP handlers
 type TrainingHandlers struct {
-	store           *TrainingStore
-	videoUploadPath string
-	imageUploadPath string
+	store             *TrainingStore
+	revisions         *RevisionStore
+	progress          *ProgressStore
+	paths             *PathStore
+	assignments       *AssignmentStore
+	media             *MediaStore
+	signer            *MediaSigner
+	videoUploadPath   string
+	imageUploadPath   string
+	captionUploadPath string
 }
 
 // NewTrainingHandlers creates a new TrainingHandlers instance
-func NewTrainingHandlers(store *TrainingStore, videoUploadPath, imageUploadPath string) *TrainingHandlers {
+func NewTrainingHandlers(store *TrainingStore, revisions *RevisionStore, progress *ProgressStore, paths *PathStore, assignments *AssignmentStore, media *MediaStore, signer *MediaSigner, videoUploadPath, imageUploadPath, captionUploadPath string) *TrainingHandlers {
 	// Ensure upload directories exist
 	os.MkdirAll(videoUploadPath, 0755)
 	os.MkdirAll(imageUploadPath, 0755)
+	os.MkdirAll(captionUploadPath, 0755)
 	return &TrainingHandlers{
-		store:           store,
-		videoUploadPath: videoUploadPath,
-		imageUploadPath: imageUploadPath,
+		store:             store,
+		revisions:         revisions,
+		progress:          progress,
+		paths:             paths,
+		assignments:       assignments,
+		media:             media,
+		signer:            signer,
+		videoUploadPath:   videoUploadPath,
+		imageUploadPath:   imageUploadPath,
+		captionUploadPath: captionUploadPath,
 	}
 }
 
+// learnerView returns the published content of a training, if it has any,
+// with quiz answers hidden and text blocks sanitized
+func (h *TrainingHandlers) learnerView(t Training) (Training, bool) {
+	view, ok := h.revisions.published(t)
+	if !ok {
+		return Training{}, false
+	}
+	view.Blocks = sanitizeTextBlocks(hideQuizAnswers(view.Blocks))
+	return view, true
+}
+
+// viewFor returns the version of a training the given user should see: the
+// working copy for its author, the published content for everyone else.
+// Learners' views are marked locked until they complete the prerequisites,
+// and always when no learner is identified.
+func (h *TrainingHandlers) viewFor(t Training, email string) (Training, bool) {
+	if email != "" && t.CreatedBy == email {
+		t.Blocks = sanitizeTextBlocks(t.Blocks)
+		return t, true
+	}
+	view, ok := h.learnerView(t)
+	if ok {
+		view.Locked = email == "" || len(missingPrerequisites(view, email, h.store, h.paths, h.assignments, h.progress)) > 0
+	}
+	return view, ok
+}
+
+// contentFor returns a training as the given user may receive it: a locked
+// view without its blocks, anything else with its media signed for playback.
+// Every handler returning training content goes through it.
+func (h *TrainingHandlers) contentFor(t Training, email string, now time.Time) (Training, bool) {
+	view, ok := h.viewFor(t, email)
+	if !ok {
+		return Training{}, false
+	}
+	if view.Locked {
+		view.Blocks = nil
+		return view, true
+	}
+	return h.signer.signTraining(view, now), true
+}
+
+// publish snapshots the working copy of a training as a new revision and
+// makes it the published content
+func (h *TrainingHandlers) publish(t *Training, by, note string) (TrainingRevision, error) {
+	number := t.Revision + 1
+	if existing := h.revisions.list(t.ID); len(existing) > 0 && existing[len(existing)-1].Number >= number {
+		number = existing[len(existing)-1].Number + 1
+	}
+
+	now := time.Now()
+	rev := newRevision(*t, number, by, note, now)
+	if err := h.revisions.add(rev); err != nil {
+		return TrainingRevision{}, err
+	}
+
+	t.Status = trainingPublished
+	t.Revision = number
+	t.HasUnpublishedChanges = false
+	t.PublishedAt = &now
+	t.UpdatedAt = now
+	return rev, h.store.put(*t)
+}
+
 // HandleCreateTraining handles training creation
 func (h *TrainingHandlers) HandleCreateTraining(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -60,52 +145,112 @@ func (h *TrainingHandlers) HandleCreateTraining(w http.ResponseWriter, r *http.R
 		return
 	}
 
+	training, failure := h.create(createdBy, req)
+	if failure != nil {
+		respondJSON(w, failure)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"training": training,
+	})
+}
+
+// create validates and saves a new training, publishing it if requested. On
+// failure it returns the error response to send instead.
+func (h *TrainingHandlers) create(createdBy string, req TrainingRequest) (Training, map[string]interface{}) {
 	// Validate input
 	title := strings.TrimSpace(req.Title)
 	description := strings.TrimSpace(req.Description)
 
 	if title == "" {
-		respondJSON(w, map[string]interface{}{"ok": false, "error": "title is required"})
-		return
+		return Training{}, map[string]interface{}{"ok": false, "error": "title is required"}
 	}
 
 	if len(title) > 200 {
-		respondJSON(w, map[string]interface{}{"ok": false, "error": "title too long (max 200 characters)"})
-		return
+		return Training{}, map[string]interface{}{"ok": false, "error": "title too long (max 200 characters)"}
+	}
+
+	blocks, errs := validateBlocks(req.Blocks)
+	if len(errs) == 0 {
+		blocks, errs = withTranscripts(blocks, h.captionUploadPath)
+	}
+	if len(errs) > 0 {
+		return Training{}, blockErrorResponse(errs)
+	}
+
+	if req.ValidityDays < 0 {
+		return Training{}, map[string]interface{}{"ok": false, "error": "validity_days must not be negative"}
+	}
+
+	id := uuid.New().String()
+	prerequisites, err := h.store.validatePrerequisites(id, req.Prerequisites)
+	if err != nil {
+		return Training{}, map[string]interface{}{"ok": false, "error": err.Error()}
+	}
+	category, err := h.store.cleanCategory(id, req.Category)
+	if err != nil {
+		return Training{}, map[string]interface{}{"ok": false, "error": err.Error()}
+	}
+	tags, err := cleanTags(req.Tags)
+	if err != nil {
+		return Training{}, map[string]interface{}{"ok": false, "error": err.Error()}
 	}
 
 	// Create training
 	training := Training{
-		ID:           uuid.New().String(),
-		Title:        title,
-		Description:  description,
-		ThumbnailURL: req.ThumbnailURL,
-		Blocks:       req.Blocks,
-		CreatedBy:    createdBy,
-		CreatedAt:    time.Now(),
-		UpdatedAt:    time.Now(),
+		ID:            id,
+		Title:         title,
+		Description:   description,
+		ThumbnailURL:  req.ThumbnailURL,
+		Category:      category,
+		Tags:          tags,
+		Blocks:        blocks,
+		ValidityDays:  req.ValidityDays,
+		Prerequisites: prerequisites,
+		Status:        trainingDraft,
+		CreatedBy:     createdBy,
+		CreatedAt:     time.Now(),
+		UpdatedAt:     time.Now(),
+	}
+
+	if err := h.checkMediaAccess(training, createdBy); err != nil {
+		return Training{}, map[string]interface{}{"ok": false, "error": err.Error()}
 	}
 
 	if err := h.store.put(training); err != nil {
 		logError("failed to save training", err)
-		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to create training"})
-		return
+		return Training{}, map[string]interface{}{"ok": false, "error": "failed to create training"}
 	}
 
-	respondJSON(w, map[string]interface{}{
-		"ok":       true,
-		"training": training,
-	})
+	if req.Publish {
+		if _, err := h.publish(&training, createdBy, "Initial version"); err != nil {
+			logError("failed to publish training", err)
+			return Training{}, map[string]interface{}{"ok": false, "error": "training saved as draft but could not be published"}
+		}
+	}
+
+	return training, nil
 }
 
-// HandleGetTrainings handles getting all trainings (non-deleted)
+// HandleGetTrainings handles getting all trainings (non-deleted). Learners
+// get published content only; authors identified by ?email= also get their
+// own drafts and working copies.
 func (h *TrainingHandlers) HandleGetTrainings(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	trainings := h.store.getAll()
+	email := r.URL.Query().Get("email")
+	now := time.Now()
+	trainings := make([]Training, 0)
+	for _, t := range h.store.getAll() {
+		if view, ok := h.contentFor(t, email, now); ok {
+			trainings = append(trainings, view)
+		}
+	}
 	respondJSON(w, map[string]interface{}{
 		"ok":        true,
 		"trainings": trainings,
@@ -132,7 +277,8 @@ func (h *TrainingHandlers) HandleGetDeletedTrainings(w http.ResponseWriter, r *h
 	})
 }
 
-// HandleGetTraining handles getting a single training
+// HandleGetTraining handles getting a single training. Authors see their
+// working copy, or a past revision with ?revision=N.
 func (h *TrainingHandlers) HandleGetTraining(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -144,12 +290,54 @@ func (h *TrainingHandlers) HandleGetTraining(w http.ResponseWriter, r *http.Requ
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "id required"})
 		return
 	}
+	email := r.URL.Query().Get("email")
 
-	training, ok := h.store.get(id)
+	stored, ok := h.store.get(id)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+
+	if number := r.URL.Query().Get("revision"); number != "" {
+		n, err := strconv.Atoi(number)
+		if err != nil || stored.CreatedBy != email {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "revision not found"})
+			return
+		}
+		rev, ok := h.revisions.get(id, n)
+		if !ok {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "revision not found"})
+			return
+		}
+		respondJSON(w, map[string]interface{}{
+			"ok":       true,
+			"training": h.signer.signTraining(rev.apply(stored), time.Now()),
+			"revision": rev,
+		})
+		return
+	}
+
+	training, ok := h.contentFor(stored, email, time.Now())
 	if !ok {
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
 		return
 	}
+	if training.Locked && email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+	if training.Locked {
+		missing := make([]map[string]string, 0)
+		for _, t := range missingPrerequisites(training, email, h.store, h.paths, h.assignments, h.progress) {
+			missing = append(missing, map[string]string{"id": t.ID, "title": t.Title})
+		}
+		respondJSON(w, map[string]interface{}{
+			"ok":            false,
+			"error":         "complete the prerequisites first",
+			"prerequisites": missing,
+		})
+		return
+	}
 
 	respondJSON(w, map[string]interface{}{
 		"ok":       true,
@@ -165,11 +353,16 @@ func (h *TrainingHandlers) HandleUpdateTraining(w http.ResponseWriter, r *http.R
 	}
 
 	var req struct {
-		ID           string         `json:"id"`
-		Title        string         `json:"title"`
-		Description  string         `json:"description"`
-		ThumbnailURL string         `json:"thumbnail_url,omitempty"`
-		Blocks       []ContentBlock `json:"blocks,omitempty"`
+		ID            string         `json:"id"`
+		Email         string         `json:"email"`
+		Title         string         `json:"title"`
+		Description   string         `json:"description"`
+		ThumbnailURL  string         `json:"thumbnail_url,omitempty"`
+		Category      *string        `json:"category,omitempty"` // "" removes the category
+		Tags          []string       `json:"tags,omitempty"`     // [] removes every tag
+		Blocks        []ContentBlock `json:"blocks,omitempty"`
+		ValidityDays  *int           `json:"validity_days,omitempty"` // Takes effect for completions once published
+		Prerequisites []string       `json:"prerequisites,omitempty"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -178,11 +371,17 @@ func (h *TrainingHandlers) HandleUpdateTraining(w http.ResponseWriter, r *http.R
 	}
 
 	training, ok := h.store.get(req.ID)
-	if !ok {
+	if !ok || training.DeletedAt != nil {
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
 		return
 	}
 
+	// Only allow users to edit their own trainings
+	if training.CreatedBy != req.Email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "you can only edit your own trainings"})
+		return
+	}
+
 	// Update fields
 	if req.Title != "" {
 		training.Title = strings.TrimSpace(req.Title)
@@ -194,7 +393,66 @@ func (h *TrainingHandlers) HandleUpdateTraining(w http.ResponseWriter, r *http.R
 		training.ThumbnailURL = req.ThumbnailURL
 	}
 	if req.Blocks != nil {
-		training.Blocks = req.Blocks
+		blocks, errs := validateBlocks(req.Blocks)
+		if len(errs) == 0 {
+			blocks, errs = withTranscripts(blocks, h.captionUploadPath)
+		}
+		if len(errs) > 0 {
+			respondJSON(w, blockErrorResponse(errs))
+			return
+		}
+		training.Blocks = blocks
+		if err := h.checkMediaAccess(training, training.CreatedBy); err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+			return
+		}
+	}
+	if req.ValidityDays != nil {
+		if *req.ValidityDays < 0 {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "validity_days must not be negative"})
+			return
+		}
+		training.ValidityDays = *req.ValidityDays
+	}
+	if req.Prerequisites != nil {
+		prerequisites, err := h.store.validatePrerequisites(training.ID, req.Prerequisites)
+		if err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+			return
+		}
+		// Paths are taken in order, so a prerequisite can also conflict with
+		// a path the training is on
+		trainings := h.store.getAll()
+		for i := range trainings {
+			if trainings[i].ID == training.ID {
+				trainings[i].Prerequisites = prerequisites
+			}
+		}
+		if t, ok := orderCycle(trainings, h.paths.getAll()); ok {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("these prerequisites would leave %q waiting on itself through a learning path", t.Title)})
+			return
+		}
+		training.Prerequisites = prerequisites
+	}
+	if req.Category != nil {
+		category, err := h.store.cleanCategory(training.ID, *req.Category)
+		if err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+			return
+		}
+		training.Category = category
+	}
+	if req.Tags != nil {
+		tags, err := cleanTags(req.Tags)
+		if err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+			return
+		}
+		training.Tags = tags
+	}
+	// Edits only reach learners once the training is published again
+	if training.Status == trainingPublished {
+		training.HasUnpublishedChanges = true
 	}
 	training.UpdatedAt = time.Now()
 
@@ -330,11 +588,15 @@ func (h *TrainingHandlers) HandlePermanentDeleteTraining(w http.ResponseWriter,
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to delete training"})
 		return
 	}
+	if err := h.revisions.deleteAll(req.ID); err != nil {
+		logError("failed to delete training revisions", err)
+	}
 
 	respondJSON(w, map[string]interface{}{"ok": true})
 }
 
-// HandleUploadVideo handles video file uploads
+// HandleUploadVideo handles video file uploads in a single request. Larger
+// videos should use the resumable uploads under /api/uploads/videos.
 func (h *TrainingHandlers) HandleUploadVideo(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -355,15 +617,15 @@ func (h *TrainingHandlers) HandleUploadVideo(w http.ResponseWriter, r *http.Requ
 	}
 	defer file.Close()
 
-	// Validate file type
-	contentType := handler.Header.Get("Content-Type")
-	if !strings.HasPrefix(contentType, "video/") {
-		respondJSON(w, map[string]interface{}{"ok": false, "error": "file must be a video"})
+	// Validate file type from its content
+	fileType, err := detectUpload(file, handler.Size, uploadKindVideo, handler.Header.Get("Content-Type"), handler.Filename)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
 		return
 	}
 
 	// Generate unique filename
-	filename := fmt.Sprintf("%s_%s", uuid.New().String(), handler.Filename)
+	filename := uploadFilename(handler.Filename, fileType)
 	uploadFilePath := filepath.Join(h.videoUploadPath, filename)
 
 	// Create file
@@ -382,6 +644,8 @@ func (h *TrainingHandlers) HandleUploadVideo(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	h.registerMedia(uploadKindVideo, uploadFilePath, handler.Filename, r.URL.Query().Get("email"))
+
 	// Return file URL
 	videoURL := fmt.Sprintf("/uploads/videos/%s", filename)
 	respondJSON(w, map[string]interface{}{
@@ -390,7 +654,9 @@ func (h *TrainingHandlers) HandleUploadVideo(w http.ResponseWriter, r *http.Requ
 	})
 }
 
-// HandleUploadImage handles image file uploads (for thumbnails)
+// HandleUploadImage handles image file uploads, for thumbnails and image
+// blocks. The image is re-encoded without its metadata and resized copies
+// are made; see processImage.
 func (h *TrainingHandlers) HandleUploadImage(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
@@ -411,23 +677,15 @@ func (h *TrainingHandlers) HandleUploadImage(w http.ResponseWriter, r *http.Requ
 	}
 	defer file.Close()
 
-	// Validate file type
-	contentType := handler.Header.Get("Content-Type")
-	allowedTypes := []string{"image/jpeg", "image/jpg", "image/png", "image/gif", "image/webp"}
-	isValidImage := false
-	for _, allowedType := range allowedTypes {
-		if contentType == allowedType {
-			isValidImage = true
-			break
-		}
-	}
-	if !isValidImage {
-		respondJSON(w, map[string]interface{}{"ok": false, "error": "file must be an image (JPEG, PNG, GIF, or WebP)"})
+	// Validate file type from its content
+	fileType, err := detectUpload(file, handler.Size, uploadKindImage, handler.Header.Get("Content-Type"), handler.Filename)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
 		return
 	}
 
 	// Generate unique filename
-	filename := fmt.Sprintf("%s_%s", uuid.New().String(), handler.Filename)
+	filename := uploadFilename(handler.Filename, fileType)
 	uploadFilePath := filepath.Join(h.imageUploadPath, filename)
 
 	// Create file
@@ -437,19 +695,437 @@ func (h *TrainingHandlers) HandleUploadImage(w http.ResponseWriter, r *http.Requ
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save file"})
 		return
 	}
-	defer dst.Close()
 
 	// Copy file
-	if _, err := io.Copy(dst, file); err != nil {
+	_, err = io.Copy(dst, file)
+	dst.Close()
+	if err != nil {
 		logError("failed to copy file", err)
+		os.Remove(uploadFilePath)
 		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save file"})
 		return
 	}
 
+	// Strip metadata and make the resized copies
+	uploadFilePath, _, err = processImage(uploadFilePath, fileType)
+	if err != nil {
+		os.Remove(filepath.Join(h.imageUploadPath, filename))
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	h.registerMedia(uploadKindImage, uploadFilePath, handler.Filename, r.URL.Query().Get("email"))
+
 	// Return file URL
-	imageURL := fmt.Sprintf("/uploads/images/%s", filename)
+	imageURL := fmt.Sprintf("/uploads/images/%s", filepath.Base(uploadFilePath))
 	respondJSON(w, map[string]interface{}{
 		"ok":        true,
 		"image_url": imageURL,
+		"variants":  imageVariantURLs(h.imageUploadPath, imageURL),
+	})
+}
+
+// HandleUploadCaptions handles caption uploads for video blocks. WebVTT and
+// SRT files are accepted and saved as WebVTT; see parseCaptions. The
+// response includes the transcript so it can be previewed before saving.
+func (h *TrainingHandlers) HandleUploadCaptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCaptionFileSize+(1<<20))
+	if err := r.ParseMultipartForm(maxCaptionFileSize); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "file too large or invalid"})
+		return
+	}
+
+	file, handler, err := r.FormFile("captions")
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "no file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, maxCaptionFileSize+1))
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "could not read file"})
+		return
+	}
+	cues, err := parseCaptions(data)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	filename := uploadFilename(handler.Filename, fileTypeVTT)
+	uploadFilePath := filepath.Join(h.captionUploadPath, filename)
+	if err := os.WriteFile(uploadFilePath, formatWebVTT(cues), 0644); err != nil {
+		logError("failed to save captions", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to save file"})
+		return
+	}
+
+	h.registerMedia(uploadKindCaption, uploadFilePath, handler.Filename, r.URL.Query().Get("email"))
+
+	respondJSON(w, map[string]interface{}{
+		"ok":           true,
+		"captions_url": "/uploads/captions/" + filename,
+		"cues":         len(cues),
+		"transcript":   captionTranscript(cues),
+	})
+}
+
+// HandleImportMarkdown handles creating a training from an uploaded Markdown
+// file. The training is saved as a draft unless the form sets publish=true.
+func (h *TrainingHandlers) HandleImportMarkdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	createdBy := r.URL.Query().Get("email")
+	if createdBy == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	// Parse multipart form (max 2MB for Markdown)
+	if err := r.ParseMultipartForm(2 << 20); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "file too large or invalid"})
+		return
+	}
+
+	file, handler, err := r.FormFile("file")
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "no file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	ext := strings.ToLower(filepath.Ext(handler.Filename))
+	if ext != ".md" && ext != ".markdown" && ext != ".txt" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "file must be Markdown (.md)"})
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		logError("failed to read markdown upload", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to read file"})
+		return
+	}
+
+	title, description, blocks, err := trainingFromMarkdown(string(data))
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	if title == "" {
+		title = strings.TrimSuffix(filepath.Base(handler.Filename), filepath.Ext(handler.Filename))
+	}
+
+	training, failure := h.create(createdBy, TrainingRequest{
+		Title:       title,
+		Description: description,
+		Blocks:      blocks,
+		Publish:     r.FormValue("publish") == "true",
+	})
+	if failure != nil {
+		respondJSON(w, failure)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"training": training,
+	})
+}
+
+// HandleExportMarkdown handles downloading a training as a Markdown file. The
+// author gets their working copy; others get the published content with quiz
+// answers left out.
+func (h *TrainingHandlers) HandleExportMarkdown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	stored, ok := h.store.get(r.URL.Query().Get("id"))
+	if !ok || stored.DeletedAt != nil {
+		respondError(w, "training not found", http.StatusNotFound)
+		return
+	}
+	training, ok := h.contentFor(stored, email, time.Now())
+	if !ok || training.Locked {
+		respondError(w, "training not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(training.Title, ".md")+`"`)
+	if _, err := io.WriteString(w, trainingToMarkdown(training)); err != nil {
+		logError("failed to write markdown export", err)
+	}
+}
+
+// HandleExportPackage handles the author downloading a training and the
+// media it uses as a zip package for import on another instance
+func (h *TrainingHandlers) HandleExportPackage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	training, ok := h.store.get(r.URL.Query().Get("id"))
+	if !ok || training.DeletedAt != nil || email == "" || training.CreatedBy != email {
+		respondError(w, "training not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+exportFilename(training.Title, ".zip")+`"`)
+	if err := h.writePackage(w, training, email); err != nil {
+		logError("failed to write training package", err)
+	}
+}
+
+// HandleImportPackage handles creating a training from an uploaded package.
+// Media is checked against the manifest checksums and saved as new uploads;
+// the training is saved as a draft unless the form sets publish=true.
+func (h *TrainingHandlers) HandleImportPackage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	createdBy := r.URL.Query().Get("email")
+	if createdBy == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxPackageUploadSize)
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "file too large or invalid"})
+		return
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	file, handler, err := r.FormFile("package")
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "no file uploaded"})
+		return
+	}
+	defer file.Close()
+
+	pkg, saved, err := h.readPackage(file, handler.Size)
+	removeSaved := func() {
+		for _, name := range saved {
+			removeWithVariants(name)
+		}
+	}
+	if err != nil {
+		removeSaved()
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "invalid package: " + err.Error()})
+		return
+	}
+
+	// The media is registered first, since the training may only use uploads
+	// its author owns
+	registered := make([]MediaItem, 0, len(saved))
+	for _, name := range saved {
+		kind := uploadKindImage
+		switch filepath.Dir(name) {
+		case h.videoUploadPath:
+			kind = uploadKindVideo
+		case h.captionUploadPath:
+			kind = uploadKindCaption
+		}
+		if item, ok := h.registerMedia(kind, name, packageUploadPrefix.ReplaceAllString(filepath.Base(name), ""), createdBy); ok {
+			registered = append(registered, item)
+		}
+	}
+
+	training, failure := h.create(createdBy, TrainingRequest{
+		Title:        pkg.Title,
+		Description:  pkg.Description,
+		ThumbnailURL: pkg.ThumbnailURL,
+		Category:     pkg.Category,
+		Tags:         pkg.Tags,
+		Blocks:       pkg.Blocks,
+		ValidityDays: pkg.ValidityDays,
+		Publish:      r.FormValue("publish") == "true",
+	})
+	if failure != nil {
+		removeSaved()
+		for _, item := range registered {
+			if err := h.media.remove(item.ID); err != nil {
+				logError("failed to remove media record", err)
+			}
+		}
+		respondJSON(w, failure)
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"training": training,
+	})
+}
+
+// registerMedia records an upload in the media library. Failing to is only
+// logged, since the next collection pass adopts files it has no record of.
+func (h *TrainingHandlers) registerMedia(kind, path, original, owner string) (MediaItem, bool) {
+	item := newMediaItem(kind, path, original, owner, time.Now())
+	if err := h.media.register(item); err != nil {
+		logError("failed to register media", err)
+		return MediaItem{}, false
+	}
+	return item, true
+}
+
+// HandlePublishTraining handles publishing the working copy of a training as
+// a new numbered revision
+func (h *TrainingHandlers) HandlePublishTraining(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+		Note  string `json:"note,omitempty"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	training, ok := h.store.get(req.ID)
+	if !ok || training.DeletedAt != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+
+	// Only allow users to publish their own trainings
+	if training.CreatedBy != req.Email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "you can only publish your own trainings"})
+		return
+	}
+
+	rev, err := h.publish(&training, req.Email, strings.TrimSpace(req.Note))
+	if err != nil {
+		logError("failed to publish training", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to publish training"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"training": training,
+		"revision": rev,
+	})
+}
+
+// HandleGetRevisions handles listing the published revisions of a training
+func (h *TrainingHandlers) HandleGetRevisions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	email := r.URL.Query().Get("email")
+
+	training, ok := h.store.get(id)
+	if !ok || training.CreatedBy != email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":        true,
+		"current":   training.Revision,
+		"revisions": h.revisions.list(id),
+	})
+}
+
+// HandleRollbackTraining handles restoring a past revision. The old content
+// replaces the working copy and is published as a new revision, so the
+// revision history itself is never rewritten.
+func (h *TrainingHandlers) HandleRollbackTraining(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID       string `json:"id"`
+		Email    string `json:"email"`
+		Revision int    `json:"revision"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	training, ok := h.store.get(req.ID)
+	if !ok || training.DeletedAt != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "training not found"})
+		return
+	}
+
+	// Only allow users to roll back their own trainings
+	if training.CreatedBy != req.Email {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "you can only roll back your own trainings"})
+		return
+	}
+
+	target, ok := h.revisions.get(req.ID, req.Revision)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "revision not found"})
+		return
+	}
+
+	// Other trainings may have changed since the revision was published, so
+	// its prerequisites and category are checked again
+	training = target.apply(training)
+	prerequisites, err := h.store.validatePrerequisites(training.ID, training.Prerequisites)
+	if err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("revision %d can't be restored: %s", target.Number, err)})
+		return
+	}
+	trainings := h.store.getAll()
+	for i := range trainings {
+		if trainings[i].ID == training.ID {
+			trainings[i].Prerequisites = prerequisites
+		}
+	}
+	if t, ok := orderCycle(trainings, h.paths.getAll()); ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("revision %d can't be restored: its prerequisites would leave %q waiting on itself through a learning path", target.Number, t.Title)})
+		return
+	}
+	training.Prerequisites = prerequisites
+	if training.Category, err = h.store.cleanCategory(training.ID, training.Category); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	rev, err := h.publish(&training, req.Email, fmt.Sprintf("Rolled back to revision %d", target.Number))
+	if err != nil {
+		logError("failed to roll back training", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to roll back training"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"training": training,
+		"revision": rev,
 	})
 }
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// A training package is a zip holding manifest.json and the uploaded videos
+// and images the training uses, so a training can be moved between instances.
+// The manifest lists every media file with its size and SHA-256 checksum.
+
+const (
+	packageFormat         = "train-hub-package"
+	packageVersion        = 1
+	packageManifest       = "manifest.json"
+	maxPackageUploadSize  = 1 << 30 // Packages carry videos, so allow up to 1GB
+	maxPackageExtractSize = 2 << 30 // Media is stored uncompressed, but a crafted zip needn't be
+)
+
+// PackageManifest describes the contents of a training package
+type PackageManifest struct {
+	Format     string          `json:"format"`
+	Version    int             `json:"version"`
+	ExportedAt time.Time       `json:"exported_at"`
+	ExportedBy string          `json:"exported_by"`
+	Training   PackageTraining `json:"training"`
+	Files      []PackageFile   `json:"files"`
+}
+
+// PackageTraining is the portable part of a training. IDs, authorship and
+// prerequisites belong to the exporting instance and are left out.
+type PackageTraining struct {
+	Title        string         `json:"title"`
+	Description  string         `json:"description"`
+	ThumbnailURL string         `json:"thumbnail_url,omitempty"`
+	Category     string         `json:"category,omitempty"`
+	Tags         []string       `json:"tags,omitempty"`
+	ValidityDays int            `json:"validity_days,omitempty"`
+	Blocks       []ContentBlock `json:"blocks"`
+}
+
+// PackageFile is a media file in a package
+type PackageFile struct {
+	Path   string `json:"path"` // Path inside the zip
+	URL    string `json:"url"`  // Upload URL the training refers to it by
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// uploadFile resolves an upload URL used by a training to the file on disk
+func (h *TrainingHandlers) uploadFile(url string) (string, bool) {
+	for prefix, dir := range map[string]string{"/uploads/videos/": h.videoUploadPath, "/uploads/images/": h.imageUploadPath, "/uploads/captions/": h.captionUploadPath} {
+		name := strings.TrimPrefix(url, prefix)
+		if name != url && name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\\") {
+			return filepath.Join(dir, name), true
+		}
+	}
+	return "", false
+}
+
+// mediaURLs returns the upload URLs a training refers to, each once
+func mediaURLs(t Training) []string {
+	urls := []string{}
+	seen := map[string]bool{}
+	add := func(url string) {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			urls = append(urls, url)
+		}
+	}
+	add(t.ThumbnailURL)
+	for _, block := range t.Blocks {
+		if block.Type == "video" || block.Type == "image" {
+			url, _ := block.Content["url"].(string)
+			add(url)
+		}
+		for _, track := range captionTrackContents(block) {
+			url, _ := track["url"].(string)
+			add(url)
+		}
+	}
+	return urls
+}
+
+// captionTrackContents returns the caption tracks of a video block as they
+// are stored in its content
+func captionTrackContents(block ContentBlock) []map[string]interface{} {
+	tracks := []map[string]interface{}{}
+	if block.Type != "video" {
+		return tracks
+	}
+	captions, _ := block.Content["captions"].([]interface{})
+	for _, track := range captions {
+		if content, ok := track.(map[string]interface{}); ok {
+			tracks = append(tracks, content)
+		}
+	}
+	return tracks
+}
+
+// fileChecksum returns the size and SHA-256 of a file
+func fileChecksum(name string) (int64, string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return 0, "", err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writePackage writes a training and its media to w as a zip
+func (h *TrainingHandlers) writePackage(w io.Writer, t Training, exportedBy string) error {
+	manifest := PackageManifest{
+		Format:     packageFormat,
+		Version:    packageVersion,
+		ExportedAt: time.Now(),
+		ExportedBy: exportedBy,
+		Training: PackageTraining{
+			Title:        t.Title,
+			Description:  t.Description,
+			ThumbnailURL: t.ThumbnailURL,
+			Category:     t.Category,
+			Tags:         t.Tags,
+			ValidityDays: t.ValidityDays,
+			Blocks:       t.Blocks,
+		},
+		Files: []PackageFile{},
+	}
+
+	files := map[string]string{} // Zip path to file on disk
+	for _, url := range mediaURLs(t) {
+		name, ok := h.uploadFile(url)
+		if !ok {
+			continue // Not one of ours; the importing instance validates it
+		}
+		size, sum, err := fileChecksum(name)
+		if err != nil {
+			return fmt.Errorf("%s: %v", url, err)
+		}
+		zipPath := "media" + strings.TrimPrefix(url, "/uploads")
+		files[zipPath] = name
+		manifest.Files = append(manifest.Files, PackageFile{Path: zipPath, URL: url, Size: size, SHA256: sum})
+	}
+
+	zw := zip.NewWriter(w)
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	mw, err := zw.CreateHeader(&zip.FileHeader{Name: packageManifest, Method: zip.Deflate, Modified: manifest.ExportedAt})
+	if err != nil {
+		return err
+	}
+	if _, err := mw.Write(data); err != nil {
+		return err
+	}
+
+	for _, file := range manifest.Files {
+		// Media is already compressed, so store it as is
+		fw, err := zw.CreateHeader(&zip.FileHeader{Name: file.Path, Method: zip.Store, Modified: manifest.ExportedAt})
+		if err != nil {
+			return err
+		}
+		f, err := os.Open(files[file.Path])
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// packageUploadPrefix strips the unique prefix added to uploaded file names
+var packageUploadPrefix = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}_`)
+
+// readPackage checks a training package and copies its media into the
+// upload directories under new names. It returns the training with its
+// upload URLs rewritten and block IDs replaced, and the files it saved so
+// they can be removed if the import fails later.
+func (h *TrainingHandlers) readPackage(r io.ReaderAt, size int64) (PackageTraining, []string, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return PackageTraining{}, nil, fmt.Errorf("not a zip file")
+	}
+	entries := map[string]*zip.File{}
+	for _, f := range zr.File {
+		entries[f.Name] = f
+	}
+
+	var manifest PackageManifest
+	mf, ok := entries[packageManifest]
+	if !ok {
+		return PackageTraining{}, nil, fmt.Errorf("package has no %s", packageManifest)
+	}
+	rc, err := mf.Open()
+	if err != nil {
+		return PackageTraining{}, nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	err = json.NewDecoder(io.LimitReader(rc, 10<<20)).Decode(&manifest)
+	rc.Close()
+	if err != nil {
+		return PackageTraining{}, nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if manifest.Format != packageFormat {
+		return PackageTraining{}, nil, fmt.Errorf("not a training package")
+	}
+	if manifest.Version > packageVersion {
+		return PackageTraining{}, nil, fmt.Errorf("package version %d is newer than this server supports", manifest.Version)
+	}
+
+	// Check the total before writing anything; each file is then held to its
+	// declared size as it is extracted
+	var total int64
+	for _, file := range manifest.Files {
+		if file.Size < 0 || file.Size > maxPackageExtractSize-total {
+			return PackageTraining{}, nil, fmt.Errorf("package media is too large (max %d MB)", maxPackageExtractSize>>20)
+		}
+		total += file.Size
+	}
+
+	saved := []string{}
+	urls := map[string]string{} // Old upload URL to new
+	for _, file := range manifest.Files {
+		newURL, name, err := h.extractPackageFile(entries, file)
+		if name != "" {
+			saved = append(saved, name)
+		}
+		if err != nil {
+			return PackageTraining{}, saved, err
+		}
+		urls[file.URL] = newURL
+	}
+
+	t := manifest.Training
+	if newURL, ok := urls[t.ThumbnailURL]; ok {
+		t.ThumbnailURL = newURL
+	}
+	for i := range t.Blocks {
+		t.Blocks[i].ID = uuid.New().String()
+		if url, ok := t.Blocks[i].Content["url"].(string); ok {
+			if newURL, ok := urls[url]; ok {
+				t.Blocks[i].Content["url"] = newURL
+			}
+		}
+		for _, track := range captionTrackContents(t.Blocks[i]) {
+			if url, ok := track["url"].(string); ok {
+				if newURL, ok := urls[url]; ok {
+					track["url"] = newURL
+				}
+			}
+		}
+	}
+	return t, saved, nil
+}
+
+// extractPackageFile verifies one media file from a package and saves it as
+// a new upload, returning its URL and where it was written
+func (h *TrainingHandlers) extractPackageFile(entries map[string]*zip.File, file PackageFile) (string, string, error) {
+	entry, ok := entries[file.Path]
+	if !ok {
+		return "", "", fmt.Errorf("%s is listed in the manifest but missing", file.Path)
+	}
+	if entry.UncompressedSize64 != uint64(file.Size) {
+		return "", "", fmt.Errorf("%s is not the size the manifest lists", file.Path)
+	}
+
+	var dir, kind, uploadKind string
+	switch {
+	case strings.HasPrefix(file.URL, "/uploads/videos/"):
+		dir, kind, uploadKind = h.videoUploadPath, "videos", uploadKindVideo
+	case strings.HasPrefix(file.URL, "/uploads/images/"):
+		dir, kind, uploadKind = h.imageUploadPath, "images", uploadKindImage
+	case strings.HasPrefix(file.URL, "/uploads/captions/"):
+		dir, kind, uploadKind = h.captionUploadPath, "captions", uploadKindCaption
+	default:
+		return "", "", fmt.Errorf("%s is not an uploaded video, image or caption file", file.URL)
+	}
+	original := packageUploadPrefix.ReplaceAllString(path.Base(file.URL), "")
+	// Written under a temporary name until its type is known
+	name := filepath.Join(dir, uuid.New().String()+".part")
+
+	rc, err := entry.Open()
+	if err != nil {
+		return "", "", fmt.Errorf("%s: %v", file.Path, err)
+	}
+	defer rc.Close()
+	dst, err := os.Create(name)
+	if err != nil {
+		return "", "", err
+	}
+	defer dst.Close()
+
+	// Read one byte past the declared size so oversized entries are caught
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hash), io.LimitReader(rc, file.Size+1))
+	if err != nil {
+		return "", name, fmt.Errorf("%s: %v", file.Path, err)
+	}
+	if written != file.Size || hex.EncodeToString(hash.Sum(nil)) != strings.ToLower(file.SHA256) {
+		return "", name, fmt.Errorf("%s failed checksum verification", file.Path)
+	}
+	if uploadKind == uploadKindCaption {
+		// Captions are text, so they are checked by parsing rather than sniffing
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return "", name, err
+		}
+		cues, err := parseCaptions(data)
+		if err != nil {
+			return "", name, fmt.Errorf("%s: %v", file.Path, err)
+		}
+		saved := filepath.Join(dir, uploadFilename(original, fileTypeVTT))
+		if err := os.WriteFile(saved, formatWebVTT(cues), 0644); err != nil {
+			return "", name, err
+		}
+		os.Remove(name)
+		return "/uploads/" + kind + "/" + filepath.Base(saved), saved, nil
+	}
+	fileType, err := detectUpload(dst, written, uploadKind, "", original)
+	if err != nil {
+		return "", name, fmt.Errorf("%s: %v", file.Path, err)
+	}
+	saved := filepath.Join(dir, uploadFilename(original, fileType))
+	if err := os.Rename(name, saved); err != nil {
+		return "", name, err
+	}
+	if uploadKind == uploadKindImage {
+		processed, _, err := processImage(saved, fileType)
+		if err != nil {
+			return "", saved, fmt.Errorf("%s: %v", file.Path, err)
+		}
+		saved = processed
+	}
+	return "/uploads/" + kind + "/" + filepath.Base(saved), saved, nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// TrainingRevision is an immutable snapshot of a training taken when it was published
+type TrainingRevision struct {
+	TrainingID    string         `json:"training_id"`
+	Number        int            `json:"number"`
+	Title         string         `json:"title"`
+	Description   string         `json:"description"`
+	ThumbnailURL  string         `json:"thumbnail_url,omitempty"`
+	Blocks        []ContentBlock `json:"blocks,omitempty"`
+	Settings      bool           `json:"settings,omitempty"` // Whether the settings below were recorded; older revisions leave the training's in place
+	ValidityDays  int            `json:"validity_days,omitempty"`
+	Prerequisites []string       `json:"prerequisites,omitempty"`
+	Category      string         `json:"category,omitempty"`
+	Tags          []string       `json:"tags,omitempty"`
+	Note          string         `json:"note,omitempty"`
+	PublishedBy   string         `json:"published_by"`
+	PublishedAt   time.Time      `json:"published_at"`
+}
+
+// newRevision snapshots the working copy of a training as revision number
+func newRevision(t Training, number int, by, note string, at time.Time) TrainingRevision {
+	blocks := make([]ContentBlock, len(t.Blocks))
+	copy(blocks, t.Blocks)
+	return TrainingRevision{
+		TrainingID:    t.ID,
+		Number:        number,
+		Title:         t.Title,
+		Description:   t.Description,
+		ThumbnailURL:  t.ThumbnailURL,
+		Blocks:        blocks,
+		Settings:      true,
+		ValidityDays:  t.ValidityDays,
+		Prerequisites: slices.Clone(t.Prerequisites),
+		Category:      t.Category,
+		Tags:          slices.Clone(t.Tags),
+		Note:          note,
+		PublishedBy:   by,
+		PublishedAt:   at,
+	}
+}
+
+// apply returns a copy of t showing this revision's content
+func (rev TrainingRevision) apply(t Training) Training {
+	t.Title = rev.Title
+	t.Description = rev.Description
+	t.ThumbnailURL = rev.ThumbnailURL
+	t.Blocks = rev.Blocks
+	if rev.Settings {
+		t.ValidityDays = rev.ValidityDays
+		t.Prerequisites = rev.Prerequisites
+		t.Category = rev.Category
+		t.Tags = rev.Tags
+	}
+	t.HasUnpublishedChanges = false
+	return t
+}
+
+// RevisionStore manages published training revisions, keyed by training ID
+type RevisionStore struct {
+	mu        sync.Mutex
+	Revisions map[string][]TrainingRevision `json:"revisions"`
+	file      string
+}
+
+// NewRevisionStore creates a new revision store
+func NewRevisionStore(path string) *RevisionStore {
+	s := &RevisionStore{Revisions: map[string][]TrainingRevision{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *RevisionStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var revisions map[string][]TrainingRevision
+	if err := readJSONFile(s.file, &revisions); err != nil {
+		logError("failed to load training revisions", err)
+		return
+	}
+	if revisions != nil {
+		s.Revisions = revisions
+	}
+}
+
+func (s *RevisionStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Revisions)
+}
+
+// get returns a single revision of a training
+func (s *RevisionStore) get(trainingID string, number int) (TrainingRevision, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, rev := range s.Revisions[trainingID] {
+		if rev.Number == number {
+			return rev, true
+		}
+	}
+	return TrainingRevision{}, false
+}
+
+// list returns every revision of a training, oldest first
+func (s *RevisionStore) list(trainingID string) []TrainingRevision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	revisions := make([]TrainingRevision, len(s.Revisions[trainingID]))
+	copy(revisions, s.Revisions[trainingID])
+	return revisions
+}
+
+// add appends a revision; revisions are never modified once added
+func (s *RevisionStore) add(rev TrainingRevision) error {
+	s.mu.Lock()
+	s.Revisions[rev.TrainingID] = append(s.Revisions[rev.TrainingID], rev)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// deleteAll removes every revision of a training
+func (s *RevisionStore) deleteAll(trainingID string) error {
+	s.mu.Lock()
+	delete(s.Revisions, trainingID)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// published returns the content learners see for a training, if it has any
+func (s *RevisionStore) published(t Training) (Training, bool) {
+	if !t.isPublished() {
+		return Training{}, false
+	}
+	rev, ok := s.get(t.ID, t.Revision)
+	if !ok {
+		logError(fmt.Sprintf("training %s is missing published revision %d", t.ID, t.Revision), nil)
+		return Training{}, false
+	}
+	return rev.apply(t), true
+}
+
+// migrateUnrevisioned gives trainings saved before revisions existed, which
+// were live as stored, a first revision of their current content and marks
+// them published, so later edits go through drafts like any other training
+func migrateUnrevisioned(trainings *TrainingStore, revisions *RevisionStore) error {
+	for _, t := range trainings.getAllIncludingDeleted() {
+		if !t.isPublished() || t.Revision != 0 {
+			continue
+		}
+		number := 1
+		if existing := revisions.list(t.ID); len(existing) > 0 {
+			number = existing[len(existing)-1].Number + 1
+		}
+		at := t.UpdatedAt
+		if t.PublishedAt != nil {
+			at = *t.PublishedAt
+		}
+		if err := revisions.add(newRevision(t, number, t.CreatedBy, "Published before revisions existed", at)); err != nil {
+			return err
+		}
+		t.Status = trainingPublished
+		t.Revision = number
+		t.HasUnpublishedChanges = false
+		t.PublishedAt = &at
+		if err := trainings.put(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
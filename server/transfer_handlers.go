@@ -0,0 +1,469 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// TransferHandlers contains all transfer-related HTTP handlers
+type TransferHandlers struct {
+	store        *TransferStore
+	users        *UserStore
+	teams        *TeamStore
+	reservations *ReservationStore
+	maintenance  *MaintenanceStore
+	mu           sync.Mutex // Serializes responses so a transfer can't be applied twice
+}
+
+// NewTransferHandlers creates a new TransferHandlers instance
+func NewTransferHandlers(store *TransferStore, users *UserStore, teams *TeamStore, reservations *ReservationStore, maintenance *MaintenanceStore) *TransferHandlers {
+	return &TransferHandlers{store: store, users: users, teams: teams, reservations: reservations, maintenance: maintenance}
+}
+
+// HandleGetTransfers handles listing a user's incoming and outgoing transfers
+func (h *TransferHandlers) HandleGetTransfers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if email == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "email required"})
+		return
+	}
+
+	incoming, outgoing := h.store.getForUser(email)
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"incoming": incoming,
+		"outgoing": outgoing,
+	})
+}
+
+// HandleCreateTransfer handles offering stock of an item to another user or
+// to a team. A team lead can also send stock from their team's inventory
+// with from_team. Transfers to a team are accepted by its lead. Serialized
+// units picked with serial_numbers go with the stock, along with their
+// maintenance plans.
+func (h *TransferHandlers) HandleCreateTransfer(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		Email    string   `json:"email"`
+		ItemID   string   `json:"item_id"`
+		FromTeam string   `json:"from_team,omitempty"`
+		ToEmail  string   `json:"to_email,omitempty"`
+		ToTeam   string   `json:"to_team,omitempty"`
+		Quantity float64  `json:"quantity"`
+		Serials  []string `json:"serial_numbers,omitempty"`
+		Note     string   `json:"note,omitempty"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	from := strings.ToLower(strings.TrimSpace(req.Email))
+	to := strings.ToLower(strings.TrimSpace(req.ToEmail))
+	if to == "" && req.ToTeam == "" {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "to_email or to_team required"})
+		return
+	}
+	if req.FromTeam == "" && req.ToTeam == "" && from == to {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "cannot transfer to your own inventory"})
+		return
+	}
+	if req.FromTeam != "" && req.FromTeam == req.ToTeam {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "cannot transfer to the same team"})
+		return
+	}
+
+	u, ok := h.users.get(from)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "user not found"})
+		return
+	}
+	inventory := u.Inventory
+	if req.FromTeam != "" {
+		team, ok := h.teams.get(req.FromTeam)
+		if !ok {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "team not found"})
+			return
+		}
+		if team.Lead != from {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "only the team lead can transfer the team's stock"})
+			return
+		}
+		inventory = team.Inventory
+	}
+	if req.ToTeam != "" {
+		team, ok := h.teams.get(req.ToTeam)
+		if !ok {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "recipient team not found"})
+			return
+		}
+		to = team.Lead
+	} else if _, ok := h.users.get(to); !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "recipient not found"})
+		return
+	}
+	item, ok := findInventoryItem(inventory, req.ItemID)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "item not found"})
+		return
+	}
+	if err := validateQuantity(*item, req.Quantity); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+	now := time.Now()
+	if available := h.transferable(from, req.FromTeam, *item, now); req.Quantity > available {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": fmt.Sprintf("only %s available to transfer", formatQuantity(available, itemUnit(*item).Code))})
+		return
+	}
+	var serials []string
+	for _, serial := range req.Serials {
+		if serial = strings.TrimSpace(serial); serial != "" && !slices.Contains(serials, serial) {
+			serials = append(serials, serial)
+		}
+	}
+	if err := h.checkUnits(from, req.FromTeam, req.ToTeam, *item, serials, req.Quantity); err != nil {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+		return
+	}
+
+	transfer := Transfer{
+		ID:              uuid.New().String(),
+		FromOwner:       from,
+		FromItemID:      item.ID,
+		FromTeam:        req.FromTeam,
+		ToOwner:         to,
+		ToTeam:          req.ToTeam,
+		Quantity:        req.Quantity,
+		SerialNumbers:   serials,
+		ItemDescription: item.Description,
+		UPC:             item.UPC,
+		Unit:            itemUnit(*item).Code,
+		Note:            strings.TrimSpace(req.Note),
+		Status:          transferPending,
+		CreatedAt:       now,
+	}
+
+	if err := h.store.put(transfer); err != nil {
+		logError("failed to save transfer", err)
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to create transfer"})
+		return
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"transfer": transfer,
+	})
+}
+
+// HandleAcceptTransfer handles the recipient accepting a transfer. Stock
+// leaves the sender's item and arrives in the recipient's inventory, and the
+// transfer is marked accepted, all or not at all, with a linked history
+// entry on each side.
+func (h *TransferHandlers) HandleAcceptTransfer(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, r, transferAccepted)
+}
+
+// HandleRejectTransfer handles the recipient declining a transfer
+func (h *TransferHandlers) HandleRejectTransfer(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, r, transferRejected)
+}
+
+// HandleCancelTransfer handles the sender withdrawing a pending transfer
+func (h *TransferHandlers) HandleCancelTransfer(w http.ResponseWriter, r *http.Request) {
+	h.respond(w, r, transferCancelled)
+}
+
+// respond moves a pending transfer to its final status
+func (h *TransferHandlers) respond(w http.ResponseWriter, r *http.Request, status string) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		ID    string `json:"id"`
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	transfer, ok := h.store.get(req.ID)
+	if !ok {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "transfer not found"})
+		return
+	}
+
+	// Recipients accept or reject; senders cancel
+	party := transfer.ToOwner
+	if status == transferCancelled {
+		party = transfer.FromOwner
+	}
+	if email != party {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "transfer not found"})
+		return
+	}
+	if transfer.Status != transferPending {
+		respondJSON(w, map[string]interface{}{"ok": false, "error": "transfer is already " + transfer.Status})
+		return
+	}
+
+	now := time.Now()
+	if status == transferAccepted {
+		accepted, err := h.applyTransfer(transfer, now)
+		if err != nil {
+			respondJSON(w, map[string]interface{}{"ok": false, "error": err.Error()})
+			return
+		}
+		transfer = accepted
+	} else {
+		transfer.Status = status
+		transfer.RespondedAt = &now
+		if err := h.store.put(transfer); err != nil {
+			logError("failed to update transfer", err)
+			respondJSON(w, map[string]interface{}{"ok": false, "error": "failed to update transfer"})
+			return
+		}
+	}
+
+	respondJSON(w, map[string]interface{}{
+		"ok":       true,
+		"transfer": transfer,
+	})
+}
+
+// applyTransfer moves the transferred quantity between the two inventories
+// and saves the transfer as accepted. Each side is a user's inventory or a
+// team's; users, teams and transfers are kept in separate files, so the
+// transfer is saved inside the teams' transact, which runs inside the users',
+// and whatever was saved is put back if a later save fails.
+func (h *TransferHandlers) applyTransfer(t Transfer, now time.Time) (Transfer, error) {
+	var emails, teamIDs []string
+	if t.FromTeam != "" {
+		teamIDs = append(teamIDs, t.FromTeam)
+	} else {
+		emails = append(emails, t.FromOwner)
+	}
+	if t.ToTeam != "" {
+		teamIDs = append(teamIDs, t.ToTeam)
+	} else {
+		emails = append(emails, t.ToOwner)
+	}
+
+	accepted := t
+	accepted.Status = transferAccepted
+	accepted.RespondedAt = &now
+	var undo []func() error
+	err := h.users.transact(emails, func(users []*User) error {
+		var before [][]InventoryItem
+		err := h.teams.transact(teamIDs, func(teams []*Team) error {
+			for _, team := range teams {
+				before = append(before, cloneItems(team.Inventory))
+			}
+
+			// inventory finds a side's inventory and when it was last changed
+			inventory := func(teamID, email string) (*[]InventoryItem, *time.Time) {
+				for _, team := range teams {
+					if teamID != "" && team.ID == teamID {
+						return &team.Inventory, &team.UpdatedAt
+					}
+				}
+				for _, u := range users {
+					if teamID == "" && u.Email == email {
+						return &u.Inventory, &u.UpdatedAt
+					}
+				}
+				return nil, nil
+			}
+			srcItems, srcUpdated := inventory(t.FromTeam, t.FromOwner)
+			dstItems, dstUpdated := inventory(t.ToTeam, t.ToOwner)
+
+			src, ok := findInventoryItem(*srcItems, t.FromItemID)
+			if !ok {
+				return fmt.Errorf("the sender no longer has this item")
+			}
+			if available := h.transferable(t.FromOwner, t.FromTeam, *src, now); t.Quantity > available {
+				return fmt.Errorf("only %s left to transfer", formatQuantity(available, itemUnit(*src).Code))
+			}
+			if err := h.checkUnits(t.FromOwner, t.FromTeam, t.ToTeam, *src, t.SerialNumbers, t.Quantity); err != nil {
+				return err
+			}
+
+			dst := matchingItem(*dstItems, *src)
+			if dst == nil {
+				*dstItems = append(*dstItems, InventoryItem{
+					ID:          uuid.New().String(),
+					Description: src.Description,
+					UPC:         src.UPC,
+					Number:      src.Number,
+					Unit:        src.Unit,
+					PackSizes:   src.PackSizes,
+					CreatedAt:   now,
+				})
+				dst = &(*dstItems)[len(*dstItems)-1]
+			}
+
+			src.History = append(src.History, HistoryEntry{
+				Timestamp:       now,
+				Action:          actionTransferOut,
+				Field:           "quantity",
+				OldValue:        formatQuantity(src.Quantity, ""),
+				NewValue:        formatQuantity(roundQuantity(src.Quantity-t.Quantity), ""),
+				ItemDescription: src.Description,
+				User:            t.ToOwner,
+				Note:            t.Note,
+				Unit:            itemUnit(*src).Code,
+				Reference:       t.ID,
+			})
+			dst.History = append(dst.History, HistoryEntry{
+				Timestamp:       now,
+				Action:          actionTransferIn,
+				Field:           "quantity",
+				OldValue:        formatQuantity(dst.Quantity, ""),
+				NewValue:        formatQuantity(roundQuantity(dst.Quantity+t.Quantity), ""),
+				ItemDescription: dst.Description,
+				User:            t.FromOwner,
+				Note:            t.Note,
+				Unit:            itemUnit(*dst).Code,
+				Reference:       t.ID,
+			})
+
+			for _, serial := range t.SerialNumbers {
+				if _, ok := findUnit(dst, serial); ok {
+					return fmt.Errorf("the recipient already has unit %s", serial)
+				}
+				src.Units = slices.DeleteFunc(src.Units, func(u SerializedUnit) bool { return u.SerialNumber == serial })
+				dst.Units = append(dst.Units, SerializedUnit{SerialNumber: serial})
+			}
+
+			src.Quantity = roundQuantity(src.Quantity - t.Quantity)
+			dst.Quantity = roundQuantity(dst.Quantity + t.Quantity)
+			src.UpdatedAt = now
+			dst.UpdatedAt = now
+			*srcUpdated = now
+			*dstUpdated = now
+			accepted.ToItemID = dst.ID
+
+			if err := h.store.put(accepted); err != nil {
+				return err
+			}
+			undo = append(undo, func() error { return h.store.put(t) })
+
+			// The units' maintenance plans follow them to the recipient
+			plans := h.maintenance.getUnitPlans(t.FromOwner, src.ID, t.SerialNumbers)
+			if len(plans) > 0 {
+				moved := slices.Clone(plans)
+				for i := range moved {
+					moved[i].Owner = t.ToOwner
+					moved[i].ItemID = dst.ID
+					moved[i].UpdatedAt = now
+				}
+				if err := h.maintenance.putPlans(moved); err != nil {
+					return err
+				}
+				undo = append(undo, func() error { return h.maintenance.putPlans(plans) })
+			}
+			return nil
+		})
+		if err == nil && len(teamIDs) > 0 {
+			undo = append(undo, func() error {
+				return h.teams.transact(teamIDs, func(teams []*Team) error {
+					for i, team := range teams {
+						team.Inventory = before[i]
+					}
+					return nil
+				})
+			})
+		}
+		return err
+	})
+	if err != nil {
+		for _, fn := range undo {
+			if err := fn(); err != nil {
+				logError("failed to roll back transfer", err)
+			}
+		}
+		logError("failed to apply transfer", err)
+		return Transfer{}, err
+	}
+	return accepted, nil
+}
+
+// transferable returns how much of an item its owner can send. Stock held
+// by a user's active reservations stays behind; team inventories can't be
+// reserved against.
+func (h *TransferHandlers) transferable(owner, team string, item InventoryItem, now time.Time) float64 {
+	available := availableForCheckout(item)
+	if team == "" {
+		available = roundQuantity(available - h.reservations.reservedFrom(owner, item.ID, now))
+	}
+	return max(available, 0)
+}
+
+// checkUnits checks the serialized units picked to go with a transfer. They
+// must be available, and enough must be picked that the units left behind
+// still fit in the quantity that stays.
+func (h *TransferHandlers) checkUnits(owner, fromTeam, toTeam string, item InventoryItem, serials []string, qty float64) error {
+	for _, serial := range serials {
+		unit, ok := findUnit(&item, serial)
+		if !ok {
+			return fmt.Errorf("unit %s not found", serial)
+		}
+		if unit.Status == statusInMaintenance {
+			return fmt.Errorf("unit %s is in maintenance", serial)
+		}
+	}
+	if float64(len(serials)) > qty {
+		return fmt.Errorf("more units picked than the quantity being sent")
+	}
+	if float64(len(item.Units)-len(serials)) > roundQuantity(item.Quantity-qty) {
+		return fmt.Errorf("pick the serial numbers of the units being sent")
+	}
+	// Maintenance plans belong to a person's inventory
+	if fromTeam == "" && toTeam != "" && len(h.maintenance.getUnitPlans(owner, item.ID, serials)) > 0 {
+		return fmt.Errorf("units with maintenance plans can only be sent to a person")
+	}
+	return nil
+}
+
+// matchingItem finds the item in an inventory that stock of src should be
+// added to: one with the same UPC, or the same number if src has no UPC, and
+// the same unit. Items with neither are never matched, since nothing says
+// they are the same thing.
+func matchingItem(items []InventoryItem, src InventoryItem) *InventoryItem {
+	for i := range items {
+		candidate := &items[i]
+		if itemUnit(*candidate).Code != itemUnit(src).Code {
+			continue
+		}
+		if src.UPC != "" && candidate.UPC == src.UPC {
+			return candidate
+		}
+		if src.UPC == "" && src.Number != "" && candidate.UPC == "" && candidate.Number == src.Number {
+			return candidate
+		}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Transfer moves stock of an item from one inventory to another. It stays
+// pending until the recipient accepts it; quantities only move on accept.
+type Transfer struct {
+	ID              string     `json:"id"`
+	FromOwner       string     `json:"from_owner"`
+	FromItemID      string     `json:"from_item_id"`
+	ToOwner         string     `json:"to_owner"`
+	ToItemID        string     `json:"to_item_id,omitempty"` // Set once accepted
+	FromTeam        string     `json:"from_team,omitempty"`  // Set when the stock leaves a team's inventory; FromOwner is its lead
+	ToTeam          string     `json:"to_team,omitempty"`    // Set when the stock goes to a team's inventory; ToOwner is its lead
+	Quantity        float64    `json:"quantity"`
+	SerialNumbers   []string   `json:"serial_numbers,omitempty"` // Serialized units sent with the stock
+	ItemDescription string     `json:"item_description"`
+	UPC             string     `json:"upc"`
+	Unit            string     `json:"unit,omitempty"`
+	Note            string     `json:"note,omitempty"`
+	Status          string     `json:"status"` // pending, accepted, rejected, cancelled
+	CreatedAt       time.Time  `json:"created_at"`
+	RespondedAt     *time.Time `json:"responded_at,omitempty"`
+}
+
+// Transfer statuses
+const (
+	transferPending   = "pending"
+	transferAccepted  = "accepted"
+	transferRejected  = "rejected"
+	transferCancelled = "cancelled"
+)
+
+// TransferStore manages inventory transfers
+type TransferStore struct {
+	mu        sync.Mutex
+	Transfers map[string]Transfer `json:"transfers"`
+	file      string
+}
+
+// NewTransferStore creates a new transfer store
+func NewTransferStore(path string) *TransferStore {
+	s := &TransferStore{Transfers: map[string]Transfer{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *TransferStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var transfers map[string]Transfer
+	if err := readJSONFile(s.file, &transfers); err != nil {
+		logError("failed to load transfers", err)
+		return
+	}
+	if transfers != nil {
+		s.Transfers = transfers
+	}
+}
+
+func (s *TransferStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Transfers)
+}
+
+func (s *TransferStore) get(id string) (Transfer, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.Transfers[id]
+	return t, ok
+}
+
+func (s *TransferStore) put(t Transfer) error {
+	s.mu.Lock()
+	s.Transfers[t.ID] = t
+	s.mu.Unlock()
+	return s.save()
+}
+
+// getForUser returns transfers sent by and to a user, newest first
+func (s *TransferStore) getForUser(email string) (incoming, outgoing []Transfer) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	incoming, outgoing = make([]Transfer, 0), make([]Transfer, 0)
+	for _, t := range s.Transfers {
+		if t.ToOwner == email {
+			incoming = append(incoming, t)
+		}
+		if t.FromOwner == email {
+			outgoing = append(outgoing, t)
+		}
+	}
+	newestFirst := func(list []Transfer) {
+		sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.After(list[j].CreatedAt) })
+	}
+	newestFirst(incoming)
+	newestFirst(outgoing)
+	return incoming, outgoing
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// UnitOfMeasure is a base unit an item's quantity can be counted in
+type UnitOfMeasure struct {
+	Code     string `json:"code"`
+	Name     string `json:"name"`
+	Discrete bool   `json:"discrete"` // Discrete units only allow whole quantities
+}
+
+// PackSize is a pack an item is received or used in, e.g. a box of 100
+type PackSize struct {
+	Name     string  `json:"name"`     // e.g. "box", "spool"
+	Quantity float64 `json:"quantity"` // Base units per pack
+}
+
+// defaultUnit is the unit assumed for items without one
+const defaultUnit = "ea"
+
+// unitsOfMeasure lists the base units items can use
+var unitsOfMeasure = map[string]UnitOfMeasure{
+	"ea":   {Code: "ea", Name: "each", Discrete: true},
+	"pair": {Code: "pair", Name: "pair", Discrete: true},
+	"ft":   {Code: "ft", Name: "feet"},
+	"in":   {Code: "in", Name: "inches"},
+	"m":    {Code: "m", Name: "meters"},
+	"lb":   {Code: "lb", Name: "pounds"},
+	"oz":   {Code: "oz", Name: "ounces"},
+	"kg":   {Code: "kg", Name: "kilograms"},
+	"gal":  {Code: "gal", Name: "gallons"},
+	"l":    {Code: "l", Name: "liters"},
+	"sqft": {Code: "sqft", Name: "square feet"},
+}
+
+// sortedUnits returns the unit catalog ordered by code
+func sortedUnits() []UnitOfMeasure {
+	units := make([]UnitOfMeasure, 0, len(unitsOfMeasure))
+	for _, u := range unitsOfMeasure {
+		units = append(units, u)
+	}
+	sort.Slice(units, func(i, j int) bool { return units[i].Code < units[j].Code })
+	return units
+}
+
+// itemUnit returns the base unit of an item, falling back to "each"
+func itemUnit(item InventoryItem) UnitOfMeasure {
+	if u, ok := unitsOfMeasure[item.Unit]; ok {
+		return u
+	}
+	return unitsOfMeasure[defaultUnit]
+}
+
+// roundQuantity trims floating point noise from quantity arithmetic
+func roundQuantity(q float64) float64 {
+	return math.Round(q*1e6) / 1e6
+}
+
+// formatQuantity renders a quantity without trailing zeros, with an optional unit
+func formatQuantity(q float64, unit string) string {
+	s := strconv.FormatFloat(roundQuantity(q), 'f', -1, 64)
+	if unit != "" {
+		s += " " + unit
+	}
+	return s
+}
+
+// validateQuantity checks that a base-unit quantity is positive and whole
+// when the item's unit is discrete
+func validateQuantity(item InventoryItem, qty float64) error {
+	if qty <= 0 || math.IsNaN(qty) || math.IsInf(qty, 0) {
+		return fmt.Errorf("quantity must be greater than 0")
+	}
+	if unit := itemUnit(item); unit.Discrete && qty != math.Trunc(qty) {
+		return fmt.Errorf("quantity must be a whole number of %s", unit.Name)
+	}
+	return nil
+}
+
+// validateStockLevels checks that an item's quantity and target quantity can
+// be counted in its unit
+func validateStockLevels(item InventoryItem) error {
+	for _, q := range []struct {
+		field string
+		qty   float64
+	}{{"quantity", item.Quantity}, {"target quantity", item.TargetQuantity}} {
+		if q.qty == 0 {
+			continue
+		}
+		if err := validateQuantity(item, math.Abs(q.qty)); err != nil {
+			return fmt.Errorf("the %s of %s can't be counted in %s", q.field, formatQuantity(q.qty, ""), itemUnit(item).Name)
+		}
+	}
+	return nil
+}
+
+// toBaseQuantity converts a quantity entered in unit (the item's base unit or
+// one of its pack names) into the item's base unit
+func toBaseQuantity(item InventoryItem, qty float64, unit string) (float64, error) {
+	unit = strings.TrimSpace(unit)
+	if unit == "" || unit == itemUnit(item).Code {
+		return qty, nil
+	}
+	for _, pack := range item.PackSizes {
+		if strings.EqualFold(pack.Name, unit) {
+			return roundQuantity(qty * pack.Quantity), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown unit %q for this item", unit)
+}
+
+// validatePackSizes checks pack definitions for an item with the given base unit
+func validatePackSizes(packs []PackSize, base UnitOfMeasure) error {
+	seen := map[string]bool{}
+	for _, pack := range packs {
+		name := strings.ToLower(strings.TrimSpace(pack.Name))
+		if name == "" {
+			return fmt.Errorf("pack name is required")
+		}
+		if _, ok := unitsOfMeasure[name]; ok {
+			return fmt.Errorf("pack name %q clashes with a unit of measure", pack.Name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate pack %q", pack.Name)
+		}
+		seen[name] = true
+		if pack.Quantity <= 0 {
+			return fmt.Errorf("pack %q must hold more than 0 %s", pack.Name, base.Name)
+		}
+		if base.Discrete && pack.Quantity != math.Trunc(pack.Quantity) {
+			return fmt.Errorf("pack %q must hold a whole number of %s", pack.Name, base.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadHandlers contains the resumable upload handlers
+type UploadHandlers struct {
+	mu              sync.Mutex
+	busy            map[string]bool // Uploads a PATCH is writing to
+	store           *UploadStore
+	users           *UserStore
+	media           *MediaStore
+	partialPath     string
+	videoUploadPath string
+	maxSize         int64
+}
+
+// NewUploadHandlers creates a new UploadHandlers instance
+func NewUploadHandlers(store *UploadStore, users *UserStore, media *MediaStore, partialPath, videoUploadPath string) *UploadHandlers {
+	os.MkdirAll(partialPath, 0755)
+	return &UploadHandlers{
+		busy:            map[string]bool{},
+		store:           store,
+		users:           users,
+		media:           media,
+		partialPath:     partialPath,
+		videoUploadPath: videoUploadPath,
+		maxSize:         maxVideoUploadSize(),
+	}
+}
+
+// middleware handles CORS for the methods and headers tus clients use,
+// answers OPTIONS with the server's capabilities, and rejects requests for
+// other protocol versions
+func (h *UploadHandlers) middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, HEAD, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Tus-Resumable, Upload-Length, Upload-Metadata, Upload-Offset, Upload-Checksum")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Tus-Resumable, Tus-Version, Tus-Extension, Tus-Max-Size, Tus-Checksum-Algorithm, Upload-Length, Upload-Offset, Upload-Expires")
+		w.Header().Set("Access-Control-Max-Age", "3600")
+		w.Header().Set("Tus-Resumable", tusVersion)
+
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Tus-Version", tusVersion)
+			w.Header().Set("Tus-Extension", tusExtensions)
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(h.maxSize, 10))
+			w.Header().Set("Tus-Checksum-Algorithm", "sha256,sha1,md5")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if r.Method != http.MethodGet && r.Header.Get("Tus-Resumable") != tusVersion {
+			w.Header().Set("Tus-Version", tusVersion)
+			respondError(w, "unsupported tus version", http.StatusPreconditionFailed)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// HandleCreateUpload handles starting a resumable video upload. The total
+// size goes in Upload-Length; Upload-Metadata carries the filename and
+// filetype, and optionally checksum as "sha256 <hex>" to verify the whole
+// file against once it is complete. The first chunk may be sent along.
+func (h *UploadHandlers) HandleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("email")))
+	if _, ok := h.users.get(email); !ok {
+		respondError(w, "user not found", http.StatusForbidden)
+		return
+	}
+	h.removeExpired()
+
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		respondError(w, "Upload-Length must be given", http.StatusBadRequest)
+		return
+	}
+	if length > h.maxSize {
+		respondError(w, fmt.Sprintf("video is larger than %dMB", h.maxSize>>20), http.StatusRequestEntityTooLarge)
+		return
+	}
+	metadata, err := parseUploadMetadata(r.Header.Get("Upload-Metadata"))
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !strings.HasPrefix(metadata["filetype"], "video/") {
+		respondError(w, "file must be a video", http.StatusUnsupportedMediaType)
+		return
+	}
+	checksum := ""
+	if v := metadata["checksum"]; v != "" {
+		algorithm, sum, _ := strings.Cut(v, " ")
+		sum = strings.ToLower(strings.TrimSpace(sum))
+		if algorithm != "sha256" || len(sum) != 64 || strings.Trim(sum, "0123456789abcdef") != "" {
+			respondError(w, `checksum must be "sha256 <hex>"`, http.StatusBadRequest)
+			return
+		}
+		checksum = sum
+	}
+
+	now := time.Now()
+	upload := ResumableUpload{
+		ID:        uuid.New().String(),
+		Email:     email,
+		Filename:  displayFilename(metadata["filename"]),
+		FileType:  metadata["filetype"],
+		Length:    length,
+		Checksum:  checksum,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	f, err := os.Create(h.partialFile(upload.ID))
+	if err != nil {
+		logError("failed to create upload", err)
+		respondError(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	f.Close()
+	if err := h.store.put(upload); err != nil {
+		logError("failed to save upload", err)
+		os.Remove(h.partialFile(upload.ID))
+		respondError(w, "failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", "/api/uploads/videos/"+upload.ID)
+	if r.Header.Get("Content-Type") == "application/offset+octet-stream" {
+		r.Header.Set("Upload-Offset", "0")
+		h.writeChunk(w, r, upload, http.StatusCreated)
+		return
+	}
+	// An empty video is complete as soon as it is created
+	if length == 0 {
+		var status int
+		var message string
+		if upload, status, message = h.complete(upload); status != 0 {
+			respondError(w, message, status)
+			return
+		}
+	}
+	h.writeOffset(w, upload)
+	w.WriteHeader(http.StatusCreated)
+}
+
+// HandleUpload handles an existing upload: HEAD reports how much has been
+// received, PATCH appends a chunk, DELETE abandons the upload, and GET
+// returns its details, including the video URL once complete
+func (h *UploadHandlers) HandleUpload(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/uploads/videos/")
+	upload, ok := h.store.get(id)
+	if !ok {
+		respondError(w, "upload not found", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+		h.writeOffset(w, upload)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPatch:
+		h.writeChunk(w, r, upload, http.StatusNoContent)
+	case http.MethodDelete:
+		if !h.acquire(upload.ID) {
+			respondError(w, "upload is busy", http.StatusConflict)
+			return
+		}
+		defer h.release(upload.ID)
+		if upload.CompletedAt == nil {
+			os.Remove(h.partialFile(upload.ID))
+		}
+		if err := h.store.remove(upload.ID); err != nil {
+			logError("failed to remove upload", err)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodGet:
+		respondJSON(w, map[string]interface{}{"ok": true, "upload": upload})
+	default:
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// partialFile is where the bytes of an incomplete upload are kept
+func (h *UploadHandlers) partialFile(id string) string {
+	return filepath.Join(h.partialPath, id)
+}
+
+// acquire marks an upload as being written to, or reports false if it
+// already is
+func (h *UploadHandlers) acquire(id string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.busy[id] {
+		return false
+	}
+	h.busy[id] = true
+	return true
+}
+
+func (h *UploadHandlers) release(id string) {
+	h.mu.Lock()
+	delete(h.busy, id)
+	h.mu.Unlock()
+}
+
+// writeOffset sets the headers describing where an upload has got to
+func (h *UploadHandlers) writeOffset(w http.ResponseWriter, upload ResumableUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	if upload.CompletedAt == nil {
+		w.Header().Set("Upload-Expires", upload.expiresAt().UTC().Format(http.TimeFormat))
+	}
+}
+
+// chunkHash returns the hash for a tus Upload-Checksum header and the
+// expected sum
+func chunkHash(header string) (hash.Hash, []byte, error) {
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, nil, fmt.Errorf("Upload-Checksum is not base64 encoded")
+	}
+	switch algorithm {
+	case "sha256":
+		return sha256.New(), sum, nil
+	case "sha1":
+		return sha1.New(), sum, nil
+	case "md5":
+		return md5.New(), sum, nil
+	}
+	return nil, nil, fmt.Errorf("checksum algorithm %s is not supported", algorithm)
+}
+
+// writeChunk appends the request body to an upload at the offset the client
+// gives. If the connection drops part way, the bytes received are kept so
+// the client can resume from them, unless an Upload-Checksum was sent, in
+// which case the whole chunk is discarded.
+func (h *UploadHandlers) writeChunk(w http.ResponseWriter, r *http.Request, upload ResumableUpload, successStatus int) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		respondError(w, "Content-Type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	if !h.acquire(upload.ID) {
+		respondError(w, "upload is busy", http.StatusConflict)
+		return
+	}
+	defer h.release(upload.ID)
+	// Re-read now that no one else is writing
+	upload, ok := h.store.get(upload.ID)
+	if !ok {
+		respondError(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	if upload.CompletedAt != nil {
+		respondError(w, "upload is already complete", http.StatusForbidden)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset != upload.Offset {
+		h.writeOffset(w, upload)
+		respondError(w, fmt.Sprintf("Upload-Offset must be %d", upload.Offset), http.StatusConflict)
+		return
+	}
+	var sum hash.Hash
+	var expected []byte
+	if header := r.Header.Get("Upload-Checksum"); header != "" {
+		if sum, expected, err = chunkHash(header); err != nil {
+			respondError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	f, err := os.OpenFile(h.partialFile(upload.ID), os.O_WRONLY, 0644)
+	if err != nil {
+		logError("failed to open upload", err)
+		respondError(w, "failed to save chunk", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		logError("failed to seek upload", err)
+		respondError(w, "failed to save chunk", http.StatusInternalServerError)
+		return
+	}
+	var dst io.Writer = f
+	if sum != nil {
+		dst = io.MultiWriter(f, sum)
+	}
+	// Read one byte past the remaining length so overlong chunks are caught
+	remaining := upload.Length - offset
+	written, copyErr := io.Copy(dst, io.LimitReader(r.Body, remaining+1))
+	discard := func() {
+		if err := f.Truncate(offset); err != nil {
+			logError("failed to discard chunk", err)
+		}
+	}
+	switch {
+	case written > remaining:
+		discard()
+		respondError(w, "chunk runs past the end of the upload", http.StatusRequestEntityTooLarge)
+		return
+	case copyErr != nil && sum != nil:
+		discard()
+		respondError(w, "chunk was not received in full", http.StatusBadRequest)
+		return
+	case sum != nil && string(sum.Sum(nil)) != string(expected):
+		discard()
+		respondError(w, "chunk failed checksum verification", statusChecksumMismatch)
+		return
+	}
+
+	upload.Offset += written
+	upload.UpdatedAt = time.Now()
+	if copyErr != nil {
+		// The client has gone; keep what arrived for it to resume from
+		if err := h.store.put(upload); err != nil {
+			logError("failed to save upload", err)
+		}
+		return
+	}
+	if upload.Offset == upload.Length {
+		f.Close()
+		var status int
+		var message string
+		if upload, status, message = h.complete(upload); status != 0 {
+			respondError(w, message, status)
+			return
+		}
+	} else if err := h.store.put(upload); err != nil {
+		logError("failed to save upload", err)
+		respondError(w, "failed to save chunk", http.StatusInternalServerError)
+		return
+	}
+
+	h.writeOffset(w, upload)
+	w.WriteHeader(successStatus)
+}
+
+// complete verifies a fully received upload against its checksum and its
+// content against the allowed video types, and moves it in with the other
+// videos. On failure it returns the status and error to report; an upload
+// that fails verification is discarded.
+func (h *UploadHandlers) complete(upload ResumableUpload) (ResumableUpload, int, string) {
+	partial := h.partialFile(upload.ID)
+	if upload.Checksum != "" {
+		_, sum, err := fileChecksum(partial)
+		if err != nil {
+			logError("failed to verify upload", err)
+			return upload, http.StatusInternalServerError, "failed to verify upload"
+		}
+		if sum != upload.Checksum {
+			h.discard(upload)
+			return upload, statusChecksumMismatch, "file failed checksum verification; upload it again"
+		}
+	}
+
+	// Only now is the whole file here to check what it really is
+	f, err := os.Open(partial)
+	if err != nil {
+		logError("failed to verify upload", err)
+		return upload, http.StatusInternalServerError, "failed to verify upload"
+	}
+	fileType, err := detectUpload(f, upload.Length, uploadKindVideo, upload.FileType, upload.Filename)
+	f.Close()
+	if err != nil {
+		h.discard(upload)
+		return upload, http.StatusUnsupportedMediaType, err.Error()
+	}
+
+	filename := uploadFilename(upload.Filename, fileType)
+	saved := filepath.Join(h.videoUploadPath, filename)
+	if err := os.Rename(partial, saved); err != nil {
+		logError("failed to move upload", err)
+		return upload, http.StatusInternalServerError, "failed to save video"
+	}
+	now := time.Now()
+	if err := h.media.register(newMediaItem(uploadKindVideo, saved, upload.Filename, upload.Email, now)); err != nil {
+		logError("failed to register media", err)
+	}
+	upload.URL = "/uploads/videos/" + filename
+	upload.CompletedAt = &now
+	if err := h.store.put(upload); err != nil {
+		logError("failed to save upload", err)
+		return upload, http.StatusInternalServerError, "failed to save video"
+	}
+	return upload, 0, ""
+}
+
+// discard removes an upload that cannot be completed
+func (h *UploadHandlers) discard(upload ResumableUpload) {
+	os.Remove(h.partialFile(upload.ID))
+	if err := h.store.remove(upload.ID); err != nil {
+		logError("failed to remove upload", err)
+	}
+}
+
+// removeExpired discards incomplete uploads that have been abandoned
+func (h *UploadHandlers) removeExpired() {
+	for _, upload := range h.store.expired(time.Now()) {
+		if !h.acquire(upload.ID) {
+			continue
+		}
+		h.discard(upload)
+		h.release(upload.ID)
+	}
+}
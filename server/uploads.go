@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads follow the tus 1.0 protocol (https://tus.io): a client
+// creates an upload with its total length, sends the bytes in any number of
+// PATCH requests carrying their offset, and after a dropped connection asks
+// for the offset with HEAD and carries on from there. Incomplete uploads are
+// kept in a directory that is not served until their last byte arrives.
+
+const (
+	tusVersion              = "1.0.0"
+	tusExtensions           = "creation,creation-with-upload,termination,checksum,expiration"
+	uploadExpiry            = 24 * time.Hour
+	defaultMaxVideoUploadMB = 2048
+	statusChecksumMismatch  = 460 // Defined by the tus checksum extension
+)
+
+// ResumableUpload is an upload in progress or finished
+type ResumableUpload struct {
+	ID          string     `json:"id"`
+	Email       string     `json:"email"`
+	Filename    string     `json:"filename"`
+	FileType    string     `json:"file_type"`
+	Length      int64      `json:"length"`
+	Offset      int64      `json:"offset"`
+	Checksum    string     `json:"checksum,omitempty"` // Expected SHA-256 of the whole file, hex encoded
+	URL         string     `json:"url,omitempty"`      // Set once the upload is complete
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// expiresAt is when an incomplete upload is discarded
+func (u ResumableUpload) expiresAt() time.Time {
+	return u.UpdatedAt.Add(uploadExpiry)
+}
+
+// maxVideoUploadSize is the largest video that may be uploaded, in bytes.
+// It defaults to 2GB and can be set in megabytes with MAX_VIDEO_UPLOAD_MB.
+func maxVideoUploadSize() int64 {
+	mb := int64(defaultMaxVideoUploadMB)
+	if v := os.Getenv("MAX_VIDEO_UPLOAD_MB"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil && n > 0 {
+			mb = n
+		} else {
+			logError(fmt.Sprintf("ignoring invalid MAX_VIDEO_UPLOAD_MB %q", v), nil)
+		}
+	}
+	return mb << 20
+}
+
+// parseUploadMetadata reads a tus Upload-Metadata header: comma separated
+// pairs of a key and an optional base64 encoded value
+func parseUploadMetadata(header string) (map[string]string, error) {
+	metadata := map[string]string{}
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return nil, fmt.Errorf("metadata %s is not base64 encoded", key)
+		}
+		metadata[key] = string(value)
+	}
+	return metadata, nil
+}
+
+// UploadStore keeps track of resumable uploads
+type UploadStore struct {
+	mu      sync.Mutex
+	Uploads map[string]ResumableUpload `json:"uploads"`
+	file    string
+}
+
+// NewUploadStore creates a new upload store
+func NewUploadStore(path string) *UploadStore {
+	s := &UploadStore{Uploads: map[string]ResumableUpload{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *UploadStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var uploads map[string]ResumableUpload
+	if err := readJSONFile(s.file, &uploads); err != nil {
+		logError("failed to load uploads", err)
+		return
+	}
+	if uploads != nil {
+		s.Uploads = uploads
+	}
+}
+
+func (s *UploadStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return writeJSONFile(s.file, s.Uploads)
+}
+
+// get returns an upload by ID
+func (s *UploadStore) get(id string) (ResumableUpload, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.Uploads[id]
+	return u, ok
+}
+
+// put adds or replaces an upload
+func (s *UploadStore) put(u ResumableUpload) error {
+	s.mu.Lock()
+	s.Uploads[u.ID] = u
+	s.mu.Unlock()
+	return s.save()
+}
+
+// remove deletes an upload's record
+func (s *UploadStore) remove(id string) error {
+	s.mu.Lock()
+	delete(s.Uploads, id)
+	s.mu.Unlock()
+	return s.save()
+}
+
+// expired returns the incomplete uploads that have not been touched for
+// longer than uploadExpiry
+func (s *UploadStore) expired(now time.Time) []ResumableUpload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := []ResumableUpload{}
+	for _, u := range s.Uploads {
+		if u.CompletedAt == nil && now.After(u.expiresAt()) {
+			uploads = append(uploads, u)
+		}
+	}
+	return uploads
+}
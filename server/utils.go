@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
+	"time"
 )
 
 // respondJSON sends a JSON response
@@ -30,3 +34,69 @@ func logError(message string, err error) {
 	}
 }
 
+// readJSONFile decodes the JSON file at path into v. A missing file is not an error.
+func readJSONFile(path string, v interface{}) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// writeJSONFile encodes v as indented JSON and writes it to path
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// parseDateTime accepts either an RFC 3339 timestamp or a plain date.
+// A plain date used as an end bound covers the whole day.
+func parseDateTime(value string, isEnd bool) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q (use YYYY-MM-DD or RFC 3339)", value)
+	}
+	if isEnd {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// exportFilename turns a title into a safe download filename with the given extension
+func exportFilename(title, ext string) string {
+	return slugify(title, "training") + ext
+}
+
+// slugify reduces s to lower case letters, digits and single dashes, at most
+// 60 characters long, falling back to fallback if nothing is left
+func slugify(s, fallback string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			sb.WriteRune(r)
+			dash = false
+		} else if !dash && sb.Len() > 0 {
+			sb.WriteByte('-')
+			dash = true
+		}
+	}
+	name := strings.TrimSuffix(sb.String(), "-")
+	if len(name) > 60 {
+		name = strings.TrimSuffix(name[:60], "-")
+	}
+	if name == "" {
+		name = fallback
+	}
+	return name
+}
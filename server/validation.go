@@ -81,8 +81,8 @@ func isValidEmailChar(r rune) bool {
 
 // ValidationError represents a validation error
 type ValidationError struct {
-	Field   string
-	Message string
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func (e *ValidationError) Error() string {
@@ -0,0 +1,708 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Learning activity is recorded as xAPI (Experience API) statements so that
+// standard reporting tools can read it from the LRS endpoint. Statements the
+// server emits and statements posted by clients share one store.
+
+const (
+	xapiVersion          = "1.0.3"
+	maxStatementsPerPage = 100
+	maxStatementsPerPost = 100
+
+	xapiVerbLaunched   = "http://adlnet.gov/expapi/verbs/launched"
+	xapiVerbProgressed = "http://adlnet.gov/expapi/verbs/progressed"
+	xapiVerbCompleted  = "http://adlnet.gov/expapi/verbs/completed"
+	xapiVerbPassed     = "http://adlnet.gov/expapi/verbs/passed"
+	xapiVerbFailed     = "http://adlnet.gov/expapi/verbs/failed"
+	xapiVerbVoided     = "http://adlnet.gov/expapi/verbs/voided"
+
+	xapiActivityCourse     = "http://adlnet.gov/expapi/activities/course"
+	xapiActivityAssessment = "http://adlnet.gov/expapi/activities/assessment"
+	xapiExtensionProgress  = "https://w3id.org/xapi/cmi5/result/extensions/progress"
+)
+
+var (
+	errStatementConflict = errors.New("a different statement with this ID already exists")
+	errStatementRejected = errors.New("statement rejected")
+)
+
+// XAPIStatement is an xAPI statement. Attachments and sub-statements are not
+// supported.
+type XAPIStatement struct {
+	ID        string       `json:"id"`
+	Actor     XAPIAgent    `json:"actor"`
+	Verb      XAPIVerb     `json:"verb"`
+	Object    XAPIObject   `json:"object"`
+	Result    *XAPIResult  `json:"result,omitempty"`
+	Context   *XAPIContext `json:"context,omitempty"`
+	Timestamp *time.Time   `json:"timestamp,omitempty"`
+	Stored    time.Time    `json:"stored"`
+	Authority *XAPIAgent   `json:"authority,omitempty"`
+	Version   string       `json:"version,omitempty"`
+}
+
+// XAPIAgent is an agent or group, identified by exactly one of mbox,
+// mbox_sha1sum, openid or account. Anonymous groups only list members.
+type XAPIAgent struct {
+	ObjectType  string       `json:"objectType,omitempty"`
+	Name        string       `json:"name,omitempty"`
+	Mbox        string       `json:"mbox,omitempty"`
+	MboxSHA1Sum string       `json:"mbox_sha1sum,omitempty"`
+	OpenID      string       `json:"openid,omitempty"`
+	Account     *XAPIAccount `json:"account,omitempty"`
+	Member      []XAPIAgent  `json:"member,omitempty"`
+}
+
+// XAPIAccount identifies an agent by an account on some system
+type XAPIAccount struct {
+	HomePage string `json:"homePage"`
+	Name     string `json:"name"`
+}
+
+// XAPIVerb is the action a statement records
+type XAPIVerb struct {
+	ID      string            `json:"id"`
+	Display map[string]string `json:"display,omitempty"`
+}
+
+// XAPIObject is the target of a statement: an activity, an agent or group
+// (using the agent fields), or a reference to another statement
+type XAPIObject struct {
+	ObjectType  string                 `json:"objectType,omitempty"`
+	ID          string                 `json:"id,omitempty"`
+	Definition  map[string]interface{} `json:"definition,omitempty"` // Kept as sent, including interaction details
+	Name        string                 `json:"name,omitempty"`
+	Mbox        string                 `json:"mbox,omitempty"`
+	MboxSHA1Sum string                 `json:"mbox_sha1sum,omitempty"`
+	OpenID      string                 `json:"openid,omitempty"`
+	Account     *XAPIAccount           `json:"account,omitempty"`
+	Member      []XAPIAgent            `json:"member,omitempty"`
+}
+
+// XAPIResult is the outcome of a statement
+type XAPIResult struct {
+	Score      *XAPIScore             `json:"score,omitempty"`
+	Success    *bool                  `json:"success,omitempty"`
+	Completion *bool                  `json:"completion,omitempty"`
+	Response   string                 `json:"response,omitempty"`
+	Duration   string                 `json:"duration,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// XAPIScore is a score, scaled to between -1 and 1 and optionally raw
+type XAPIScore struct {
+	Scaled *float64 `json:"scaled,omitempty"`
+	Raw    *float64 `json:"raw,omitempty"`
+	Min    *float64 `json:"min,omitempty"`
+	Max    *float64 `json:"max,omitempty"`
+}
+
+// XAPIContext gives the circumstances of a statement
+type XAPIContext struct {
+	Registration      string                 `json:"registration,omitempty"`
+	Instructor        *XAPIAgent             `json:"instructor,omitempty"`
+	Team              *XAPIAgent             `json:"team,omitempty"`
+	ContextActivities *XAPIContextActivities `json:"contextActivities,omitempty"`
+	Revision          string                 `json:"revision,omitempty"`
+	Platform          string                 `json:"platform,omitempty"`
+	Language          string                 `json:"language,omitempty"`
+	Statement         *XAPIObject            `json:"statement,omitempty"`
+	Extensions        map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// XAPIContextActivities lists activities related to a statement
+type XAPIContextActivities struct {
+	Parent   xapiActivityList `json:"parent,omitempty"`
+	Grouping xapiActivityList `json:"grouping,omitempty"`
+	Category xapiActivityList `json:"category,omitempty"`
+	Other    xapiActivityList `json:"other,omitempty"`
+}
+
+// xapiActivityList is a list of context activities; a single activity is
+// accepted too, as older clients send one
+type xapiActivityList []XAPIObject
+
+func (l *xapiActivityList) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		var one XAPIObject
+		if err := json.Unmarshal(data, &one); err != nil {
+			return err
+		}
+		*l = xapiActivityList{one}
+		return nil
+	}
+	var many []XAPIObject
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*l = many
+	return nil
+}
+
+// all returns every context activity
+func (c *XAPIContextActivities) all() []XAPIObject {
+	if c == nil {
+		return nil
+	}
+	activities := []XAPIObject{}
+	for _, list := range []xapiActivityList{c.Parent, c.Grouping, c.Category, c.Other} {
+		activities = append(activities, list...)
+	}
+	return activities
+}
+
+// agent returns the object as an agent, if it is one
+func (o XAPIObject) agent() (XAPIAgent, bool) {
+	if o.ObjectType != "Agent" && o.ObjectType != "Group" {
+		return XAPIAgent{}, false
+	}
+	return XAPIAgent{ObjectType: o.ObjectType, Name: o.Name, Mbox: o.Mbox, MboxSHA1Sum: o.MboxSHA1Sum, OpenID: o.OpenID, Account: o.Account, Member: o.Member}, true
+}
+
+// ifi returns the inverse functional identifier of an agent, or "" if it has
+// none, as for an anonymous group
+func (a XAPIAgent) ifi() string {
+	switch {
+	case a.Mbox != "":
+		return "mbox:" + strings.ToLower(a.Mbox)
+	case a.MboxSHA1Sum != "":
+		return "sha1:" + strings.ToLower(a.MboxSHA1Sum)
+	case a.OpenID != "":
+		return "openid:" + a.OpenID
+	case a.Account != nil:
+		return "account:" + a.Account.HomePage + "|" + a.Account.Name
+	}
+	return ""
+}
+
+// validate checks an agent or group has the identification xAPI requires
+func (a XAPIAgent) validate(field string) error {
+	ifis := 0
+	for _, set := range []bool{a.Mbox != "", a.MboxSHA1Sum != "", a.OpenID != "", a.Account != nil} {
+		if set {
+			ifis++
+		}
+	}
+	if a.Mbox != "" && !strings.HasPrefix(a.Mbox, "mailto:") {
+		return fmt.Errorf("%s.mbox must be a mailto: IRI", field)
+	}
+	if a.Account != nil && (!isIRI(a.Account.HomePage) || a.Account.Name == "") {
+		return fmt.Errorf("%s.account needs a homePage IRL and a name", field)
+	}
+	switch a.ObjectType {
+	case "", "Agent":
+		if ifis != 1 {
+			return fmt.Errorf("%s must have exactly one of mbox, mbox_sha1sum, openid or account", field)
+		}
+	case "Group":
+		if ifis > 1 {
+			return fmt.Errorf("%s must have at most one of mbox, mbox_sha1sum, openid or account", field)
+		}
+		if ifis == 0 && len(a.Member) == 0 {
+			return fmt.Errorf("%s is an anonymous group without members", field)
+		}
+		for i, member := range a.Member {
+			if member.ObjectType == "Group" {
+				return fmt.Errorf("%s.member[%d] must be an agent", field, i)
+			}
+			if err := member.validate(fmt.Sprintf("%s.member[%d]", field, i)); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s.objectType must be Agent or Group", field)
+	}
+	return nil
+}
+
+// isIRI reports whether s is an absolute IRI
+func isIRI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != ""
+}
+
+// isUUID reports whether s is a UUID as xAPI writes them
+func isUUID(s string) bool {
+	_, err := uuid.Parse(s)
+	return err == nil && len(s) == 36
+}
+
+// validate checks a statement received by the LRS
+func (s *XAPIStatement) validate() error {
+	if s.ID != "" && !isUUID(s.ID) {
+		return fmt.Errorf("id must be a UUID")
+	}
+	if err := s.Actor.validate("actor"); err != nil {
+		return err
+	}
+	if !isIRI(s.Verb.ID) {
+		return fmt.Errorf("verb.id must be an IRI")
+	}
+
+	switch s.Object.ObjectType {
+	case "", "Activity":
+		if !isIRI(s.Object.ID) {
+			return fmt.Errorf("object.id must be an IRI")
+		}
+	case "Agent", "Group":
+		agent, _ := s.Object.agent()
+		if err := agent.validate("object"); err != nil {
+			return err
+		}
+	case "StatementRef":
+		if !isUUID(s.Object.ID) {
+			return fmt.Errorf("object.id must be the UUID of a statement")
+		}
+	case "SubStatement":
+		return fmt.Errorf("sub-statements are not supported")
+	default:
+		return fmt.Errorf("object.objectType %q is not valid", s.Object.ObjectType)
+	}
+	if s.Verb.ID == xapiVerbVoided && s.Object.ObjectType != "StatementRef" {
+		return fmt.Errorf("a voiding statement's object must be a StatementRef")
+	}
+
+	if s.Result != nil && s.Result.Score != nil {
+		if scaled := s.Result.Score.Scaled; scaled != nil && (*scaled < -1 || *scaled > 1) {
+			return fmt.Errorf("result.score.scaled must be between -1 and 1")
+		}
+	}
+	if s.Context != nil {
+		if s.Context.Registration != "" && !isUUID(s.Context.Registration) {
+			return fmt.Errorf("context.registration must be a UUID")
+		}
+		for _, activity := range s.Context.ContextActivities.all() {
+			if !isIRI(activity.ID) {
+				return fmt.Errorf("context activities must have IRI ids")
+			}
+		}
+	}
+	if s.Version != "" && !strings.HasPrefix(s.Version, "1.0") {
+		return fmt.Errorf("version %s is not supported", s.Version)
+	}
+	return nil
+}
+
+// prepare fills in the properties the LRS sets on a statement as it is stored
+func (s *XAPIStatement) prepare(authority XAPIAgent, now time.Time) {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	s.ID = strings.ToLower(s.ID)
+	if s.Timestamp == nil {
+		s.Timestamp = &now
+	}
+	s.Stored = now
+	s.Authority = &authority
+	if s.Version == "" {
+		s.Version = "1.0.0"
+	}
+}
+
+// sameStatement reports whether two statements with the same ID say the
+// same thing, ignoring what the LRS set when storing them
+func sameStatement(a, b XAPIStatement) bool {
+	// Timestamps the LRS filled in from the stored time were not sent
+	for _, st := range []*XAPIStatement{&a, &b} {
+		if st.Timestamp != nil && st.Timestamp.Equal(st.Stored) {
+			st.Timestamp = nil
+		}
+	}
+	a.Stored, b.Stored = time.Time{}, time.Time{}
+	a.Authority, b.Authority = nil, nil
+	a.Version, b.Version = "", ""
+	if a.Timestamp != nil && b.Timestamp != nil && a.Timestamp.Equal(*b.Timestamp) {
+		b.Timestamp = a.Timestamp
+	}
+	if (a.Timestamp == nil) != (b.Timestamp == nil) {
+		a.Timestamp, b.Timestamp = nil, nil
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// StatementQuery holds the filters of a statement query
+type StatementQuery struct {
+	Agent             *XAPIAgent
+	Verb              string
+	Activity          string
+	Registration      string
+	RelatedActivities bool
+	RelatedAgents     bool
+	Since             *time.Time
+	Until             *time.Time
+	Ascending         bool
+}
+
+// matches reports whether a statement passes the query's filters
+func (q StatementQuery) matches(s XAPIStatement) bool {
+	if q.Verb != "" && s.Verb.ID != q.Verb {
+		return false
+	}
+	if q.Since != nil && !s.Stored.After(*q.Since) {
+		return false
+	}
+	if q.Until != nil && s.Stored.After(*q.Until) {
+		return false
+	}
+	if q.Registration != "" && (s.Context == nil || !strings.EqualFold(s.Context.Registration, q.Registration)) {
+		return false
+	}
+
+	if q.Activity != "" {
+		activities := []XAPIObject{s.Object}
+		if q.RelatedActivities && s.Context != nil {
+			activities = append(activities, s.Context.ContextActivities.all()...)
+		}
+		found := false
+		for _, activity := range activities {
+			if (activity.ObjectType == "" || activity.ObjectType == "Activity") && activity.ID == q.Activity {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if q.Agent != nil {
+		want := q.Agent.ifi()
+		agents := []XAPIAgent{s.Actor}
+		if agent, ok := s.Object.agent(); ok {
+			agents = append(agents, agent)
+		}
+		if q.RelatedAgents {
+			if s.Authority != nil {
+				agents = append(agents, *s.Authority)
+			}
+			if s.Context != nil && s.Context.Instructor != nil {
+				agents = append(agents, *s.Context.Instructor)
+			}
+			if s.Context != nil && s.Context.Team != nil {
+				agents = append(agents, *s.Context.Team)
+			}
+			for _, agent := range agents {
+				agents = append(agents, agent.Member...)
+			}
+		}
+		found := false
+		for _, agent := range agents {
+			if agent.ifi() != "" && agent.ifi() == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// StatementStore is the Learning Record Store, holding statements in the
+// order they were stored. Statements are never changed once stored, so the
+// file is a log with one statement per line, and adding statements appends to
+// it rather than rewriting it.
+type StatementStore struct {
+	mu         sync.Mutex
+	Statements []XAPIStatement `json:"statements"`
+	file       string
+}
+
+// NewStatementStore creates a new statement store
+func NewStatementStore(path string) *StatementStore {
+	s := &StatementStore{Statements: []XAPIStatement{}, file: path}
+	s.load()
+	return s
+}
+
+func (s *StatementStore) load() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, err := os.ReadFile(s.file)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logError("failed to load xAPI statements", err)
+		}
+		return
+	}
+
+	// Stores written before the log was introduced hold a single array;
+	// rewrite them as a log
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		var statements []XAPIStatement
+		if err := json.Unmarshal(trimmed, &statements); err != nil {
+			logError("failed to load xAPI statements", err)
+			return
+		}
+		if err := s.rewrite(statements); err != nil {
+			logError("failed to convert xAPI statements", err)
+			return
+		}
+		s.Statements = statements
+		return
+	}
+
+	for n, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var statement XAPIStatement
+		if err := json.Unmarshal(line, &statement); err != nil {
+			// Most likely the end of a write cut short, which was never
+			// acknowledged to the client
+			logError(fmt.Sprintf("skipping unreadable xAPI statement on line %d", n+1), err)
+			continue
+		}
+		s.Statements = append(s.Statements, statement)
+	}
+
+	// Cut off a line left unfinished so the next statement starts on its own
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		if err := os.Truncate(s.file, int64(bytes.LastIndexByte(data, '\n')+1)); err != nil {
+			logError("failed to trim xAPI statement log", err)
+		}
+	}
+}
+
+// encodeStatements renders statements as log lines
+func encodeStatements(statements []XAPIStatement) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, statement := range statements {
+		if err := enc.Encode(statement); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// rewrite replaces the log with statements; the caller holds the lock
+func (s *StatementStore) rewrite(statements []XAPIStatement) error {
+	data, err := encodeStatements(statements)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.file, data, 0644)
+}
+
+// appendLog writes statements to the end of the log in one write; the caller
+// holds the lock
+func (s *StatementStore) appendLog(statements []XAPIStatement) error {
+	data, err := encodeStatements(statements)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		// Don't leave half a line for the next statement to be joined onto
+		f.Truncate(info.Size())
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// find returns the stored statement with an ID; the caller holds the lock
+func (s *StatementStore) find(id string) (XAPIStatement, bool) {
+	id = strings.ToLower(id)
+	for _, statement := range s.Statements {
+		if statement.ID == id {
+			return statement, true
+		}
+	}
+	return XAPIStatement{}, false
+}
+
+// isVoided reports whether a voiding statement targets id; the caller holds
+// the lock
+func (s *StatementStore) isVoided(id string) bool {
+	for _, statement := range s.Statements {
+		if statement.Verb.ID == xapiVerbVoided && strings.EqualFold(statement.Object.ID, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// add stores prepared statements, all or none. Statements whose ID is
+// already stored with the same content are skipped; a different statement
+// with a stored ID fails with errStatementConflict, and statements the LRS
+// will not accept with errStatementRejected.
+func (s *StatementStore) add(statements []XAPIStatement) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fresh := []XAPIStatement{}
+	seen := map[string]bool{}
+	for _, statement := range statements {
+		if seen[statement.ID] {
+			return fmt.Errorf("%w: %s is sent twice", errStatementRejected, statement.ID)
+		}
+		seen[statement.ID] = true
+		if existing, ok := s.find(statement.ID); ok {
+			if !sameStatement(existing, statement) {
+				return errStatementConflict
+			}
+			continue
+		}
+		if statement.Verb.ID == xapiVerbVoided {
+			if target, ok := s.find(statement.Object.ID); ok && target.Verb.ID == xapiVerbVoided {
+				return fmt.Errorf("%w: voiding statements cannot be voided", errStatementRejected)
+			}
+		}
+		fresh = append(fresh, statement)
+	}
+	if len(fresh) == 0 {
+		return nil
+	}
+	if err := s.appendLog(fresh); err != nil {
+		return err
+	}
+	s.Statements = append(s.Statements, fresh...)
+	return nil
+}
+
+// get returns a statement by ID. Voided statements are only returned when
+// voided is true, and other statements only when it is false.
+func (s *StatementStore) get(id string, voided bool) (XAPIStatement, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statement, ok := s.find(id)
+	if !ok || s.isVoided(statement.ID) != voided {
+		return XAPIStatement{}, false
+	}
+	return statement, true
+}
+
+// query returns the statements matching q that have not been voided, most
+// recently stored first unless q.Ascending is set
+func (s *StatementStore) query(q StatementQuery) []XAPIStatement {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	voided := map[string]bool{}
+	for _, statement := range s.Statements {
+		if statement.Verb.ID == xapiVerbVoided {
+			voided[strings.ToLower(statement.Object.ID)] = true
+		}
+	}
+	statements := make([]XAPIStatement, 0)
+	for _, statement := range s.Statements {
+		if !voided[statement.ID] && q.matches(statement) {
+			statements = append(statements, statement)
+		}
+	}
+	sort.SliceStable(statements, func(i, j int) bool {
+		if q.Ascending {
+			return statements[i].Stored.Before(statements[j].Stored)
+		}
+		return statements[i].Stored.After(statements[j].Stored)
+	})
+	return statements
+}
+
+// XAPIEmitter records statements for learning activity in the app. Activity
+// IDs are built from XAPI_BASE_URL, which should be the site's public
+// address so that they stay the same wherever the statements are read.
+type XAPIEmitter struct {
+	store   *StatementStore
+	users   *UserStore
+	baseURL string
+}
+
+// newXAPIEmitter creates an emitter writing to store
+func newXAPIEmitter(store *StatementStore, users *UserStore) *XAPIEmitter {
+	baseURL := strings.TrimRight(os.Getenv("XAPI_BASE_URL"), "/")
+	if baseURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		baseURL = "http://localhost:" + port
+	}
+	return &XAPIEmitter{store: store, users: users, baseURL: baseURL}
+}
+
+// authority is the agent the server records its own statements as
+func (e *XAPIEmitter) authority() XAPIAgent {
+	return XAPIAgent{ObjectType: "Agent", Name: "Train Hub", Account: &XAPIAccount{HomePage: e.baseURL, Name: "train-hub"}}
+}
+
+// trainingActivity is the activity for a training
+func (e *XAPIEmitter) trainingActivity(t Training) XAPIObject {
+	return XAPIObject{
+		ObjectType: "Activity",
+		ID:         e.baseURL + "/trainings/" + t.ID,
+		Definition: map[string]interface{}{
+			"name": map[string]string{"en-US": t.Title},
+			"type": xapiActivityCourse,
+		},
+	}
+}
+
+// quizActivity is the activity for a quiz block in a training
+func (e *XAPIEmitter) quizActivity(t Training, blockID, title string) XAPIObject {
+	if title == "" {
+		title = t.Title + " quiz"
+	}
+	return XAPIObject{
+		ObjectType: "Activity",
+		ID:         e.baseURL + "/trainings/" + t.ID + "/blocks/" + blockID,
+		Definition: map[string]interface{}{
+			"name": map[string]string{"en-US": title},
+			"type": xapiActivityAssessment,
+		},
+	}
+}
+
+// trainingContext places a statement within a training
+func (e *XAPIEmitter) trainingContext(t Training) *XAPIContext {
+	return &XAPIContext{
+		Revision:          strconv.Itoa(t.Revision),
+		Platform:          "Train Hub",
+		ContextActivities: &XAPIContextActivities{Parent: xapiActivityList{e.trainingActivity(t)}},
+	}
+}
+
+// emit records a statement by a learner, logging rather than failing if it
+// cannot be stored
+func (e *XAPIEmitter) emit(email, verb string, object XAPIObject, result *XAPIResult, context *XAPIContext) {
+	user, _ := e.users.get(email)
+	display := verb[strings.LastIndex(verb, "/")+1:]
+	statement := XAPIStatement{
+		Actor:   XAPIAgent{ObjectType: "Agent", Name: user.Name, Mbox: "mailto:" + email},
+		Verb:    XAPIVerb{ID: verb, Display: map[string]string{"en-US": display}},
+		Object:  object,
+		Result:  result,
+		Context: context,
+	}
+	statement.prepare(e.authority(), time.Now())
+	statement.Version = xapiVersion
+	if err := e.store.add([]XAPIStatement{statement}); err != nil {
+		logError("failed to record xAPI statement", err)
+	}
+}
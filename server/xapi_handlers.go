@@ -0,0 +1,326 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XAPIHandlers contains the Learning Record Store endpoints. Clients
+// authenticate with HTTP Basic auth as XAPI_USERNAME and XAPI_PASSWORD; until
+// both are set the endpoints refuse every request, though the server still
+// records statements of its own.
+type XAPIHandlers struct {
+	store    *StatementStore
+	emitter  *XAPIEmitter
+	username string
+	password string
+}
+
+// NewXAPIHandlers creates a new XAPIHandlers instance
+func NewXAPIHandlers(store *StatementStore, emitter *XAPIEmitter) *XAPIHandlers {
+	return &XAPIHandlers{
+		store:    store,
+		emitter:  emitter,
+		username: os.Getenv("XAPI_USERNAME"),
+		password: os.Getenv("XAPI_PASSWORD"),
+	}
+}
+
+// enabled reports whether credentials are configured for the LRS endpoints
+func (h *XAPIHandlers) enabled() bool {
+	return h.username != "" && h.password != ""
+}
+
+// middleware handles what every LRS request needs: CORS for the headers xAPI
+// clients send, Basic auth, and the version header
+func (h *XAPIHandlers) middleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Experience-API-Version")
+		w.Header().Set("Access-Control-Expose-Headers", "X-Experience-API-Version, X-Experience-API-Consistent-Through")
+		w.Header().Set("Access-Control-Max-Age", "3600")
+		w.Header().Set("X-Experience-API-Version", xapiVersion)
+
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		if !h.enabled() {
+			respondError(w, "the LRS is disabled until XAPI_USERNAME and XAPI_PASSWORD are set", http.StatusServiceUnavailable)
+			return
+		}
+		username, password, ok := r.BasicAuth()
+		if !ok ||
+			subtle.ConstantTimeCompare([]byte(username), []byte(h.username)) != 1 ||
+			subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="xAPI"`)
+			respondError(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// The about resource is the one place clients may omit the version
+		if r.URL.Path != "/api/xapi/about" && !strings.HasPrefix(r.Header.Get("X-Experience-API-Version"), "1.0") {
+			respondError(w, "X-Experience-API-Version header must be 1.0.x", http.StatusBadRequest)
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// authority is the agent recorded as vouching for statements clients send
+func (h *XAPIHandlers) authority() XAPIAgent {
+	return XAPIAgent{ObjectType: "Agent", Account: &XAPIAccount{HomePage: h.emitter.baseURL, Name: h.username}}
+}
+
+// HandleAbout handles clients asking which xAPI versions the LRS speaks
+func (h *XAPIHandlers) HandleAbout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	respondJSON(w, map[string]interface{}{"version": []string{xapiVersion}})
+}
+
+// HandleStatements handles the statements resource: PUT stores one statement
+// under ?statementId=, POST stores one or an array and returns their IDs, and
+// GET fetches a statement or queries them
+func (h *XAPIHandlers) HandleStatements(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		h.getStatements(w, r)
+	case http.MethodPut:
+		h.putStatement(w, r)
+	case http.MethodPost:
+		h.postStatements(w, r)
+	default:
+		respondError(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// readStatements decodes one statement or an array of them
+func readStatements(body io.Reader) ([]XAPIStatement, error) {
+	data, err := io.ReadAll(io.LimitReader(body, 10<<20))
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(strings.TrimSpace(string(data)))
+	if len(data) > 0 && data[0] == '[' {
+		var statements []XAPIStatement
+		if err := json.Unmarshal(data, &statements); err != nil {
+			return nil, err
+		}
+		return statements, nil
+	}
+	var statement XAPIStatement
+	if err := json.Unmarshal(data, &statement); err != nil {
+		return nil, err
+	}
+	return []XAPIStatement{statement}, nil
+}
+
+// storeStatements validates and stores statements, writing the error
+// response and returning false if they are not stored
+func (h *XAPIHandlers) storeStatements(w http.ResponseWriter, statements []XAPIStatement) bool {
+	if len(statements) == 0 {
+		respondError(w, "no statements sent", http.StatusBadRequest)
+		return false
+	}
+	if len(statements) > maxStatementsPerPost {
+		respondError(w, fmt.Sprintf("at most %d statements may be sent at once", maxStatementsPerPost), http.StatusBadRequest)
+		return false
+	}
+	now := time.Now()
+	for i := range statements {
+		if err := statements[i].validate(); err != nil {
+			respondError(w, fmt.Sprintf("statement %d: %v", i, err), http.StatusBadRequest)
+			return false
+		}
+		statements[i].prepare(h.authority(), now)
+	}
+
+	err := h.store.add(statements)
+	switch {
+	case errors.Is(err, errStatementConflict):
+		respondError(w, err.Error(), http.StatusConflict)
+		return false
+	case errors.Is(err, errStatementRejected):
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return false
+	case err != nil:
+		logError("failed to save xAPI statements", err)
+		respondError(w, "failed to save statements", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// putStatement handles storing a statement with the ID given in the query
+func (h *XAPIHandlers) putStatement(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("statementId")
+	if !isUUID(id) {
+		respondError(w, "statementId must be a UUID", http.StatusBadRequest)
+		return
+	}
+	var statement XAPIStatement
+	if err := json.NewDecoder(io.LimitReader(r.Body, 10<<20)).Decode(&statement); err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if statement.ID != "" && !strings.EqualFold(statement.ID, id) {
+		respondError(w, "statement id does not match statementId", http.StatusBadRequest)
+		return
+	}
+	statement.ID = id
+	if h.storeStatements(w, []XAPIStatement{statement}) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// postStatements handles storing one statement or an array of them
+func (h *XAPIHandlers) postStatements(w http.ResponseWriter, r *http.Request) {
+	statements, err := readStatements(r.Body)
+	if err != nil {
+		respondError(w, "invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !h.storeStatements(w, statements) {
+		return
+	}
+	ids := make([]string, len(statements))
+	for i, statement := range statements {
+		ids[i] = statement.ID
+	}
+	respondJSON(w, ids)
+}
+
+// getStatements handles fetching one statement by statementId or
+// voidedStatementId, or querying with the standard filters: agent, verb,
+// activity, registration, related_activities, related_agents, since, until,
+// limit and ascending. Results are paged; "more" holds the URL of the next
+// page, or is empty on the last one.
+func (h *XAPIHandlers) getStatements(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	now := time.Now()
+	w.Header().Set("X-Experience-API-Consistent-Through", now.UTC().Format(time.RFC3339Nano))
+
+	id, voided := query.Get("statementId"), false
+	if v := query.Get("voidedStatementId"); v != "" {
+		if id != "" {
+			respondError(w, "statementId and voidedStatementId cannot be combined", http.StatusBadRequest)
+			return
+		}
+		id, voided = v, true
+	}
+	if id != "" {
+		for key := range query {
+			switch key {
+			case "statementId", "voidedStatementId", "format", "attachments":
+			default:
+				respondError(w, fmt.Sprintf("%s cannot be used when fetching a single statement", key), http.StatusBadRequest)
+				return
+			}
+		}
+		statement, ok := h.store.get(id, voided)
+		if !ok {
+			respondError(w, "statement not found", http.StatusNotFound)
+			return
+		}
+		respondJSON(w, statement)
+		return
+	}
+
+	q, err := parseStatementQuery(query)
+	if err != nil {
+		respondError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	limit := maxStatementsPerPage
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondError(w, "limit must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		if n > 0 && n < limit {
+			limit = n
+		}
+	}
+	offset := 0
+	if v := query.Get("cursor"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			respondError(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	// Later pages keep to the statements stored when the first was fetched
+	if q.Until == nil {
+		q.Until = &now
+	}
+
+	statements := h.store.query(q)
+	if offset > len(statements) {
+		offset = len(statements)
+	}
+	page := statements[offset:]
+	more := ""
+	if len(page) > limit {
+		page = page[:limit]
+		next := url.Values{}
+		for key, values := range query {
+			next[key] = values
+		}
+		next.Set("until", q.Until.UTC().Format(time.RFC3339Nano))
+		next.Set("cursor", strconv.Itoa(offset+limit))
+		more = r.URL.Path + "?" + next.Encode()
+	}
+	respondJSON(w, map[string]interface{}{
+		"statements": page,
+		"more":       more,
+	})
+}
+
+// parseStatementQuery reads the filters of a statement query
+func parseStatementQuery(query url.Values) (StatementQuery, error) {
+	q := StatementQuery{
+		Verb:              query.Get("verb"),
+		Activity:          query.Get("activity"),
+		Registration:      query.Get("registration"),
+		RelatedActivities: query.Get("related_activities") == "true",
+		RelatedAgents:     query.Get("related_agents") == "true",
+		Ascending:         query.Get("ascending") == "true",
+	}
+	if v := query.Get("agent"); v != "" {
+		var agent XAPIAgent
+		if err := json.Unmarshal([]byte(v), &agent); err != nil {
+			return q, fmt.Errorf("agent must be an agent as JSON")
+		}
+		if err := agent.validate("agent"); err != nil {
+			return q, err
+		}
+		q.Agent = &agent
+	}
+	for key, target := range map[string]**time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := query.Get(key); v != "" {
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return q, fmt.Errorf("%s must be an ISO 8601 timestamp", key)
+			}
+			*target = &t
+		}
+	}
+	return q, nil
+}